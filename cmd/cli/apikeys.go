@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/hiimjako/syncinator/internal/repository"
+	"github.com/hiimjako/syncinator/pkg/middleware"
+)
+
+// createAPIKey prints a new key of the workspace, it can't be shown again
+// since only its hash is stored
+func createAPIKey(args []string) {
+	flags := flag.NewFlagSet("create-api-key", flag.ExitOnError)
+	workspaceName := flags.String("workspace", "", "workspace name")
+	keyName := flags.String("name", "", "api key name, e.g. the job using it")
+	scopeNames := flags.String("scopes", string(middleware.ScopeRead), "comma separated scopes: read, write, export, admin")
+	expiresIn := flags.Duration("expires", 0, "validity of the key, e.g. 720h (0 never expires)")
+	dbPath := flags.String("db", "", "sqlite db path")
+	_ = flags.Parse(args)
+
+	if *workspaceName == "" || *keyName == "" || *dbPath == "" {
+		flags.PrintDefaults()
+		return
+	}
+
+	scopes, err := middleware.ParseScopes(*scopeNames)
+	failOnError("unable to create api key", err)
+
+	db := openRepository(*dbPath)
+
+	workspace, err := db.FetchWorkspace(context.Background(), *workspaceName)
+	failOnError("unable to find workspace", err)
+
+	key, err := middleware.GenerateAPIKey()
+	failOnError("unable to create api key", err)
+
+	var expiresAt sql.NullTime
+	if *expiresIn > 0 {
+		expiresAt = sql.NullTime{Time: time.Now().UTC().Add(*expiresIn), Valid: true}
+	}
+
+	created, err := db.CreateAPIKey(context.Background(), repository.CreateAPIKeyParams{
+		WorkspaceID: workspace.ID,
+		Name:        *keyName,
+		KeyHash:     middleware.HashAPIKey(key),
+		Scopes:      scopes.String(),
+		ExpiresAt:   expiresAt,
+	})
+	failOnError("unable to create api key", err)
+
+	fmt.Printf("api key %d created correctly, store it now as it won't be shown again:\n%s\n", created.ID, key)
+}
+
+func listAPIKeys(args []string) {
+	flags := flag.NewFlagSet("list-api-keys", flag.ExitOnError)
+	workspaceName := flags.String("workspace", "", "workspace name")
+	dbPath := flags.String("db", "", "sqlite db path")
+	_ = flags.Parse(args)
+
+	if *workspaceName == "" || *dbPath == "" {
+		flags.PrintDefaults()
+		return
+	}
+
+	db := openRepository(*dbPath)
+
+	workspace, err := db.FetchWorkspace(context.Background(), *workspaceName)
+	failOnError("unable to find workspace", err)
+
+	keys, err := db.FetchAPIKeys(context.Background(), workspace.ID)
+	failOnError("unable to list api keys", err)
+
+	for _, key := range keys {
+		expires := "never expires"
+		if key.ExpiresAt.Valid {
+			expires = "expires " + key.ExpiresAt.Time.Format("2006-01-02")
+		}
+		fmt.Printf("%d\t%s\t%s\t(%s)\n", key.ID, key.Name, key.Scopes, expires)
+	}
+}
+
+func revokeAPIKey(args []string) {
+	flags := flag.NewFlagSet("revoke-api-key", flag.ExitOnError)
+	workspaceName := flags.String("workspace", "", "workspace name")
+	keyID := flags.String("id", "", "api key id, as shown by list-api-keys")
+	dbPath := flags.String("db", "", "sqlite db path")
+	_ = flags.Parse(args)
+
+	if *workspaceName == "" || *keyID == "" || *dbPath == "" {
+		flags.PrintDefaults()
+		return
+	}
+
+	id, err := strconv.ParseInt(*keyID, 10, 64)
+	failOnError("invalid api key id", err)
+
+	db := openRepository(*dbPath)
+
+	workspace, err := db.FetchWorkspace(context.Background(), *workspaceName)
+	failOnError("unable to find workspace", err)
+
+	revoked, err := db.DeleteAPIKey(context.Background(), repository.DeleteAPIKeyParams{
+		ID:          id,
+		WorkspaceID: workspace.ID,
+	})
+	failOnError("unable to revoke api key", err)
+
+	if revoked == 0 {
+		fmt.Printf("api key %d not found in %s\n", id, *workspaceName)
+		os.Exit(1)
+	}
+
+	fmt.Println("api key revoked correctly")
+}
@@ -0,0 +1,227 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/hiimjako/syncinator/internal/repository"
+	"github.com/hiimjako/syncinator/pkg/filestorage"
+)
+
+func listFiles(args []string) {
+	flags := flag.NewFlagSet("list-files", flag.ExitOnError)
+	workspaceName := flags.String("workspace", "", "workspace name")
+	dbPath := flags.String("db", "", "sqlite db path")
+	storageDir := flags.String("storage", defaultStorageDir, "storage directory")
+	_ = flags.Parse(args)
+
+	if *workspaceName == "" || *dbPath == "" {
+		flags.PrintDefaults()
+		return
+	}
+
+	dbSqlite := openDB(*dbPath)
+	db := repository.New(dbSqlite)
+	storage := openStorage(*storageDir, dbSqlite)
+
+	workspace, err := db.FetchWorkspace(context.Background(), *workspaceName)
+	failOnError("unable to find workspace", err)
+
+	files, err := db.FetchWorkspaceFiles(context.Background(), workspace.ID)
+	failOnError("unable to list files", err)
+
+	for _, file := range files {
+		fmt.Printf("%d\t%s\tv%d\t%s\t%s\t(updated %s)\n",
+			file.ID,
+			file.WorkspacePath,
+			file.Version,
+			formatSize(objectSize(storage, file.DiskPath)),
+			file.MimeType,
+			file.UpdatedAt.Format("2006-01-02 15:04:05"),
+		)
+	}
+}
+
+// listSnapshots prints the history of a file, newest first
+func listSnapshots(args []string) {
+	flags := flag.NewFlagSet("list-snapshots", flag.ExitOnError)
+	workspaceName := flags.String("workspace", "", "workspace name")
+	filePath := flags.String("path", "", "path of the file in the workspace")
+	dbPath := flags.String("db", "", "sqlite db path")
+	_ = flags.Parse(args)
+
+	if *workspaceName == "" || *filePath == "" || *dbPath == "" {
+		flags.PrintDefaults()
+		return
+	}
+
+	db := openRepository(*dbPath)
+
+	workspace, err := db.FetchWorkspace(context.Background(), *workspaceName)
+	failOnError("unable to find workspace", err)
+
+	file, err := db.FetchFileFromWorkspacePath(context.Background(), repository.FetchFileFromWorkspacePathParams{
+		WorkspaceID:   workspace.ID,
+		WorkspacePath: *filePath,
+	})
+	failOnError("unable to find file", err)
+
+	snapshots, err := db.FetchSnapshots(context.Background(), repository.FetchSnapshotsParams{
+		FileID:      file.ID,
+		WorkspaceID: workspace.ID,
+	})
+	failOnError("unable to list snapshots", err)
+
+	for _, snapshot := range snapshots {
+		fmt.Printf("v%d\t%s\t%s\t(created %s)\n",
+			snapshot.Version,
+			snapshot.Type,
+			snapshot.Hash,
+			snapshot.CreatedAt.Format("2006-01-02 15:04:05"),
+		)
+	}
+}
+
+// checkIntegrity verifies that the objects of the files and the snapshots
+// exist and match their hashes, and that the history of every file can be
+// rebuilt. The text files still cached by a running server may be reported
+// as mismatching until they are flushed.
+func checkIntegrity(args []string) {
+	flags := flag.NewFlagSet("check", flag.ExitOnError)
+	workspaceName := flags.String("workspace", "", "workspace name (empty checks all of them)")
+	dbPath := flags.String("db", "", "sqlite db path")
+	storageDir := flags.String("storage", defaultStorageDir, "storage directory")
+	_ = flags.Parse(args)
+
+	if *dbPath == "" {
+		flags.PrintDefaults()
+		return
+	}
+
+	dbSqlite := openDB(*dbPath)
+	db := repository.New(dbSqlite)
+	storage := openStorage(*storageDir, dbSqlite)
+
+	workspaces, err := db.FetchWorkspaces(context.Background())
+	failOnError("unable to list workspaces", err)
+
+	problems := 0
+	report := func(workspace, format string, args ...any) {
+		problems++
+		fmt.Printf("%s\t%s\n", workspace, fmt.Sprintf(format, args...))
+	}
+
+	for _, workspace := range workspaces {
+		if *workspaceName != "" && workspace.Name != *workspaceName {
+			continue
+		}
+
+		files, err := db.FetchWorkspaceFiles(context.Background(), workspace.ID)
+		failOnError("unable to list files", err)
+		deletedFiles, err := db.FetchWorkspaceDeletedFiles(context.Background(), workspace.ID)
+		failOnError("unable to list deleted files", err)
+		snapshots, err := db.FetchWorkspaceSnapshots(context.Background(), workspace.ID)
+		failOnError("unable to list snapshots", err)
+
+		paths := make(map[int64]string, len(files)+len(deletedFiles))
+		for _, file := range files {
+			paths[file.ID] = file.WorkspacePath
+
+			hash, err := hashObject(storage, file.DiskPath)
+			switch {
+			case err != nil:
+				report(workspace.Name, "%s: unreadable object %s: %v", file.WorkspacePath, file.DiskPath, err)
+			case hash != file.Hash:
+				report(workspace.Name, "%s: hash mismatch, expected %s got %s", file.WorkspacePath, file.Hash, hash)
+			}
+		}
+
+		// the deleted files keep their history until the retention purges it
+		for _, file := range deletedFiles {
+			if file.DiskPath == "" {
+				continue
+			}
+			paths[file.FileID] = file.WorkspacePath + " (deleted)"
+
+			if _, err := hashObject(storage, file.DiskPath); err != nil {
+				report(workspace.Name, "%s (deleted): unreadable object %s: %v", file.WorkspacePath, file.DiskPath, err)
+			}
+		}
+
+		// the snapshots are sorted by file and version, so the first one of
+		// every file is the base the others are rebuilt from
+		var previousFileID int64
+		for _, snapshot := range snapshots {
+			path, ok := paths[snapshot.FileID]
+			if !ok {
+				report(workspace.Name, "snapshot v%d of missing file %d", snapshot.Version, snapshot.FileID)
+				continue
+			}
+			if snapshot.FileID != previousFileID && snapshot.Type == "diff" {
+				report(workspace.Name, "%s: diff snapshot v%d without a full snapshot before it", path, snapshot.Version)
+			}
+			previousFileID = snapshot.FileID
+
+			hash, err := hashObject(storage, snapshot.DiskPath)
+			switch {
+			case err != nil:
+				report(workspace.Name, "%s: unreadable snapshot v%d %s: %v", path, snapshot.Version, snapshot.DiskPath, err)
+			case hash != snapshot.Hash:
+				report(workspace.Name, "%s: snapshot v%d hash mismatch, expected %s got %s", path, snapshot.Version, snapshot.Hash, hash)
+			}
+		}
+	}
+
+	if problems > 0 {
+		fmt.Printf("found %d problems\n", problems)
+		os.Exit(1)
+	}
+
+	fmt.Println("no problems found")
+}
+
+func hashObject(storage filestorage.Storage, diskPath string) (string, error) {
+	object, err := storage.ReadObject(diskPath)
+	if err != nil {
+		return "", err
+	}
+	defer object.Close()
+
+	return filestorage.GenerateHash(object)
+}
+
+// objectSize is the size of the content of the object, decrypted, or -1 if
+// it can't be read
+func objectSize(storage filestorage.Storage, diskPath string) int64 {
+	object, err := storage.ReadObject(diskPath)
+	if err != nil {
+		return -1
+	}
+	defer object.Close()
+
+	size, err := io.Copy(io.Discard, object)
+	if err != nil {
+		return -1
+	}
+	return size
+}
+
+func formatSize(size int64) string {
+	if size < 0 {
+		return "unreadable"
+	}
+
+	const unit = 1024
+	if size < unit {
+		return fmt.Sprintf("%d B", size)
+	}
+	div, exp := int64(unit), 0
+	for n := size / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(size)/float64(div), "KMGTPE"[exp])
+}
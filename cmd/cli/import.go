@@ -0,0 +1,137 @@
+package main
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	syncinator "github.com/hiimjako/syncinator/pkg"
+)
+
+// importVault uploads a local directory, like an Obsidian vault, to a running
+// server through POST /v1/api/import. The hidden files and directories, like
+// .obsidian and .trash, are left out.
+func importVault(args []string) {
+	flags := flag.NewFlagSet("import", flag.ExitOnError)
+	dir := flags.String("dir", "", "directory to import")
+	serverURL := flags.String("url", "", "server url, e.g. https://sync.example.com")
+	token := flags.String("token", os.Getenv("SYNCINATOR_TOKEN"), "token or api key of the workspace (default $SYNCINATOR_TOKEN)")
+	strategy := flags.String("strategy", string(syncinator.ImportSkip), "what to do with the paths that already exist: skip, replace or merge")
+	_ = flags.Parse(args)
+
+	if *dir == "" || *serverURL == "" || *token == "" {
+		flags.PrintDefaults()
+		return
+	}
+
+	endpoint, err := url.JoinPath(*serverURL, syncinator.PathHTTPAPI, "import")
+	failOnError("invalid server url", err)
+	endpoint += "?strategy=" + url.QueryEscape(*strategy)
+
+	// the archive is streamed to the server while the directory is walked
+	body, writer := io.Pipe()
+	form := multipart.NewWriter(writer)
+	go func() {
+		writer.CloseWithError(writeVault(form, *dir))
+	}()
+
+	req, err := http.NewRequest(http.MethodPost, endpoint, body)
+	failOnError("unable to import", err)
+	req.Header.Set("Authorization", "Bearer "+*token)
+	req.Header.Set("Content-Type", form.FormDataContentType())
+
+	res, err := http.DefaultClient.Do(req)
+	failOnError("unable to import", err)
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(res.Body)
+		fmt.Printf("unable to import: %s %s\n", res.Status, strings.TrimSpace(string(msg)))
+		os.Exit(1)
+	}
+
+	var results []syncinator.ImportResult
+	err = json.NewDecoder(res.Body).Decode(&results)
+	failOnError("unable to read the import results", err)
+
+	failed := 0
+	for _, result := range results {
+		switch {
+		case result.Status == syncinator.ImportFailed:
+			failed++
+			fmt.Printf("%s\t%s\t%s\n", result.Status, result.Path, result.Error)
+		case result.CopyPath != "":
+			fmt.Printf("%s\t%s\t-> %s\n", result.Status, result.Path, result.CopyPath)
+		default:
+			fmt.Printf("%s\t%s\n", result.Status, result.Path)
+		}
+	}
+
+	if failed > 0 {
+		fmt.Printf("%d of %d files failed\n", failed, len(results))
+		os.Exit(1)
+	}
+
+	fmt.Printf("%d files imported correctly\n", len(results))
+}
+
+// writeVault writes the files of dir as a zip archive in the file field of
+// the form
+func writeVault(form *multipart.Writer, dir string) error {
+	part, err := form.CreateFormFile(syncinator.MultipartFileField, filepath.Base(dir)+".zip")
+	if err != nil {
+		return err
+	}
+
+	archive := zip.NewWriter(part)
+	err = filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if path != dir && strings.HasPrefix(d.Name(), ".") {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+
+		name, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+
+		entry, err := archive.Create(filepath.ToSlash(name))
+		if err != nil {
+			return err
+		}
+
+		file, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+
+		_, err = io.Copy(entry, file)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := archive.Close(); err != nil {
+		return err
+	}
+	return form.Close()
+}
@@ -3,19 +3,61 @@ package main
 import (
 	"context"
 	"database/sql"
+	"encoding/base64"
 	"flag"
 	"fmt"
 	"os"
 
+	"github.com/hiimjako/syncinator/internal/env"
 	"github.com/hiimjako/syncinator/internal/repository"
+	"github.com/hiimjako/syncinator/internal/storage"
+	"github.com/hiimjako/syncinator/pkg/filestorage"
 	_ "github.com/mattn/go-sqlite3"
 	"golang.org/x/crypto/bcrypt"
 )
 
+// commands are the subcommands of the cli, without one a workspace is created
+var commands = map[string]func(args []string){
+	"rotate-master-key": rotateMasterKey,
+	"encrypt-storage":   encryptStorage,
+	"create-user":       createUser,
+	"add-member":        addMember,
+	"remove-member":     removeMember,
+	"set-role":          setRole,
+	"list-members":      listMembers,
+	"add-rule":          addRule,
+	"list-rules":        listRules,
+	"remove-rule":       removeRule,
+	"create-api-key":    createAPIKey,
+	"list-api-keys":     listAPIKeys,
+	"revoke-api-key":    revokeAPIKey,
+	"list-workspaces":   listWorkspaces,
+	"rename-workspace":  renameWorkspace,
+	"set-password":      setPassword,
+	"delete-workspace":  deleteWorkspace,
+	"list-files":        listFiles,
+	"list-snapshots":    listSnapshots,
+	"check":             checkIntegrity,
+	"storage-usage":     storageUsage,
+	"import":            importVault,
+}
+
+// defaultStorageDir is the STORAGE_DIR default of the server
+const defaultStorageDir = "./data"
+
 func main() {
+	if len(os.Args) > 1 {
+		if command, ok := commands[os.Args[1]]; ok {
+			command(os.Args[2:])
+			return
+		}
+	}
+
 	workspaceName := flag.String("name", "", "workspace name")
 	workspacePass := flag.String("pass", "", "workspace password")
 	dbPath := flag.String("db", "", "sqlite db path")
+	snapshotRetention := flag.Int64("snapshot-retention", 0, "snapshots kept per binary file (0 uses the server default)")
+	endToEnd := flag.Bool("e2e", false, "end-to-end encrypted workspace, the server only stores and relays opaque contents")
 	flag.Parse()
 
 	if workspaceName == nil || *workspaceName == "" {
@@ -33,26 +75,132 @@ func main() {
 		return
 	}
 
+	if *snapshotRetention < 0 {
+		flag.PrintDefaults()
+		return
+	}
+
 	dbSqlite, err := sql.Open("sqlite3", *dbPath)
-	failOnError(err)
+	failOnError("unable to create workspace", err)
 
 	db := repository.New(dbSqlite)
 
 	hash, err := bcrypt.GenerateFromPassword([]byte(*workspacePass), bcrypt.DefaultCost)
-	failOnError(err)
+	failOnError("unable to create workspace", err)
 
 	err = db.AddWorkspace(context.Background(), repository.AddWorkspaceParams{
-		Name:     *workspaceName,
-		Password: string(hash),
+		Name:                    *workspaceName,
+		Password:                string(hash),
+		BinarySnapshotRetention: *snapshotRetention,
+		EndToEndEncrypted:       *endToEnd,
 	})
-	failOnError(err)
+	failOnError("unable to create workspace", err)
 
 	fmt.Println("workspace created correctly")
 }
 
-func failOnError(err error) {
+// rotateMasterKey re-wraps the workspace keys with a new master key, reading
+// both from the environment to keep them out of the shell history.
+func rotateMasterKey(args []string) {
+	flags := flag.NewFlagSet("rotate-master-key", flag.ExitOnError)
+	dbPath := flags.String("db", "", "sqlite db path")
+	_ = flags.Parse(args)
+
+	if *dbPath == "" {
+		fmt.Println("usage: STORAGE_MASTER_KEY=<current> NEW_STORAGE_MASTER_KEY=<new> cli rotate-master-key -db <path>")
+		flags.PrintDefaults()
+		return
+	}
+
+	oldKey, err := base64.StdEncoding.DecodeString(os.Getenv("STORAGE_MASTER_KEY"))
+	failOnError("unable to decode STORAGE_MASTER_KEY", err)
+	newKey, err := base64.StdEncoding.DecodeString(os.Getenv("NEW_STORAGE_MASTER_KEY"))
+	failOnError("unable to decode NEW_STORAGE_MASTER_KEY", err)
+
+	dbSqlite, err := sql.Open("sqlite3", *dbPath)
+	failOnError("unable to open db", err)
+
+	rotated, err := filestorage.RotateMasterKey(context.Background(), dbSqlite, oldKey, newKey)
+	failOnError("unable to rotate master key", err)
+
+	fmt.Printf("rotated %d workspace keys, restart the server with the new STORAGE_MASTER_KEY\n", rotated)
+}
+
+// encryptStorage rewrites encrypted the files and snapshots written before
+// enabling the encryption, so that STORAGE_ENCRYPTION_STRICT can be enabled.
+func encryptStorage(args []string) {
+	flags := flag.NewFlagSet("encrypt-storage", flag.ExitOnError)
+	dbPath := flags.String("db", "", "sqlite db path")
+	storageDir := flags.String("storage", defaultStorageDir, "storage directory")
+	_ = flags.Parse(args)
+
+	if *dbPath == "" {
+		fmt.Println("usage: STORAGE_MASTER_KEY=<key> cli encrypt-storage -db <path>")
+		flags.PrintDefaults()
+		return
+	}
+
+	dbSqlite := openDB(*dbPath)
+	db := repository.New(dbSqlite)
+	encrypted, ok := openStorage(*storageDir, dbSqlite).(*filestorage.EncryptedStorage)
+	if !ok {
+		fmt.Println("the storage isn't encrypted, set STORAGE_MASTER_KEY")
+		os.Exit(1)
+	}
+
+	workspaces, err := db.FetchWorkspaces(context.Background())
+	failOnError("unable to list workspaces", err)
+
+	rewritten := 0
+	encrypt := func(workspaceID int64, diskPath string) {
+		ok, err := encrypted.EncryptPlaintext(workspaceID, diskPath)
+		failOnError("unable to encrypt "+diskPath, err)
+		if ok {
+			rewritten++
+		}
+	}
+	for _, workspace := range workspaces {
+		files, err := db.FetchWorkspaceFiles(context.Background(), workspace.ID)
+		failOnError("unable to list files", err)
+		for _, file := range files {
+			encrypt(workspace.ID, file.DiskPath)
+		}
+
+		deletedFiles, err := db.FetchWorkspaceDeletedFiles(context.Background(), workspace.ID)
+		failOnError("unable to list deleted files", err)
+		for _, file := range deletedFiles {
+			if file.DiskPath != "" {
+				encrypt(workspace.ID, file.DiskPath)
+			}
+		}
+
+		snapshots, err := db.FetchWorkspaceSnapshots(context.Background(), workspace.ID)
+		failOnError("unable to list snapshots", err)
+		for _, snapshot := range snapshots {
+			encrypt(workspace.ID, snapshot.DiskPath)
+		}
+	}
+
+	fmt.Printf("encrypted %d objects\n", rewritten)
+}
+
+// openStorage reads the objects written by the server, the backend, the
+// deduplication and the encryption are configured from the environment like
+// on the server, dir is the directory of the disk backend
+func openStorage(dir string, dbSqlite *sql.DB) filestorage.Storage {
+	storageEnv, err := env.LoadStorageEnv()
+	failOnError("unable to read the storage configuration", err)
+	storageEnv.StorageDir = dir
+
+	fileStorage, err := storage.Open(storageEnv, dbSqlite)
+	failOnError("unable to open storage", err)
+
+	return fileStorage
+}
+
+func failOnError(msg string, err error) {
 	if err != nil {
-		fmt.Println("unable to create workspace")
+		fmt.Println(msg)
 		fmt.Println(err)
 		os.Exit(1)
 	}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+
+	"github.com/hiimjako/syncinator/internal/repository"
+	syncinator "github.com/hiimjako/syncinator/pkg"
+)
+
+// addRule restricts the member to the paths matching the pattern, once a
+// member has a rule it can only access the paths matched by its rules
+func addRule(args []string) {
+	flags := flag.NewFlagSet("add-rule", flag.ExitOnError)
+	workspaceName := flags.String("workspace", "", "workspace name")
+	userName := flags.String("user", "", "user name")
+	pattern := flags.String("pattern", "", "workspace path pattern, e.g. Projects/Alpha/**")
+	access := flags.String("access", syncinator.PathWrite, "path access: read or write")
+	dbPath := flags.String("db", "", "sqlite db path")
+	_ = flags.Parse(args)
+
+	if *workspaceName == "" || *userName == "" || *pattern == "" || *dbPath == "" {
+		flags.PrintDefaults()
+		return
+	}
+
+	rule := syncinator.PathRule{Pattern: *pattern, Access: *access}
+	failOnError("unable to add rule", rule.Validate())
+
+	db := openRepository(*dbPath)
+	workspaceID, userID := fetchMembership(db, *workspaceName, *userName)
+
+	added, err := db.AddMemberPathRule(context.Background(), repository.AddMemberPathRuleParams{
+		WorkspaceID: workspaceID,
+		UserID:      userID,
+		Pattern:     rule.Pattern,
+		Access:      rule.Access,
+	})
+	failOnError("unable to add rule", err)
+
+	fmt.Printf("rule %d added correctly\n", added.ID)
+}
+
+func listRules(args []string) {
+	flags := flag.NewFlagSet("list-rules", flag.ExitOnError)
+	workspaceName := flags.String("workspace", "", "workspace name")
+	userName := flags.String("user", "", "user name")
+	dbPath := flags.String("db", "", "sqlite db path")
+	_ = flags.Parse(args)
+
+	if *workspaceName == "" || *userName == "" || *dbPath == "" {
+		flags.PrintDefaults()
+		return
+	}
+
+	db := openRepository(*dbPath)
+	workspaceID, userID := fetchMembership(db, *workspaceName, *userName)
+
+	rules, err := db.FetchMemberPathRules(context.Background(), repository.FetchMemberPathRulesParams{
+		WorkspaceID: workspaceID,
+		UserID:      userID,
+	})
+	failOnError("unable to list rules", err)
+
+	if len(rules) == 0 {
+		fmt.Printf("%s can access the whole workspace\n", *userName)
+		return
+	}
+
+	for _, rule := range rules {
+		fmt.Printf("%d\t%s\t%s\n", rule.ID, rule.Access, rule.Pattern)
+	}
+}
+
+func removeRule(args []string) {
+	flags := flag.NewFlagSet("remove-rule", flag.ExitOnError)
+	workspaceName := flags.String("workspace", "", "workspace name")
+	ruleID := flags.String("id", "", "rule id, as shown by list-rules")
+	dbPath := flags.String("db", "", "sqlite db path")
+	_ = flags.Parse(args)
+
+	if *workspaceName == "" || *ruleID == "" || *dbPath == "" {
+		flags.PrintDefaults()
+		return
+	}
+
+	id, err := strconv.ParseInt(*ruleID, 10, 64)
+	failOnError("invalid rule id", err)
+
+	db := openRepository(*dbPath)
+
+	workspace, err := db.FetchWorkspace(context.Background(), *workspaceName)
+	failOnError("unable to find workspace", err)
+
+	removed, err := db.DeleteMemberPathRule(context.Background(), repository.DeleteMemberPathRuleParams{
+		ID:          id,
+		WorkspaceID: workspace.ID,
+	})
+	failOnError("unable to remove rule", err)
+
+	if removed == 0 {
+		fmt.Printf("rule %d not found in %s\n", id, *workspaceName)
+		os.Exit(1)
+	}
+
+	fmt.Println("rule removed correctly")
+}
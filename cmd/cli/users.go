@@ -0,0 +1,189 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/hiimjako/syncinator/internal/repository"
+	"github.com/hiimjako/syncinator/pkg/middleware"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// createUser adds a user, who can log in to the workspaces it is member of
+func createUser(args []string) {
+	flags := flag.NewFlagSet("create-user", flag.ExitOnError)
+	userName := flags.String("name", "", "user name")
+	userPass := flags.String("pass", "", "user password")
+	dbPath := flags.String("db", "", "sqlite db path")
+	_ = flags.Parse(args)
+
+	if *userName == "" || *userPass == "" || *dbPath == "" {
+		flags.PrintDefaults()
+		return
+	}
+
+	db := openRepository(*dbPath)
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(*userPass), bcrypt.DefaultCost)
+	failOnError("unable to create user", err)
+
+	err = db.AddUser(context.Background(), repository.AddUserParams{
+		Name:     *userName,
+		Password: string(hash),
+	})
+	failOnError("unable to create user", err)
+
+	fmt.Println("user created correctly")
+}
+
+// addMember gives the user access to the workspace
+func addMember(args []string) {
+	flags := flag.NewFlagSet("add-member", flag.ExitOnError)
+	workspaceName := flags.String("workspace", "", "workspace name")
+	userName := flags.String("user", "", "user name")
+	roleName := flags.String("role", string(middleware.RoleEditor), "member role: viewer, editor or admin")
+	dbPath := flags.String("db", "", "sqlite db path")
+	_ = flags.Parse(args)
+
+	if *workspaceName == "" || *userName == "" || *dbPath == "" {
+		flags.PrintDefaults()
+		return
+	}
+
+	role, err := middleware.ParseRole(*roleName)
+	failOnError("unable to add member", err)
+
+	db := openRepository(*dbPath)
+	workspaceID, userID := fetchMembership(db, *workspaceName, *userName)
+
+	err = db.AddWorkspaceMember(context.Background(), repository.AddWorkspaceMemberParams{
+		WorkspaceID: workspaceID,
+		UserID:      userID,
+		Role:        string(role),
+	})
+	failOnError("unable to add member", err)
+
+	fmt.Println("member added correctly")
+}
+
+// removeMember revokes the access of the user to the workspace, without
+// affecting the other members
+func removeMember(args []string) {
+	flags := flag.NewFlagSet("remove-member", flag.ExitOnError)
+	workspaceName := flags.String("workspace", "", "workspace name")
+	userName := flags.String("user", "", "user name")
+	dbPath := flags.String("db", "", "sqlite db path")
+	_ = flags.Parse(args)
+
+	if *workspaceName == "" || *userName == "" || *dbPath == "" {
+		flags.PrintDefaults()
+		return
+	}
+
+	db := openRepository(*dbPath)
+	workspaceID, userID := fetchMembership(db, *workspaceName, *userName)
+
+	removed, err := db.DeleteWorkspaceMember(context.Background(), repository.DeleteWorkspaceMemberParams{
+		WorkspaceID: workspaceID,
+		UserID:      userID,
+	})
+	failOnError("unable to remove member", err)
+
+	// the rules would apply again if the user was added back
+	err = db.DeleteMemberPathRules(context.Background(), repository.DeleteMemberPathRulesParams{
+		WorkspaceID: workspaceID,
+		UserID:      userID,
+	})
+	failOnError("unable to remove member rules", err)
+
+	if removed == 0 {
+		fmt.Printf("%s is not a member of %s\n", *userName, *workspaceName)
+		os.Exit(1)
+	}
+
+	fmt.Println("member removed correctly")
+}
+
+// setRole changes what the member is allowed to do in the workspace, it
+// applies to the next login
+func setRole(args []string) {
+	flags := flag.NewFlagSet("set-role", flag.ExitOnError)
+	workspaceName := flags.String("workspace", "", "workspace name")
+	userName := flags.String("user", "", "user name")
+	roleName := flags.String("role", "", "member role: viewer, editor or admin")
+	dbPath := flags.String("db", "", "sqlite db path")
+	_ = flags.Parse(args)
+
+	if *workspaceName == "" || *userName == "" || *roleName == "" || *dbPath == "" {
+		flags.PrintDefaults()
+		return
+	}
+
+	role, err := middleware.ParseRole(*roleName)
+	failOnError("unable to set role", err)
+
+	db := openRepository(*dbPath)
+	workspaceID, userID := fetchMembership(db, *workspaceName, *userName)
+
+	updated, err := db.UpdateWorkspaceMemberRole(context.Background(), repository.UpdateWorkspaceMemberRoleParams{
+		Role:        string(role),
+		WorkspaceID: workspaceID,
+		UserID:      userID,
+	})
+	failOnError("unable to set role", err)
+
+	if updated == 0 {
+		fmt.Printf("%s is not a member of %s\n", *userName, *workspaceName)
+		os.Exit(1)
+	}
+
+	fmt.Println("role updated correctly")
+}
+
+func listMembers(args []string) {
+	flags := flag.NewFlagSet("list-members", flag.ExitOnError)
+	workspaceName := flags.String("workspace", "", "workspace name")
+	dbPath := flags.String("db", "", "sqlite db path")
+	_ = flags.Parse(args)
+
+	if *workspaceName == "" || *dbPath == "" {
+		flags.PrintDefaults()
+		return
+	}
+
+	db := openRepository(*dbPath)
+
+	workspace, err := db.FetchWorkspace(context.Background(), *workspaceName)
+	failOnError("unable to find workspace", err)
+
+	members, err := db.FetchWorkspaceMembers(context.Background(), workspace.ID)
+	failOnError("unable to list members", err)
+
+	for _, member := range members {
+		fmt.Printf("%s\t%s\t(since %s)\n", member.Name, member.Role, member.CreatedAt.Format("2006-01-02"))
+	}
+}
+
+func fetchMembership(db *repository.Queries, workspaceName, userName string) (int64, int64) {
+	workspace, err := db.FetchWorkspace(context.Background(), workspaceName)
+	failOnError("unable to find workspace", err)
+
+	user, err := db.FetchUser(context.Background(), userName)
+	failOnError("unable to find user", err)
+
+	return workspace.ID, user.ID
+}
+
+func openRepository(dbPath string) *repository.Queries {
+	return repository.New(openDB(dbPath))
+}
+
+func openDB(dbPath string) *sql.DB {
+	dbSqlite, err := sql.Open("sqlite3", dbPath)
+	failOnError("unable to open db", err)
+
+	return dbSqlite
+}
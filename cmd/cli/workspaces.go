@@ -0,0 +1,229 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/hiimjako/syncinator/internal/repository"
+	syncinator "github.com/hiimjako/syncinator/pkg"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func listWorkspaces(args []string) {
+	flags := flag.NewFlagSet("list-workspaces", flag.ExitOnError)
+	dbPath := flags.String("db", "", "sqlite db path")
+	_ = flags.Parse(args)
+
+	if *dbPath == "" {
+		flags.PrintDefaults()
+		return
+	}
+
+	db := openRepository(*dbPath)
+
+	workspaces, err := db.FetchWorkspaces(context.Background())
+	failOnError("unable to list workspaces", err)
+
+	for _, workspace := range workspaces {
+		encryption := ""
+		if workspace.EndToEndEncrypted {
+			encryption = "\te2e"
+		}
+		fmt.Printf("%d\t%s\t(since %s)%s\n", workspace.ID, workspace.Name, workspace.CreatedAt.Time.Format("2006-01-02"), encryption)
+	}
+}
+
+// renameWorkspace changes the name used to log in, the tokens already issued
+// keep working since they refer to the workspace id
+func renameWorkspace(args []string) {
+	flags := flag.NewFlagSet("rename-workspace", flag.ExitOnError)
+	workspaceName := flags.String("workspace", "", "current workspace name")
+	newName := flags.String("name", "", "new workspace name")
+	dbPath := flags.String("db", "", "sqlite db path")
+	_ = flags.Parse(args)
+
+	if *workspaceName == "" || *newName == "" || *dbPath == "" {
+		flags.PrintDefaults()
+		return
+	}
+
+	db := openRepository(*dbPath)
+
+	workspace, err := db.FetchWorkspace(context.Background(), *workspaceName)
+	failOnError("unable to find workspace", err)
+
+	renamed, err := db.RenameWorkspace(context.Background(), repository.RenameWorkspaceParams{
+		Name: *newName,
+		ID:   workspace.ID,
+	})
+	failOnError("unable to rename workspace", err)
+
+	if renamed == 0 {
+		fmt.Printf("workspace %s was deleted meanwhile\n", *workspaceName)
+		os.Exit(1)
+	}
+
+	fmt.Println("workspace renamed correctly")
+}
+
+// setPassword changes the shared password of the workspace or, with -user,
+// the password of a user. The refresh tokens issued with the old password are
+// deleted, so that they can't be used to stay logged in.
+func setPassword(args []string) {
+	flags := flag.NewFlagSet("set-password", flag.ExitOnError)
+	workspaceName := flags.String("workspace", "", "workspace name")
+	userName := flags.String("user", "", "user name, instead of the workspace")
+	password := flags.String("pass", "", "new password")
+	dbPath := flags.String("db", "", "sqlite db path")
+	_ = flags.Parse(args)
+
+	if (*workspaceName == "") == (*userName == "") || *password == "" || *dbPath == "" {
+		fmt.Println("usage: cli set-password (-workspace <name> | -user <name>) -pass <password> -db <path>")
+		flags.PrintDefaults()
+		return
+	}
+
+	dbSqlite := openDB(*dbPath)
+	db := repository.New(dbSqlite)
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(*password), bcrypt.DefaultCost)
+	failOnError("unable to set password", err)
+
+	ctx := context.Background()
+	tx, err := dbSqlite.BeginTx(ctx, nil)
+	failOnError("unable to set password", err)
+	defer func() { _ = tx.Rollback() }()
+
+	txq := db.WithTx(tx)
+
+	if *userName != "" {
+		user, err := txq.FetchUser(ctx, *userName)
+		failOnError("unable to find user", err)
+
+		err = txq.UpdateUserPassword(ctx, repository.UpdateUserPasswordParams{
+			Password: string(hash),
+			ID:       user.ID,
+		})
+		failOnError("unable to set password", err)
+
+		err = txq.DeleteUserRefreshTokens(ctx, user.ID)
+		failOnError("unable to delete the refresh tokens", err)
+	} else {
+		workspace, err := txq.FetchWorkspace(ctx, *workspaceName)
+		failOnError("unable to find workspace", err)
+
+		err = txq.UpdateWorkspacePassword(ctx, repository.UpdateWorkspacePasswordParams{
+			Password: string(hash),
+			ID:       workspace.ID,
+		})
+		failOnError("unable to set password", err)
+
+		err = txq.DeleteSharedRefreshTokens(ctx, workspace.ID)
+		failOnError("unable to delete the refresh tokens", err)
+	}
+
+	err = tx.Commit()
+	failOnError("unable to set password", err)
+
+	fmt.Println("password updated correctly")
+}
+
+// deleteWorkspace removes the workspace with all its files and history. The
+// server must be stopped, otherwise it could write back the cached files: a
+// running server deletes them with DELETE /v1/api/workspace.
+func deleteWorkspace(args []string) {
+	flags := flag.NewFlagSet("delete-workspace", flag.ExitOnError)
+	workspaceName := flags.String("workspace", "", "workspace name")
+	dryRun := flags.Bool("dry-run", false, "only print what would be deleted")
+	dbPath := flags.String("db", "", "sqlite db path")
+	storageDir := flags.String("storage", defaultStorageDir, "storage directory")
+	_ = flags.Parse(args)
+
+	if *workspaceName == "" || *dbPath == "" {
+		flags.PrintDefaults()
+		return
+	}
+
+	dbSqlite := openDB(*dbPath)
+	db := repository.New(dbSqlite)
+	storage := openStorage(*storageDir, dbSqlite)
+
+	workspace, err := db.FetchWorkspace(context.Background(), *workspaceName)
+	failOnError("unable to find workspace", err)
+
+	deletion, err := syncinator.DeleteWorkspace(context.Background(), dbSqlite, storage, workspace.ID, *dryRun)
+	failOnError("unable to delete workspace", err)
+
+	summary := fmt.Sprintf("%d files, %d snapshots, %d operations, %d events, %d members, %d api keys, %d storage objects",
+		deletion.Files, deletion.Snapshots, deletion.Operations, deletion.Events, deletion.Members, deletion.APIKeys, deletion.Objects)
+	if *dryRun {
+		fmt.Printf("workspace %s would be deleted with %s\n", *workspaceName, summary)
+		return
+	}
+
+	fmt.Printf("workspace deleted correctly: %s\n", summary)
+}
+
+// storageUsage prints the files and the snapshots of every workspace with
+// the size of their contents, as read through the storage
+func storageUsage(args []string) {
+	flags := flag.NewFlagSet("storage-usage", flag.ExitOnError)
+	dbPath := flags.String("db", "", "sqlite db path")
+	storageDir := flags.String("storage", defaultStorageDir, "storage directory")
+	_ = flags.Parse(args)
+
+	if *dbPath == "" {
+		flags.PrintDefaults()
+		return
+	}
+
+	dbSqlite := openDB(*dbPath)
+	db := repository.New(dbSqlite)
+	storage := openStorage(*storageDir, dbSqlite)
+
+	workspaces, err := db.FetchWorkspaces(context.Background())
+	failOnError("unable to list workspaces", err)
+
+	var total int64
+	for _, workspace := range workspaces {
+		files, err := db.FetchWorkspaceFiles(context.Background(), workspace.ID)
+		failOnError("unable to list files", err)
+		deletedFiles, err := db.FetchWorkspaceDeletedFiles(context.Background(), workspace.ID)
+		failOnError("unable to list deleted files", err)
+		snapshots, err := db.FetchWorkspaceSnapshots(context.Background(), workspace.ID)
+		failOnError("unable to list snapshots", err)
+
+		// an object referenced by more than one row is counted once, the
+		// last content of the deleted files is part of the history
+		counted := make(map[string]struct{})
+		var filesSize, snapshotsSize int64
+		for _, file := range files {
+			counted[file.DiskPath] = struct{}{}
+			filesSize += max(objectSize(storage, file.DiskPath), 0)
+		}
+		history := make([]string, 0, len(deletedFiles)+len(snapshots))
+		for _, file := range deletedFiles {
+			if file.DiskPath != "" {
+				history = append(history, file.DiskPath)
+			}
+		}
+		for _, snapshot := range snapshots {
+			history = append(history, snapshot.DiskPath)
+		}
+		for _, diskPath := range history {
+			if _, ok := counted[diskPath]; ok {
+				continue
+			}
+			counted[diskPath] = struct{}{}
+			snapshotsSize += max(objectSize(storage, diskPath), 0)
+		}
+		total += filesSize + snapshotsSize
+
+		fmt.Printf("%s\t%d files\t%s\t%d snapshots\t%s\n",
+			workspace.Name, len(files), formatSize(filesSize), len(snapshots), formatSize(snapshotsSize))
+	}
+
+	fmt.Printf("total\t%s\n", formatSize(total))
+}
@@ -3,7 +3,9 @@ package main
 import (
 	"context"
 	"database/sql"
+	"errors"
 	"flag"
+	"fmt"
 	"log"
 	"net"
 	"net/http"
@@ -14,8 +16,9 @@ import (
 
 	"github.com/hiimjako/syncinator/internal/env"
 	"github.com/hiimjako/syncinator/internal/migration"
+	"github.com/hiimjako/syncinator/internal/storage"
 	syncinator "github.com/hiimjako/syncinator/pkg"
-	"github.com/hiimjako/syncinator/pkg/filestorage"
+	"github.com/hiimjako/syncinator/pkg/middleware"
 
 	_ "github.com/mattn/go-sqlite3"
 )
@@ -59,17 +62,52 @@ func run(ev *env.EnvVariables) error {
 	}
 	log.Printf("listening on http://%v", l.Addr())
 
-	disk := filestorage.NewDisk(ev.StorageDir)
-
-	handler := syncinator.New(dbSqlite, disk, syncinator.Options{
-		JWTSecret:            ev.JWTSecret,
-		OperationTTL:         ev.OperationTTL,
-		CacheSize:            ev.CacheSize,
-		MaxFileSizeMB:        ev.MaxFileSizeMB,
-		MinChangesThreshold:  ev.MinChangesThreshold,
-		FlushInterval:        ev.FlushInterval,
-		SnapshotCheckpoint:   ev.SnapshotCheckpoint,
-		MaxSnapshotDiffChain: ev.MaxSnapshotDiffChain,
+	snapshotRetention, err := syncinator.ParseRetentionPolicy(ev.SnapshotRetention)
+	if err != nil {
+		return err
+	}
+
+	jwtKeys, err := newKeySet(ev)
+	if err != nil {
+		return fmt.Errorf("loading jwt keys: %w", err)
+	}
+
+	fileStorage, err := storage.Open(&ev.StorageVariables, dbSqlite)
+	if err != nil {
+		return err
+	}
+
+	var oidc *syncinator.OIDCOptions
+	if ev.OIDCIssuer != "" {
+		if ev.OIDCClientID == "" || ev.OIDCRedirectURL == "" {
+			return errors.New("OIDC_CLIENT_ID and OIDC_REDIRECT_URL are required with OIDC_ISSUER")
+		}
+		oidc = &syncinator.OIDCOptions{
+			Issuer:        ev.OIDCIssuer,
+			ClientID:      ev.OIDCClientID,
+			ClientSecret:  ev.OIDCClientSecret,
+			RedirectURL:   ev.OIDCRedirectURL,
+			UsernameClaim: ev.OIDCUsernameClaim,
+		}
+	}
+
+	handler := syncinator.New(dbSqlite, fileStorage, syncinator.Options{
+		JWTSecret:                  ev.JWTSecret,
+		JWTKeys:                    jwtKeys,
+		OperationTTL:               ev.OperationTTL,
+		CacheSize:                  ev.CacheSize,
+		MaxFileSizeMB:              ev.MaxFileSizeMB,
+		MinChangesThreshold:        ev.MinChangesThreshold,
+		FlushInterval:              ev.FlushInterval,
+		SnapshotCheckpoint:         ev.SnapshotCheckpoint,
+		MaxSnapshotDiffChain:       ev.MaxSnapshotDiffChain,
+		BinarySnapshotRetention:    ev.BinarySnapshotRetention,
+		SnapshotRetention:          snapshotRetention,
+		SnapshotCompactionInterval: ev.SnapshotCompactionInterval,
+		RefreshTokenTTL:            ev.RefreshTokenTTL,
+		OIDC:                       oidc,
+		LoginFreeAttempts:          ev.LoginFreeAttempts,
+		LoginLockout:               ev.LoginLockout,
 	})
 	defer handler.Close()
 
@@ -98,3 +136,85 @@ func run(ev *env.EnvVariables) error {
 
 	return s.Shutdown(ctx)
 }
+
+// newKeySet loads the keys of the tokens: the private keys sign them, with
+// JWT_SECRET still verifying the tokens issued before they were configured,
+// while the retired keys only verify them until their retirement time.
+func newKeySet(ev *env.EnvVariables) (*middleware.KeySet, error) {
+	var keys []middleware.SigningKey
+	for _, path := range ev.JWTPrivateKeys {
+		key, err := readPrivateKey(path)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	if len(ev.JWTSecret) > 0 {
+		keys = append(keys, middleware.NewHMACKey(ev.JWTSecret))
+	}
+
+	retiredUntil, err := parseRetiredUntil(ev.JWTRetiredUntil, len(ev.JWTRetiredKeys)+len(ev.JWTRetiredSecrets))
+	if err != nil {
+		return nil, err
+	}
+	for i, path := range ev.JWTRetiredKeys {
+		key, err := readPrivateKey(path)
+		if err != nil {
+			return nil, err
+		}
+		key.VerifyUntil = retiredUntil[i]
+		keys = append(keys, key)
+	}
+	for i, secret := range ev.JWTRetiredSecrets {
+		key := middleware.NewHMACKey([]byte(secret))
+		key.VerifyUntil = retiredUntil[len(ev.JWTRetiredKeys)+i]
+		keys = append(keys, key)
+	}
+
+	if len(keys) == 0 {
+		return nil, errors.New("either JWT_SECRET or JWT_PRIVATE_KEYS must be set")
+	}
+
+	return middleware.NewKeySet(keys...)
+}
+
+// parseRetiredUntil returns the retirement time of each of the n retired keys,
+// fixed so that restarting the server doesn't extend it
+func parseRetiredUntil(values []string, n int) ([]time.Time, error) {
+	if n == 0 {
+		return nil, nil
+	}
+	if len(values) != 1 && len(values) != n {
+		return nil, fmt.Errorf("JWT_RETIRED_UNTIL must have one time, or one for each of the %d retired keys", n)
+	}
+
+	times := make([]time.Time, n)
+	for i := range times {
+		value := values[0]
+		if len(values) == n {
+			value = values[i]
+		}
+
+		t, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid JWT_RETIRED_UNTIL %q: %w", value, err)
+		}
+		times[i] = t
+	}
+
+	return times, nil
+}
+
+func readPrivateKey(path string) (middleware.SigningKey, error) {
+	pemBytes, err := os.ReadFile(path)
+	if err != nil {
+		return middleware.SigningKey{}, fmt.Errorf("reading %s: %w", path, err)
+	}
+
+	key, err := middleware.ParsePrivateKey(pemBytes)
+	if err != nil {
+		return middleware.SigningKey{}, fmt.Errorf("loading %s: %w", path, err)
+	}
+
+	return key, nil
+}
@@ -10,20 +10,62 @@ import (
 	"github.com/sethvargo/go-envconfig"
 )
 
+// StorageVariables configure where the objects are stored, they are shared by
+// the server and the cli
+type StorageVariables struct {
+	// disk or s3
+	StorageBackend    string `env:"STORAGE_BACKEND,default=disk"`
+	StorageDir        string `env:"STORAGE_DIR,default=./data"`
+	S3Endpoint        string `env:"S3_ENDPOINT"`
+	S3Region          string `env:"S3_REGION,default=us-east-1"`
+	S3Bucket          string `env:"S3_BUCKET"`
+	S3AccessKeyID     string `env:"S3_ACCESS_KEY_ID"`
+	S3SecretAccessKey string `env:"S3_SECRET_ACCESS_KEY"`
+	S3Prefix          string `env:"S3_PREFIX"`
+	StorageDedup      bool   `env:"STORAGE_DEDUP,default=false"`
+	// base64 of 32 random bytes, empty disables encryption
+	StorageMasterKey string `env:"STORAGE_MASTER_KEY"`
+	// rejects the objects left unencrypted, once they have been rewritten
+	StorageEncryptionStrict bool `env:"STORAGE_ENCRYPTION_STRICT,default=false"`
+}
+
 type EnvVariables struct {
 	Host string `env:"HOST,default=0.0.0.0"`
 	Port string `env:"PORT,default=8080"`
 
-	StorageDir           string        `env:"STORAGE_DIR,default=./data"`
-	SqliteFilepath       string        `env:"SQLITE_FILEPATH,default=./data/db.sqlite3"`
-	JWTSecret            []byte        `env:"JWT_SECRET,required"`
-	OperationTTL         time.Duration `env:"OPERATION_TTL,default=1h"`
-	CacheSize            int           `env:"CACHE_SIZE,default=128"`
-	FlushInterval        time.Duration `env:"FLUSH_INTERVAL,default=1m"`
-	MaxFileSizeMB        int64         `env:"MAX_FILE_SIZE,default=1024"`
-	MinChangesThreshold  int64         `env:"MIN_CHANGES_THRESHOLD,default=5"`
-	SnapshotCheckpoint   int64         `env:"SNAPSHOT_CHECKPOINT,default=5"`
-	MaxSnapshotDiffChain int64         `env:"MAX_SNAPSHOT_DIFF_CHAIN,default=10"`
+	StorageVariables
+
+	SqliteFilepath             string        `env:"SQLITE_FILEPATH,default=./data/db.sqlite3"`
+	JWTSecret                  []byte        `env:"JWT_SECRET"`
+	OperationTTL               time.Duration `env:"OPERATION_TTL,default=1h"`
+	CacheSize                  int           `env:"CACHE_SIZE,default=128"`
+	FlushInterval              time.Duration `env:"FLUSH_INTERVAL,default=1m"`
+	MaxFileSizeMB              int64         `env:"MAX_FILE_SIZE,default=1024"`
+	MinChangesThreshold        int64         `env:"MIN_CHANGES_THRESHOLD,default=5"`
+	SnapshotCheckpoint         int64         `env:"SNAPSHOT_CHECKPOINT,default=5"`
+	MaxSnapshotDiffChain       int64         `env:"MAX_SNAPSHOT_DIFF_CHAIN,default=10"`
+	BinarySnapshotRetention    int64         `env:"BINARY_SNAPSHOT_RETENTION,default=10"`
+	SnapshotRetention          string        `env:"SNAPSHOT_RETENTION,default=24h:all;168h:1h;720h:24h"`
+	SnapshotCompactionInterval time.Duration `env:"SNAPSHOT_COMPACTION_INTERVAL,default=1h"`
+	RefreshTokenTTL            time.Duration `env:"REFRESH_TOKEN_TTL,default=720h"`
+	LoginFreeAttempts          int           `env:"LOGIN_FREE_ATTEMPTS,default=5"`
+	LoginLockout               time.Duration `env:"LOGIN_LOCKOUT,default=15m"`
+
+	// paths of PEM encoded RSA or Ed25519 keys, the first one signs the tokens
+	JWTPrivateKeys []string `env:"JWT_PRIVATE_KEYS"`
+	// keys and secrets which don't sign anymore, but still verify the tokens
+	// until the RFC 3339 times of JWT_RETIRED_UNTIL, one for each key and
+	// then secret, or a single one for all of them
+	JWTRetiredKeys    []string `env:"JWT_RETIRED_KEYS"`
+	JWTRetiredSecrets []string `env:"JWT_RETIRED_SECRETS"`
+	JWTRetiredUntil   []string `env:"JWT_RETIRED_UNTIL"`
+
+	// empty disables the login with OpenID Connect
+	OIDCIssuer        string `env:"OIDC_ISSUER"`
+	OIDCClientID      string `env:"OIDC_CLIENT_ID"`
+	OIDCClientSecret  string `env:"OIDC_CLIENT_SECRET"`
+	OIDCRedirectURL   string `env:"OIDC_REDIRECT_URL"`
+	OIDCUsernameClaim string `env:"OIDC_USERNAME_CLAIM,default=email"`
 }
 
 func LoadEnv(paths ...string) *EnvVariables {
@@ -49,3 +91,14 @@ func LoadEnv(paths ...string) *EnvVariables {
 
 	return &env
 }
+
+// LoadStorageEnv reads the storage variables alone, for the tools working on
+// the objects of the server
+func LoadStorageEnv() (*StorageVariables, error) {
+	var env StorageVariables
+	if err := envconfig.Process(context.Background(), &env); err != nil {
+		return nil, err
+	}
+
+	return &env, nil
+}
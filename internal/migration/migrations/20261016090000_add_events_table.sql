@@ -0,0 +1,21 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE events (
+  seq INTEGER PRIMARY KEY AUTOINCREMENT,
+  workspace_id INTEGER NOT NULL,
+  file_id INTEGER NOT NULL,
+  type INTEGER NOT NULL,
+  workspace_path TEXT NOT NULL,
+  object_type TEXT NOT NULL,
+  created_at DATETIME DEFAULT CURRENT_TIMESTAMP NOT NULL,
+  FOREIGN KEY (workspace_id) REFERENCES workspaces (id)
+);
+
+CREATE INDEX events_workspace_seq ON events (workspace_id, seq);
+
+-- +goose StatementEnd
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE events;
+
+-- +goose StatementEnd
@@ -0,0 +1,35 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE workspaces
+ADD COLUMN change_seq INTEGER DEFAULT 0 NOT NULL;
+
+ALTER TABLE files
+ADD COLUMN change_seq INTEGER DEFAULT 0 NOT NULL;
+
+CREATE INDEX files_workspace_change_seq ON files (workspace_id, change_seq);
+
+CREATE TABLE deleted_files (
+  file_id INTEGER PRIMARY KEY,
+  workspace_id INTEGER NOT NULL,
+  workspace_path TEXT NOT NULL,
+  change_seq INTEGER NOT NULL,
+  deleted_at DATETIME DEFAULT CURRENT_TIMESTAMP NOT NULL,
+  FOREIGN KEY (workspace_id) REFERENCES workspaces (id)
+);
+
+CREATE INDEX deleted_files_workspace_change_seq ON deleted_files (workspace_id, change_seq);
+
+-- +goose StatementEnd
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE deleted_files;
+
+DROP INDEX files_workspace_change_seq;
+
+ALTER TABLE files
+DROP COLUMN change_seq;
+
+ALTER TABLE workspaces
+DROP COLUMN change_seq;
+
+-- +goose StatementEnd
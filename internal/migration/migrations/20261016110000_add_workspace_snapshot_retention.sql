@@ -0,0 +1,12 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE workspaces
+ADD COLUMN binary_snapshot_retention INTEGER DEFAULT 0 NOT NULL;
+
+-- +goose StatementEnd
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE workspaces
+DROP COLUMN binary_snapshot_retention;
+
+-- +goose StatementEnd
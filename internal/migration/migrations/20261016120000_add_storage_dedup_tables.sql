@@ -0,0 +1,28 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE storage_blobs (
+  hash TEXT PRIMARY KEY,
+  disk_path TEXT NOT NULL,
+  ref_count INTEGER NOT NULL,
+  created_at DATETIME DEFAULT CURRENT_TIMESTAMP NOT NULL
+);
+
+CREATE TABLE storage_objects (
+  path TEXT PRIMARY KEY,
+  hash TEXT NOT NULL,
+  created_at DATETIME DEFAULT CURRENT_TIMESTAMP NOT NULL,
+  FOREIGN KEY (hash) REFERENCES storage_blobs (hash)
+);
+
+CREATE INDEX storage_objects_hash ON storage_objects (hash);
+
+-- +goose StatementEnd
+-- +goose Down
+-- +goose StatementBegin
+DROP INDEX storage_objects_hash;
+
+DROP TABLE storage_objects;
+
+DROP TABLE storage_blobs;
+
+-- +goose StatementEnd
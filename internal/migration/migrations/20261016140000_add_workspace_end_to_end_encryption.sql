@@ -0,0 +1,12 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE workspaces
+ADD COLUMN end_to_end_encrypted BOOLEAN DEFAULT FALSE NOT NULL;
+
+-- +goose StatementEnd
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE workspaces
+DROP COLUMN end_to_end_encrypted;
+
+-- +goose StatementEnd
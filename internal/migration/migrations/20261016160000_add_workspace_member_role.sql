@@ -0,0 +1,12 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE workspace_members
+ADD COLUMN role TEXT DEFAULT 'editor' NOT NULL CHECK (role IN ('viewer', 'editor', 'admin'));
+
+-- +goose StatementEnd
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE workspace_members
+DROP COLUMN role;
+
+-- +goose StatementEnd
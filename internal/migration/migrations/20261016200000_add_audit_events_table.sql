@@ -0,0 +1,36 @@
+-- +goose Up
+-- +goose StatementBegin
+-- audit_events outlives the workspaces, so there is no foreign key on them
+CREATE TABLE audit_events (
+  id INTEGER PRIMARY KEY AUTOINCREMENT,
+  -- 0 when the workspace is unknown, e.g. a failed login to a missing one
+  workspace_id INTEGER NOT NULL,
+  -- "user:<id>", "apikey:<id>", "workspace" for the shared password or
+  -- "anonymous" for failed logins
+  actor TEXT NOT NULL,
+  action TEXT NOT NULL,
+  -- the workspace path of the file, if any
+  target TEXT NOT NULL DEFAULT '',
+  details TEXT NOT NULL DEFAULT '',
+  ip TEXT NOT NULL DEFAULT '',
+  created_at DATETIME NOT NULL
+);
+
+CREATE INDEX audit_events_workspace_created_at ON audit_events (workspace_id, created_at);
+
+CREATE TRIGGER audit_events_no_update BEFORE UPDATE ON audit_events
+BEGIN
+  SELECT RAISE(ABORT, 'audit events are append-only');
+END;
+
+CREATE TRIGGER audit_events_no_delete BEFORE DELETE ON audit_events
+BEGIN
+  SELECT RAISE(ABORT, 'audit events are append-only');
+END;
+
+-- +goose StatementEnd
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE audit_events;
+
+-- +goose StatementEnd
@@ -0,0 +1,28 @@
+-- +goose Up
+-- +goose StatementBegin
+-- the version of the content stored in disk_path: the operations after it
+-- are the only copy of the edits of the end-to-end encrypted files
+ALTER TABLE files
+ADD COLUMN blob_version INTEGER DEFAULT 0 NOT NULL;
+
+UPDATE files
+SET blob_version = version;
+
+-- the version the operation was made on, older than the previous one for
+-- the encrypted operations that won over the concurrent ones
+ALTER TABLE operations
+ADD COLUMN base_version INTEGER DEFAULT 0 NOT NULL;
+
+UPDATE operations
+SET base_version = version - 1;
+
+-- +goose StatementEnd
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE operations
+DROP COLUMN base_version;
+
+ALTER TABLE files
+DROP COLUMN blob_version;
+
+-- +goose StatementEnd
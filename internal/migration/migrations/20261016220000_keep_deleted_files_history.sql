@@ -0,0 +1,215 @@
+-- +goose Up
+-- +goose StatementBegin
+-- the snapshots and the operations of the deleted files are kept until the
+-- retention window expires, they can't reference the files anymore
+CREATE TABLE snapshots_new (
+  file_id INTEGER NOT NULL,
+  version INTEGER NOT NULL,
+  disk_path TEXT NOT NULL,
+  hash TEXT NOT NULL,
+  created_at DATETIME DEFAULT CURRENT_TIMESTAMP NOT NULL,
+  type TEXT CHECK (type IN ('file', 'diff')) NOT NULL,
+  workspace_id INTEGER NOT NULL,
+  PRIMARY KEY (file_id, version),
+  FOREIGN KEY (workspace_id) REFERENCES workspaces (id)
+);
+
+INSERT INTO
+  snapshots_new
+SELECT
+  file_id,
+  version,
+  disk_path,
+  hash,
+  created_at,
+  type,
+  workspace_id
+FROM
+  snapshots;
+
+DROP TABLE snapshots;
+
+ALTER TABLE snapshots_new
+RENAME TO snapshots;
+
+CREATE TABLE operations_new (
+  file_id INTEGER NOT NULL,
+  version INTEGER NOT NULL,
+  operation TEXT CHECK (json_valid(operation)) NOT NULL,
+  created_at DATETIME DEFAULT CURRENT_TIMESTAMP NOT NULL,
+  base_version INTEGER DEFAULT 0 NOT NULL,
+  PRIMARY KEY (file_id, version)
+);
+
+INSERT INTO
+  operations_new
+SELECT
+  file_id,
+  version,
+  operation,
+  created_at,
+  base_version
+FROM
+  operations;
+
+DROP TABLE operations;
+
+ALTER TABLE operations_new
+RENAME TO operations;
+
+-- the last content of the deleted file, emptied when its history is purged,
+-- as it already is for the files deleted so far
+CREATE TABLE deleted_files_new (
+  file_id INTEGER PRIMARY KEY,
+  workspace_id INTEGER NOT NULL,
+  workspace_path TEXT NOT NULL,
+  change_seq INTEGER NOT NULL,
+  deleted_at DATETIME DEFAULT CURRENT_TIMESTAMP NOT NULL,
+  disk_path TEXT DEFAULT '' NOT NULL,
+  mime_type TEXT DEFAULT '' NOT NULL,
+  version INTEGER DEFAULT 0 NOT NULL,
+  file_created_at DATETIME DEFAULT CURRENT_TIMESTAMP NOT NULL,
+  file_updated_at DATETIME DEFAULT CURRENT_TIMESTAMP NOT NULL,
+  FOREIGN KEY (workspace_id) REFERENCES workspaces (id)
+);
+
+INSERT INTO
+  deleted_files_new (
+    file_id,
+    workspace_id,
+    workspace_path,
+    change_seq,
+    deleted_at,
+    file_created_at,
+    file_updated_at
+  )
+SELECT
+  file_id,
+  workspace_id,
+  workspace_path,
+  change_seq,
+  deleted_at,
+  COALESCE(
+    (
+      SELECT
+        MIN(e.created_at)
+      FROM
+        events e
+      WHERE
+        e.file_id = deleted_files.file_id
+    ),
+    deleted_at
+  ),
+  deleted_at
+FROM
+  deleted_files;
+
+DROP TABLE deleted_files;
+
+ALTER TABLE deleted_files_new
+RENAME TO deleted_files;
+
+CREATE INDEX deleted_files_workspace_change_seq ON deleted_files (workspace_id, change_seq);
+
+-- +goose StatementEnd
+-- +goose Down
+-- +goose StatementBegin
+CREATE TABLE deleted_files_old (
+  file_id INTEGER PRIMARY KEY,
+  workspace_id INTEGER NOT NULL,
+  workspace_path TEXT NOT NULL,
+  change_seq INTEGER NOT NULL,
+  deleted_at DATETIME DEFAULT CURRENT_TIMESTAMP NOT NULL,
+  FOREIGN KEY (workspace_id) REFERENCES workspaces (id)
+);
+
+INSERT INTO
+  deleted_files_old
+SELECT
+  file_id,
+  workspace_id,
+  workspace_path,
+  change_seq,
+  deleted_at
+FROM
+  deleted_files;
+
+DROP TABLE deleted_files;
+
+ALTER TABLE deleted_files_old
+RENAME TO deleted_files;
+
+CREATE INDEX deleted_files_workspace_change_seq ON deleted_files (workspace_id, change_seq);
+
+CREATE TABLE operations_old (
+  file_id INTEGER NOT NULL,
+  version INTEGER NOT NULL,
+  operation TEXT CHECK (json_valid(operation)) NOT NULL,
+  created_at DATETIME DEFAULT CURRENT_TIMESTAMP NOT NULL,
+  base_version INTEGER DEFAULT 0 NOT NULL,
+  PRIMARY KEY (file_id, version),
+  FOREIGN KEY (file_id) REFERENCES files (id)
+);
+
+INSERT INTO
+  operations_old
+SELECT
+  file_id,
+  version,
+  operation,
+  created_at,
+  base_version
+FROM
+  operations
+WHERE
+  file_id IN (
+    SELECT
+      id
+    FROM
+      files
+  );
+
+DROP TABLE operations;
+
+ALTER TABLE operations_old
+RENAME TO operations;
+
+CREATE TABLE snapshots_old (
+  file_id INTEGER NOT NULL,
+  version INTEGER NOT NULL,
+  disk_path TEXT NOT NULL,
+  hash TEXT NOT NULL,
+  created_at DATETIME DEFAULT CURRENT_TIMESTAMP NOT NULL,
+  type TEXT CHECK (type IN ('file', 'diff')) NOT NULL,
+  workspace_id INTEGER NOT NULL,
+  PRIMARY KEY (file_id, version),
+  FOREIGN KEY (file_id) REFERENCES files (id),
+  FOREIGN KEY (workspace_id) REFERENCES workspaces (id)
+);
+
+INSERT INTO
+  snapshots_old
+SELECT
+  file_id,
+  version,
+  disk_path,
+  hash,
+  created_at,
+  type,
+  workspace_id
+FROM
+  snapshots
+WHERE
+  file_id IN (
+    SELECT
+      id
+    FROM
+      files
+  );
+
+DROP TABLE snapshots;
+
+ALTER TABLE snapshots_old
+RENAME TO snapshots;
+
+-- +goose StatementEnd
@@ -7,12 +7,56 @@ package repository
 
 import (
 	"context"
+	"time"
 )
 
+const clearDeletedFileHistory = `-- name: ClearDeletedFileHistory :exec
+UPDATE deleted_files
+SET disk_path = ''
+WHERE file_id = ?
+`
+
+func (q *Queries) ClearDeletedFileHistory(ctx context.Context, fileID int64) error {
+	_, err := q.db.ExecContext(ctx, clearDeletedFileHistory, fileID)
+	return err
+}
+
+const createDeletedFile = `-- name: CreateDeletedFile :exec
+INSERT INTO deleted_files (file_id, workspace_id, workspace_path, change_seq, disk_path, mime_type, version, file_created_at, file_updated_at)
+VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+`
+
+type CreateDeletedFileParams struct {
+	FileID        int64     `json:"fileId"`
+	WorkspaceID   int64     `json:"workspaceId"`
+	WorkspacePath string    `json:"workspacePath"`
+	ChangeSeq     int64     `json:"changeSeq"`
+	DiskPath      string    `json:"diskPath"`
+	MimeType      string    `json:"mimeType"`
+	Version       int64     `json:"version"`
+	FileCreatedAt time.Time `json:"fileCreatedAt"`
+	FileUpdatedAt time.Time `json:"fileUpdatedAt"`
+}
+
+func (q *Queries) CreateDeletedFile(ctx context.Context, arg CreateDeletedFileParams) error {
+	_, err := q.db.ExecContext(ctx, createDeletedFile,
+		arg.FileID,
+		arg.WorkspaceID,
+		arg.WorkspacePath,
+		arg.ChangeSeq,
+		arg.DiskPath,
+		arg.MimeType,
+		arg.Version,
+		arg.FileCreatedAt,
+		arg.FileUpdatedAt,
+	)
+	return err
+}
+
 const createFile = `-- name: CreateFile :one
-INSERT INTO files (disk_path, workspace_path, mime_type, hash, workspace_id)
-VALUES (?, ?, ?, ?, ?)
-RETURNING id, disk_path, workspace_path, mime_type, hash, created_at, updated_at, version, workspace_id
+INSERT INTO files (disk_path, workspace_path, mime_type, hash, workspace_id, change_seq)
+VALUES (?, ?, ?, ?, ?, ?)
+RETURNING id, disk_path, workspace_path, mime_type, hash, created_at, updated_at, version, workspace_id, change_seq, blob_version
 `
 
 type CreateFileParams struct {
@@ -21,6 +65,7 @@ type CreateFileParams struct {
 	MimeType      string `json:"mimeType"`
 	Hash          string `json:"hash"`
 	WorkspaceID   int64  `json:"workspaceId"`
+	ChangeSeq     int64  `json:"changeSeq"`
 }
 
 func (q *Queries) CreateFile(ctx context.Context, arg CreateFileParams) (File, error) {
@@ -30,6 +75,7 @@ func (q *Queries) CreateFile(ctx context.Context, arg CreateFileParams) (File, e
 		arg.MimeType,
 		arg.Hash,
 		arg.WorkspaceID,
+		arg.ChangeSeq,
 	)
 	var i File
 	err := row.Scan(
@@ -42,6 +88,8 @@ func (q *Queries) CreateFile(ctx context.Context, arg CreateFileParams) (File, e
 		&i.UpdatedAt,
 		&i.Version,
 		&i.WorkspaceID,
+		&i.ChangeSeq,
+		&i.BlobVersion,
 	)
 	return i, err
 }
@@ -56,8 +104,31 @@ func (q *Queries) DeleteFile(ctx context.Context, id int64) error {
 	return err
 }
 
+const deleteWorkspaceDeletedFiles = `-- name: DeleteWorkspaceDeletedFiles :exec
+DELETE FROM deleted_files
+WHERE workspace_id = ?
+`
+
+func (q *Queries) DeleteWorkspaceDeletedFiles(ctx context.Context, workspaceID int64) error {
+	_, err := q.db.ExecContext(ctx, deleteWorkspaceDeletedFiles, workspaceID)
+	return err
+}
+
+const deleteWorkspaceFiles = `-- name: DeleteWorkspaceFiles :execrows
+DELETE FROM files
+WHERE workspace_id = ?
+`
+
+func (q *Queries) DeleteWorkspaceFiles(ctx context.Context, workspaceID int64) (int64, error) {
+	result, err := q.db.ExecContext(ctx, deleteWorkspaceFiles, workspaceID)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
 const fetchAllFiles = `-- name: FetchAllFiles :many
-SELECT id, disk_path, workspace_path, mime_type, hash, created_at, updated_at, version, workspace_id
+SELECT id, disk_path, workspace_path, mime_type, hash, created_at, updated_at, version, workspace_id, change_seq, blob_version
 FROM files
 `
 
@@ -80,6 +151,8 @@ func (q *Queries) FetchAllFiles(ctx context.Context) ([]File, error) {
 			&i.UpdatedAt,
 			&i.Version,
 			&i.WorkspaceID,
+			&i.ChangeSeq,
+			&i.BlobVersion,
 		); err != nil {
 			return nil, err
 		}
@@ -95,7 +168,7 @@ func (q *Queries) FetchAllFiles(ctx context.Context) ([]File, error) {
 }
 
 const fetchAllTextFiles = `-- name: FetchAllTextFiles :many
-SELECT id, disk_path, workspace_path, mime_type, hash, created_at, updated_at, version, workspace_id
+SELECT id, disk_path, workspace_path, mime_type, hash, created_at, updated_at, version, workspace_id, change_seq, blob_version
 FROM files
 WHERE mime_type LIKE 'text/%'
 `
@@ -119,6 +192,119 @@ func (q *Queries) FetchAllTextFiles(ctx context.Context) ([]File, error) {
 			&i.UpdatedAt,
 			&i.Version,
 			&i.WorkspaceID,
+			&i.ChangeSeq,
+			&i.BlobVersion,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const fetchDeletedFile = `-- name: FetchDeletedFile :one
+SELECT file_id, workspace_id, workspace_path, change_seq, deleted_at, disk_path, mime_type, version, file_created_at, file_updated_at
+FROM deleted_files
+WHERE file_id = ?
+LIMIT 1
+`
+
+func (q *Queries) FetchDeletedFile(ctx context.Context, fileID int64) (DeletedFile, error) {
+	row := q.db.QueryRowContext(ctx, fetchDeletedFile, fileID)
+	var i DeletedFile
+	err := row.Scan(
+		&i.FileID,
+		&i.WorkspaceID,
+		&i.WorkspacePath,
+		&i.ChangeSeq,
+		&i.DeletedAt,
+		&i.DiskPath,
+		&i.MimeType,
+		&i.Version,
+		&i.FileCreatedAt,
+		&i.FileUpdatedAt,
+	)
+	return i, err
+}
+
+const fetchDeletedFilesSince = `-- name: FetchDeletedFilesSince :many
+SELECT file_id, workspace_id, workspace_path, change_seq, deleted_at, disk_path, mime_type, version, file_created_at, file_updated_at
+FROM deleted_files
+WHERE workspace_id = ? AND change_seq > ?
+ORDER BY change_seq ASC
+`
+
+type FetchDeletedFilesSinceParams struct {
+	WorkspaceID int64 `json:"workspaceId"`
+	ChangeSeq   int64 `json:"changeSeq"`
+}
+
+func (q *Queries) FetchDeletedFilesSince(ctx context.Context, arg FetchDeletedFilesSinceParams) ([]DeletedFile, error) {
+	rows, err := q.db.QueryContext(ctx, fetchDeletedFilesSince, arg.WorkspaceID, arg.ChangeSeq)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []DeletedFile
+	for rows.Next() {
+		var i DeletedFile
+		if err := rows.Scan(
+			&i.FileID,
+			&i.WorkspaceID,
+			&i.WorkspacePath,
+			&i.ChangeSeq,
+			&i.DeletedAt,
+			&i.DiskPath,
+			&i.MimeType,
+			&i.Version,
+			&i.FileCreatedAt,
+			&i.FileUpdatedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const fetchDeletedFilesWithHistoryBefore = `-- name: FetchDeletedFilesWithHistoryBefore :many
+SELECT file_id, workspace_id, workspace_path, change_seq, deleted_at, disk_path, mime_type, version, file_created_at, file_updated_at
+FROM deleted_files
+WHERE disk_path != '' AND deleted_at < ?
+`
+
+func (q *Queries) FetchDeletedFilesWithHistoryBefore(ctx context.Context, deletedAt time.Time) ([]DeletedFile, error) {
+	rows, err := q.db.QueryContext(ctx, fetchDeletedFilesWithHistoryBefore, deletedAt)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []DeletedFile
+	for rows.Next() {
+		var i DeletedFile
+		if err := rows.Scan(
+			&i.FileID,
+			&i.WorkspaceID,
+			&i.WorkspacePath,
+			&i.ChangeSeq,
+			&i.DeletedAt,
+			&i.DiskPath,
+			&i.MimeType,
+			&i.Version,
+			&i.FileCreatedAt,
+			&i.FileUpdatedAt,
 		); err != nil {
 			return nil, err
 		}
@@ -134,7 +320,7 @@ func (q *Queries) FetchAllTextFiles(ctx context.Context) ([]File, error) {
 }
 
 const fetchFile = `-- name: FetchFile :one
-SELECT id, disk_path, workspace_path, mime_type, hash, created_at, updated_at, version, workspace_id
+SELECT id, disk_path, workspace_path, mime_type, hash, created_at, updated_at, version, workspace_id, change_seq, blob_version
 FROM files
 WHERE id = ?
 LIMIT 1
@@ -153,12 +339,14 @@ func (q *Queries) FetchFile(ctx context.Context, id int64) (File, error) {
 		&i.UpdatedAt,
 		&i.Version,
 		&i.WorkspaceID,
+		&i.ChangeSeq,
+		&i.BlobVersion,
 	)
 	return i, err
 }
 
 const fetchFileFromWorkspacePath = `-- name: FetchFileFromWorkspacePath :one
-SELECT id, disk_path, workspace_path, mime_type, hash, created_at, updated_at, version, workspace_id
+SELECT id, disk_path, workspace_path, mime_type, hash, created_at, updated_at, version, workspace_id, change_seq, blob_version
 FROM files
 WHERE workspace_id = ? AND workspace_path = ?
 LIMIT 1
@@ -182,12 +370,14 @@ func (q *Queries) FetchFileFromWorkspacePath(ctx context.Context, arg FetchFileF
 		&i.UpdatedAt,
 		&i.Version,
 		&i.WorkspaceID,
+		&i.ChangeSeq,
+		&i.BlobVersion,
 	)
 	return i, err
 }
 
 const fetchFiles = `-- name: FetchFiles :many
-SELECT id, disk_path, workspace_path, mime_type, hash, created_at, updated_at, version, workspace_id
+SELECT id, disk_path, workspace_path, mime_type, hash, created_at, updated_at, version, workspace_id, change_seq, blob_version
 FROM files
 WHERE workspace_id = ?
 `
@@ -211,6 +401,95 @@ func (q *Queries) FetchFiles(ctx context.Context, workspaceID int64) ([]File, er
 			&i.UpdatedAt,
 			&i.Version,
 			&i.WorkspaceID,
+			&i.ChangeSeq,
+			&i.BlobVersion,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const fetchFilesChangedSince = `-- name: FetchFilesChangedSince :many
+SELECT id, disk_path, workspace_path, mime_type, hash, created_at, updated_at, version, workspace_id, change_seq, blob_version
+FROM files
+WHERE workspace_id = ? AND change_seq > ?
+ORDER BY change_seq ASC
+`
+
+type FetchFilesChangedSinceParams struct {
+	WorkspaceID int64 `json:"workspaceId"`
+	ChangeSeq   int64 `json:"changeSeq"`
+}
+
+func (q *Queries) FetchFilesChangedSince(ctx context.Context, arg FetchFilesChangedSinceParams) ([]File, error) {
+	rows, err := q.db.QueryContext(ctx, fetchFilesChangedSince, arg.WorkspaceID, arg.ChangeSeq)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []File
+	for rows.Next() {
+		var i File
+		if err := rows.Scan(
+			&i.ID,
+			&i.DiskPath,
+			&i.WorkspacePath,
+			&i.MimeType,
+			&i.Hash,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+			&i.Version,
+			&i.WorkspaceID,
+			&i.ChangeSeq,
+			&i.BlobVersion,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const fetchWorkspaceDeletedFiles = `-- name: FetchWorkspaceDeletedFiles :many
+SELECT file_id, workspace_id, workspace_path, change_seq, deleted_at, disk_path, mime_type, version, file_created_at, file_updated_at
+FROM deleted_files
+WHERE workspace_id = ?
+`
+
+func (q *Queries) FetchWorkspaceDeletedFiles(ctx context.Context, workspaceID int64) ([]DeletedFile, error) {
+	rows, err := q.db.QueryContext(ctx, fetchWorkspaceDeletedFiles, workspaceID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []DeletedFile
+	for rows.Next() {
+		var i DeletedFile
+		if err := rows.Scan(
+			&i.FileID,
+			&i.WorkspaceID,
+			&i.WorkspacePath,
+			&i.ChangeSeq,
+			&i.DeletedAt,
+			&i.DiskPath,
+			&i.MimeType,
+			&i.Version,
+			&i.FileCreatedAt,
+			&i.FileUpdatedAt,
 		); err != nil {
 			return nil, err
 		}
@@ -226,7 +505,7 @@ func (q *Queries) FetchFiles(ctx context.Context, workspaceID int64) ([]File, er
 }
 
 const fetchWorkspaceFiles = `-- name: FetchWorkspaceFiles :many
-SELECT id, disk_path, workspace_path, mime_type, hash, created_at, updated_at, version, workspace_id
+SELECT id, disk_path, workspace_path, mime_type, hash, created_at, updated_at, version, workspace_id, change_seq, blob_version
 FROM files
 WHERE workspace_id = ?
 `
@@ -250,6 +529,8 @@ func (q *Queries) FetchWorkspaceFiles(ctx context.Context, workspaceID int64) ([
 			&i.UpdatedAt,
 			&i.Version,
 			&i.WorkspaceID,
+			&i.ChangeSeq,
+			&i.BlobVersion,
 		); err != nil {
 			return nil, err
 		}
@@ -264,39 +545,112 @@ func (q *Queries) FetchWorkspaceFiles(ctx context.Context, workspaceID int64) ([
 	return items, nil
 }
 
+const updateFileContent = `-- name: UpdateFileContent :execrows
+UPDATE files
+SET
+    disk_path = ?,
+    mime_type = ?,
+    hash = ?,
+    version = ?,
+    blob_version = ?,
+    change_seq = ?,
+    updated_at = CURRENT_TIMESTAMP
+WHERE id = ? AND version = ?
+`
+
+type UpdateFileContentParams struct {
+	DiskPath        string `json:"diskPath"`
+	MimeType        string `json:"mimeType"`
+	Hash            string `json:"hash"`
+	Version         int64  `json:"version"`
+	BlobVersion     int64  `json:"blobVersion"`
+	ChangeSeq       int64  `json:"changeSeq"`
+	ID              int64  `json:"id"`
+	ExpectedVersion int64  `json:"expectedVersion"`
+}
+
+func (q *Queries) UpdateFileContent(ctx context.Context, arg UpdateFileContentParams) (int64, error) {
+	result, err := q.db.ExecContext(ctx, updateFileContent,
+		arg.DiskPath,
+		arg.MimeType,
+		arg.Hash,
+		arg.Version,
+		arg.BlobVersion,
+		arg.ChangeSeq,
+		arg.ID,
+		arg.ExpectedVersion,
+	)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
 const updateFileHash = `-- name: UpdateFileHash :exec
 UPDATE files
 SET
     hash = ?,
+    blob_version = ?,
     updated_at = CURRENT_TIMESTAMP
 WHERE id = ?
 `
 
 type UpdateFileHashParams struct {
-	Hash string `json:"hash"`
-	ID   int64  `json:"id"`
+	Hash        string `json:"hash"`
+	BlobVersion int64  `json:"blobVersion"`
+	ID          int64  `json:"id"`
 }
 
 func (q *Queries) UpdateFileHash(ctx context.Context, arg UpdateFileHashParams) error {
-	_, err := q.db.ExecContext(ctx, updateFileHash, arg.Hash, arg.ID)
+	_, err := q.db.ExecContext(ctx, updateFileHash, arg.Hash, arg.BlobVersion, arg.ID)
 	return err
 }
 
-const updateFileVersion = `-- name: UpdateFileVersion :exec
+const updateFileVersion = `-- name: UpdateFileVersion :execrows
 UPDATE files
 SET 
     version = ?,
+    change_seq = ?,
     updated_at = CURRENT_TIMESTAMP
-WHERE id = ?
+WHERE id = ? AND version = ?
 `
 
 type UpdateFileVersionParams struct {
-	Version int64 `json:"version"`
-	ID      int64 `json:"id"`
+	Version         int64 `json:"version"`
+	ChangeSeq       int64 `json:"changeSeq"`
+	ID              int64 `json:"id"`
+	ExpectedVersion int64 `json:"expectedVersion"`
+}
+
+func (q *Queries) UpdateFileVersion(ctx context.Context, arg UpdateFileVersionParams) (int64, error) {
+	result, err := q.db.ExecContext(ctx, updateFileVersion,
+		arg.Version,
+		arg.ChangeSeq,
+		arg.ID,
+		arg.ExpectedVersion,
+	)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const updateFileVersions = `-- name: UpdateFileVersions :exec
+UPDATE files
+SET
+    version = ?,
+    blob_version = ?
+WHERE id = ?
+`
+
+type UpdateFileVersionsParams struct {
+	Version     int64 `json:"version"`
+	BlobVersion int64 `json:"blobVersion"`
+	ID          int64 `json:"id"`
 }
 
-func (q *Queries) UpdateFileVersion(ctx context.Context, arg UpdateFileVersionParams) error {
-	_, err := q.db.ExecContext(ctx, updateFileVersion, arg.Version, arg.ID)
+func (q *Queries) UpdateFileVersions(ctx context.Context, arg UpdateFileVersionsParams) error {
+	_, err := q.db.ExecContext(ctx, updateFileVersions, arg.Version, arg.BlobVersion, arg.ID)
 	return err
 }
 
@@ -304,16 +658,18 @@ const updateWorkspacePath = `-- name: UpdateWorkspacePath :exec
 UPDATE files
 SET 
     workspace_path = ?,
+    change_seq = ?,
     updated_at = CURRENT_TIMESTAMP
 WHERE id = ?
 `
 
 type UpdateWorkspacePathParams struct {
 	WorkspacePath string `json:"workspacePath"`
+	ChangeSeq     int64  `json:"changeSeq"`
 	ID            int64  `json:"id"`
 }
 
 func (q *Queries) UpdateWorkspacePath(ctx context.Context, arg UpdateWorkspacePathParams) error {
-	_, err := q.db.ExecContext(ctx, updateWorkspacePath, arg.WorkspacePath, arg.ID)
+	_, err := q.db.ExecContext(ctx, updateWorkspacePath, arg.WorkspacePath, arg.ChangeSeq, arg.ID)
 	return err
 }
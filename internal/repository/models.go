@@ -9,6 +9,50 @@ import (
 	"time"
 )
 
+type ApiKey struct {
+	ID          int64        `json:"id"`
+	WorkspaceID int64        `json:"workspaceId"`
+	Name        string       `json:"name"`
+	KeyHash     string       `json:"keyHash"`
+	Scopes      string       `json:"scopes"`
+	ExpiresAt   sql.NullTime `json:"expiresAt"`
+	CreatedAt   time.Time    `json:"createdAt"`
+}
+
+type AuditEvent struct {
+	ID          int64     `json:"id"`
+	WorkspaceID int64     `json:"workspaceId"`
+	Actor       string    `json:"actor"`
+	Action      string    `json:"action"`
+	Target      string    `json:"target"`
+	Details     string    `json:"details"`
+	Ip          string    `json:"ip"`
+	CreatedAt   time.Time `json:"createdAt"`
+}
+
+type DeletedFile struct {
+	FileID        int64     `json:"fileId"`
+	WorkspaceID   int64     `json:"workspaceId"`
+	WorkspacePath string    `json:"workspacePath"`
+	ChangeSeq     int64     `json:"changeSeq"`
+	DeletedAt     time.Time `json:"deletedAt"`
+	DiskPath      string    `json:"diskPath"`
+	MimeType      string    `json:"mimeType"`
+	Version       int64     `json:"version"`
+	FileCreatedAt time.Time `json:"fileCreatedAt"`
+	FileUpdatedAt time.Time `json:"fileUpdatedAt"`
+}
+
+type Event struct {
+	Seq           int64     `json:"seq"`
+	WorkspaceID   int64     `json:"workspaceId"`
+	FileID        int64     `json:"fileId"`
+	Type          int64     `json:"type"`
+	WorkspacePath string    `json:"workspacePath"`
+	ObjectType    string    `json:"objectType"`
+	CreatedAt     time.Time `json:"createdAt"`
+}
+
 type File struct {
 	ID            int64     `json:"id"`
 	DiskPath      string    `json:"diskPath"`
@@ -19,12 +63,40 @@ type File struct {
 	UpdatedAt     time.Time `json:"updatedAt"`
 	Version       int64     `json:"version"`
 	WorkspaceID   int64     `json:"workspaceId"`
+	ChangeSeq     int64     `json:"changeSeq"`
+	BlobVersion   int64     `json:"blobVersion"`
+}
+
+type MemberPathRule struct {
+	ID          int64     `json:"id"`
+	WorkspaceID int64     `json:"workspaceId"`
+	UserID      int64     `json:"userId"`
+	Pattern     string    `json:"pattern"`
+	Access      string    `json:"access"`
+	CreatedAt   time.Time `json:"createdAt"`
 }
 
 type Operation struct {
-	FileID    int64     `json:"fileId"`
-	Version   int64     `json:"version"`
-	Operation string    `json:"operation"`
+	FileID      int64     `json:"fileId"`
+	Version     int64     `json:"version"`
+	Operation   string    `json:"operation"`
+	CreatedAt   time.Time `json:"createdAt"`
+	BaseVersion int64     `json:"baseVersion"`
+}
+
+type RefreshToken struct {
+	ID            int64     `json:"id"`
+	TokenHash     string    `json:"tokenHash"`
+	WorkspaceID   int64     `json:"workspaceId"`
+	UserID        int64     `json:"userId"`
+	AccessTokenID string    `json:"accessTokenId"`
+	ExpiresAt     time.Time `json:"expiresAt"`
+	CreatedAt     time.Time `json:"createdAt"`
+}
+
+type RevokedToken struct {
+	Jti       string    `json:"jti"`
+	ExpiresAt time.Time `json:"expiresAt"`
 	CreatedAt time.Time `json:"createdAt"`
 }
 
@@ -38,10 +110,48 @@ type Snapshot struct {
 	WorkspaceID int64     `json:"workspaceId"`
 }
 
+type StorageBlob struct {
+	Hash      string    `json:"hash"`
+	DiskPath  string    `json:"diskPath"`
+	RefCount  int64     `json:"refCount"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+type StorageObject struct {
+	Path      string    `json:"path"`
+	Hash      string    `json:"hash"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+type User struct {
+	ID        int64     `json:"id"`
+	Name      string    `json:"name"`
+	Password  string    `json:"password"`
+	CreatedAt time.Time `json:"createdAt"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
 type Workspace struct {
-	ID        int64        `json:"id"`
-	Name      string       `json:"name"`
-	Password  string       `json:"password"`
-	CreatedAt sql.NullTime `json:"createdAt"`
-	UpdatedAt sql.NullTime `json:"updatedAt"`
+	ID                      int64        `json:"id"`
+	Name                    string       `json:"name"`
+	Password                string       `json:"password"`
+	CreatedAt               sql.NullTime `json:"createdAt"`
+	UpdatedAt               sql.NullTime `json:"updatedAt"`
+	ChangeSeq               int64        `json:"changeSeq"`
+	BinarySnapshotRetention int64        `json:"binarySnapshotRetention"`
+	EndToEndEncrypted       bool         `json:"endToEndEncrypted"`
+}
+
+type WorkspaceKey struct {
+	WorkspaceID int64     `json:"workspaceId"`
+	WrappedKey  []byte    `json:"wrappedKey"`
+	CreatedAt   time.Time `json:"createdAt"`
+	UpdatedAt   time.Time `json:"updatedAt"`
+}
+
+type WorkspaceMember struct {
+	WorkspaceID int64     `json:"workspaceId"`
+	UserID      int64     `json:"userId"`
+	CreatedAt   time.Time `json:"createdAt"`
+	Role        string    `json:"role"`
 }
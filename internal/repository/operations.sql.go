@@ -10,25 +10,70 @@ import (
 	"time"
 )
 
+const copyFileOperations = `-- name: CopyFileOperations :exec
+INSERT INTO operations (file_id, version, operation, base_version, created_at)
+SELECT ?, version, operation, base_version, created_at
+FROM operations
+WHERE file_id = ? AND version > ? AND version <= ?
+`
+
+type CopyFileOperationsParams struct {
+	TargetFileID int64 `json:"targetFileId"`
+	FileID       int64 `json:"fileId"`
+	FromVersion  int64 `json:"fromVersion"`
+	ToVersion    int64 `json:"toVersion"`
+}
+
+func (q *Queries) CopyFileOperations(ctx context.Context, arg CopyFileOperationsParams) error {
+	_, err := q.db.ExecContext(ctx, copyFileOperations,
+		arg.TargetFileID,
+		arg.FileID,
+		arg.FromVersion,
+		arg.ToVersion,
+	)
+	return err
+}
+
 const createOperation = `-- name: CreateOperation :exec
-INSERT INTO operations (file_id, version, operation)
-VALUES (?, ?, ?)
+INSERT INTO operations (file_id, version, operation, base_version)
+VALUES (?, ?, ?, ?)
 `
 
 type CreateOperationParams struct {
-	FileID    int64  `json:"fileId"`
-	Version   int64  `json:"version"`
-	Operation string `json:"operation"`
+	FileID      int64  `json:"fileId"`
+	Version     int64  `json:"version"`
+	Operation   string `json:"operation"`
+	BaseVersion int64  `json:"baseVersion"`
 }
 
 func (q *Queries) CreateOperation(ctx context.Context, arg CreateOperationParams) error {
-	_, err := q.db.ExecContext(ctx, createOperation, arg.FileID, arg.Version, arg.Operation)
+	_, err := q.db.ExecContext(ctx, createOperation,
+		arg.FileID,
+		arg.Version,
+		arg.Operation,
+		arg.BaseVersion,
+	)
+	return err
+}
+
+const deleteFileOperations = `-- name: DeleteFileOperations :exec
+DELETE FROM operations
+WHERE file_id = ?
+`
+
+func (q *Queries) DeleteFileOperations(ctx context.Context, fileID int64) error {
+	_, err := q.db.ExecContext(ctx, deleteFileOperations, fileID)
 	return err
 }
 
 const deleteOperationOlderThan = `-- name: DeleteOperationOlderThan :exec
 DELETE FROM operations
-WHERE created_at < ?
+WHERE created_at < ? AND NOT EXISTS (
+    SELECT 1
+    FROM files f
+    JOIN workspaces w ON w.id = f.workspace_id
+    WHERE f.id = operations.file_id AND w.end_to_end_encrypted AND operations.version > f.blob_version
+)
 `
 
 func (q *Queries) DeleteOperationOlderThan(ctx context.Context, createdAt time.Time) error {
@@ -36,11 +81,34 @@ func (q *Queries) DeleteOperationOlderThan(ctx context.Context, createdAt time.T
 	return err
 }
 
+const deleteWorkspaceOperations = `-- name: DeleteWorkspaceOperations :execrows
+DELETE FROM operations
+WHERE file_id IN (
+    SELECT id
+    FROM files
+    WHERE workspace_id = ?1
+    UNION
+    SELECT file_id
+    FROM deleted_files
+    WHERE workspace_id = ?1
+)
+`
+
+func (q *Queries) DeleteWorkspaceOperations(ctx context.Context, workspaceID int64) (int64, error) {
+	result, err := q.db.ExecContext(ctx, deleteWorkspaceOperations, workspaceID)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
 const fetchFileOperationsFromVersion = `-- name: FetchFileOperationsFromVersion :many
-SELECT o.file_id, o.version, o.operation, o.created_at
+SELECT o.file_id, o.version, o.operation, o.created_at, o.base_version
 FROM operations o
-JOIN files f ON o.file_id = f.id
-WHERE o.file_id = ? AND o.version > ? AND f.workspace_id = ?
+WHERE o.file_id = ?1 AND o.version > ?2 AND (
+    EXISTS (SELECT 1 FROM files f WHERE f.id = o.file_id AND f.workspace_id = ?3)
+    OR EXISTS (SELECT 1 FROM deleted_files d WHERE d.file_id = o.file_id AND d.workspace_id = ?3)
+)
 ORDER BY o.version ASC
 `
 
@@ -64,6 +132,7 @@ func (q *Queries) FetchFileOperationsFromVersion(ctx context.Context, arg FetchF
 			&i.Version,
 			&i.Operation,
 			&i.CreatedAt,
+			&i.BaseVersion,
 		); err != nil {
 			return nil, err
 		}
@@ -79,7 +148,7 @@ func (q *Queries) FetchFileOperationsFromVersion(ctx context.Context, arg FetchF
 }
 
 const fetchOperation = `-- name: FetchOperation :one
-SELECT file_id, version, operation, created_at
+SELECT file_id, version, operation, created_at, base_version
 FROM operations
 WHERE file_id = ? AND version = ?
 LIMIT 1
@@ -98,6 +167,7 @@ func (q *Queries) FetchOperation(ctx context.Context, arg FetchOperationParams)
 		&i.Version,
 		&i.Operation,
 		&i.CreatedAt,
+		&i.BaseVersion,
 	)
 	return i, err
 }
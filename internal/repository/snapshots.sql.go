@@ -35,6 +35,21 @@ func (q *Queries) CreateSnapshot(ctx context.Context, arg CreateSnapshotParams)
 	return err
 }
 
+const deleteSnapshot = `-- name: DeleteSnapshot :exec
+DELETE FROM snapshots
+WHERE file_id = ? AND version = ?
+`
+
+type DeleteSnapshotParams struct {
+	FileID  int64 `json:"fileId"`
+	Version int64 `json:"version"`
+}
+
+func (q *Queries) DeleteSnapshot(ctx context.Context, arg DeleteSnapshotParams) error {
+	_, err := q.db.ExecContext(ctx, deleteSnapshot, arg.FileID, arg.Version)
+	return err
+}
+
 const deleteSnapshotsForFile = `-- name: DeleteSnapshotsForFile :exec
 DELETE FROM snapshots
 WHERE file_id = ?
@@ -45,6 +60,52 @@ func (q *Queries) DeleteSnapshotsForFile(ctx context.Context, fileID int64) erro
 	return err
 }
 
+const deleteWorkspaceSnapshots = `-- name: DeleteWorkspaceSnapshots :execrows
+DELETE FROM snapshots
+WHERE workspace_id = ?
+`
+
+func (q *Queries) DeleteWorkspaceSnapshots(ctx context.Context, workspaceID int64) (int64, error) {
+	result, err := q.db.ExecContext(ctx, deleteWorkspaceSnapshots, workspaceID)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const fetchFilesWithSnapshots = `-- name: FetchFilesWithSnapshots :many
+SELECT DISTINCT file_id, workspace_id
+FROM snapshots
+`
+
+type FetchFilesWithSnapshotsRow struct {
+	FileID      int64 `json:"fileId"`
+	WorkspaceID int64 `json:"workspaceId"`
+}
+
+func (q *Queries) FetchFilesWithSnapshots(ctx context.Context) ([]FetchFilesWithSnapshotsRow, error) {
+	rows, err := q.db.QueryContext(ctx, fetchFilesWithSnapshots)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []FetchFilesWithSnapshotsRow
+	for rows.Next() {
+		var i FetchFilesWithSnapshotsRow
+		if err := rows.Scan(&i.FileID, &i.WorkspaceID); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
 const fetchLatestSnapshotForFile = `-- name: FetchLatestSnapshotForFile :one
 SELECT file_id, version, disk_path, hash, created_at, type, workspace_id
 FROM snapshots
@@ -140,3 +201,69 @@ func (q *Queries) FetchSnapshots(ctx context.Context, arg FetchSnapshotsParams)
 	}
 	return items, nil
 }
+
+const fetchWorkspaceSnapshots = `-- name: FetchWorkspaceSnapshots :many
+SELECT file_id, version, disk_path, hash, created_at, type, workspace_id
+FROM snapshots
+WHERE workspace_id = ?
+ORDER BY file_id ASC, version ASC
+`
+
+func (q *Queries) FetchWorkspaceSnapshots(ctx context.Context, workspaceID int64) ([]Snapshot, error) {
+	rows, err := q.db.QueryContext(ctx, fetchWorkspaceSnapshots, workspaceID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Snapshot
+	for rows.Next() {
+		var i Snapshot
+		if err := rows.Scan(
+			&i.FileID,
+			&i.Version,
+			&i.DiskPath,
+			&i.Hash,
+			&i.CreatedAt,
+			&i.Type,
+			&i.WorkspaceID,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const updateSnapshotContent = `-- name: UpdateSnapshotContent :exec
+UPDATE snapshots
+SET
+    disk_path = ?,
+    type = ?,
+    hash = ?
+WHERE file_id = ? AND version = ?
+`
+
+type UpdateSnapshotContentParams struct {
+	DiskPath string `json:"diskPath"`
+	Type     string `json:"type"`
+	Hash     string `json:"hash"`
+	FileID   int64  `json:"fileId"`
+	Version  int64  `json:"version"`
+}
+
+func (q *Queries) UpdateSnapshotContent(ctx context.Context, arg UpdateSnapshotContentParams) error {
+	_, err := q.db.ExecContext(ctx, updateSnapshotContent,
+		arg.DiskPath,
+		arg.Type,
+		arg.Hash,
+		arg.FileID,
+		arg.Version,
+	)
+	return err
+}
@@ -7,39 +7,214 @@ package repository
 
 import (
 	"context"
+	"database/sql"
 )
 
 const addWorkspace = `-- name: AddWorkspace :exec
-INSERT INTO workspaces (name, password)
-VALUES (?, ?)
+INSERT INTO workspaces (name, password, binary_snapshot_retention, end_to_end_encrypted)
+VALUES (?, ?, ?, ?)
 `
 
 type AddWorkspaceParams struct {
-	Name     string `json:"name"`
-	Password string `json:"password"`
+	Name                    string `json:"name"`
+	Password                string `json:"password"`
+	BinarySnapshotRetention int64  `json:"binarySnapshotRetention"`
+	EndToEndEncrypted       bool   `json:"endToEndEncrypted"`
 }
 
 func (q *Queries) AddWorkspace(ctx context.Context, arg AddWorkspaceParams) error {
-	_, err := q.db.ExecContext(ctx, addWorkspace, arg.Name, arg.Password)
+	_, err := q.db.ExecContext(ctx, addWorkspace,
+		arg.Name,
+		arg.Password,
+		arg.BinarySnapshotRetention,
+		arg.EndToEndEncrypted,
+	)
+	return err
+}
+
+const bumpWorkspaceChangeSeq = `-- name: BumpWorkspaceChangeSeq :one
+UPDATE workspaces
+SET change_seq = change_seq + 1
+WHERE id = ?
+RETURNING change_seq
+`
+
+func (q *Queries) BumpWorkspaceChangeSeq(ctx context.Context, id int64) (int64, error) {
+	row := q.db.QueryRowContext(ctx, bumpWorkspaceChangeSeq, id)
+	var change_seq int64
+	err := row.Scan(&change_seq)
+	return change_seq, err
+}
+
+const deleteWorkspace = `-- name: DeleteWorkspace :exec
+DELETE FROM workspaces
+WHERE id = ?
+`
+
+func (q *Queries) DeleteWorkspace(ctx context.Context, id int64) error {
+	_, err := q.db.ExecContext(ctx, deleteWorkspace, id)
 	return err
 }
 
 const fetchWorkspace = `-- name: FetchWorkspace :one
-SELECT id, name, password 
+SELECT id, name, password, end_to_end_encrypted
 FROM workspaces
 WHERE name = ?
 LIMIT 1
 `
 
 type FetchWorkspaceRow struct {
-	ID       int64  `json:"id"`
-	Name     string `json:"name"`
-	Password string `json:"password"`
+	ID                int64  `json:"id"`
+	Name              string `json:"name"`
+	Password          string `json:"password"`
+	EndToEndEncrypted bool   `json:"endToEndEncrypted"`
 }
 
 func (q *Queries) FetchWorkspace(ctx context.Context, name string) (FetchWorkspaceRow, error) {
 	row := q.db.QueryRowContext(ctx, fetchWorkspace, name)
 	var i FetchWorkspaceRow
-	err := row.Scan(&i.ID, &i.Name, &i.Password)
+	err := row.Scan(
+		&i.ID,
+		&i.Name,
+		&i.Password,
+		&i.EndToEndEncrypted,
+	)
 	return i, err
 }
+
+const fetchWorkspaceChangeSeq = `-- name: FetchWorkspaceChangeSeq :one
+SELECT change_seq
+FROM workspaces
+WHERE id = ?
+`
+
+func (q *Queries) FetchWorkspaceChangeSeq(ctx context.Context, id int64) (int64, error) {
+	row := q.db.QueryRowContext(ctx, fetchWorkspaceChangeSeq, id)
+	var change_seq int64
+	err := row.Scan(&change_seq)
+	return change_seq, err
+}
+
+const fetchWorkspaceEndToEndEncrypted = `-- name: FetchWorkspaceEndToEndEncrypted :one
+SELECT end_to_end_encrypted
+FROM workspaces
+WHERE id = ?
+`
+
+func (q *Queries) FetchWorkspaceEndToEndEncrypted(ctx context.Context, id int64) (bool, error) {
+	row := q.db.QueryRowContext(ctx, fetchWorkspaceEndToEndEncrypted, id)
+	var end_to_end_encrypted bool
+	err := row.Scan(&end_to_end_encrypted)
+	return end_to_end_encrypted, err
+}
+
+const fetchWorkspaceSnapshotRetention = `-- name: FetchWorkspaceSnapshotRetention :one
+SELECT binary_snapshot_retention
+FROM workspaces
+WHERE id = ?
+`
+
+func (q *Queries) FetchWorkspaceSnapshotRetention(ctx context.Context, id int64) (int64, error) {
+	row := q.db.QueryRowContext(ctx, fetchWorkspaceSnapshotRetention, id)
+	var binary_snapshot_retention int64
+	err := row.Scan(&binary_snapshot_retention)
+	return binary_snapshot_retention, err
+}
+
+const fetchWorkspaces = `-- name: FetchWorkspaces :many
+SELECT id, name, end_to_end_encrypted, created_at
+FROM workspaces
+ORDER BY name ASC
+`
+
+type FetchWorkspacesRow struct {
+	ID                int64        `json:"id"`
+	Name              string       `json:"name"`
+	EndToEndEncrypted bool         `json:"endToEndEncrypted"`
+	CreatedAt         sql.NullTime `json:"createdAt"`
+}
+
+func (q *Queries) FetchWorkspaces(ctx context.Context) ([]FetchWorkspacesRow, error) {
+	rows, err := q.db.QueryContext(ctx, fetchWorkspaces)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []FetchWorkspacesRow
+	for rows.Next() {
+		var i FetchWorkspacesRow
+		if err := rows.Scan(
+			&i.ID,
+			&i.Name,
+			&i.EndToEndEncrypted,
+			&i.CreatedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const renameWorkspace = `-- name: RenameWorkspace :execrows
+UPDATE workspaces
+SET
+    name = ?,
+    updated_at = CURRENT_TIMESTAMP
+WHERE id = ?
+`
+
+type RenameWorkspaceParams struct {
+	Name string `json:"name"`
+	ID   int64  `json:"id"`
+}
+
+func (q *Queries) RenameWorkspace(ctx context.Context, arg RenameWorkspaceParams) (int64, error) {
+	result, err := q.db.ExecContext(ctx, renameWorkspace, arg.Name, arg.ID)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const updateWorkspacePassword = `-- name: UpdateWorkspacePassword :exec
+UPDATE workspaces
+SET
+    password = ?,
+    updated_at = CURRENT_TIMESTAMP
+WHERE id = ?
+`
+
+type UpdateWorkspacePasswordParams struct {
+	Password string `json:"password"`
+	ID       int64  `json:"id"`
+}
+
+func (q *Queries) UpdateWorkspacePassword(ctx context.Context, arg UpdateWorkspacePasswordParams) error {
+	_, err := q.db.ExecContext(ctx, updateWorkspacePassword, arg.Password, arg.ID)
+	return err
+}
+
+const updateWorkspaceSnapshotRetention = `-- name: UpdateWorkspaceSnapshotRetention :exec
+UPDATE workspaces
+SET
+    binary_snapshot_retention = ?,
+    updated_at = CURRENT_TIMESTAMP
+WHERE id = ?
+`
+
+type UpdateWorkspaceSnapshotRetentionParams struct {
+	BinarySnapshotRetention int64 `json:"binarySnapshotRetention"`
+	ID                      int64 `json:"id"`
+}
+
+func (q *Queries) UpdateWorkspaceSnapshotRetention(ctx context.Context, arg UpdateWorkspaceSnapshotRetentionParams) error {
+	_, err := q.db.ExecContext(ctx, updateWorkspaceSnapshotRetention, arg.BinarySnapshotRetention, arg.ID)
+	return err
+}
@@ -0,0 +1,25 @@
+package requestutils
+
+import (
+	"net/http"
+	"strings"
+)
+
+// IfMatch reports whether the request If-Match header allows modifying a
+// resource whose current entity tag is etag. A missing header always matches,
+// weak tags never do.
+func IfMatch(r *http.Request, etag string) bool {
+	header := r.Header.Get("If-Match")
+	if header == "" {
+		return true
+	}
+
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || candidate == etag || candidate == `"`+etag+`"` {
+			return true
+		}
+	}
+
+	return false
+}
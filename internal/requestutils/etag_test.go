@@ -0,0 +1,37 @@
+package requestutils
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIfMatch(t *testing.T) {
+	tests := []struct {
+		name   string
+		header string
+		want   bool
+	}{
+		{"missing header", "", true},
+		{"wildcard", "*", true},
+		{"quoted tag", `"abc"`, true},
+		{"bare tag", "abc", true},
+		{"list with match", `"foo", "abc"`, true},
+		{"weak tag", `W/"abc"`, false},
+		{"different tag", `"foo"`, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequestWithContext(context.Background(), http.MethodPut, "/", http.NoBody)
+			if tt.header != "" {
+				req.Header.Set("If-Match", tt.header)
+			}
+
+			assert.Equal(t, tt.want, IfMatch(req, "abc"))
+		})
+	}
+}
@@ -0,0 +1,66 @@
+package storage
+
+import (
+	"database/sql"
+	"encoding/base64"
+	"errors"
+	"fmt"
+
+	"github.com/hiimjako/syncinator/internal/env"
+	"github.com/hiimjako/syncinator/pkg/filestorage"
+)
+
+// Open returns the storage configured by the variables, with the
+// deduplication or the encryption on top of the backend
+func Open(sv *env.StorageVariables, db *sql.DB) (filestorage.Storage, error) {
+	if sv.StorageDedup && sv.StorageMasterKey != "" {
+		return nil, errors.New("storage deduplication and encryption can't be enabled together")
+	}
+	if sv.StorageEncryptionStrict && sv.StorageMasterKey == "" {
+		return nil, errors.New("strict storage encryption requires a master key")
+	}
+
+	storage, err := openBackend(sv)
+	if err != nil {
+		return nil, err
+	}
+
+	if sv.StorageDedup {
+		storage = filestorage.NewDedup(storage, db)
+	}
+
+	if sv.StorageMasterKey != "" {
+		masterKey, err := base64.StdEncoding.DecodeString(sv.StorageMasterKey)
+		if err != nil {
+			return nil, fmt.Errorf("decoding storage master key: %w", err)
+		}
+		encrypted, err := filestorage.NewEncryptedStorage(storage, db, masterKey)
+		if err != nil {
+			return nil, err
+		}
+		if sv.StorageEncryptionStrict {
+			encrypted.RejectPlaintext()
+		}
+		storage = encrypted
+	}
+
+	return storage, nil
+}
+
+func openBackend(sv *env.StorageVariables) (filestorage.Storage, error) {
+	switch sv.StorageBackend {
+	case "disk":
+		return filestorage.NewDisk(sv.StorageDir), nil
+	case "s3":
+		return filestorage.NewS3(filestorage.S3Options{
+			Endpoint:        sv.S3Endpoint,
+			Region:          sv.S3Region,
+			Bucket:          sv.S3Bucket,
+			AccessKeyID:     sv.S3AccessKeyID,
+			SecretAccessKey: sv.S3SecretAccessKey,
+			Prefix:          sv.S3Prefix,
+		})
+	default:
+		return nil, fmt.Errorf("unknown storage backend %q", sv.StorageBackend)
+	}
+}
@@ -0,0 +1,62 @@
+package storage
+
+import (
+	"testing"
+
+	"github.com/hiimjako/syncinator/internal/env"
+	"github.com/hiimjako/syncinator/internal/testutils"
+	"github.com/hiimjako/syncinator/pkg/filestorage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+func TestOpen(t *testing.T) {
+	db := testutils.CreateDB(t)
+
+	t.Run("should parse the variables like the server", func(t *testing.T) {
+		t.Setenv("STORAGE_BACKEND", "disk")
+		t.Setenv("STORAGE_DEDUP", "1")
+		t.Setenv("STORAGE_MASTER_KEY", "")
+
+		storageEnv, err := env.LoadStorageEnv()
+		require.NoError(t, err)
+		storageEnv.StorageDir = t.TempDir()
+
+		storage, err := Open(storageEnv, db)
+		require.NoError(t, err)
+		assert.IsType(t, &filestorage.Dedup{}, storage)
+	})
+
+	t.Run("should open the s3 backend", func(t *testing.T) {
+		storage, err := Open(&env.StorageVariables{
+			StorageBackend:    "s3",
+			S3Endpoint:        "http://localhost:9000",
+			S3Region:          "us-east-1",
+			S3Bucket:          "bucket",
+			S3AccessKeyID:     "key",
+			S3SecretAccessKey: "secret",
+		}, db)
+		require.NoError(t, err)
+		assert.IsType(t, &filestorage.S3{}, storage)
+	})
+
+	t.Run("should reject invalid configurations", func(t *testing.T) {
+		_, err := Open(&env.StorageVariables{StorageBackend: "ftp"}, db)
+		assert.Error(t, err)
+
+		_, err = Open(&env.StorageVariables{
+			StorageBackend:   "disk",
+			StorageDedup:     true,
+			StorageMasterKey: "a2V5",
+		}, db)
+		assert.Error(t, err)
+
+		_, err = Open(&env.StorageVariables{
+			StorageBackend:          "disk",
+			StorageEncryptionStrict: true,
+		}, db)
+		assert.Error(t, err)
+	})
+}
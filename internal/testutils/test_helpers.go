@@ -45,8 +45,16 @@ func CreateDB(t testing.TB) *sql.DB {
 type requestOption func(req *http.Request) error
 
 func WithAuthHeader(secretKey []byte, workspaceID int64) requestOption {
+	return WithRoleAuthHeader(secretKey, workspaceID, middleware.RoleAdmin)
+}
+
+func WithRoleAuthHeader(secretKey []byte, workspaceID int64, role middleware.Role) requestOption {
+	return WithUserAuthHeader(secretKey, workspaceID, 0, role)
+}
+
+func WithUserAuthHeader(secretKey []byte, workspaceID, userID int64, role middleware.Role) requestOption {
 	return func(req *http.Request) error {
-		token, err := middleware.CreateToken(middleware.AuthOptions{SecretKey: secretKey}, workspaceID)
+		token, err := middleware.CreateToken(middleware.AuthOptions{SecretKey: secretKey}, workspaceID, userID, role)
 		if err != nil {
 			return err
 		}
@@ -55,6 +63,13 @@ func WithAuthHeader(secretKey []byte, workspaceID int64) requestOption {
 	}
 }
 
+func WithBearerToken(token string) requestOption {
+	return func(req *http.Request) error {
+		req.Header.Add("Authorization", "Bearer "+token)
+		return nil
+	}
+}
+
 func WithContentTypeHeader(contentType string) requestOption {
 	return func(req *http.Request) error {
 		req.Header.Add("Content-Type", contentType)
@@ -62,6 +77,13 @@ func WithContentTypeHeader(contentType string) requestOption {
 	}
 }
 
+func WithIfMatchHeader(etag string) requestOption {
+	return func(req *http.Request) error {
+		req.Header.Add("If-Match", etag)
+		return nil
+	}
+}
+
 type FileWithContent struct {
 	Metadata repository.File
 	Content  []byte
@@ -224,9 +246,10 @@ func CreateMultipart(t *testing.T, filepath string, content []byte, encodeBase64
 	if encodeBase64 {
 		require.NoError(t, err)
 		encoder := base64.NewEncoder(base64.StdEncoding, fileWriter)
-		defer encoder.Close()
 		_, err = encoder.Write(content)
 		require.NoError(t, err)
+		// flush the trailing partial block before the next part starts
+		require.NoError(t, encoder.Close())
 	} else {
 		_, err = fileWriter.Write(content)
 		require.NoError(t, err)
@@ -0,0 +1,226 @@
+package syncinator
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"path"
+	"strings"
+
+	"github.com/hiimjako/syncinator/internal/repository"
+	"github.com/hiimjako/syncinator/pkg/middleware"
+)
+
+type PathAccess = string
+
+const (
+	PathRead  PathAccess = "read"
+	PathWrite PathAccess = "write"
+)
+
+const ErrForbiddenPath = "path not allowed"
+
+// PathRule grants a member access to the workspace paths matching Pattern,
+// where "*" matches part of a name and "**" any number of folders, e.g.
+// "Projects/Alpha/**". Writing implies reading.
+type PathRule struct {
+	Pattern string     `json:"pattern"`
+	Access  PathAccess `json:"access"`
+}
+
+// PathRules are the rules of a member: without any rule the member can access
+// the whole workspace, otherwise only the paths matching one of them.
+type PathRules []PathRule
+
+func (p PathRule) Validate() error {
+	if p.Access != PathRead && p.Access != PathWrite {
+		return fmt.Errorf("invalid access %q", p.Access)
+	}
+
+	for _, segment := range splitPath(p.Pattern) {
+		if _, err := path.Match(segment, ""); err != nil {
+			return fmt.Errorf("invalid pattern %q: %w", p.Pattern, err)
+		}
+	}
+
+	return nil
+}
+
+func (r PathRules) CanRead(workspacePath string) bool {
+	return r.allows(workspacePath, PathRead)
+}
+
+func (r PathRules) CanWrite(workspacePath string) bool {
+	return r.allows(workspacePath, PathWrite)
+}
+
+func (r PathRules) allows(workspacePath string, access PathAccess) bool {
+	if len(r) == 0 {
+		return true
+	}
+
+	segments := splitPath(workspacePath)
+	for _, rule := range r {
+		if access == PathWrite && rule.Access != PathWrite {
+			continue
+		}
+		if matchPath(splitPath(rule.Pattern), segments) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func splitPath(p string) []string {
+	cleaned := strings.TrimPrefix(path.Clean("/"+p), "/")
+	if cleaned == "" {
+		return nil
+	}
+	return strings.Split(cleaned, "/")
+}
+
+func matchPath(pattern, segments []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			for i := 0; i <= len(segments); i++ {
+				if matchPath(pattern[1:], segments[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+
+		if len(segments) == 0 {
+			return false
+		}
+		if ok, _ := path.Match(pattern[0], segments[0]); !ok {
+			return false
+		}
+
+		pattern, segments = pattern[1:], segments[1:]
+	}
+
+	return len(segments) == 0
+}
+
+// memberPathRules returns the rules of the member, the shared workspace
+// password (userID 0) is never restricted.
+func (s *syncinator) memberPathRules(ctx context.Context, workspaceID, userID int64) (PathRules, error) {
+	if userID == 0 {
+		return nil, nil
+	}
+
+	dbRules, err := s.db.FetchMemberPathRules(ctx, repository.FetchMemberPathRulesParams{
+		WorkspaceID: workspaceID,
+		UserID:      userID,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("fetching path rules: %w", err)
+	}
+
+	rules := make(PathRules, len(dbRules))
+	for i, rule := range dbRules {
+		rules[i] = PathRule{Pattern: rule.Pattern, Access: rule.Access}
+	}
+
+	return rules, nil
+}
+
+type pathRulesKey struct{}
+
+// withPathRules loads the path rules of the authenticated member, it must
+// follow IsAuthenticated.
+func (s *syncinator) withPathRules(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		workspaceID, _ := middleware.WorkspaceIDFromCtx(r.Context())
+		userID, _ := middleware.UserIDFromCtx(r.Context())
+
+		rules, err := s.memberPathRules(r.Context(), workspaceID, userID)
+		if err != nil {
+			log.Println(err)
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+
+		ctx := context.WithValue(r.Context(), pathRulesKey{}, rules)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+func pathRulesFromCtx(ctx context.Context) PathRules {
+	rules, _ := ctx.Value(pathRulesKey{}).(PathRules)
+	return rules
+}
+
+// filterReadable drops the items whose path the member can't read
+func filterReadable[T any](rules PathRules, items []T, pathOf func(T) string) []T {
+	if len(rules) == 0 {
+		return items
+	}
+
+	readable := make([]T, 0, len(items))
+	for _, item := range items {
+		if rules.CanRead(pathOf(item)) {
+			readable = append(readable, item)
+		}
+	}
+
+	return readable
+}
+
+// canReadFile reports whether the member of the request can read the file,
+// files that can't be read are reported as not existing.
+func (s *syncinator) canReadFile(ctx context.Context, fileID int64) bool {
+	rules := pathRulesFromCtx(ctx)
+	if len(rules) == 0 {
+		return true
+	}
+
+	file, err := s.db.FetchFile(ctx, fileID)
+	if err != nil {
+		return false
+	}
+
+	return rules.CanRead(file.WorkspacePath)
+}
+
+// checkWritable writes the error response when the member of the request
+// can't change the path, hiding the paths it can't even read.
+func checkWritable(w http.ResponseWriter, r *http.Request, workspacePath string) bool {
+	rules := pathRulesFromCtx(r.Context())
+	if !rules.CanRead(workspacePath) {
+		http.Error(w, ErrNotExistingFile, http.StatusNotFound)
+		return false
+	}
+	if !rules.CanWrite(workspacePath) {
+		http.Error(w, ErrForbiddenPath, http.StatusForbidden)
+		return false
+	}
+	return true
+}
+
+// messagePath returns the workspace path a websocket message is about
+func (s *syncinator) messagePath(msg any) (string, bool) {
+	fileID := int64(0)
+	switch m := msg.(type) {
+	case CursorMessage:
+		return m.Path, true
+	case EventMessage:
+		return m.WorkspacePath, true
+	case ChunkMessage:
+		fileID = m.FileID
+	case EncryptedChunkMessage:
+		fileID = m.FileID
+	default:
+		return "", false
+	}
+
+	file, err := s.db.FetchFile(s.ctx, fileID)
+	if err != nil {
+		return "", false
+	}
+
+	return file.WorkspacePath, true
+}
@@ -0,0 +1,84 @@
+package syncinator
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+
+	"github.com/hiimjako/syncinator/internal/repository"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPathRules(t *testing.T) {
+	rules := PathRules{
+		{Pattern: "Projects/Alpha/**", Access: PathWrite},
+		{Pattern: "Shared/*.md", Access: PathRead},
+		{Pattern: "**/README.md", Access: PathRead},
+	}
+
+	tests := []struct {
+		path     string
+		canRead  bool
+		canWrite bool
+	}{
+		{path: "Projects/Alpha/note.md", canRead: true, canWrite: true},
+		{path: "Projects/Alpha/deep/er/note.md", canRead: true, canWrite: true},
+		{path: "/Projects/Alpha/../Alpha/note.md", canRead: true, canWrite: true},
+		{path: "Projects/Beta/note.md"},
+		{path: "Projects/Alpha/../Beta/note.md"},
+		{path: "Shared/todo.md", canRead: true},
+		{path: "Shared/sub/todo.md"},
+		{path: "Shared/image.png"},
+		{path: "README.md", canRead: true},
+		{path: "Projects/Beta/README.md", canRead: true},
+		{path: "Projects"},
+	}
+
+	for _, tt := range tests {
+		assert.Equal(t, tt.canRead, rules.CanRead(tt.path), "read %s", tt.path)
+		assert.Equal(t, tt.canWrite, rules.CanWrite(tt.path), "write %s", tt.path)
+	}
+
+	t.Run("should allow everything without rules", func(t *testing.T) {
+		var none PathRules
+		assert.True(t, none.CanRead("any/path.md"))
+		assert.True(t, none.CanWrite("any/path.md"))
+	})
+
+	t.Run("should validate rules", func(t *testing.T) {
+		assert.NoError(t, PathRule{Pattern: "a/**/b*", Access: PathRead}.Validate())
+		assert.Error(t, PathRule{Pattern: "a/[", Access: PathRead}.Validate())
+		assert.Error(t, PathRule{Pattern: "a/**", Access: "admin"}.Validate())
+	})
+}
+
+// addRestrictedMember adds a member to the workspace limited to the rules
+func addRestrictedMember(t *testing.T, db *sql.DB, workspaceID int64, name string, rules PathRules) int64 {
+	repo := repository.New(db)
+
+	require.NoError(t, repo.AddUser(context.Background(), repository.AddUserParams{
+		Name:     name,
+		Password: "unused",
+	}))
+	user, err := repo.FetchUser(context.Background(), name)
+	require.NoError(t, err)
+
+	require.NoError(t, repo.AddWorkspaceMember(context.Background(), repository.AddWorkspaceMemberParams{
+		WorkspaceID: workspaceID,
+		UserID:      user.ID,
+		Role:        "editor",
+	}))
+
+	for _, rule := range rules {
+		_, err := repo.AddMemberPathRule(context.Background(), repository.AddMemberPathRuleParams{
+			WorkspaceID: workspaceID,
+			UserID:      user.ID,
+			Pattern:     rule.Pattern,
+			Access:      rule.Access,
+		})
+		require.NoError(t, err)
+	}
+
+	return user.ID
+}
@@ -1,8 +1,8 @@
 package syncinator
 
 import (
-	"archive/zip"
 	"bytes"
+	"context"
 	"database/sql"
 	"encoding/base64"
 	"encoding/json"
@@ -36,11 +36,24 @@ type UpdateFileBody struct {
 	Path string `json:"path"`
 }
 
+type ChangesResponse struct {
+	// Cursor is the workspace change sequence to send as "since" on the next call
+	Cursor  int64                    `json:"cursor"`
+	Files   []repository.File        `json:"files"`
+	Deleted []repository.DeletedFile `json:"deleted"`
+}
+
 type Operation struct {
 	FileID    int64        `json:"fileId"`
 	Version   int64        `json:"version"`
-	Operation []diff.Chunk `json:"operation"`
-	CreatedAt time.Time    `json:"createdAt"`
+	Operation []diff.Chunk `json:"operation,omitempty"`
+	// Payload is the encrypted operation of the end-to-end encrypted
+	// workspaces, in place of Operation
+	Payload   string    `json:"payload,omitempty"`
+	CreatedAt time.Time `json:"createdAt"`
+	// BaseVersion is the version the operation was made on, older than the
+	// previous one for the encrypted operations that won over concurrent ones
+	BaseVersion int64 `json:"baseVersion"`
 }
 
 const (
@@ -49,28 +62,61 @@ const (
 	ErrReadingFile         = "impossible to read file"
 	ErrNotExistingFile     = "not existing file"
 	ErrNotExistingSnapshot = "not existing snapshot"
+	ErrFileChanged         = "file changed since last fetch"
+	ErrTextFileReplace     = "text files can only be edited through the sync socket"
+	ErrBinaryFileRestore   = "only text files can be restored from a snapshot"
 )
 
+var errStaleFileVersion = errors.New("stale file version")
+
 func (s *syncinator) apiHandler() http.Handler {
+	// every member can read, only editors can change the files, API keys are
+	// further limited by their scopes
+	reader := func(handler http.HandlerFunc) http.Handler {
+		return middleware.RequireScope(middleware.ScopeRead)(handler)
+	}
+	editor := func(handler http.HandlerFunc) http.Handler {
+		return middleware.CreateStack(
+			middleware.RequireRole(middleware.RoleEditor),
+			middleware.RequireScope(middleware.ScopeWrite),
+		)(handler)
+	}
+	admin := func(handler http.HandlerFunc) http.Handler {
+		return middleware.CreateStack(
+			middleware.RequireRole(middleware.RoleAdmin),
+			middleware.RequireScope(middleware.ScopeAdmin),
+		)(handler)
+	}
+
 	router := http.NewServeMux()
-	router.HandleFunc("GET /export", s.exportHandler)
-	router.HandleFunc("GET /file", s.listFilesHandler)
-	router.HandleFunc("GET /file/{id}", s.fetchFileHandler)
-	router.HandleFunc("GET /file/{id}/snapshot", s.listFileSnapshotsHandler)
-	router.HandleFunc("GET /file/{id}/snapshot/{version}", s.fetchSnapshotHandler)
-	router.HandleFunc("POST /file", s.createFileHandler)
-	router.HandleFunc("DELETE /file/{id}", s.deleteFileHandler)
-	router.HandleFunc("PATCH /file/{id}", s.updateFileHandler)
-	router.HandleFunc("GET /operation", s.listOperationsHandler)
+	router.Handle("GET /export", middleware.RequireScope(middleware.ScopeExport)(http.HandlerFunc(s.exportHandler)))
+	router.Handle("POST /import", editor(s.importHandler))
+	router.Handle("GET /file", reader(s.listFilesHandler))
+	router.Handle("GET /file/{id}", reader(s.fetchFileHandler))
+	router.Handle("GET /file/{id}/snapshot", reader(s.listFileSnapshotsHandler))
+	router.Handle("GET /file/{id}/snapshot/{version}", reader(s.fetchSnapshotHandler))
+	router.Handle("POST /file/{id}/snapshot/{version}/restore", editor(s.restoreSnapshotHandler))
+	router.Handle("POST /file", editor(s.createFileHandler))
+	router.Handle("DELETE /file/{id}", editor(s.deleteFileHandler))
+	router.Handle("PATCH /file/{id}", editor(s.updateFileHandler))
+	router.Handle("PUT /file/{id}", editor(s.replaceFileHandler))
+	router.Handle("GET /operation", reader(s.listOperationsHandler))
+	router.Handle("GET /event", reader(s.listEventsHandler))
+	router.Handle("GET /change", reader(s.listChangesHandler))
+	router.Handle("GET /key", admin(s.listAPIKeysHandler))
+	router.Handle("DELETE /key/{id}", admin(s.revokeAPIKeyHandler))
+	router.Handle("GET /audit", admin(s.listAuditHandler))
+	router.Handle("DELETE /workspace", admin(s.deleteWorkspaceHandler))
 
 	stack := middleware.CreateStack(
 		middleware.Logging,
 		middleware.Cors(middleware.CorsOptions{
 			AllowedOrigins: []string{"*"},
-			AllowedMethods: []string{"HEAD", "GET", "POST", "OPTIONS", "DELETE", "PATCH"},
-			AllowedHeaders: []string{"Origin", "X-Requested-With", "Content-Type", "Accept", "Authorization"},
+			AllowedMethods: []string{"HEAD", "GET", "POST", "OPTIONS", "DELETE", "PATCH", "PUT"},
+			AllowedHeaders: []string{"Origin", "X-Requested-With", "Content-Type", "Accept", "Authorization", "If-Match"},
 		}),
-		middleware.IsAuthenticated(middleware.AuthOptions{SecretKey: s.jwtSecret}, middleware.ExtractBearerToken),
+		middleware.IsAuthenticated(s.authOptions(), middleware.ExtractBearerToken),
+		s.withPathRules,
 	)
 
 	routerWithStack := stack(router)
@@ -133,50 +179,6 @@ func writeJSON(w http.ResponseWriter, status int, v any) {
 	buf.WriteTo(w) //nolint:errcheck
 }
 
-func (s *syncinator) exportHandler(w http.ResponseWriter, r *http.Request) {
-	workspaceID, _ := middleware.WorkspaceIDFromCtx(r.Context())
-
-	files, err := s.db.FetchFiles(r.Context(), workspaceID)
-	if err != nil {
-		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
-		return
-	}
-
-	zipWriter := zip.NewWriter(w)
-
-	w.Header().Set("Content-Type", "application/zip")
-	w.Header().Set(
-		"Content-Disposition",
-		fmt.Sprintf("attachment; filename=workspace-%d-%s.zip", workspaceID, time.Now().Format(time.DateOnly)),
-	)
-	for _, file := range files {
-		f, err := zipWriter.Create(file.WorkspacePath)
-		if err != nil {
-			log.Printf("failed to create file in zip: %v", err)
-			return
-		}
-
-		r, err := s.storage.ReadObject(file.DiskPath)
-		if err != nil {
-			log.Printf("failed to read object for zip: %v", err)
-			return
-		}
-
-		_, err = io.Copy(f, r)
-		if err != nil {
-			r.Close()
-			log.Printf("failed to write content to zip: %v", err)
-			return
-		}
-		r.Close()
-	}
-
-	if err = zipWriter.Close(); err != nil {
-		log.Printf("failed to close zip writer: %v", err)
-		return
-	}
-}
-
 func (s *syncinator) listFilesHandler(w http.ResponseWriter, r *http.Request) {
 	workspaceID, _ := middleware.WorkspaceIDFromCtx(r.Context())
 
@@ -186,7 +188,9 @@ func (s *syncinator) listFilesHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	writeJSON(w, http.StatusOK, files)
+	writeJSON(w, http.StatusOK, filterReadable(pathRulesFromCtx(r.Context()), files, func(f repository.File) string {
+		return f.WorkspacePath
+	}))
 }
 
 func (s *syncinator) listFileSnapshotsHandler(w http.ResponseWriter, r *http.Request) {
@@ -198,6 +202,11 @@ func (s *syncinator) listFileSnapshotsHandler(w http.ResponseWriter, r *http.Req
 	}
 
 	workspaceID, _ := middleware.WorkspaceIDFromCtx(r.Context())
+	if !s.canReadFile(r.Context(), int64(fileID)) {
+		http.Error(w, ErrNotExistingFile, http.StatusNotFound)
+		return
+	}
+
 	files, err := s.db.FetchSnapshots(r.Context(), repository.FetchSnapshotsParams{
 		FileID:      int64(fileID),
 		WorkspaceID: workspaceID,
@@ -224,6 +233,11 @@ func (s *syncinator) listOperationsHandler(w http.ResponseWriter, r *http.Reques
 	}
 
 	workspaceID, _ := middleware.WorkspaceIDFromCtx(r.Context())
+	if !s.canReadFile(r.Context(), int64(fileID)) {
+		http.Error(w, ErrNotExistingFile, http.StatusNotFound)
+		return
+	}
+
 	dbOperations, err := s.db.FetchFileOperationsFromVersion(r.Context(), repository.FetchFileOperationsFromVersionParams{
 		FileID:      int64(fileID),
 		Version:     int64(fromVersion),
@@ -234,26 +248,106 @@ func (s *syncinator) listOperationsHandler(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
+	endToEnd, err := s.db.FetchWorkspaceEndToEndEncrypted(r.Context(), workspaceID)
+	if err != nil {
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
 	operations := make([]Operation, len(dbOperations))
 	for i := 0; i < len(operations); i++ {
-		var chunks []diff.Chunk
-		err := json.Unmarshal([]byte(dbOperations[i].Operation), &chunks)
+		operations[i] = Operation{
+			FileID:      dbOperations[i].FileID,
+			Version:     dbOperations[i].Version,
+			CreatedAt:   dbOperations[i].CreatedAt,
+			BaseVersion: dbOperations[i].BaseVersion,
+		}
+
+		var target any = &operations[i].Operation
+		if endToEnd {
+			target = &operations[i].Payload
+		}
+		err := json.Unmarshal([]byte(dbOperations[i].Operation), target)
 		if err != nil {
 			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
 			return
 		}
-
-		operations[i] = Operation{
-			FileID:    dbOperations[i].FileID,
-			Version:   dbOperations[i].Version,
-			Operation: chunks,
-			CreatedAt: dbOperations[i].CreatedAt,
-		}
 	}
 
 	writeJSON(w, http.StatusOK, operations)
 }
 
+func (s *syncinator) listEventsHandler(w http.ResponseWriter, r *http.Request) {
+	since, err := strconv.Atoi(r.URL.Query().Get("since"))
+	if since < 0 || err != nil {
+		http.Error(w, "invalid \"since\"", http.StatusBadRequest)
+		return
+	}
+
+	workspaceID, _ := middleware.WorkspaceIDFromCtx(r.Context())
+	events, err := s.db.FetchEventsSince(r.Context(), repository.FetchEventsSinceParams{
+		WorkspaceID: workspaceID,
+		Seq:         int64(since),
+	})
+	if err != nil {
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, filterReadable(pathRulesFromCtx(r.Context()), events, func(e repository.Event) string {
+		return e.WorkspacePath
+	}))
+}
+
+// listChangesHandler returns the files created, updated or deleted in the
+// workspace after the "since" change sequence.
+func (s *syncinator) listChangesHandler(w http.ResponseWriter, r *http.Request) {
+	since, err := strconv.Atoi(r.URL.Query().Get("since"))
+	if since < 0 || err != nil {
+		http.Error(w, "invalid \"since\"", http.StatusBadRequest)
+		return
+	}
+
+	workspaceID, _ := middleware.WorkspaceIDFromCtx(r.Context())
+
+	// the cursor is read first, changes committed meanwhile are returned
+	// again on the next call instead of being lost
+	cursor, err := s.db.FetchWorkspaceChangeSeq(r.Context(), workspaceID)
+	if err != nil {
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	files, err := s.db.FetchFilesChangedSince(r.Context(), repository.FetchFilesChangedSinceParams{
+		WorkspaceID: workspaceID,
+		ChangeSeq:   int64(since),
+	})
+	if err != nil {
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	deleted, err := s.db.FetchDeletedFilesSince(r.Context(), repository.FetchDeletedFilesSinceParams{
+		WorkspaceID: workspaceID,
+		ChangeSeq:   int64(since),
+	})
+	if err != nil {
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	rules := pathRulesFromCtx(r.Context())
+	writeJSON(w, http.StatusOK, ChangesResponse{
+		Cursor: cursor,
+		Files: filterReadable(rules, files, func(f repository.File) string {
+			return f.WorkspacePath
+		}),
+		Deleted: filterReadable(rules, deleted, func(f repository.DeletedFile) string {
+			return f.WorkspacePath
+		}),
+	})
+}
+
 func (s *syncinator) fetchFileHandler(w http.ResponseWriter, r *http.Request) {
 	fileID, err := strconv.Atoi(r.PathValue("id"))
 
@@ -269,7 +363,7 @@ func (s *syncinator) fetchFileHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	workspaceID, _ := middleware.WorkspaceIDFromCtx(r.Context())
-	if file.WorkspaceID != workspaceID {
+	if file.WorkspaceID != workspaceID || !pathRulesFromCtx(r.Context()).CanRead(file.WorkspacePath) {
 		http.Error(w, ErrNotExistingFile, http.StatusNotFound)
 		return
 	}
@@ -312,16 +406,29 @@ func (s *syncinator) fetchSnapshotHandler(w http.ResponseWriter, r *http.Request
 		Version:     int64(snapshotVersion),
 		WorkspaceID: workspaceID,
 	})
-	if err != nil {
+	if err != nil || !s.canReadFile(r.Context(), snapshot.FileID) {
 		http.Error(w, ErrNotExistingSnapshot, http.StatusNotFound)
 		return
 	}
 
-	// Reconstruct the file content at this version
-	content, err := s.ReconstructSnapshot(int64(fileID), int64(snapshotVersion), workspaceID)
-	if err != nil {
-		http.Error(w, "Error reconstructing snapshot", http.StatusInternalServerError)
-		return
+	// full snapshots, like the ones of binary files, are streamed as they
+	// are, while diff snapshots are rebuilt from their base
+	var content io.Reader
+	if snapshot.Type == "file" {
+		blob, err := s.storage.ReadObject(snapshot.DiskPath)
+		if err != nil {
+			http.Error(w, ErrReadingFile, http.StatusInternalServerError)
+			return
+		}
+		defer blob.Close()
+		content = blob
+	} else {
+		reconstructed, err := s.ReconstructSnapshot(int64(fileID), int64(snapshotVersion), workspaceID)
+		if err != nil {
+			http.Error(w, "Error reconstructing snapshot", http.StatusInternalServerError)
+			return
+		}
+		content = strings.NewReader(reconstructed)
 	}
 
 	fileMeta, err := s.db.FetchFile(r.Context(), snapshot.FileID)
@@ -331,37 +438,174 @@ func (s *syncinator) fetchSnapshotHandler(w http.ResponseWriter, r *http.Request
 	}
 
 	fname := path.Base(fileMeta.WorkspacePath)
-	if err := writeMultipartResponse(w, snapshot, fileMeta.MimeType, fname, strings.NewReader(content)); err != nil {
+	if err := writeMultipartResponse(w, snapshot, fileMeta.MimeType, fname, content); err != nil {
 		log.Printf("error writing multipart response: %v", err)
 		return
 	}
 }
 
-func (s *syncinator) createFileHandler(w http.ResponseWriter, r *http.Request) {
+// restoreSnapshotHandler brings a text file back to the content of one of its
+// snapshots. The change goes through the usual operation log and is pushed
+// to every subscriber, so connected clients converge on the restored content.
+func (s *syncinator) restoreSnapshotHandler(w http.ResponseWriter, r *http.Request) {
+	fileID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "invalid file id", http.StatusBadRequest)
+		return
+	}
+
+	snapshotVersion, err := strconv.Atoi(r.PathValue("version"))
+	if snapshotVersion < 0 || err != nil {
+		http.Error(w, "invalid snapshot version", http.StatusBadRequest)
+		return
+	}
+
 	workspaceID, _ := middleware.WorkspaceIDFromCtx(r.Context())
+	_, err = s.db.FetchSnapshotByVersion(r.Context(), repository.FetchSnapshotByVersionParams{
+		FileID:      int64(fileID),
+		Version:     int64(snapshotVersion),
+		WorkspaceID: workspaceID,
+	})
+	if err != nil {
+		http.Error(w, ErrNotExistingSnapshot, http.StatusNotFound)
+		return
+	}
+
+	fileMeta, err := s.db.FetchFile(r.Context(), int64(fileID))
+	if err != nil || fileMeta.WorkspaceID != workspaceID {
+		http.Error(w, ErrNotExistingFile, http.StatusNotFound)
+		return
+	}
+
+	if !checkWritable(w, r, fileMeta.WorkspacePath) {
+		return
+	}
+
+	if !mimeutils.IsText(fileMeta.MimeType) {
+		http.Error(w, ErrBinaryFileRestore, http.StatusConflict)
+		return
+	}
+
+	content, err := s.ReconstructSnapshot(int64(fileID), int64(snapshotVersion), workspaceID)
+	if err != nil {
+		http.Error(w, "Error reconstructing snapshot", http.StatusInternalServerError)
+		return
+	}
+
+	file, ok := s.fileCache.Get(fileMeta.ID)
+	if !ok {
+		file, err = s.fetchAndCacheFile(fileMeta.ID)
+		if err != nil {
+			log.Printf("error while caching file %v: %v\n", fileMeta.ID, err)
+			http.Error(w, ErrReadingFile, http.StatusInternalServerError)
+			return
+		}
+	}
+
+	restored, changed, err := s.setTextContent(r.Context(), file, content)
+	if err != nil {
+		log.Printf("error persisting restore of file %d: %v", fileMeta.ID, err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	if changed {
+		s.auditRequest(r, AuditSnapshotRestore, restored.WorkspacePath, fmt.Sprintf("version %d", snapshotVersion))
+	}
+
+	writeJSON(w, http.StatusOK, restored)
+}
 
+// setTextContent changes the content of a cached text file as if it was
+// edited on the sync socket: the difference is stored as an operation and
+// sent to the clients. It reports false if the content was the same.
+func (s *syncinator) setTextContent(ctx context.Context, file *LockedCachedFile, content string) (repository.File, bool, error) {
+	file.mut.Lock()
+	defer file.mut.Unlock()
+
+	chunks := diff.Compute([]rune(file.Content), []rune(content))
+	if len(chunks) == 0 {
+		return file.File, false, nil
+	}
+
+	newVersion := file.Version + 1
+	changeSeq, err := persistChunkOperation(ctx, s.conn, s.db, file.File, newVersion, chunks)
+	if err != nil {
+		return repository.File{}, false, err
+	}
+
+	file.Content = content
+	file.Version = newVersion
+	file.ChangeSeq = changeSeq
+	file.pendingChanges += 1
+	file.UpdatedAt = time.Now()
+
+	s.publishToWorkspace(file.WorkspaceID, "", ChunkMessage{
+		WsMessageHeader: WsMessageHeader{
+			FileID: file.ID,
+			Type:   ChunkEventType,
+		},
+		Chunks:  chunks,
+		Version: newVersion,
+	})
+
+	return file.File, true, nil
+}
+
+// parseMultipartFile extracts the uploaded file from a multipart request,
+// decoding it when it is sent as base64. On failure the error response has
+// already been written; otherwise the caller must invoke the returned cleanup.
+func (s *syncinator) parseMultipartFile(w http.ResponseWriter, r *http.Request) (io.ReadSeeker, func(), bool) {
 	if !requestutils.IsMultipartFormData(r) {
 		errMsg := fmt.Sprintf("Unsupported Content-Type %q", r.Header.Get("Content-Type"))
 		http.Error(w, errMsg, http.StatusUnsupportedMediaType)
-		return
+		return nil, nil, false
 	}
 
 	r.Body = http.MaxBytesReader(w, r.Body, s.maxFileSizeBytes)
 	err := r.ParseMultipartForm(s.maxFileSizeBytes)
 	if err != nil {
 		http.Error(w, "Unable to parse form", http.StatusBadRequest)
-		return
-	}
-	if r.MultipartForm != nil {
-		defer func() { _ = r.MultipartForm.RemoveAll() }()
+		return nil, nil, false
 	}
 
 	file, header, err := r.FormFile(MultipartFileField)
 	if err != nil {
+		_ = r.MultipartForm.RemoveAll()
 		http.Error(w, "Error retrieving the file", http.StatusBadRequest)
+		return nil, nil, false
+	}
+
+	cleanup := func() {
+		file.Close()
+		_ = r.MultipartForm.RemoveAll()
+	}
+
+	var fileReader io.ReadSeeker = file
+	if header.Header.Get("Content-Transfer-Encoding") == "base64" {
+		decoder := base64.NewDecoder(base64.StdEncoding, file)
+
+		data, err := io.ReadAll(decoder)
+		if err != nil {
+			cleanup()
+			http.Error(w, "Unable to parse base64", http.StatusBadRequest)
+			return nil, nil, false
+		}
+
+		fileReader = bytes.NewReader(data)
+	}
+
+	return fileReader, cleanup, true
+}
+
+func (s *syncinator) createFileHandler(w http.ResponseWriter, r *http.Request) {
+	workspaceID, _ := middleware.WorkspaceIDFromCtx(r.Context())
+
+	fileReader, cleanup, ok := s.parseMultipartFile(w, r)
+	if !ok {
 		return
 	}
-	defer file.Close()
+	defer cleanup()
 
 	filepath := r.FormValue(MultipartFilepathField)
 	if filepath == "" {
@@ -369,8 +613,13 @@ func (s *syncinator) createFileHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if !pathRulesFromCtx(r.Context()).CanWrite(filepath) {
+		http.Error(w, ErrForbiddenPath, http.StatusForbidden)
+		return
+	}
+
 	// if there isn't any file an error is returned
-	_, err = s.db.FetchFileFromWorkspacePath(r.Context(), repository.FetchFileFromWorkspacePathParams{
+	_, err := s.db.FetchFileFromWorkspacePath(r.Context(), repository.FetchFileFromWorkspacePathParams{
 		WorkspaceID:   workspaceID,
 		WorkspacePath: filepath,
 	})
@@ -383,45 +632,72 @@ func (s *syncinator) createFileHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	var fileReader io.ReadSeeker = file
-	if header.Header.Get("Content-Transfer-Encoding") == "base64" {
-		decoder := base64.NewDecoder(base64.StdEncoding, file)
-
-		data, err := io.ReadAll(decoder)
-		if err != nil {
-			http.Error(w, "Unable to parse base64", http.StatusBadRequest)
-			return
-		}
-
-		fileReader = bytes.NewReader(data)
+	// the content of end-to-end encrypted files must never be treated as text,
+	// even when the ciphertext is encoded as such
+	endToEnd, err := s.db.FetchWorkspaceEndToEndEncrypted(r.Context(), workspaceID)
+	if err != nil {
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
 	}
 
-	diskPath, err := s.storage.CreateObject(fileReader)
+	diskPath, err := s.storageFor(workspaceID).CreateObject(fileReader)
 	if err != nil {
 		http.Error(w, ErrInvalidFile, http.StatusInternalServerError)
 		return
 	}
 
 	mimeType := requestutils.DetectFileMimeType(fileReader, filepath)
+	if endToEnd {
+		mimeType = mimeutils.Encrypted
+	}
+
 	hash, err := filestorage.GenerateHash(fileReader)
 	if err != nil {
 		http.Error(w, ErrInvalidFile, http.StatusInternalServerError)
 		return
 	}
 
-	dbFile, err := s.db.CreateFile(r.Context(), repository.CreateFileParams{
+	tx, err := s.conn.BeginTx(r.Context(), nil)
+	if err != nil {
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	txq := s.db.WithTx(tx)
+	changeSeq, err := txq.BumpWorkspaceChangeSeq(r.Context(), workspaceID)
+	if err != nil {
+		http.Error(w, ErrInvalidFile, http.StatusInternalServerError)
+		return
+	}
+
+	dbFile, err := txq.CreateFile(r.Context(), repository.CreateFileParams{
 		DiskPath:      diskPath,
 		WorkspacePath: filepath,
 		MimeType:      mimeType,
 		Hash:          hash,
 		WorkspaceID:   workspaceID,
+		ChangeSeq:     changeSeq,
 	})
+	if err != nil {
+		http.Error(w, ErrInvalidFile, http.StatusInternalServerError)
+		return
+	}
 
+	// the event is recorded here, the client announces it on the sync socket
+	_, err = createFileEvent(r.Context(), txq, CreateEventType, dbFile.ID, workspaceID, dbFile.WorkspacePath)
 	if err != nil {
 		http.Error(w, ErrInvalidFile, http.StatusInternalServerError)
 		return
 	}
 
+	if err := tx.Commit(); err != nil {
+		http.Error(w, ErrInvalidFile, http.StatusInternalServerError)
+		return
+	}
+
+	s.auditRequest(r, AuditFileCreate, dbFile.WorkspacePath, "")
+
 	writeJSON(w, http.StatusCreated, dbFile)
 }
 
@@ -445,44 +721,54 @@ func (s *syncinator) deleteFileHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if !checkWritable(w, r, file.WorkspacePath) {
+		return
+	}
+
+	// the last content, the snapshots and the operations are kept for the
+	// point-in-time exports until the retention window expires
 	if cached, ok := s.fileCache.Get(file.ID); ok {
 		cached.mut.Lock()
+		if err := s.flushFileToStorage(cached.CachedFile); err != nil {
+			cached.mut.Unlock()
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
 		cached.pendingChanges = 0
 		cached.mut.Unlock()
 		s.fileCache.Remove(file.ID)
 	}
 
-	snapshots, err := s.db.FetchSnapshots(r.Context(), repository.FetchSnapshotsParams{
-		FileID:      int64(fileID),
-		WorkspaceID: workspaceID,
-	})
+	// delete DB records in a transaction — all or nothing
+	tx, err := s.conn.BeginTx(r.Context(), nil)
 	if err != nil {
 		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
 		return
 	}
 
-	// delete storage objects first — if any fail, DB records are untouched
-	for _, snapshot := range snapshots {
-		if err := s.storage.DeleteObject(snapshot.DiskPath); err != nil {
-			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
-			return
-		}
-	}
-
-	if err := s.storage.DeleteObject(file.DiskPath); err != nil {
+	txq := s.db.WithTx(tx)
+	changeSeq, err := txq.BumpWorkspaceChangeSeq(r.Context(), workspaceID)
+	if err != nil {
+		_ = tx.Rollback()
 		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
 		return
 	}
-
-	// delete DB records in a transaction — all or nothing
-	tx, err := s.conn.BeginTx(r.Context(), nil)
-	if err != nil {
+	if err := txq.CreateDeletedFile(r.Context(), repository.CreateDeletedFileParams{
+		FileID:        file.ID,
+		WorkspaceID:   workspaceID,
+		WorkspacePath: file.WorkspacePath,
+		ChangeSeq:     changeSeq,
+		DiskPath:      file.DiskPath,
+		MimeType:      file.MimeType,
+		Version:       file.Version,
+		FileCreatedAt: file.CreatedAt,
+		FileUpdatedAt: file.UpdatedAt,
+	}); err != nil {
+		_ = tx.Rollback()
 		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
 		return
 	}
-
-	txq := s.db.WithTx(tx)
-	if err := txq.DeleteSnapshotsForFile(r.Context(), int64(fileID)); err != nil {
+	if _, err := createFileEvent(r.Context(), txq, DeleteEventType, file.ID, workspaceID, file.WorkspacePath); err != nil {
 		_ = tx.Rollback()
 		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
 		return
@@ -497,6 +783,8 @@ func (s *syncinator) deleteFileHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	s.auditRequest(r, AuditFileDelete, file.WorkspacePath, "")
+
 	w.WriteHeader(http.StatusNoContent)
 }
 
@@ -538,8 +826,32 @@ func (s *syncinator) updateFileHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	err = s.db.UpdateWorkspacePath(r.Context(), repository.UpdateWorkspacePathParams{
+	if !checkWritable(w, r, file.WorkspacePath) {
+		return
+	}
+
+	if !pathRulesFromCtx(r.Context()).CanWrite(data.Path) {
+		http.Error(w, ErrForbiddenPath, http.StatusForbidden)
+		return
+	}
+
+	tx, err := s.conn.BeginTx(r.Context(), nil)
+	if err != nil {
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	txq := s.db.WithTx(tx)
+	changeSeq, err := txq.BumpWorkspaceChangeSeq(r.Context(), workspaceID)
+	if err != nil {
+		http.Error(w, ErrInvalidFile, http.StatusInternalServerError)
+		return
+	}
+
+	err = txq.UpdateWorkspacePath(r.Context(), repository.UpdateWorkspacePathParams{
 		WorkspacePath: data.Path,
+		ChangeSeq:     changeSeq,
 		ID:            file.ID,
 	})
 	if err != nil {
@@ -547,6 +859,20 @@ func (s *syncinator) updateFileHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// the event is recorded here, the client announces it on the sync socket
+	_, err = createFileEvent(r.Context(), txq, RenameEventType, file.ID, workspaceID, data.Path)
+	if err != nil {
+		http.Error(w, ErrInvalidFile, http.StatusInternalServerError)
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		http.Error(w, ErrInvalidFile, http.StatusInternalServerError)
+		return
+	}
+
+	s.auditRequest(r, AuditFileRename, data.Path, "from "+file.WorkspacePath)
+
 	updatedFile, err := s.db.FetchFile(r.Context(), int64(fileID))
 	if err != nil {
 		http.Error(w, ErrNotExistingFile, http.StatusNotFound)
@@ -555,3 +881,349 @@ func (s *syncinator) updateFileHandler(w http.ResponseWriter, r *http.Request) {
 
 	writeJSON(w, http.StatusOK, updatedFile)
 }
+
+// replaceFileHandler replaces the content of a binary file keeping its ID.
+// The previous blob is kept as a snapshot of the replaced version.
+//
+// The files of end-to-end encrypted workspaces can't be merged by the server,
+// so a stale write isn't rejected: the last writer wins, and the content it
+// overwrites is kept as a new conflict copy next to the file.
+func (s *syncinator) replaceFileHandler(w http.ResponseWriter, r *http.Request) {
+	fileID, err := strconv.Atoi(r.PathValue("id"))
+
+	if err != nil {
+		http.Error(w, "invalid file id", http.StatusBadRequest)
+		return
+	}
+
+	file, err := s.db.FetchFile(r.Context(), int64(fileID))
+	if err != nil {
+		http.Error(w, ErrNotExistingFile, http.StatusNotFound)
+		return
+	}
+
+	workspaceID, _ := middleware.WorkspaceIDFromCtx(r.Context())
+	if file.WorkspaceID != workspaceID {
+		http.Error(w, ErrNotExistingFile, http.StatusNotFound)
+		return
+	}
+
+	if !checkWritable(w, r, file.WorkspacePath) {
+		return
+	}
+
+	// text files are versioned by the OT operations, overwriting them
+	// would desync every connected client
+	if mimeutils.IsText(file.MimeType) {
+		http.Error(w, ErrTextFileReplace, http.StatusConflict)
+		return
+	}
+
+	endToEnd, err := s.db.FetchWorkspaceEndToEndEncrypted(r.Context(), workspaceID)
+	if err != nil {
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	staleWrite := !requestutils.IfMatch(r, file.Hash)
+	if staleWrite && !endToEnd {
+		http.Error(w, ErrFileChanged, http.StatusPreconditionFailed)
+		return
+	}
+
+	fileReader, cleanup, ok := s.parseMultipartFile(w, r)
+	if !ok {
+		return
+	}
+	defer cleanup()
+
+	diskPath, err := s.storageFor(file.WorkspaceID).CreateObject(fileReader)
+	if err != nil {
+		http.Error(w, ErrInvalidFile, http.StatusInternalServerError)
+		return
+	}
+
+	mimeType := requestutils.DetectFileMimeType(fileReader, file.WorkspacePath)
+	if endToEnd {
+		mimeType = mimeutils.Encrypted
+	}
+
+	hash, err := filestorage.GenerateHash(fileReader)
+	if err != nil {
+		_ = s.storage.DeleteObject(diskPath)
+		http.Error(w, ErrInvalidFile, http.StatusInternalServerError)
+		return
+	}
+
+	conflictDiskPath := ""
+	if staleWrite {
+		conflictDiskPath, err = s.copyObject(file.WorkspaceID, file.DiskPath)
+		if err != nil {
+			_ = s.storage.DeleteObject(diskPath)
+			log.Printf("error copying file %d for a conflict: %v", file.ID, err)
+			http.Error(w, ErrInvalidFile, http.StatusInternalServerError)
+			return
+		}
+	}
+
+	conflict, err := s.replaceFileContent(r.Context(), file, diskPath, mimeType, hash, conflictDiskPath)
+	if err != nil {
+		_ = s.storage.DeleteObject(diskPath)
+		if conflictDiskPath != "" {
+			_ = s.storage.DeleteObject(conflictDiskPath)
+		}
+		if errors.Is(err, errStaleFileVersion) {
+			http.Error(w, ErrFileChanged, http.StatusPreconditionFailed)
+			return
+		}
+		log.Printf("error replacing file %d: %v", file.ID, err)
+		http.Error(w, ErrInvalidFile, http.StatusInternalServerError)
+		return
+	}
+
+	if conflict != nil {
+		s.publishToWorkspace(workspaceID, "", *conflict)
+	}
+
+	if err := s.pruneBinarySnapshots(r.Context(), file); err != nil {
+		log.Printf("error pruning snapshots of file %d: %v", file.ID, err)
+	}
+
+	s.auditRequest(r, AuditFileReplace, file.WorkspacePath, "")
+
+	updatedFile, err := s.db.FetchFile(r.Context(), file.ID)
+	if err != nil {
+		http.Error(w, ErrNotExistingFile, http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("ETag", strconv.Quote(updatedFile.Hash))
+	writeJSON(w, http.StatusOK, updatedFile)
+}
+
+// replaceFileContent points the file to the new blob and bumps its version,
+// turning the previous blob into a snapshot. The update only succeeds if
+// nobody else replaced the file meanwhile.
+//
+// When conflictDiskPath is set, it is registered as a copy of the replaced
+// content, returning the creation event to publish.
+func (s *syncinator) replaceFileContent(
+	ctx context.Context,
+	file repository.File,
+	diskPath, mimeType, hash, conflictDiskPath string,
+) (*EventMessage, error) {
+	tx, err := s.conn.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("opening transaction: %w", err)
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	txq := s.db.WithTx(tx)
+
+	// the blob is dropped only if a snapshot of this version already exists
+	staleBlob := ""
+	_, err = txq.FetchSnapshotByVersion(ctx, repository.FetchSnapshotByVersionParams{
+		FileID:      file.ID,
+		Version:     file.Version,
+		WorkspaceID: file.WorkspaceID,
+	})
+	switch {
+	case err == nil:
+		staleBlob = file.DiskPath
+	case errors.Is(err, sql.ErrNoRows):
+		if err := txq.CreateSnapshot(ctx, repository.CreateSnapshotParams{
+			FileID:      file.ID,
+			Version:     file.Version,
+			DiskPath:    file.DiskPath,
+			Type:        "file",
+			Hash:        file.Hash,
+			WorkspaceID: file.WorkspaceID,
+		}); err != nil {
+			return nil, fmt.Errorf("creating snapshot: %w", err)
+		}
+	default:
+		return nil, fmt.Errorf("fetching snapshot: %w", err)
+	}
+
+	changeSeq, err := txq.BumpWorkspaceChangeSeq(ctx, file.WorkspaceID)
+	if err != nil {
+		return nil, fmt.Errorf("bumping change sequence: %w", err)
+	}
+
+	updated, err := txq.UpdateFileContent(ctx, repository.UpdateFileContentParams{
+		DiskPath:        diskPath,
+		MimeType:        mimeType,
+		Hash:            hash,
+		Version:         file.Version + 1,
+		BlobVersion:     file.Version + 1,
+		ChangeSeq:       changeSeq,
+		ID:              file.ID,
+		ExpectedVersion: file.Version,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("updating file: %w", err)
+	}
+	if updated == 0 {
+		return nil, errStaleFileVersion
+	}
+
+	var conflict *EventMessage
+	if conflictDiskPath != "" {
+		conflict, err = createConflictCopy(ctx, txq, file, conflictDiskPath, changeSeq)
+		if err != nil {
+			return nil, fmt.Errorf("creating conflict copy: %w", err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("committing transaction: %w", err)
+	}
+
+	if staleBlob != "" {
+		if err := s.storage.DeleteObject(staleBlob); err != nil {
+			log.Printf("error deleting replaced blob of file %d: %v", file.ID, err)
+		}
+	}
+
+	return conflict, nil
+}
+
+// createConflictCopy adds a file pointing to diskPath, next to the given one
+// and with its metadata, recording its creation as a workspace event.
+func createConflictCopy(
+	ctx context.Context,
+	txq *repository.Queries,
+	file repository.File,
+	diskPath string,
+	changeSeq int64,
+) (*EventMessage, error) {
+	now := time.Now()
+
+	workspacePath := ""
+	for attempt := 1; workspacePath == ""; attempt++ {
+		candidate := conflictCopyPath(file.WorkspacePath, now, attempt)
+		_, err := txq.FetchFileFromWorkspacePath(ctx, repository.FetchFileFromWorkspacePathParams{
+			WorkspaceID:   file.WorkspaceID,
+			WorkspacePath: candidate,
+		})
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			workspacePath = candidate
+		case err != nil:
+			return nil, fmt.Errorf("fetching file: %w", err)
+		}
+	}
+
+	return createFileWithEvent(ctx, txq, repository.CreateFileParams{
+		DiskPath:      diskPath,
+		WorkspacePath: workspacePath,
+		MimeType:      file.MimeType,
+		Hash:          file.Hash,
+		WorkspaceID:   file.WorkspaceID,
+		ChangeSeq:     changeSeq,
+	})
+}
+
+// createFileWithEvent adds the file recording its creation as a workspace
+// event, returning the event to publish.
+func createFileWithEvent(ctx context.Context, txq *repository.Queries, params repository.CreateFileParams) (*EventMessage, error) {
+	created, err := txq.CreateFile(ctx, params)
+	if err != nil {
+		return nil, fmt.Errorf("creating file: %w", err)
+	}
+
+	return createFileEvent(ctx, txq, CreateEventType, created.ID, created.WorkspaceID, created.WorkspacePath)
+}
+
+// createFileEvent records the change of the file as a workspace event, so
+// that its path can be followed back in time, returning the event to publish.
+func createFileEvent(
+	ctx context.Context,
+	txq *repository.Queries,
+	eventType MessageType,
+	fileID, workspaceID int64,
+	workspacePath string,
+) (*EventMessage, error) {
+	event, err := txq.CreateEvent(ctx, repository.CreateEventParams{
+		WorkspaceID:   workspaceID,
+		FileID:        fileID,
+		Type:          int64(eventType),
+		WorkspacePath: workspacePath,
+		ObjectType:    "file",
+	})
+	if err != nil {
+		return nil, fmt.Errorf("creating event: %w", err)
+	}
+
+	return &EventMessage{
+		WsMessageHeader: WsMessageHeader{
+			FileID: fileID,
+			Type:   eventType,
+		},
+		Seq:           event.Seq,
+		WorkspacePath: workspacePath,
+		ObjectType:    "file",
+	}, nil
+}
+
+// conflictCopyPath names the copy of a file keeping it in the same folder and
+// with the same extension, e.g. "notes/todo (conflict 2026-10-16 153000).md".
+func conflictCopyPath(workspacePath string, at time.Time, attempt int) string {
+	ext := path.Ext(workspacePath)
+	suffix := " (conflict " + at.UTC().Format("2006-01-02 150405")
+	if attempt > 1 {
+		suffix += " " + strconv.Itoa(attempt)
+	}
+	suffix += ")"
+
+	return strings.TrimSuffix(workspacePath, ext) + suffix + ext
+}
+
+// copyObject duplicates the object in the storage of the workspace
+func (s *syncinator) copyObject(workspaceID int64, diskPath string) (string, error) {
+	reader, err := s.storage.ReadObject(diskPath)
+	if err != nil {
+		return "", err
+	}
+	defer reader.Close()
+
+	return s.storageFor(workspaceID).CreateObject(reader)
+}
+
+// pruneBinarySnapshots drops the oldest snapshots of a binary file beyond the
+// retention of its workspace, falling back to the server one when unset.
+func (s *syncinator) pruneBinarySnapshots(ctx context.Context, file repository.File) error {
+	retention, err := s.db.FetchWorkspaceSnapshotRetention(ctx, file.WorkspaceID)
+	if err != nil {
+		return fmt.Errorf("fetching retention: %w", err)
+	}
+	if retention <= 0 {
+		retention = s.binarySnapshotRetention
+	}
+
+	snapshots, err := s.db.FetchSnapshots(ctx, repository.FetchSnapshotsParams{
+		FileID:      file.ID,
+		WorkspaceID: file.WorkspaceID,
+	})
+	if err != nil {
+		return fmt.Errorf("fetching snapshots: %w", err)
+	}
+
+	// snapshots are sorted from the newest one
+	for i := int(retention); i < len(snapshots); i++ {
+		snapshot := snapshots[i]
+		err := s.db.DeleteSnapshot(ctx, repository.DeleteSnapshotParams{
+			FileID:  snapshot.FileID,
+			Version: snapshot.Version,
+		})
+		if err != nil {
+			return fmt.Errorf("deleting snapshot %d: %w", snapshot.Version, err)
+		}
+
+		if err := s.storage.DeleteObject(snapshot.DiskPath); err != nil {
+			log.Printf("error deleting blob of snapshot %d of file %d: %v", snapshot.Version, file.ID, err)
+		}
+	}
+
+	return nil
+}
@@ -7,16 +7,21 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"net/http/httptest"
 	"os"
 	"strconv"
 	"strings"
 	"testing"
 	"time"
 
+	"github.com/coder/websocket"
+	"github.com/coder/websocket/wsjson"
 	"github.com/hiimjako/syncinator/internal/repository"
 	"github.com/hiimjako/syncinator/internal/testutils"
 	"github.com/hiimjako/syncinator/pkg/diff"
 	"github.com/hiimjako/syncinator/pkg/filestorage"
+	"github.com/hiimjako/syncinator/pkg/middleware"
+	"github.com/hiimjako/syncinator/pkg/mimeutils"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
 	"github.com/stretchr/testify/require"
@@ -147,7 +152,8 @@ func Test_exportHandler(t *testing.T) {
 	// Read the zip content
 	zipReader, err := zip.NewReader(strings.NewReader(body), int64(len(body)))
 	require.NoError(t, err)
-	assert.Len(t, zipReader.File, 2)
+	// the files and the manifest
+	assert.Len(t, zipReader.File, 3)
 
 	for _, wantFile := range filesToInsert {
 		found := false
@@ -248,6 +254,7 @@ func Test_fetchFileHandler(t *testing.T) {
 			CreatedAt:     body.Metadata.CreatedAt,
 			UpdatedAt:     body.Metadata.UpdatedAt,
 			WorkspaceID:   workspaceID,
+			ChangeSeq:     1,
 		},
 		Content: []byte("here a new file 2!"),
 	}, body)
@@ -298,6 +305,7 @@ func Test_createFileHandler(t *testing.T) {
 			CreatedAt:     body.CreatedAt,
 			UpdatedAt:     body.UpdatedAt,
 			WorkspaceID:   workspaceID,
+			ChangeSeq:     1,
 		}, body)
 
 		// check db
@@ -313,6 +321,7 @@ func Test_createFileHandler(t *testing.T) {
 			CreatedAt:     files[0].CreatedAt,
 			UpdatedAt:     files[0].UpdatedAt,
 			WorkspaceID:   workspaceID,
+			ChangeSeq:     1,
 		}, files[0])
 
 		// check mock assertions
@@ -363,6 +372,7 @@ func Test_createFileHandler(t *testing.T) {
 			CreatedAt:     body.CreatedAt,
 			UpdatedAt:     body.UpdatedAt,
 			WorkspaceID:   workspaceID,
+			ChangeSeq:     1,
 		}, body)
 
 		// check db
@@ -377,6 +387,7 @@ func Test_createFileHandler(t *testing.T) {
 			CreatedAt:     file.CreatedAt,
 			UpdatedAt:     file.UpdatedAt,
 			WorkspaceID:   workspaceID,
+			ChangeSeq:     1,
 		}, file)
 
 		// check mock assertions
@@ -547,7 +558,6 @@ func Test_deleteFileHandler(t *testing.T) {
 		content := []byte("here a new file!")
 		diskPath := "/foo/bar"
 
-		mockFileStorage.On("DeleteObject", diskPath).Return(nil).Once()
 		mockFileStorage.On("CreateObject", mock.AnythingOfType("multipart.sectionReadCloser")).
 			Return(diskPath, nil).
 			Once()
@@ -582,12 +592,29 @@ func Test_deleteFileHandler(t *testing.T) {
 		assert.NoError(t, err)
 		assert.Len(t, files, 0)
 
+		// the creation and the deletion are recorded once, by the api
+		events, err := server.db.FetchEventsSince(context.Background(), repository.FetchEventsSinceParams{
+			WorkspaceID: workspaceID,
+		})
+		require.NoError(t, err)
+		require.Len(t, events, 2)
+		assert.Equal(t, int64(CreateEventType), events[0].Type)
+		assert.Equal(t, int64(DeleteEventType), events[1].Type)
+		assert.Equal(t, filepath, events[1].WorkspacePath)
+
+		// the last content is kept for the point-in-time exports
+		deleted, err := server.db.FetchDeletedFile(context.Background(), createBody.ID)
+		require.NoError(t, err)
+		assert.Equal(t, diskPath, deleted.DiskPath)
+		assert.Equal(t, createBody.MimeType, deleted.MimeType)
+		assert.Equal(t, createBody.Version, deleted.Version)
+
 		// check mock assertions
 		mockFileStorage.AssertNumberOfCalls(t, "CreateObject", 1)
-		mockFileStorage.AssertCalled(t, "DeleteObject", diskPath)
+		mockFileStorage.AssertNotCalled(t, "DeleteObject", diskPath)
 	})
 
-	t.Run("successfully delete a file and related snapshots", func(t *testing.T) {
+	t.Run("successfully delete a file keeping its snapshots", func(t *testing.T) {
 		mockFileStorage := new(filestorage.MockFileStorage)
 		db := testutils.CreateDB(t)
 		options := Options{JWTSecret: []byte("secret")}
@@ -635,9 +662,6 @@ func Test_deleteFileHandler(t *testing.T) {
 		})
 		require.NoError(t, err)
 
-		mockFileStorage.On("DeleteObject", diskPath).Return(nil).Once()
-		mockFileStorage.On("DeleteObject", snapshotPath).Return(nil).Once()
-
 		// deleting a file
 		res, deleteBody := testutils.DoRequest[string](
 			t,
@@ -660,47 +684,45 @@ func Test_deleteFileHandler(t *testing.T) {
 			WorkspaceID: createBody.WorkspaceID,
 		})
 		assert.NoError(t, err)
-		assert.Len(t, snapshots, 0)
+		assert.Len(t, snapshots, 1)
 
 		// check mock assertions
 		mockFileStorage.AssertNumberOfCalls(t, "CreateObject", 2)
-		mockFileStorage.AssertCalled(t, "DeleteObject", diskPath)
+		mockFileStorage.AssertNotCalled(t, "DeleteObject", mock.Anything)
 	})
 
-	t.Run("file preserved on storage delete failure", func(t *testing.T) {
+	t.Run("file preserved on storage flush failure", func(t *testing.T) {
 		mockFileStorage := new(filestorage.MockFileStorage)
 		db := testutils.CreateDB(t)
-		options := Options{JWTSecret: []byte("secret")}
+		options := Options{JWTSecret: []byte("secret"), FlushInterval: time.Hour}
 		server := New(db, mockFileStorage, options)
 		t.Cleanup(func() { server.Close() })
 
 		var workspaceID int64 = 10
 		diskPath := "/foo/bar"
-		snapshotDiskPath := "/snapshot/path"
-
-		mockFileStorage.On("CreateObject", mock.AnythingOfType("multipart.sectionReadCloser")).
-			Return(diskPath, nil).Once()
-
-		form, contentType := testutils.CreateMultipart(t, "/home/file", []byte("content"), false)
-		res, createBody := testutils.DoRequest[repository.File](
-			t, server, http.MethodPost, PathHTTPAPI+"/file", form,
-			testutils.WithAuthHeader(options.JWTSecret, workspaceID),
-			testutils.WithContentTypeHeader(contentType),
-		)
-		require.Equal(t, http.StatusCreated, res.Code)
 
-		err := server.db.CreateSnapshot(server.ctx, repository.CreateSnapshotParams{
-			FileID: createBody.ID, Version: 0, DiskPath: snapshotDiskPath,
-			Type: "file", Hash: "h", WorkspaceID: workspaceID,
+		file, err := server.db.CreateFile(context.Background(), repository.CreateFileParams{
+			DiskPath:      diskPath,
+			WorkspacePath: "/home/file",
+			MimeType:      "text/plain; charset=utf-8",
+			Hash:          "h",
+			WorkspaceID:   workspaceID,
 		})
 		require.NoError(t, err)
 
-		mockFileStorage.On("DeleteObject", diskPath).Return(nil).Once()
-		mockFileStorage.On("DeleteObject", snapshotDiskPath).Return(fmt.Errorf("storage error")).Once()
+		server.fileCache.Add(file.ID, &LockedCachedFile{
+			CachedFile: CachedFile{
+				File:           file,
+				Content:        "modified content",
+				pendingChanges: 1,
+			},
+		})
+
+		mockFileStorage.On("WriteObject", diskPath, mock.Anything).Return(fmt.Errorf("storage error"))
 
-		res, _ = testutils.DoRequest[string](
+		res, _ := testutils.DoRequest[string](
 			t, server, http.MethodDelete,
-			PathHTTPAPI+"/file/"+strconv.Itoa(int(createBody.ID)), nil,
+			PathHTTPAPI+"/file/"+strconv.Itoa(int(file.ID)), nil,
 			testutils.WithAuthHeader(options.JWTSecret, workspaceID),
 		)
 		assert.Equal(t, http.StatusInternalServerError, res.Code)
@@ -709,11 +731,8 @@ func Test_deleteFileHandler(t *testing.T) {
 		assert.NoError(t, err)
 		assert.Len(t, files, 1, "file DB record should be preserved on storage failure")
 
-		snapshots, err := server.db.FetchSnapshots(context.Background(), repository.FetchSnapshotsParams{
-			FileID: createBody.ID, WorkspaceID: workspaceID,
-		})
-		assert.NoError(t, err)
-		assert.Len(t, snapshots, 1, "snapshot DB record should be preserved on storage failure")
+		_, ok := server.fileCache.Get(file.ID)
+		assert.True(t, ok, "the edits not flushed should stay cached")
 	})
 
 	t.Run("delete evicts file from cache", func(t *testing.T) {
@@ -758,6 +777,17 @@ func Test_deleteFileHandler(t *testing.T) {
 
 		_, ok := server.fileCache.Get(file.ID)
 		assert.False(t, ok, "file should be evicted from cache after delete")
+
+		// the edits not flushed yet are the last content kept
+		deleted, err := server.db.FetchDeletedFile(context.Background(), file.ID)
+		require.NoError(t, err)
+		require.Equal(t, diskPath, deleted.DiskPath)
+		object, err := realStorage.ReadObject(diskPath)
+		require.NoError(t, err)
+		defer object.Close()
+		flushed, err := io.ReadAll(object)
+		require.NoError(t, err)
+		assert.Equal(t, "modified content", string(flushed))
 	})
 
 	t.Run("unauthorize to delete a file of other workspace", func(t *testing.T) {
@@ -791,7 +821,7 @@ func Test_deleteFileHandler(t *testing.T) {
 		assert.Equal(t, http.StatusCreated, res.Code)
 
 		// deleting a file
-		anotherWorkspaceID := int64(20)
+		anotherWorkspaceID := int64(11)
 		res, deleteBody := testutils.DoRequest[string](
 			t,
 			server,
@@ -876,6 +906,7 @@ func Test_updateFileHandler(t *testing.T) {
 			CreatedAt:     files[0].CreatedAt,
 			UpdatedAt:     files[0].UpdatedAt,
 			WorkspaceID:   workspaceID,
+			ChangeSeq:     2,
 		}, files[0])
 
 		// check mock assertions
@@ -909,7 +940,7 @@ func Test_updateFileHandler(t *testing.T) {
 		updateData := UpdateFileBody{
 			Path: "/home/new-fancy-name",
 		}
-		anotherWorkspaceID := int64(20)
+		anotherWorkspaceID := int64(11)
 		res, deleteBody := testutils.DoRequest[string](
 			t,
 			server,
@@ -928,6 +959,673 @@ func Test_updateFileHandler(t *testing.T) {
 	})
 }
 
+func Test_replaceFileHandler(t *testing.T) {
+	fs := filestorage.NewDisk(t.TempDir())
+	db := testutils.CreateDB(t)
+	options := Options{JWTSecret: []byte("secret")}
+	server := New(db, fs, options)
+	t.Cleanup(func() { server.Close() })
+
+	var workspaceID int64 = 10
+
+	image, err := os.ReadFile("./testdata/image.png")
+	require.NoError(t, err)
+
+	form, contentType := testutils.CreateMultipart(t, "/home/image.png", image, true)
+	res, created := testutils.DoRequest[repository.File](
+		t,
+		server,
+		http.MethodPost,
+		PathHTTPAPI+"/file",
+		form,
+		testutils.WithAuthHeader(options.JWTSecret, workspaceID),
+		testutils.WithContentTypeHeader(contentType),
+	)
+	require.Equal(t, http.StatusCreated, res.Code)
+
+	fileURL := PathHTTPAPI + "/file/" + strconv.Itoa(int(created.ID))
+	newContent := []byte{0x00, 0xff, 0x01, 0x02}
+
+	t.Run("should reject a stale If-Match", func(t *testing.T) {
+		form, contentType := testutils.CreateMultipart(t, "/home/image.png", newContent, true)
+		res, body := testutils.DoRequest[string](
+			t,
+			server,
+			http.MethodPut,
+			fileURL,
+			form,
+			testutils.WithAuthHeader(options.JWTSecret, workspaceID),
+			testutils.WithContentTypeHeader(contentType),
+			testutils.WithIfMatchHeader(`"not-the-hash"`),
+		)
+		assert.Equal(t, http.StatusPreconditionFailed, res.Code)
+		assert.Equal(t, ErrFileChanged, body)
+	})
+
+	t.Run("should not replace a file of other workspace", func(t *testing.T) {
+		form, contentType := testutils.CreateMultipart(t, "/home/image.png", newContent, true)
+		res, _ := testutils.DoRequest[string](
+			t,
+			server,
+			http.MethodPut,
+			fileURL,
+			form,
+			testutils.WithAuthHeader(options.JWTSecret, 11),
+			testutils.WithContentTypeHeader(contentType),
+		)
+		assert.Equal(t, http.StatusNotFound, res.Code)
+	})
+
+	t.Run("should replace the content keeping the previous one as snapshot", func(t *testing.T) {
+		form, contentType := testutils.CreateMultipart(t, "/home/image.png", newContent, true)
+		res, body := testutils.DoRequest[repository.File](
+			t,
+			server,
+			http.MethodPut,
+			fileURL,
+			form,
+			testutils.WithAuthHeader(options.JWTSecret, workspaceID),
+			testutils.WithContentTypeHeader(contentType),
+			testutils.WithIfMatchHeader(strconv.Quote(created.Hash)),
+		)
+		require.Equal(t, http.StatusOK, res.Code)
+		assert.Equal(t, created.ID, body.ID)
+		assert.Equal(t, int64(1), body.Version)
+		assert.Equal(t, "application/octet-stream", body.MimeType)
+		assert.NotEqual(t, created.Hash, body.Hash)
+		assert.NotEqual(t, created.DiskPath, body.DiskPath)
+		assert.Equal(t, strconv.Quote(body.Hash), res.Header().Get("ETag"))
+
+		res, fetched := testutils.DoRequest[testutils.FileWithContent](
+			t,
+			server,
+			http.MethodGet,
+			fileURL,
+			nil,
+			testutils.WithAuthHeader(options.JWTSecret, workspaceID),
+		)
+		require.Equal(t, http.StatusOK, res.Code)
+		assert.Equal(t, newContent, fetched.Content)
+
+		res, snapshot := testutils.DoRequest[testutils.SnapshotWithContent](
+			t,
+			server,
+			http.MethodGet,
+			fileURL+"/snapshot/0",
+			nil,
+			testutils.WithAuthHeader(options.JWTSecret, workspaceID),
+		)
+		require.Equal(t, http.StatusOK, res.Code)
+		assert.Equal(t, created.Hash, snapshot.Metadata.Hash)
+		assert.Equal(t, "file", snapshot.Metadata.Type)
+		assert.Equal(t, image, snapshot.Content)
+	})
+
+	t.Run("should not replace a text file", func(t *testing.T) {
+		form, contentType := testutils.CreateMultipart(t, "/home/note.md", []byte("hello"), false)
+		res, textFile := testutils.DoRequest[repository.File](
+			t,
+			server,
+			http.MethodPost,
+			PathHTTPAPI+"/file",
+			form,
+			testutils.WithAuthHeader(options.JWTSecret, workspaceID),
+			testutils.WithContentTypeHeader(contentType),
+		)
+		require.Equal(t, http.StatusCreated, res.Code)
+
+		form, contentType = testutils.CreateMultipart(t, "/home/note.md", []byte("world"), false)
+		res, body := testutils.DoRequest[string](
+			t,
+			server,
+			http.MethodPut,
+			PathHTTPAPI+"/file/"+strconv.Itoa(int(textFile.ID)),
+			form,
+			testutils.WithAuthHeader(options.JWTSecret, workspaceID),
+			testutils.WithContentTypeHeader(contentType),
+		)
+		assert.Equal(t, http.StatusConflict, res.Code)
+		assert.Equal(t, ErrTextFileReplace, body)
+	})
+}
+
+func Test_roles(t *testing.T) {
+	fs := filestorage.NewDisk(t.TempDir())
+	db := testutils.CreateDB(t)
+	options := Options{JWTSecret: []byte("secret")}
+	server := New(db, fs, options)
+	t.Cleanup(func() { server.Close() })
+
+	var workspaceID int64 = 123
+
+	form, contentType := testutils.CreateMultipart(t, "roles.md", []byte("hello"), false)
+	res, created := testutils.DoRequest[repository.File](
+		t,
+		server,
+		http.MethodPost,
+		PathHTTPAPI+"/file",
+		form,
+		testutils.WithRoleAuthHeader(options.JWTSecret, workspaceID, middleware.RoleEditor),
+		testutils.WithContentTypeHeader(contentType),
+	)
+	require.Equal(t, http.StatusCreated, res.Code)
+
+	fileURL := PathHTTPAPI + "/file/" + strconv.Itoa(int(created.ID))
+	asViewer := testutils.WithRoleAuthHeader(options.JWTSecret, workspaceID, middleware.RoleViewer)
+
+	t.Run("should let a viewer read", func(t *testing.T) {
+		for _, url := range []string{PathHTTPAPI + "/file", fileURL, fileURL + "/snapshot", PathHTTPAPI + "/export"} {
+			req := httptest.NewRequestWithContext(context.Background(), http.MethodGet, url, http.NoBody)
+			require.NoError(t, asViewer(req))
+			res := httptest.NewRecorder()
+			server.ServeHTTP(res, req)
+			assert.Equal(t, http.StatusOK, res.Code, url)
+		}
+	})
+
+	t.Run("should not let a viewer change files", func(t *testing.T) {
+		form, contentType := testutils.CreateMultipart(t, "other.md", []byte("hello"), false)
+		res, _ := testutils.DoRequest[string](
+			t, server, http.MethodPost, PathHTTPAPI+"/file", form,
+			asViewer, testutils.WithContentTypeHeader(contentType),
+		)
+		assert.Equal(t, http.StatusForbidden, res.Code)
+
+		res, _ = testutils.DoRequest[string](
+			t, server, http.MethodPatch, fileURL, UpdateFileBody{Path: "renamed.md"}, asViewer,
+		)
+		assert.Equal(t, http.StatusForbidden, res.Code)
+
+		form, contentType = testutils.CreateMultipart(t, "roles.md", []byte("world"), false)
+		res, _ = testutils.DoRequest[string](
+			t, server, http.MethodPut, fileURL, form,
+			asViewer, testutils.WithContentTypeHeader(contentType),
+		)
+		assert.Equal(t, http.StatusForbidden, res.Code)
+
+		res, _ = testutils.DoRequest[string](t, server, http.MethodPost, fileURL+"/snapshot/0/restore", nil, asViewer)
+		assert.Equal(t, http.StatusForbidden, res.Code)
+
+		res, _ = testutils.DoRequest[string](t, server, http.MethodDelete, fileURL, nil, asViewer)
+		assert.Equal(t, http.StatusForbidden, res.Code)
+
+		fetched, err := server.db.FetchFile(context.Background(), created.ID)
+		require.NoError(t, err)
+		assert.Equal(t, created.WorkspacePath, fetched.WorkspacePath)
+	})
+}
+
+func Test_pathRules(t *testing.T) {
+	fs := filestorage.NewDisk(t.TempDir())
+	db := testutils.CreateDB(t)
+	options := Options{JWTSecret: []byte("secret")}
+	server := New(db, fs, options)
+	t.Cleanup(func() { server.Close() })
+
+	var workspaceID int64 = 2
+	userID := addRestrictedMember(t, db, workspaceID, "alpha-member", PathRules{
+		{Pattern: "Alpha/**", Access: PathWrite},
+		{Pattern: "Shared/**", Access: PathRead},
+	})
+	asMember := testutils.WithUserAuthHeader(options.JWTSecret, workspaceID, userID, middleware.RoleEditor)
+
+	files := map[string]repository.File{}
+	for _, p := range []string{"Alpha/note.md", "Shared/note.md", "Beta/note.md"} {
+		form, contentType := testutils.CreateMultipart(t, p, []byte("hello"), false)
+		res, created := testutils.DoRequest[repository.File](
+			t,
+			server,
+			http.MethodPost,
+			PathHTTPAPI+"/file",
+			form,
+			testutils.WithAuthHeader(options.JWTSecret, workspaceID),
+			testutils.WithContentTypeHeader(contentType),
+		)
+		require.Equal(t, http.StatusCreated, res.Code)
+		files[p] = created
+	}
+	fileURL := func(p string) string {
+		return PathHTTPAPI + "/file/" + strconv.Itoa(int(files[p].ID))
+	}
+
+	t.Run("should only list the readable files", func(t *testing.T) {
+		res, listed := testutils.DoRequest[[]repository.File](t, server, http.MethodGet, PathHTTPAPI+"/file", nil, asMember)
+		require.Equal(t, http.StatusOK, res.Code)
+
+		var paths []string
+		for _, f := range listed {
+			paths = append(paths, f.WorkspacePath)
+		}
+		assert.ElementsMatch(t, []string{"Alpha/note.md", "Shared/note.md"}, paths)
+
+		res, changes := testutils.DoRequest[ChangesResponse](t, server, http.MethodGet, PathHTTPAPI+"/change?since=0", nil, asMember)
+		require.Equal(t, http.StatusOK, res.Code)
+		assert.Len(t, changes.Files, 2)
+	})
+
+	t.Run("should only export the readable files", func(t *testing.T) {
+		req := httptest.NewRequestWithContext(context.Background(), http.MethodGet, PathHTTPAPI+"/export", http.NoBody)
+		require.NoError(t, asMember(req))
+		res := httptest.NewRecorder()
+		server.ServeHTTP(res, req)
+		require.Equal(t, http.StatusOK, res.Code)
+
+		zipReader, err := zip.NewReader(strings.NewReader(res.Body.String()), int64(res.Body.Len()))
+		require.NoError(t, err)
+
+		var paths []string
+		for _, f := range zipReader.File {
+			paths = append(paths, f.Name)
+		}
+		assert.ElementsMatch(t, []string{"Alpha/note.md", "Shared/note.md", ExportManifestPath}, paths)
+	})
+
+	t.Run("should hide the files outside the rules", func(t *testing.T) {
+		res, _ := testutils.DoRequest[string](t, server, http.MethodGet, fileURL("Beta/note.md"), nil, asMember)
+		assert.Equal(t, http.StatusNotFound, res.Code)
+
+		res, _ = testutils.DoRequest[string](t, server, http.MethodGet, fileURL("Beta/note.md")+"/snapshot", nil, asMember)
+		assert.Equal(t, http.StatusNotFound, res.Code)
+
+		res, _ = testutils.DoRequest[string](t, server, http.MethodDelete, fileURL("Beta/note.md"), nil, asMember)
+		assert.Equal(t, http.StatusNotFound, res.Code)
+
+		res, fetched := testutils.DoRequest[testutils.FileWithContent](t, server, http.MethodGet, fileURL("Shared/note.md"), nil, asMember)
+		require.Equal(t, http.StatusOK, res.Code)
+		assert.Equal(t, []byte("hello"), fetched.Content)
+	})
+
+	t.Run("should only change the writable paths", func(t *testing.T) {
+		form, contentType := testutils.CreateMultipart(t, "Beta/new.md", []byte("hello"), false)
+		res, _ := testutils.DoRequest[string](
+			t, server, http.MethodPost, PathHTTPAPI+"/file", form,
+			asMember, testutils.WithContentTypeHeader(contentType),
+		)
+		assert.Equal(t, http.StatusForbidden, res.Code)
+
+		res, _ = testutils.DoRequest[string](
+			t, server, http.MethodPatch, fileURL("Shared/note.md"), UpdateFileBody{Path: "Alpha/moved.md"}, asMember,
+		)
+		assert.Equal(t, http.StatusForbidden, res.Code)
+
+		res, _ = testutils.DoRequest[string](
+			t, server, http.MethodPatch, fileURL("Alpha/note.md"), UpdateFileBody{Path: "Beta/moved.md"}, asMember,
+		)
+		assert.Equal(t, http.StatusForbidden, res.Code)
+
+		res, renamed := testutils.DoRequest[repository.File](
+			t, server, http.MethodPatch, fileURL("Alpha/note.md"), UpdateFileBody{Path: "Alpha/renamed.md"}, asMember,
+		)
+		require.Equal(t, http.StatusOK, res.Code)
+		assert.Equal(t, "Alpha/renamed.md", renamed.WorkspacePath)
+
+		form, contentType = testutils.CreateMultipart(t, "Alpha/new.md", []byte("hello"), false)
+		res, _ = testutils.DoRequest[string](
+			t, server, http.MethodPost, PathHTTPAPI+"/file", form,
+			asMember, testutils.WithContentTypeHeader(contentType),
+		)
+		assert.Equal(t, http.StatusCreated, res.Code)
+	})
+}
+
+func Test_endToEndEncryptedWorkspace(t *testing.T) {
+	fs := filestorage.NewDisk(t.TempDir())
+	db := testutils.CreateDB(t)
+	options := Options{JWTSecret: []byte("secret")}
+	server := New(db, fs, options)
+	t.Cleanup(func() { server.Close() })
+
+	var workspaceID int64 = 51
+	createEndToEndWorkspace(t, db, workspaceID)
+
+	// base64 ciphertext would be detected as text
+	form, contentType := testutils.CreateMultipart(t, "notes/todo.md", []byte("Y2lwaGVydGV4dA=="), false)
+	res, created := testutils.DoRequest[repository.File](
+		t,
+		server,
+		http.MethodPost,
+		PathHTTPAPI+"/file",
+		form,
+		testutils.WithAuthHeader(options.JWTSecret, workspaceID),
+		testutils.WithContentTypeHeader(contentType),
+	)
+	require.Equal(t, http.StatusCreated, res.Code)
+	assert.Equal(t, mimeutils.Encrypted, created.MimeType)
+
+	fileURL := PathHTTPAPI + "/file/" + strconv.Itoa(int(created.ID))
+
+	replace := func(t *testing.T, content []byte, ifMatch string) repository.File {
+		form, contentType := testutils.CreateMultipart(t, "notes/todo.md", content, false)
+		res, body := testutils.DoRequest[repository.File](
+			t,
+			server,
+			http.MethodPut,
+			fileURL,
+			form,
+			testutils.WithAuthHeader(options.JWTSecret, workspaceID),
+			testutils.WithContentTypeHeader(contentType),
+			testutils.WithIfMatchHeader(strconv.Quote(ifMatch)),
+		)
+		require.Equal(t, http.StatusOK, res.Code)
+		return body
+	}
+
+	listFiles := func(t *testing.T) []repository.File {
+		res, files := testutils.DoRequest[[]repository.File](
+			t,
+			server,
+			http.MethodGet,
+			PathHTTPAPI+"/file",
+			nil,
+			testutils.WithAuthHeader(options.JWTSecret, workspaceID),
+		)
+		require.Equal(t, http.StatusOK, res.Code)
+		return files
+	}
+
+	first := replace(t, []byte("Zmlyc3Q="), created.Hash)
+	assert.Equal(t, int64(1), first.Version)
+	assert.Equal(t, mimeutils.Encrypted, first.MimeType)
+	require.Len(t, listFiles(t), 1)
+
+	t.Run("should keep a conflict copy when a stale write wins", func(t *testing.T) {
+		// written by a client that didn't see the first replace
+		second := replace(t, []byte("c2Vjb25k"), created.Hash)
+		assert.Equal(t, int64(2), second.Version)
+
+		files := listFiles(t)
+		require.Len(t, files, 2)
+
+		var conflict repository.File
+		for _, f := range files {
+			if f.ID != created.ID {
+				conflict = f
+			}
+		}
+		assert.Regexp(t, `^notes/todo \(conflict [0-9-]+ [0-9]+\)\.md$`, conflict.WorkspacePath)
+		assert.Equal(t, first.Hash, conflict.Hash)
+
+		res, fetched := testutils.DoRequest[testutils.FileWithContent](
+			t,
+			server,
+			http.MethodGet,
+			PathHTTPAPI+"/file/"+strconv.Itoa(int(conflict.ID)),
+			nil,
+			testutils.WithAuthHeader(options.JWTSecret, workspaceID),
+		)
+		require.Equal(t, http.StatusOK, res.Code)
+		assert.Equal(t, []byte("Zmlyc3Q="), fetched.Content)
+
+		events, err := server.db.FetchEventsSince(context.Background(), repository.FetchEventsSinceParams{
+			WorkspaceID: workspaceID,
+		})
+		require.NoError(t, err)
+		require.Len(t, events, 2)
+		assert.Equal(t, created.ID, events[0].FileID)
+		assert.Equal(t, conflict.ID, events[1].FileID)
+		assert.Equal(t, int64(CreateEventType), events[1].Type)
+	})
+
+	t.Run("should name conflict copies uniquely", func(t *testing.T) {
+		at := time.Date(2026, 10, 16, 15, 30, 0, 0, time.UTC)
+		assert.Equal(t, "notes/todo (conflict 2026-10-16 153000).md", conflictCopyPath("notes/todo.md", at, 1))
+		assert.Equal(t, "todo (conflict 2026-10-16 153000 2)", conflictCopyPath("todo", at, 2))
+	})
+}
+
+func Test_binarySnapshotRetention(t *testing.T) {
+	fs := filestorage.NewDisk(t.TempDir())
+	db := testutils.CreateDB(t)
+	options := Options{JWTSecret: []byte("secret"), BinarySnapshotRetention: 2}
+	server := New(db, fs, options)
+	t.Cleanup(func() { server.Close() })
+
+	var workspaceID int64 = 10
+
+	form, contentType := testutils.CreateMultipart(t, "/home/doc.pdf", []byte{0x00, 0xff, 0x00}, true)
+	res, created := testutils.DoRequest[repository.File](
+		t,
+		server,
+		http.MethodPost,
+		PathHTTPAPI+"/file",
+		form,
+		testutils.WithAuthHeader(options.JWTSecret, workspaceID),
+		testutils.WithContentTypeHeader(contentType),
+	)
+	require.Equal(t, http.StatusCreated, res.Code)
+
+	fileURL := PathHTTPAPI + "/file/" + strconv.Itoa(int(created.ID))
+	replace := func(content []byte) {
+		form, contentType := testutils.CreateMultipart(t, "/home/doc.pdf", content, true)
+		res, _ := testutils.DoRequest[repository.File](
+			t,
+			server,
+			http.MethodPut,
+			fileURL,
+			form,
+			testutils.WithAuthHeader(options.JWTSecret, workspaceID),
+			testutils.WithContentTypeHeader(contentType),
+		)
+		require.Equal(t, http.StatusOK, res.Code)
+	}
+	listVersions := func() []int64 {
+		res, snapshots := testutils.DoRequest[[]repository.Snapshot](
+			t,
+			server,
+			http.MethodGet,
+			fileURL+"/snapshot",
+			nil,
+			testutils.WithAuthHeader(options.JWTSecret, workspaceID),
+		)
+		require.Equal(t, http.StatusOK, res.Code)
+
+		versions := make([]int64, 0, len(snapshots))
+		for _, snapshot := range snapshots {
+			versions = append(versions, snapshot.Version)
+		}
+		return versions
+	}
+
+	t.Run("should keep the server retention by default", func(t *testing.T) {
+		replace([]byte{0x00, 0xff, 0x01})
+		replace([]byte{0x00, 0xff, 0x02})
+		replace([]byte{0x00, 0xff, 0x03})
+
+		assert.Equal(t, []int64{2, 1}, listVersions())
+
+		_, err := fs.ReadObject(created.DiskPath)
+		assert.Error(t, err)
+
+		res, snapshot := testutils.DoRequest[testutils.SnapshotWithContent](
+			t,
+			server,
+			http.MethodGet,
+			fileURL+"/snapshot/1",
+			nil,
+			testutils.WithAuthHeader(options.JWTSecret, workspaceID),
+		)
+		require.Equal(t, http.StatusOK, res.Code)
+		assert.Equal(t, []byte{0x00, 0xff, 0x01}, snapshot.Content)
+
+		res, _ = testutils.DoRequest[string](
+			t,
+			server,
+			http.MethodGet,
+			fileURL+"/snapshot/0",
+			nil,
+			testutils.WithAuthHeader(options.JWTSecret, workspaceID),
+		)
+		assert.Equal(t, http.StatusNotFound, res.Code)
+	})
+
+	t.Run("should prefer the workspace retention", func(t *testing.T) {
+		err := repository.New(db).UpdateWorkspaceSnapshotRetention(context.Background(), repository.UpdateWorkspaceSnapshotRetentionParams{
+			BinarySnapshotRetention: 1,
+			ID:                      workspaceID,
+		})
+		require.NoError(t, err)
+
+		replace([]byte{0x00, 0xff, 0x04})
+
+		assert.Equal(t, []int64{3}, listVersions())
+	})
+}
+
+func Test_restoreSnapshotHandler(t *testing.T) {
+	fs := filestorage.NewDisk(t.TempDir())
+	db := testutils.CreateDB(t)
+	repo := repository.New(db)
+
+	var workspaceID int64 = 10
+
+	diskPath, err := fs.CreateObject(strings.NewReader("hello world"))
+	require.NoError(t, err)
+	file, err := repo.CreateFile(context.Background(), repository.CreateFileParams{
+		DiskPath:      diskPath,
+		WorkspacePath: "note.md",
+		MimeType:      "text/markdown",
+		Hash:          "h",
+		WorkspaceID:   workspaceID,
+	})
+	require.NoError(t, err)
+	_, err = repo.UpdateFileVersion(context.Background(), repository.UpdateFileVersionParams{
+		ID:      file.ID,
+		Version: 3,
+	})
+	require.NoError(t, err)
+
+	snapshotPath, err := fs.CreateObject(strings.NewReader("hello"))
+	require.NoError(t, err)
+	require.NoError(t, repo.CreateSnapshot(context.Background(), repository.CreateSnapshotParams{
+		FileID:      file.ID,
+		Version:     1,
+		DiskPath:    snapshotPath,
+		Type:        "file",
+		Hash:        "s",
+		WorkspaceID: workspaceID,
+	}))
+
+	options := Options{JWTSecret: []byte("secret"), FlushInterval: time.Hour}
+	server := New(db, fs, options)
+	ts := httptest.NewServer(server)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	t.Cleanup(func() {
+		cancel()
+		ts.Close()
+		server.Close()
+	})
+
+	restoreURL := func(fileID int64, version int) string {
+		return fmt.Sprintf("%s/file/%d/snapshot/%d/restore", PathHTTPAPI, fileID, version)
+	}
+
+	t.Run("should not restore a snapshot of other workspace", func(t *testing.T) {
+		res, body := testutils.DoRequest[string](
+			t,
+			server,
+			http.MethodPost,
+			restoreURL(file.ID, 1),
+			nil,
+			testutils.WithAuthHeader(options.JWTSecret, 11),
+		)
+		assert.Equal(t, http.StatusNotFound, res.Code)
+		assert.Equal(t, ErrNotExistingSnapshot, body)
+	})
+
+	t.Run("should not restore a not existing snapshot", func(t *testing.T) {
+		res, _ := testutils.DoRequest[string](
+			t,
+			server,
+			http.MethodPost,
+			restoreURL(file.ID, 2),
+			nil,
+			testutils.WithAuthHeader(options.JWTSecret, workspaceID),
+		)
+		assert.Equal(t, http.StatusNotFound, res.Code)
+	})
+
+	t.Run("should restore the snapshot and broadcast the chunks", func(t *testing.T) {
+		url := createWsURLWithAuth(t, ts.URL, workspaceID, options.JWTSecret)
+		//nolint:bodyclose
+		client, _, err := websocket.Dial(ctx, url, nil)
+		require.NoError(t, err)
+		t.Cleanup(func() { client.Close(websocket.StatusNormalClosure, "") })
+
+		require.Eventually(t, func() bool {
+			server.subscribersMu.RLock()
+			ws, ok := server.subscribers[workspaceID]
+			server.subscribersMu.RUnlock()
+			if !ok {
+				return false
+			}
+			ws.mu.Lock()
+			defer ws.mu.Unlock()
+			return len(ws.subs) == 1
+		}, time.Second, 10*time.Millisecond)
+
+		res, restored := testutils.DoRequest[repository.File](
+			t,
+			server,
+			http.MethodPost,
+			restoreURL(file.ID, 1),
+			nil,
+			testutils.WithAuthHeader(options.JWTSecret, workspaceID),
+		)
+		require.Equal(t, http.StatusOK, res.Code)
+		assert.Equal(t, int64(4), restored.Version)
+
+		var msg ChunkMessage
+		require.NoError(t, wsjson.Read(ctx, client, &msg))
+		assert.Equal(t, file.ID, msg.FileID)
+		assert.Equal(t, ChunkEventType, msg.Type)
+		assert.Equal(t, int64(4), msg.Version)
+		assert.Equal(t, "hello", diff.ApplyMultiple("hello world", msg.Chunks))
+
+		operations, err := repo.FetchFileOperationsFromVersion(context.Background(), repository.FetchFileOperationsFromVersionParams{
+			FileID:      file.ID,
+			Version:     3,
+			WorkspaceID: workspaceID,
+		})
+		require.NoError(t, err)
+		require.Len(t, operations, 1)
+		assert.Equal(t, int64(4), operations[0].Version)
+
+		cached, ok := server.fileCache.Get(file.ID)
+		require.True(t, ok)
+		assert.Equal(t, "hello", cached.Content)
+	})
+
+	t.Run("should not restore a binary file", func(t *testing.T) {
+		image, err := repo.CreateFile(context.Background(), repository.CreateFileParams{
+			DiskPath:      snapshotPath,
+			WorkspacePath: "image.png",
+			MimeType:      "image/png",
+			Hash:          "i",
+			WorkspaceID:   workspaceID,
+		})
+		require.NoError(t, err)
+		require.NoError(t, repo.CreateSnapshot(context.Background(), repository.CreateSnapshotParams{
+			FileID:      image.ID,
+			Version:     0,
+			DiskPath:    snapshotPath,
+			Type:        "file",
+			Hash:        "i",
+			WorkspaceID: workspaceID,
+		}))
+
+		res, body := testutils.DoRequest[string](
+			t,
+			server,
+			http.MethodPost,
+			restoreURL(image.ID, 0),
+			nil,
+			testutils.WithAuthHeader(options.JWTSecret, workspaceID),
+		)
+		assert.Equal(t, http.StatusConflict, res.Code)
+		assert.Equal(t, ErrBinaryFileRestore, body)
+	})
+}
+
 func Test_listOperationsHandler(t *testing.T) {
 	mockFileStorage := new(filestorage.MockFileStorage)
 	db := testutils.CreateDB(t)
@@ -1080,6 +1778,150 @@ func Test_listOperationsHandler_corruptOperation(t *testing.T) {
 	assert.Equal(t, http.StatusInternalServerError, res.Code)
 }
 
+func Test_listEventsHandler(t *testing.T) {
+	mockFileStorage := new(filestorage.MockFileStorage)
+	db := testutils.CreateDB(t)
+	options := Options{JWTSecret: []byte("secret")}
+	server := New(db, mockFileStorage, options)
+	t.Cleanup(func() { server.Close() })
+
+	workspaceID := int64(10)
+	eventsToInsert := []repository.CreateEventParams{
+		{WorkspaceID: workspaceID, FileID: 1, Type: int64(CreateEventType), WorkspacePath: "a.md", ObjectType: "file"},
+		{WorkspaceID: 2, FileID: 2, Type: int64(CreateEventType), WorkspacePath: "b.md", ObjectType: "file"},
+		{WorkspaceID: workspaceID, FileID: 1, Type: int64(RenameEventType), WorkspacePath: "c.md", ObjectType: "file"},
+		{WorkspaceID: workspaceID, FileID: 1, Type: int64(DeleteEventType), WorkspacePath: "c.md", ObjectType: "file"},
+	}
+	for _, e := range eventsToInsert {
+		_, err := server.db.CreateEvent(context.Background(), e)
+		require.NoError(t, err)
+	}
+
+	t.Run("should return events after the given sequence", func(t *testing.T) {
+		res, body := testutils.DoRequest[[]repository.Event](
+			t,
+			server,
+			http.MethodGet,
+			PathHTTPAPI+"/event?since=1",
+			nil,
+			testutils.WithAuthHeader(options.JWTSecret, workspaceID),
+		)
+		assert.Equal(t, http.StatusOK, res.Code)
+		require.Len(t, body, 2)
+		assert.Equal(t, int64(3), body[0].Seq)
+		assert.Equal(t, int64(RenameEventType), body[0].Type)
+		assert.Equal(t, "c.md", body[0].WorkspacePath)
+		assert.Equal(t, int64(4), body[1].Seq)
+		assert.Equal(t, int64(DeleteEventType), body[1].Type)
+	})
+
+	t.Run("invalid since", func(t *testing.T) {
+		res, _ := testutils.DoRequest[string](
+			t,
+			server,
+			http.MethodGet,
+			PathHTTPAPI+"/event?since=-1",
+			nil,
+			testutils.WithAuthHeader(options.JWTSecret, workspaceID),
+		)
+		assert.Equal(t, http.StatusBadRequest, res.Code)
+	})
+}
+
+func Test_listChangesHandler(t *testing.T) {
+	fs := filestorage.NewDisk(t.TempDir())
+	db := testutils.CreateDB(t)
+	options := Options{JWTSecret: []byte("secret")}
+	server := New(db, fs, options)
+	t.Cleanup(func() { server.Close() })
+
+	workspaceID := int64(10)
+	createFile := func(t *testing.T, filepath string, workspaceID int64) repository.File {
+		form, contentType := testutils.CreateMultipart(t, filepath, []byte("content"), false)
+		res, file := testutils.DoRequest[repository.File](
+			t,
+			server,
+			http.MethodPost,
+			PathHTTPAPI+"/file",
+			form,
+			testutils.WithAuthHeader(options.JWTSecret, workspaceID),
+			testutils.WithContentTypeHeader(contentType),
+		)
+		require.Equal(t, http.StatusCreated, res.Code)
+		return file
+	}
+
+	file1 := createFile(t, "/home/file/1", workspaceID)
+	file2 := createFile(t, "/home/file/2", workspaceID)
+	createFile(t, "/home/file/3", 123)
+
+	res, body := testutils.DoRequest[ChangesResponse](
+		t,
+		server,
+		http.MethodGet,
+		PathHTTPAPI+"/change?since=0",
+		nil,
+		testutils.WithAuthHeader(options.JWTSecret, workspaceID),
+	)
+	assert.Equal(t, http.StatusOK, res.Code)
+	assert.Equal(t, int64(2), body.Cursor)
+	require.Len(t, body.Files, 2)
+	assert.Equal(t, file1.ID, body.Files[0].ID)
+	assert.Equal(t, file2.ID, body.Files[1].ID)
+	assert.Len(t, body.Deleted, 0)
+	cursor := body.Cursor
+
+	// rename file1 and delete file2
+	res, _ = testutils.DoRequest[repository.File](
+		t,
+		server,
+		http.MethodPatch,
+		PathHTTPAPI+"/file/"+strconv.Itoa(int(file1.ID)),
+		UpdateFileBody{Path: "/home/file/renamed"},
+		testutils.WithAuthHeader(options.JWTSecret, workspaceID),
+	)
+	require.Equal(t, http.StatusOK, res.Code)
+
+	res, _ = testutils.DoRequest[string](
+		t,
+		server,
+		http.MethodDelete,
+		PathHTTPAPI+"/file/"+strconv.Itoa(int(file2.ID)),
+		nil,
+		testutils.WithAuthHeader(options.JWTSecret, workspaceID),
+	)
+	require.Equal(t, http.StatusNoContent, res.Code)
+
+	res, body = testutils.DoRequest[ChangesResponse](
+		t,
+		server,
+		http.MethodGet,
+		PathHTTPAPI+"/change?since="+strconv.Itoa(int(cursor)),
+		nil,
+		testutils.WithAuthHeader(options.JWTSecret, workspaceID),
+	)
+	assert.Equal(t, http.StatusOK, res.Code)
+	assert.Equal(t, int64(4), body.Cursor)
+	require.Len(t, body.Files, 1)
+	assert.Equal(t, file1.ID, body.Files[0].ID)
+	assert.Equal(t, "/home/file/renamed", body.Files[0].WorkspacePath)
+	assert.Equal(t, int64(3), body.Files[0].ChangeSeq)
+	require.Len(t, body.Deleted, 1)
+	assert.Equal(t, file2.ID, body.Deleted[0].FileID)
+	assert.Equal(t, "/home/file/2", body.Deleted[0].WorkspacePath)
+	assert.Equal(t, int64(4), body.Deleted[0].ChangeSeq)
+
+	res, _ = testutils.DoRequest[string](
+		t,
+		server,
+		http.MethodGet,
+		PathHTTPAPI+"/change?since=abc",
+		nil,
+		testutils.WithAuthHeader(options.JWTSecret, workspaceID),
+	)
+	assert.Equal(t, http.StatusBadRequest, res.Code)
+}
+
 // Test_listSnapshotsHandler tests the listSnapshotsHandler using mocked storage
 func Test_listSnapshotsHandler(t *testing.T) {
 	mockFileStorage := new(filestorage.MockFileStorage)
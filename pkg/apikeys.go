@@ -0,0 +1,109 @@
+package syncinator
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/hiimjako/syncinator/internal/repository"
+	"github.com/hiimjako/syncinator/pkg/middleware"
+)
+
+// APIKey describes a key of the workspace, the key itself is shown only
+// once, when it is created with the cli.
+type APIKey struct {
+	ID        int64             `json:"id"`
+	Name      string            `json:"name"`
+	Scopes    middleware.Scopes `json:"scopes"`
+	ExpiresAt *time.Time        `json:"expiresAt,omitempty"`
+	CreatedAt time.Time         `json:"createdAt"`
+}
+
+const ErrNotExistingAPIKey = "not existing api key"
+
+func (s *syncinator) lookupAPIKey(ctx context.Context, key string) (*middleware.APIKey, error) {
+	apiKey, err := s.db.FetchValidAPIKey(ctx, repository.FetchValidAPIKeyParams{
+		KeyHash:   middleware.HashAPIKey(key),
+		ExpiresAt: sql.NullTime{Time: time.Now().UTC(), Valid: true},
+	})
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("fetching api key: %w", err)
+	}
+
+	scopes, err := middleware.ParseScopes(apiKey.Scopes)
+	if err != nil {
+		return nil, fmt.Errorf("parsing scopes of api key %d: %w", apiKey.ID, err)
+	}
+
+	return &middleware.APIKey{
+		ID:          apiKey.ID,
+		WorkspaceID: apiKey.WorkspaceID,
+		Scopes:      scopes,
+	}, nil
+}
+
+func (s *syncinator) listAPIKeysHandler(w http.ResponseWriter, r *http.Request) {
+	workspaceID, _ := middleware.WorkspaceIDFromCtx(r.Context())
+
+	dbKeys, err := s.db.FetchAPIKeys(r.Context(), workspaceID)
+	if err != nil {
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	keys := make([]APIKey, len(dbKeys))
+	for i, key := range dbKeys {
+		scopes, err := middleware.ParseScopes(key.Scopes)
+		if err != nil {
+			log.Printf("error parsing scopes of api key %d: %v", key.ID, err)
+		}
+
+		keys[i] = APIKey{
+			ID:        key.ID,
+			Name:      key.Name,
+			Scopes:    scopes,
+			CreatedAt: key.CreatedAt,
+		}
+		if key.ExpiresAt.Valid {
+			keys[i].ExpiresAt = &key.ExpiresAt.Time
+		}
+	}
+
+	writeJSON(w, http.StatusOK, keys)
+}
+
+// revokeAPIKeyHandler deletes the key, disconnecting the clients using it
+func (s *syncinator) revokeAPIKeyHandler(w http.ResponseWriter, r *http.Request) {
+	keyID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "invalid api key id", http.StatusBadRequest)
+		return
+	}
+
+	workspaceID, _ := middleware.WorkspaceIDFromCtx(r.Context())
+	deleted, err := s.db.DeleteAPIKey(r.Context(), repository.DeleteAPIKeyParams{
+		ID:          int64(keyID),
+		WorkspaceID: workspaceID,
+	})
+	if err != nil {
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	if deleted == 0 {
+		http.Error(w, ErrNotExistingAPIKey, http.StatusNotFound)
+		return
+	}
+
+	s.disconnectToken(workspaceID, middleware.APIKeyTokenID(int64(keyID)))
+	s.auditRequest(r, AuditAPIKeyRevoke, "", middleware.APIKeyTokenID(int64(keyID)))
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,115 @@
+package syncinator
+
+import (
+	"context"
+	"database/sql"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/hiimjako/syncinator/internal/repository"
+	"github.com/hiimjako/syncinator/internal/testutils"
+	"github.com/hiimjako/syncinator/pkg/filestorage"
+	"github.com/hiimjako/syncinator/pkg/middleware"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// createAPIKey adds a key to the workspace, returning its ID and the key
+func createAPIKey(t *testing.T, db *sql.DB, workspaceID int64, scopes string, expiresAt sql.NullTime) (int64, string) {
+	key, err := middleware.GenerateAPIKey()
+	require.NoError(t, err)
+
+	created, err := repository.New(db).CreateAPIKey(context.Background(), repository.CreateAPIKeyParams{
+		WorkspaceID: workspaceID,
+		Name:        "ci",
+		KeyHash:     middleware.HashAPIKey(key),
+		Scopes:      scopes,
+		ExpiresAt:   expiresAt,
+	})
+	require.NoError(t, err)
+
+	return created.ID, key
+}
+
+func Test_apiKeys(t *testing.T) {
+	fs := filestorage.NewDisk(t.TempDir())
+	db := testutils.CreateDB(t)
+	options := Options{JWTSecret: []byte("secret")}
+	server := New(db, fs, options)
+	t.Cleanup(func() { server.Close() })
+
+	var workspaceID int64 = 10
+
+	do := func(method, url, key string) int {
+		req := httptest.NewRequestWithContext(context.Background(), method, url, http.NoBody)
+		req.Header.Set("Authorization", "Bearer "+key)
+		res := httptest.NewRecorder()
+		server.ServeHTTP(res, req)
+		return res.Code
+	}
+
+	t.Run("should limit the key to its scopes", func(t *testing.T) {
+		_, key := createAPIKey(t, db, workspaceID, "read", sql.NullTime{})
+
+		assert.Equal(t, http.StatusOK, do(http.MethodGet, PathHTTPAPI+"/file", key))
+		assert.Equal(t, http.StatusForbidden, do(http.MethodGet, PathHTTPAPI+"/export", key))
+		assert.Equal(t, http.StatusForbidden, do(http.MethodDelete, PathHTTPAPI+"/file/1", key))
+		assert.Equal(t, http.StatusForbidden, do(http.MethodGet, PathHTTPAPI+"/key", key))
+
+		_, exportKey := createAPIKey(t, db, workspaceID, "export", sql.NullTime{})
+		assert.Equal(t, http.StatusOK, do(http.MethodGet, PathHTTPAPI+"/export", exportKey))
+		assert.Equal(t, http.StatusForbidden, do(http.MethodGet, PathHTTPAPI+"/file", exportKey))
+	})
+
+	t.Run("should reject expired and unknown keys", func(t *testing.T) {
+		_, expired := createAPIKey(t, db, workspaceID, "read", sql.NullTime{Time: time.Now().UTC().Add(-time.Minute), Valid: true})
+		assert.Equal(t, http.StatusUnauthorized, do(http.MethodGet, PathHTTPAPI+"/file", expired))
+
+		_, valid := createAPIKey(t, db, workspaceID, "read", sql.NullTime{Time: time.Now().UTC().Add(time.Hour), Valid: true})
+		assert.Equal(t, http.StatusOK, do(http.MethodGet, PathHTTPAPI+"/file", valid))
+
+		assert.Equal(t, http.StatusUnauthorized, do(http.MethodGet, PathHTTPAPI+"/file", middleware.APIKeyPrefix+"unknown"))
+	})
+
+	t.Run("should list and revoke the keys of the workspace", func(t *testing.T) {
+		adminID, adminKey := createAPIKey(t, db, workspaceID, "admin", sql.NullTime{})
+		keyID, key := createAPIKey(t, db, workspaceID, "read,write", sql.NullTime{})
+		createAPIKey(t, db, 11, "read", sql.NullTime{})
+
+		res, keys := testutils.DoRequest[[]APIKey](
+			t, server, http.MethodGet, PathHTTPAPI+"/key", nil, testutils.WithBearerToken(adminKey),
+		)
+		require.Equal(t, http.StatusOK, res.Code)
+		require.Len(t, keys, 6)
+		assert.Equal(t, adminID, keys[4].ID)
+		assert.Equal(t, APIKey{
+			ID:        keyID,
+			Name:      "ci",
+			Scopes:    middleware.Scopes{middleware.ScopeRead, middleware.ScopeWrite},
+			CreatedAt: keys[5].CreatedAt,
+		}, keys[5])
+
+		// members of the workspace can't manage the keys
+		res, _ = testutils.DoRequest[string](
+			t, server, http.MethodDelete, PathHTTPAPI+"/key/"+strconv.Itoa(int(keyID)), nil,
+			testutils.WithRoleAuthHeader(options.JWTSecret, workspaceID, middleware.RoleEditor),
+		)
+		assert.Equal(t, http.StatusForbidden, res.Code)
+
+		res, _ = testutils.DoRequest[string](
+			t, server, http.MethodDelete, PathHTTPAPI+"/key/"+strconv.Itoa(int(keyID)), nil,
+			testutils.WithAuthHeader(options.JWTSecret, workspaceID),
+		)
+		assert.Equal(t, http.StatusNoContent, res.Code)
+		assert.Equal(t, http.StatusUnauthorized, do(http.MethodGet, PathHTTPAPI+"/file", key))
+
+		res, _ = testutils.DoRequest[string](
+			t, server, http.MethodDelete, PathHTTPAPI+"/key/"+strconv.Itoa(int(keyID)), nil,
+			testutils.WithAuthHeader(options.JWTSecret, workspaceID),
+		)
+		assert.Equal(t, http.StatusNotFound, res.Code)
+	})
+}
@@ -0,0 +1,193 @@
+package syncinator
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/hiimjako/syncinator/internal/repository"
+	"github.com/hiimjako/syncinator/pkg/middleware"
+)
+
+// AuditAction is what an audit event records
+type AuditAction string
+
+const (
+	AuditLogin           AuditAction = "login"
+	AuditLoginFailed     AuditAction = "login.failed"
+	AuditLogout          AuditAction = "logout"
+	AuditFileCreate      AuditAction = "file.create"
+	AuditFileEdit        AuditAction = "file.edit"
+	AuditFileDelete      AuditAction = "file.delete"
+	AuditFileRename      AuditAction = "file.rename"
+	AuditFileReplace     AuditAction = "file.replace"
+	AuditSnapshotRestore AuditAction = "snapshot.restore"
+	AuditExport          AuditAction = "export"
+	AuditImport          AuditAction = "import"
+	AuditAPIKeyRevoke    AuditAction = "apikey.revoke"
+	AuditWorkspaceDelete AuditAction = "workspace.delete"
+)
+
+const (
+	// AuditActorWorkspace is the actor logged in with the shared password
+	AuditActorWorkspace = "workspace"
+	// AuditActorAnonymous is the actor of the failed logins
+	AuditActorAnonymous = "anonymous"
+)
+
+// eventAuditActions are the actions of the events sent on the sync socket
+var eventAuditActions = map[MessageType]AuditAction{
+	CreateEventType: AuditFileCreate,
+	DeleteEventType: AuditFileDelete,
+	RenameEventType: AuditFileRename,
+}
+
+const (
+	defaultAuditLimit = 100
+	maxAuditLimit     = 1000
+)
+
+type auditEvent struct {
+	workspaceID int64
+	actor       string
+	action      AuditAction
+	target      string
+	details     string
+	ip          string
+}
+
+// audit appends the event to the audit log. A failure doesn't undo the
+// action, which already happened, so it is only logged.
+func (s *syncinator) audit(ctx context.Context, event auditEvent) {
+	err := s.db.CreateAuditEvent(ctx, repository.CreateAuditEventParams{
+		WorkspaceID: event.workspaceID,
+		Actor:       event.actor,
+		Action:      string(event.action),
+		Target:      event.target,
+		Details:     event.details,
+		Ip:          event.ip,
+		CreatedAt:   time.Now().UTC(),
+	})
+	if err != nil {
+		log.Printf("error writing audit event %s of workspace %d: %v", event.action, event.workspaceID, err)
+	}
+}
+
+// auditRequest records the action done by the authenticated request
+func (s *syncinator) auditRequest(r *http.Request, action AuditAction, target, details string) {
+	workspaceID, _ := middleware.WorkspaceIDFromCtx(r.Context())
+	s.audit(r.Context(), auditEvent{
+		workspaceID: workspaceID,
+		actor:       auditActor(r.Context()),
+		action:      action,
+		target:      target,
+		details:     details,
+		ip:          clientIP(r),
+	})
+}
+
+// auditSubscriber records the action done by the client of the sync socket
+func (s *syncinator) auditSubscriber(sender *subscriber, action AuditAction, target string) {
+	s.audit(s.ctx, auditEvent{
+		workspaceID: sender.workspaceID,
+		actor:       sender.actor,
+		action:      action,
+		target:      target,
+		ip:          clientIP(sender.r),
+	})
+}
+
+// auditAnnouncedEvent records the change announced on the sync socket, unless
+// the same actor already did it with the api, which audited it.
+func (s *syncinator) auditAnnouncedEvent(sender *subscriber, event EventMessage, recorded repository.Event) {
+	action, ok := eventAuditActions[event.Type]
+	if !ok {
+		return
+	}
+
+	audited, err := s.db.FetchAuditEvents(s.ctx, repository.FetchAuditEventsParams{
+		WorkspaceID: sender.workspaceID,
+		Since:       recorded.CreatedAt.UTC(),
+		Until:       time.Now().UTC().Add(time.Minute),
+		Actor:       sender.actor,
+		Action:      string(action),
+		Limit:       maxAuditLimit,
+	})
+	if err != nil {
+		log.Printf("error fetching audit events of workspace %d: %v", sender.workspaceID, err)
+	}
+	for _, e := range audited {
+		if e.Target == event.WorkspacePath {
+			return
+		}
+	}
+
+	s.auditSubscriber(sender, action, event.WorkspacePath)
+}
+
+// auditActor identifies who authenticated the request: the user, the API key
+// or the shared password of the workspace
+func auditActor(ctx context.Context) string {
+	if _, ok := middleware.ScopesFromCtx(ctx); ok {
+		tokenID, _ := middleware.TokenIDFromCtx(ctx)
+		return tokenID
+	}
+	userID, _ := middleware.UserIDFromCtx(ctx)
+	return loginActor(userID)
+}
+
+func loginActor(userID int64) string {
+	if userID == 0 {
+		return AuditActorWorkspace
+	}
+	return "user:" + strconv.FormatInt(userID, 10)
+}
+
+// listAuditHandler returns the newest events first. They can be filtered by
+// "since" and "until" (RFC 3339), "actor" and "action", and "limit"ed.
+func (s *syncinator) listAuditHandler(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	params := repository.FetchAuditEventsParams{
+		Until:  time.Date(9999, time.December, 31, 0, 0, 0, 0, time.UTC),
+		Actor:  query.Get("actor"),
+		Action: query.Get("action"),
+		Limit:  defaultAuditLimit,
+	}
+	params.WorkspaceID, _ = middleware.WorkspaceIDFromCtx(r.Context())
+
+	for name, value := range map[string]*time.Time{"since": &params.Since, "until": &params.Until} {
+		if query.Get(name) == "" {
+			continue
+		}
+		parsed, err := time.Parse(time.RFC3339, query.Get(name))
+		if err != nil {
+			http.Error(w, "invalid "+name+" time", http.StatusBadRequest)
+			return
+		}
+		*value = parsed.UTC()
+	}
+
+	if query.Get("limit") != "" {
+		limit, err := strconv.ParseInt(query.Get("limit"), 10, 64)
+		if err != nil || limit <= 0 {
+			http.Error(w, "invalid limit", http.StatusBadRequest)
+			return
+		}
+		params.Limit = min(limit, maxAuditLimit)
+	}
+
+	events, err := s.db.FetchAuditEvents(r.Context(), params)
+	if err != nil {
+		log.Printf("error fetching audit events: %v", err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	if events == nil {
+		events = []repository.AuditEvent{}
+	}
+
+	writeJSON(w, http.StatusOK, events)
+}
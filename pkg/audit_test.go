@@ -0,0 +1,254 @@
+package syncinator
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/coder/websocket"
+	"github.com/coder/websocket/wsjson"
+	"github.com/hiimjako/syncinator/internal/repository"
+	"github.com/hiimjako/syncinator/internal/testutils"
+	"github.com/hiimjako/syncinator/pkg/diff"
+	"github.com/hiimjako/syncinator/pkg/filestorage"
+	"github.com/hiimjako/syncinator/pkg/middleware"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func Test_auditLog(t *testing.T) {
+	mockFileStorage := new(filestorage.MockFileStorage)
+	db := testutils.CreateDB(t)
+	options := Options{JWTSecret: []byte("secret")}
+	server := New(db, mockFileStorage, options)
+	t.Cleanup(func() { server.Close() })
+
+	var workspaceID int64 = 10
+	userID := addRestrictedMember(t, db, workspaceID, "alice", nil)
+	asAlice := testutils.WithUserAuthHeader(options.JWTSecret, workspaceID, userID, middleware.RoleEditor)
+	asAdmin := testutils.WithAuthHeader(options.JWTSecret, workspaceID)
+	alice := "user:" + strconv.FormatInt(userID, 10)
+
+	mockFileStorage.On("CreateObject", mock.AnythingOfType("multipart.sectionReadCloser")).Return("/foo/bar", nil).Once()
+	mockFileStorage.On("DeleteObject", "/foo/bar").Return(nil).Once()
+
+	form, contentType := testutils.CreateMultipart(t, "notes/todo.md", []byte("todo"), false)
+	res, file := testutils.DoRequest[repository.File](t, server, http.MethodPost, PathHTTPAPI+"/file", form,
+		asAlice, testutils.WithContentTypeHeader(contentType))
+	require.Equal(t, http.StatusCreated, res.Code)
+
+	res, _ = testutils.DoRequest[repository.File](t, server, http.MethodPatch, PathHTTPAPI+"/file/"+strconv.FormatInt(file.ID, 10),
+		UpdateFileBody{Path: "notes/done.md"}, asAlice)
+	require.Equal(t, http.StatusOK, res.Code)
+
+	res, _ = testutils.DoRequest[string](t, server, http.MethodDelete, PathHTTPAPI+"/file/"+strconv.FormatInt(file.ID, 10), nil, asAdmin)
+	require.Equal(t, http.StatusNoContent, res.Code)
+
+	t.Run("records who did what, newest first", func(t *testing.T) {
+		res, events := testutils.DoRequest[[]repository.AuditEvent](t, server, http.MethodGet, PathHTTPAPI+"/audit", nil, asAdmin)
+		require.Equal(t, http.StatusOK, res.Code)
+		require.Len(t, events, 3)
+
+		assert.Equal(t, string(AuditFileDelete), events[0].Action)
+		assert.Equal(t, AuditActorWorkspace, events[0].Actor)
+		assert.Equal(t, "notes/done.md", events[0].Target)
+
+		assert.Equal(t, string(AuditFileRename), events[1].Action)
+		assert.Equal(t, alice, events[1].Actor)
+		assert.Equal(t, "notes/done.md", events[1].Target)
+		assert.Equal(t, "from notes/todo.md", events[1].Details)
+
+		assert.Equal(t, string(AuditFileCreate), events[2].Action)
+		assert.Equal(t, alice, events[2].Actor)
+		assert.Equal(t, "notes/todo.md", events[2].Target)
+		assert.NotEmpty(t, events[2].Ip)
+	})
+
+	t.Run("filters", func(t *testing.T) {
+		res, events := testutils.DoRequest[[]repository.AuditEvent](t, server, http.MethodGet,
+			PathHTTPAPI+"/audit?action=file.delete", nil, asAdmin)
+		require.Equal(t, http.StatusOK, res.Code)
+		require.Len(t, events, 1)
+		assert.Equal(t, AuditActorWorkspace, events[0].Actor)
+
+		res, events = testutils.DoRequest[[]repository.AuditEvent](t, server, http.MethodGet,
+			PathHTTPAPI+"/audit?actor="+alice+"&limit=1", nil, asAdmin)
+		require.Equal(t, http.StatusOK, res.Code)
+		require.Len(t, events, 1)
+		assert.Equal(t, string(AuditFileRename), events[0].Action)
+
+		since := time.Now().Add(time.Hour).Format(time.RFC3339)
+		res, events = testutils.DoRequest[[]repository.AuditEvent](t, server, http.MethodGet,
+			PathHTTPAPI+"/audit?since="+since, nil, asAdmin)
+		require.Equal(t, http.StatusOK, res.Code)
+		assert.Empty(t, events)
+
+		until := time.Now().Add(time.Hour).Format(time.RFC3339)
+		res, events = testutils.DoRequest[[]repository.AuditEvent](t, server, http.MethodGet,
+			PathHTTPAPI+"/audit?until="+until, nil, asAdmin)
+		require.Equal(t, http.StatusOK, res.Code)
+		assert.Len(t, events, 3)
+
+		res, _ = testutils.DoRequest[string](t, server, http.MethodGet, PathHTTPAPI+"/audit?since=yesterday", nil, asAdmin)
+		assert.Equal(t, http.StatusBadRequest, res.Code)
+	})
+
+	t.Run("only admins read the log", func(t *testing.T) {
+		res, _ := testutils.DoRequest[string](t, server, http.MethodGet, PathHTTPAPI+"/audit", nil, asAlice)
+		assert.Equal(t, http.StatusForbidden, res.Code)
+	})
+
+	t.Run("other workspaces don't see the events", func(t *testing.T) {
+		res, events := testutils.DoRequest[[]repository.AuditEvent](t, server, http.MethodGet, PathHTTPAPI+"/audit", nil,
+			testutils.WithAuthHeader(options.JWTSecret, 11))
+		require.Equal(t, http.StatusOK, res.Code)
+		assert.Empty(t, events)
+	})
+
+	t.Run("events can't be changed", func(t *testing.T) {
+		_, err := db.ExecContext(context.Background(), "UPDATE audit_events SET actor = 'someone'")
+		assert.Error(t, err)
+		_, err = db.ExecContext(context.Background(), "DELETE FROM audit_events")
+		assert.Error(t, err)
+	})
+}
+
+func Test_auditLog_Login(t *testing.T) {
+	db := testutils.CreateDB(t)
+	server := New(db, new(filestorage.MockFileStorage), Options{JWTSecret: []byte("secret")})
+	t.Cleanup(func() { server.Close() })
+
+	hash, err := bcrypt.GenerateFromPassword([]byte("strong_password"), bcrypt.MinCost)
+	require.NoError(t, err)
+	require.NoError(t, server.db.AddWorkspace(context.Background(), repository.AddWorkspaceParams{
+		Name:     "audited",
+		Password: string(hash),
+	}))
+	workspace, err := server.db.FetchWorkspace(context.Background(), "audited")
+	require.NoError(t, err)
+
+	res, _ := testutils.DoRequest[string](t, server, http.MethodPost, PathHTTPAuth+"/login",
+		WorkspaceCredentials{Name: "audited", User: "mallory", Password: "guess"})
+	require.Equal(t, http.StatusUnauthorized, res.Code)
+
+	res, _ = testutils.DoRequest[LoginResponse](t, server, http.MethodPost, PathHTTPAuth+"/login",
+		WorkspaceCredentials{Name: "audited", Password: "strong_password"})
+	require.Equal(t, http.StatusOK, res.Code)
+
+	events, err := server.db.FetchAuditEvents(context.Background(), repository.FetchAuditEventsParams{
+		WorkspaceID: workspace.ID,
+		Until:       time.Now().Add(time.Hour),
+		Limit:       10,
+	})
+	require.NoError(t, err)
+	require.Len(t, events, 2)
+
+	assert.Equal(t, string(AuditLogin), events[0].Action)
+	assert.Equal(t, AuditActorWorkspace, events[0].Actor)
+
+	assert.Equal(t, string(AuditLoginFailed), events[1].Action)
+	assert.Equal(t, AuditActorAnonymous, events[1].Actor)
+	assert.Equal(t, "workspace audited, user mallory", events[1].Details)
+	assert.NotEmpty(t, events[1].Ip)
+}
+
+func Test_auditLog_WebSocket(t *testing.T) {
+	db := testutils.CreateDB(t)
+	options := Options{JWTSecret: []byte("secret"), FlushInterval: time.Hour}
+	handler := New(db, filestorage.NewDisk(t.TempDir()), options)
+	ts := httptest.NewServer(handler)
+	t.Cleanup(func() {
+		ts.Close()
+		handler.Close()
+	})
+
+	var workspaceID int64 = 1
+	userID := addRestrictedMember(t, db, workspaceID, "alice", nil)
+	asAlice := testutils.WithUserAuthHeader(options.JWTSecret, workspaceID, userID, middleware.RoleEditor)
+	alice := "user:" + strconv.FormatInt(userID, 10)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	t.Cleanup(cancel)
+
+	//nolint:bodyclose
+	sender, _, err := websocket.Dial(ctx, createWsURLWithUser(t, ts.URL, workspaceID, userID, options.JWTSecret, middleware.RoleEditor), nil)
+	require.NoError(t, err)
+	t.Cleanup(func() { sender.CloseNow() })
+
+	// changed with the api, which audits it
+	form, contentType := testutils.CreateMultipart(t, "notes/todo.md", []byte("todo"), false)
+	res, file := testutils.DoRequest[repository.File](t, handler, http.MethodPost, PathHTTPAPI+"/file", form,
+		asAlice, testutils.WithContentTypeHeader(contentType))
+	require.Equal(t, http.StatusCreated, res.Code)
+
+	res, file = testutils.DoRequest[repository.File](t, handler, http.MethodPatch, PathHTTPAPI+"/file/"+strconv.FormatInt(file.ID, 10),
+		UpdateFileBody{Path: "notes/done.md"}, asAlice)
+	require.Equal(t, http.StatusOK, res.Code)
+
+	// deleted without an audit event, e.g. before it was introduced
+	require.NoError(t, handler.db.CreateDeletedFile(ctx, repository.CreateDeletedFileParams{
+		FileID:        999,
+		WorkspaceID:   workspaceID,
+		WorkspacePath: "notes/old.md",
+	}))
+	_, err = handler.db.CreateEvent(ctx, repository.CreateEventParams{
+		WorkspaceID:   workspaceID,
+		FileID:        999,
+		Type:          int64(DeleteEventType),
+		WorkspacePath: "notes/old.md",
+		ObjectType:    "file",
+	})
+	require.NoError(t, err)
+
+	for _, event := range []EventMessage{
+		{WsMessageHeader: WsMessageHeader{Type: RenameEventType, FileID: file.ID}, WorkspacePath: "notes/done.md"},
+		{WsMessageHeader: WsMessageHeader{Type: DeleteEventType, FileID: 999}, WorkspacePath: "notes/old.md"},
+	} {
+		event.ObjectType = "file"
+		require.NoError(t, wsjson.Write(ctx, sender, event))
+	}
+
+	for i := range 2 {
+		require.NoError(t, wsjson.Write(ctx, sender, ChunkMessage{
+			WsMessageHeader: WsMessageHeader{Type: ChunkEventType, FileID: file.ID},
+			RequestID:       "edit-" + strconv.Itoa(i),
+			Version:         int64(i),
+			Chunks:          []diff.Chunk{{Position: 0, Type: diff.Add, Text: "a", Len: 1}},
+		}))
+	}
+
+	// the messages are handled in order, the last ack comes after the others
+	for {
+		var msg map[string]any
+		require.NoError(t, wsjson.Read(ctx, sender, &msg))
+		if msg["type"] == float64(AckEventType) && msg["requestId"] == "edit-1" {
+			break
+		}
+	}
+
+	events, err := handler.db.FetchAuditEvents(ctx, repository.FetchAuditEventsParams{
+		WorkspaceID: workspaceID,
+		Until:       time.Now().Add(time.Hour),
+		Limit:       10,
+	})
+	require.NoError(t, err)
+
+	var recorded [][2]string
+	for _, event := range events {
+		assert.Equal(t, alice, event.Actor)
+		recorded = append(recorded, [2]string{event.Action, event.Target})
+	}
+	// the rename announced after the api one is recorded once, the edits
+	// once per session
+	assert.Equal(t, [][2]string{
+		{string(AuditFileEdit), "notes/done.md"},
+		{string(AuditFileDelete), "notes/old.md"},
+		{string(AuditFileRename), "notes/done.md"},
+		{string(AuditFileCreate), "notes/todo.md"},
+	}, recorded)
+}
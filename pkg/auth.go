@@ -1,32 +1,72 @@
 package syncinator
 
 import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"io"
+	"log"
+	"math"
 	"net/http"
+	"strconv"
+	"time"
 
+	"github.com/hiimjako/syncinator/internal/repository"
 	"github.com/hiimjako/syncinator/pkg/middleware"
 	"golang.org/x/crypto/bcrypt"
 )
 
+// WorkspaceCredentials logs in the workspace Name. Members send their own User
+// and Password, otherwise Password is the shared one of the workspace.
 type WorkspaceCredentials struct {
 	Name     string `json:"name"`
+	User     string `json:"user,omitempty"`
 	Password string `json:"password"`
 }
 
 type LoginResponse struct {
 	Token string `json:"token"`
+	// RefreshToken gets a new pair of tokens once Token expires, it can be
+	// used only once
+	RefreshToken string `json:"refreshToken"`
+	// EndToEndEncrypted tells the client to encrypt the files and operations
+	// it sends, since the server treats them as opaque
+	EndToEndEncrypted bool `json:"endToEndEncrypted"`
+}
+
+type RefreshRequest struct {
+	RefreshToken string `json:"refreshToken"`
 }
 
 const (
-	ErrIncorrectPassword  = "incorrect password"
-	ErrWorkspaceNotFound  = "workspace not found"
-	ErrInvalidCredentials = "invalid credentials" //nolint:gosec
+	ErrIncorrectPassword    = "incorrect password"
+	ErrWorkspaceNotFound    = "workspace not found"
+	ErrInvalidCredentials   = "invalid credentials"   //nolint:gosec
+	ErrInvalidRefreshToken  = "invalid refresh token" //nolint:gosec
+	ErrTooManyLoginAttempts = "too many failed login attempts, retry later"
 )
 
 func (s *syncinator) authHandler() http.Handler {
 	router := http.NewServeMux()
 	router.HandleFunc("POST /login", s.fetchWorkspaceHandler)
+	router.HandleFunc("POST /refresh", s.refreshTokenHandler)
+	if s.oidc != nil {
+		router.HandleFunc("GET /oidc/login", s.oidcLoginHandler)
+		router.HandleFunc("GET /oidc/callback", s.oidcCallbackHandler)
+	}
+
+	// API keys are revoked through the api instead
+	jwtOnly := s.authOptions()
+	jwtOnly.LookupAPIKey = nil
+	router.Handle("POST /logout", middleware.IsAuthenticated(jwtOnly, middleware.ExtractBearerToken)(
+		http.HandlerFunc(s.logoutHandler),
+	))
 
 	stack := middleware.CreateStack(
 		middleware.Logging,
@@ -57,27 +97,138 @@ func (s *syncinator) fetchWorkspaceHandler(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
-	workspace, err := s.db.FetchWorkspace(r.Context(), data.Name)
+	ip := clientIP(r)
+	if wait := s.loginThrottle.reserve(time.Now(), ip, data.Name); wait > 0 {
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+		http.Error(w, ErrTooManyLoginAttempts, http.StatusTooManyRequests)
+		return
+	}
+
+	var workspace repository.FetchMemberWorkspaceRow
+	var userID int64
+	if data.User != "" {
+		workspace, userID, err = s.authenticateMember(r.Context(), data)
+	} else {
+		workspace, err = s.authenticateWorkspace(r.Context(), data)
+	}
 	if err != nil {
-		// dummy bcrypt to prevent timing oracle on workspace existence
-		bcrypt.CompareHashAndPassword([]byte("$2a$10$dummy"), []byte(data.Password)) //nolint:errcheck
+		s.auditFailedLogin(r, data)
 		http.Error(w, ErrInvalidCredentials, http.StatusUnauthorized)
 		return
 	}
+	s.loginThrottle.recordSuccess(ip, data.Name)
 
-	if err := bcrypt.CompareHashAndPassword([]byte(workspace.Password), []byte(data.Password)); err != nil {
-		http.Error(w, ErrInvalidCredentials, http.StatusUnauthorized)
+	s.audit(r.Context(), auditEvent{
+		workspaceID: workspace.ID,
+		actor:       loginActor(userID),
+		action:      AuditLogin,
+		ip:          ip,
+	})
+
+	s.writeLoginResponse(w, r, workspace, userID)
+}
+
+// refreshTokenHandler trades a refresh token for a new pair of tokens. The
+// role is read again, so the changes to the membership apply on refresh.
+func (s *syncinator) refreshTokenHandler(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxBodySize)
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, "request body too large", http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	var data RefreshRequest
+	if err := json.Unmarshal(body, &data); err != nil {
+		http.Error(w, "error parsing JSON", http.StatusBadRequest)
 		return
 	}
 
-	token, err := middleware.CreateToken(middleware.AuthOptions{SecretKey: s.jwtSecret}, workspace.ID)
+	// consuming the token rotates it, a stolen copy is useless once used
+	refreshToken, err := s.db.ConsumeRefreshToken(r.Context(), repository.ConsumeRefreshTokenParams{
+		TokenHash: hashRefreshToken(data.RefreshToken),
+		ExpiresAt: time.Now().UTC(),
+	})
+	if err != nil {
+		if !errors.Is(err, sql.ErrNoRows) {
+			log.Printf("error consuming refresh token: %v", err)
+		}
+		http.Error(w, ErrInvalidRefreshToken, http.StatusUnauthorized)
+		return
+	}
+
+	workspace, err := s.fetchTokenWorkspace(r.Context(), refreshToken.WorkspaceID, refreshToken.UserID)
+	if err != nil {
+		http.Error(w, ErrInvalidRefreshToken, http.StatusUnauthorized)
+		return
+	}
+
+	s.writeLoginResponse(w, r, workspace, refreshToken.UserID)
+}
+
+// logoutHandler revokes the token of the request, along with its refresh
+// token, and disconnects the clients using it.
+func (s *syncinator) logoutHandler(w http.ResponseWriter, r *http.Request) {
+	workspaceID, _ := middleware.WorkspaceIDFromCtx(r.Context())
+	tokenID, _ := middleware.TokenIDFromCtx(r.Context())
+
+	if err := s.revokeToken(r.Context(), tokenID); err != nil {
+		log.Printf("error revoking token: %v", err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	s.disconnectToken(workspaceID, tokenID)
+	s.auditRequest(r, AuditLogout, "", "")
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// auditFailedLogin records the failure in the workspace, if it exists, so
+// that its admins can see it.
+func (s *syncinator) auditFailedLogin(r *http.Request, data WorkspaceCredentials) {
+	var workspaceID int64
+	if workspace, err := s.db.FetchWorkspace(r.Context(), data.Name); err == nil {
+		workspaceID = workspace.ID
+	}
+
+	details := "workspace " + data.Name
+	if data.User != "" {
+		details += ", user " + data.User
+	}
+
+	s.audit(r.Context(), auditEvent{
+		workspaceID: workspaceID,
+		actor:       AuditActorAnonymous,
+		action:      AuditLoginFailed,
+		details:     details,
+		ip:          clientIP(r),
+	})
+}
+
+func (s *syncinator) writeLoginResponse(
+	w http.ResponseWriter,
+	r *http.Request,
+	workspace repository.FetchMemberWorkspaceRow,
+	userID int64,
+) {
+	token, claims, err := middleware.IssueToken(s.authOptions(), workspace.ID, userID, middleware.Role(workspace.Role))
 	if err != nil {
 		http.Error(w, "error while creating auth token", http.StatusInternalServerError)
 		return
 	}
 
+	refreshToken, err := s.createRefreshToken(r.Context(), workspace.ID, userID, claims.ID)
+	if err != nil {
+		log.Printf("error creating refresh token: %v", err)
+		http.Error(w, "error while creating auth token", http.StatusInternalServerError)
+		return
+	}
+
 	response := LoginResponse{
-		Token: token,
+		Token:             token,
+		RefreshToken:      refreshToken,
+		EndToEndEncrypted: workspace.EndToEndEncrypted,
 	}
 
 	w.Header().Set("Content-Type", "application/json")
@@ -87,3 +238,197 @@ func (s *syncinator) fetchWorkspaceHandler(w http.ResponseWriter, r *http.Reques
 		return
 	}
 }
+
+func (s *syncinator) authOptions() middleware.AuthOptions {
+	return middleware.AuthOptions{
+		SecretKey:    s.jwtSecret,
+		Keys:         s.jwtKeys,
+		IsRevoked:    s.isTokenRevoked,
+		LookupAPIKey: s.lookupAPIKey,
+		LookupRole:   s.lookupRole,
+	}
+}
+
+// jwksHandler publishes the public keys of the tokens, so that other services
+// can verify them
+func (s *syncinator) jwksHandler(w http.ResponseWriter, _ *http.Request) {
+	jwks := middleware.JWKS{Keys: []middleware.JWK{}}
+	if s.jwtKeys != nil {
+		jwks = s.jwtKeys.JWKS()
+	}
+
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	writeJSON(w, http.StatusOK, jwks)
+}
+
+func (s *syncinator) isTokenRevoked(ctx context.Context, tokenID string) (bool, error) {
+	revoked, err := s.db.IsTokenRevoked(ctx, tokenID)
+	if err != nil {
+		return false, fmt.Errorf("checking token revocation: %w", err)
+	}
+	return revoked != 0, nil
+}
+
+// lookupRole returns the current role of the user in the workspace, empty if
+// the user isn't a member anymore or the workspace was deleted. The shared
+// password belongs to the owners.
+func (s *syncinator) lookupRole(ctx context.Context, workspaceID, userID int64) (middleware.Role, error) {
+	if userID == 0 {
+		_, err := s.db.FetchWorkspaceEndToEndEncrypted(ctx, workspaceID)
+		if errors.Is(err, sql.ErrNoRows) {
+			return "", nil
+		}
+		if err != nil {
+			return "", fmt.Errorf("fetching workspace: %w", err)
+		}
+		return middleware.RoleAdmin, nil
+	}
+
+	role, err := s.db.FetchWorkspaceMemberRole(ctx, repository.FetchWorkspaceMemberRoleParams{
+		WorkspaceID: workspaceID,
+		UserID:      userID,
+	})
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("fetching member role: %w", err)
+	}
+	return middleware.Role(role), nil
+}
+
+// revokeToken rejects the token until it expires, its refresh token is
+// deleted so that it can't be traded for a new one.
+func (s *syncinator) revokeToken(ctx context.Context, tokenID string) error {
+	tx, err := s.conn.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("opening transaction: %w", err)
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	txq := s.db.WithTx(tx)
+
+	// the token was issued before now, so it expires within a TokenTTL
+	err = txq.RevokeToken(ctx, repository.RevokeTokenParams{
+		Jti:       tokenID,
+		ExpiresAt: time.Now().UTC().Add(middleware.TokenTTL),
+	})
+	if err != nil {
+		return fmt.Errorf("revoking token: %w", err)
+	}
+
+	if err := txq.DeleteAccessTokenRefreshTokens(ctx, tokenID); err != nil {
+		return fmt.Errorf("deleting refresh tokens: %w", err)
+	}
+
+	return tx.Commit()
+}
+
+// createRefreshToken returns a new random refresh token, only its hash is
+// stored.
+func (s *syncinator) createRefreshToken(ctx context.Context, workspaceID, userID int64, accessTokenID string) (string, error) {
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return "", fmt.Errorf("generating refresh token: %w", err)
+	}
+	token := base64.RawURLEncoding.EncodeToString(raw)
+
+	err := s.db.CreateRefreshToken(ctx, repository.CreateRefreshTokenParams{
+		TokenHash:     hashRefreshToken(token),
+		WorkspaceID:   workspaceID,
+		UserID:        userID,
+		AccessTokenID: accessTokenID,
+		ExpiresAt:     time.Now().UTC().Add(s.refreshTokenTTL),
+	})
+	if err != nil {
+		return "", fmt.Errorf("storing refresh token: %w", err)
+	}
+
+	return token, nil
+}
+
+// hashRefreshToken doesn't need a slow hash, refresh tokens are random and
+// long enough to not be guessed
+func hashRefreshToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// fetchTokenWorkspace returns the workspace the refreshed token grants access
+// to, failing if the user isn't a member anymore.
+func (s *syncinator) fetchTokenWorkspace(ctx context.Context, workspaceID, userID int64) (repository.FetchMemberWorkspaceRow, error) {
+	endToEnd, err := s.db.FetchWorkspaceEndToEndEncrypted(ctx, workspaceID)
+	if err != nil {
+		return repository.FetchMemberWorkspaceRow{}, fmt.Errorf("fetching workspace: %w", err)
+	}
+
+	role := string(middleware.RoleAdmin)
+	if userID != 0 {
+		role, err = s.db.FetchWorkspaceMemberRole(ctx, repository.FetchWorkspaceMemberRoleParams{
+			WorkspaceID: workspaceID,
+			UserID:      userID,
+		})
+		if err != nil {
+			return repository.FetchMemberWorkspaceRow{}, fmt.Errorf("fetching member role: %w", err)
+		}
+	}
+
+	return repository.FetchMemberWorkspaceRow{
+		ID:                workspaceID,
+		EndToEndEncrypted: endToEnd,
+		Role:              role,
+	}, nil
+}
+
+var errInvalidCredentials = errors.New("invalid credentials")
+
+// dummyPasswordHash is compared when the workspace or the user doesn't exist,
+// so that the response takes as long as with a wrong password. It must be a
+// valid hash with the default cost, otherwise bcrypt fails right away.
+const dummyPasswordHash = "$2a$10$wOh0yBokWyxO797KH2VnC.0ROKi5iDzoccyVFHNmPxaC07zBhP.jS" //nolint:gosec
+
+// authenticateWorkspace checks the shared password of the workspace
+func (s *syncinator) authenticateWorkspace(ctx context.Context, data WorkspaceCredentials) (repository.FetchMemberWorkspaceRow, error) {
+	workspace, err := s.db.FetchWorkspace(ctx, data.Name)
+	if err != nil {
+		// dummy bcrypt to prevent timing oracle on workspace existence
+		bcrypt.CompareHashAndPassword([]byte(dummyPasswordHash), []byte(data.Password)) //nolint:errcheck
+		return repository.FetchMemberWorkspaceRow{}, errInvalidCredentials
+	}
+
+	if err := bcrypt.CompareHashAndPassword([]byte(workspace.Password), []byte(data.Password)); err != nil {
+		return repository.FetchMemberWorkspaceRow{}, errInvalidCredentials
+	}
+
+	// the shared password belongs to the owners of the workspace
+	return repository.FetchMemberWorkspaceRow{
+		ID:                workspace.ID,
+		EndToEndEncrypted: workspace.EndToEndEncrypted,
+		Role:              string(middleware.RoleAdmin),
+	}, nil
+}
+
+// authenticateMember checks the password of the user, who must be a member of
+// the workspace. The membership is checked last, to not disclose it.
+func (s *syncinator) authenticateMember(ctx context.Context, data WorkspaceCredentials) (repository.FetchMemberWorkspaceRow, int64, error) {
+	user, err := s.db.FetchUser(ctx, data.User)
+	if err != nil {
+		// dummy bcrypt to prevent timing oracle on user existence
+		bcrypt.CompareHashAndPassword([]byte(dummyPasswordHash), []byte(data.Password)) //nolint:errcheck
+		return repository.FetchMemberWorkspaceRow{}, 0, errInvalidCredentials
+	}
+
+	if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(data.Password)); err != nil {
+		return repository.FetchMemberWorkspaceRow{}, 0, errInvalidCredentials
+	}
+
+	workspace, err := s.db.FetchMemberWorkspace(ctx, repository.FetchMemberWorkspaceParams{
+		Name:   data.Name,
+		UserID: user.ID,
+	})
+	if err != nil {
+		return repository.FetchMemberWorkspaceRow{}, 0, errInvalidCredentials
+	}
+
+	return workspace, user.ID, nil
+}
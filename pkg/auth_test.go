@@ -1,17 +1,27 @@
 package syncinator
 
 import (
+	"bytes"
 	"context"
+	"crypto/ed25519"
+	"crypto/rand"
 	"database/sql"
+	"encoding/base64"
+	"encoding/json"
 	"net/http"
+	"net/http/httptest"
 	"regexp"
 	"strings"
 	"testing"
+	"time"
 
+	"github.com/coder/websocket"
+	"github.com/golang-jwt/jwt/v5"
 	"github.com/hiimjako/syncinator/internal/migration"
 	"github.com/hiimjako/syncinator/internal/repository"
 	"github.com/hiimjako/syncinator/internal/testutils"
 	"github.com/hiimjako/syncinator/pkg/filestorage"
+	"github.com/hiimjako/syncinator/pkg/middleware"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"golang.org/x/crypto/bcrypt"
@@ -73,6 +83,62 @@ func Test_fetchWorkspaceHandler(t *testing.T) {
 		assert.Equal(t, ErrInvalidCredentials, body)
 	})
 
+	t.Run("member of the workspace", func(t *testing.T) {
+		ctx := context.Background()
+		userHash, err := bcrypt.GenerateFromPassword([]byte("member_password"), bcrypt.DefaultCost)
+		require.NoError(t, err)
+
+		require.NoError(t, server.db.AddUser(ctx, repository.AddUserParams{Name: "alice", Password: string(userHash)}))
+		require.NoError(t, server.db.AddUser(ctx, repository.AddUserParams{Name: "bob", Password: string(userHash)}))
+		alice, err := server.db.FetchUser(ctx, "alice")
+		require.NoError(t, err)
+		workspace, err := server.db.FetchWorkspace(ctx, "workspace1")
+		require.NoError(t, err)
+
+		require.NoError(t, server.db.AddWorkspaceMember(ctx, repository.AddWorkspaceMemberParams{
+			WorkspaceID: workspace.ID,
+			UserID:      alice.ID,
+			Role:        string(middleware.RoleViewer),
+		}))
+
+		data := WorkspaceCredentials{Name: "workspace1", User: "alice", Password: "member_password"}
+		res, body := testutils.DoRequest[LoginResponse](t, server, http.MethodPost, apiPath, data)
+		require.Equal(t, http.StatusOK, res.Code)
+
+		claims, err := middleware.VerifyToken(middleware.AuthOptions{SecretKey: []byte("secret")}, body.Token)
+		require.NoError(t, err)
+		assert.Equal(t, workspace.ID, claims.WorkspaceID)
+		assert.Equal(t, alice.ID, claims.UserID)
+		assert.Equal(t, middleware.RoleViewer, claims.Role)
+
+		for name, data := range map[string]WorkspaceCredentials{
+			"wrong password": {Name: "workspace1", User: "alice", Password: "strong_password"},
+			"not a member":   {Name: "workspace1", User: "bob", Password: "member_password"},
+			"missing user":   {Name: "workspace1", User: "carol", Password: "member_password"},
+		} {
+			res, body := testutils.DoRequest[string](t, server, http.MethodPost, apiPath, data)
+			assert.Equal(t, http.StatusUnauthorized, res.Code, name)
+			assert.Equal(t, ErrInvalidCredentials, body, name)
+		}
+
+		// revoking a member doesn't affect the others
+		removed, err := server.db.DeleteWorkspaceMember(ctx, repository.DeleteWorkspaceMemberParams{
+			WorkspaceID: workspace.ID,
+			UserID:      alice.ID,
+		})
+		require.NoError(t, err)
+		assert.Equal(t, int64(1), removed)
+
+		res, _ = testutils.DoRequest[string](t, server, http.MethodPost, apiPath, data)
+		assert.Equal(t, http.StatusUnauthorized, res.Code)
+
+		res, _ = testutils.DoRequest[string](t, server, http.MethodPost, apiPath, WorkspaceCredentials{
+			Name:     "workspace1",
+			Password: "strong_password",
+		})
+		assert.Equal(t, http.StatusOK, res.Code)
+	})
+
 	t.Run("oversized body", func(t *testing.T) {
 		largeBody := strings.Repeat("x", 2<<20) // 2MB
 		res, _ := testutils.DoRequest[string](t, server, http.MethodPost, apiPath, largeBody)
@@ -84,3 +150,205 @@ func Test_fetchWorkspaceHandler(t *testing.T) {
 		db.Close()
 	})
 }
+
+func Test_refreshAndLogout(t *testing.T) {
+	db, err := sql.Open("sqlite3", ":memory:")
+	require.NoError(t, err)
+	require.NoError(t, migration.Migrate(db))
+
+	server := New(db, new(filestorage.MockFileStorage), Options{JWTSecret: []byte("secret")})
+	ts := httptest.NewServer(server)
+	t.Cleanup(func() {
+		ts.Close()
+		server.Close()
+		db.Close()
+	})
+
+	ctx := context.Background()
+	hash, err := bcrypt.GenerateFromPassword([]byte("member_password"), bcrypt.MinCost)
+	require.NoError(t, err)
+	require.NoError(t, server.db.AddWorkspace(ctx, repository.AddWorkspaceParams{Name: "workspace1", Password: string(hash)}))
+	require.NoError(t, server.db.AddUser(ctx, repository.AddUserParams{Name: "alice", Password: string(hash)}))
+	workspace, err := server.db.FetchWorkspace(ctx, "workspace1")
+	require.NoError(t, err)
+	alice, err := server.db.FetchUser(ctx, "alice")
+	require.NoError(t, err)
+	require.NoError(t, server.db.AddWorkspaceMember(ctx, repository.AddWorkspaceMemberParams{
+		WorkspaceID: workspace.ID,
+		UserID:      alice.ID,
+		Role:        string(middleware.RoleEditor),
+	}))
+
+	login := func(t *testing.T) LoginResponse {
+		data := WorkspaceCredentials{Name: "workspace1", User: "alice", Password: "member_password"}
+		res, body := testutils.DoRequest[LoginResponse](t, server, http.MethodPost, PathHTTPAuth+"/login", data)
+		require.Equal(t, http.StatusOK, res.Code)
+		require.NotEmpty(t, body.RefreshToken)
+		return body
+	}
+
+	refresh := func(t *testing.T, refreshToken string) (*httptest.ResponseRecorder, LoginResponse) {
+		body, err := json.Marshal(RefreshRequest{RefreshToken: refreshToken})
+		require.NoError(t, err)
+
+		req := httptest.NewRequestWithContext(ctx, http.MethodPost, PathHTTPAuth+"/refresh", bytes.NewReader(body))
+		res := httptest.NewRecorder()
+		server.ServeHTTP(res, req)
+
+		var response LoginResponse
+		if res.Code == http.StatusOK {
+			require.NoError(t, json.Unmarshal(res.Body.Bytes(), &response))
+		}
+		return res, response
+	}
+
+	listFiles := func(t *testing.T, token string) int {
+		req := httptest.NewRequestWithContext(ctx, http.MethodGet, PathHTTPAPI+"/file", http.NoBody)
+		req.Header.Set("Authorization", "Bearer "+token)
+		res := httptest.NewRecorder()
+		server.ServeHTTP(res, req)
+		return res.Code
+	}
+
+	t.Run("should rotate the refresh token", func(t *testing.T) {
+		first := login(t)
+
+		res, refreshed := refresh(t, first.RefreshToken)
+		require.Equal(t, http.StatusOK, res.Code)
+		assert.NotEqual(t, first.RefreshToken, refreshed.RefreshToken)
+		assert.Equal(t, http.StatusOK, listFiles(t, refreshed.Token))
+
+		res, _ = refresh(t, first.RefreshToken)
+		assert.Equal(t, http.StatusUnauthorized, res.Code, "a refresh token can be used only once")
+
+		res, _ = refresh(t, "not-a-token")
+		assert.Equal(t, http.StatusUnauthorized, res.Code)
+	})
+
+	t.Run("should apply the membership changes on refresh", func(t *testing.T) {
+		first := login(t)
+
+		_, err := server.db.UpdateWorkspaceMemberRole(ctx, repository.UpdateWorkspaceMemberRoleParams{
+			Role:        string(middleware.RoleViewer),
+			WorkspaceID: workspace.ID,
+			UserID:      alice.ID,
+		})
+		require.NoError(t, err)
+
+		res, refreshed := refresh(t, first.RefreshToken)
+		require.Equal(t, http.StatusOK, res.Code)
+		claims, err := middleware.VerifyToken(middleware.AuthOptions{SecretKey: []byte("secret")}, refreshed.Token)
+		require.NoError(t, err)
+		assert.Equal(t, middleware.RoleViewer, claims.Role)
+
+		_, err = server.db.DeleteWorkspaceMember(ctx, repository.DeleteWorkspaceMemberParams{
+			WorkspaceID: workspace.ID,
+			UserID:      alice.ID,
+		})
+		require.NoError(t, err)
+
+		res, _ = refresh(t, refreshed.RefreshToken)
+		assert.Equal(t, http.StatusUnauthorized, res.Code)
+
+		require.NoError(t, server.db.AddWorkspaceMember(ctx, repository.AddWorkspaceMemberParams{
+			WorkspaceID: workspace.ID,
+			UserID:      alice.ID,
+			Role:        string(middleware.RoleEditor),
+		}))
+	})
+
+	t.Run("should revoke the token on logout", func(t *testing.T) {
+		session := login(t)
+		other := login(t)
+
+		wsURL := strings.Replace(ts.URL, "http", "ws", 1) + PathWebSocket + "?jwt=" + session.Token
+		//nolint:bodyclose
+		conn, _, err := websocket.Dial(ctx, wsURL, nil)
+		require.NoError(t, err)
+		t.Cleanup(func() { conn.CloseNow() })
+
+		require.Eventually(t, func() bool {
+			server.subscribersMu.RLock()
+			ws, ok := server.subscribers[workspace.ID]
+			server.subscribersMu.RUnlock()
+			if !ok {
+				return false
+			}
+			ws.mu.Lock()
+			defer ws.mu.Unlock()
+			return len(ws.subs) == 1
+		}, time.Second, 10*time.Millisecond)
+
+		res, _ := testutils.DoRequest[string](
+			t, server, http.MethodPost, PathHTTPAuth+"/logout", nil,
+			testutils.WithBearerToken(session.Token),
+		)
+		require.Equal(t, http.StatusNoContent, res.Code)
+
+		assert.Equal(t, http.StatusUnauthorized, listFiles(t, session.Token))
+		assert.Equal(t, http.StatusOK, listFiles(t, other.Token), "other sessions are not affected")
+
+		res, _ = refresh(t, session.RefreshToken)
+		assert.Equal(t, http.StatusUnauthorized, res.Code)
+
+		readCtx, cancel := context.WithTimeout(ctx, 2*time.Second)
+		defer cancel()
+		_, _, err = conn.Read(readCtx)
+		assert.Equal(t, websocket.StatusPolicyViolation, websocket.CloseStatus(err))
+
+		//nolint:bodyclose
+		_, _, err = websocket.Dial(ctx, wsURL, nil)
+		assert.Error(t, err)
+	})
+}
+
+func Test_jwksHandler(t *testing.T) {
+	db := testutils.CreateDB(t)
+
+	t.Run("without asymmetric keys", func(t *testing.T) {
+		server := New(db, new(filestorage.MockFileStorage), Options{JWTSecret: []byte("secret")})
+		t.Cleanup(func() { server.Close() })
+
+		res, body := testutils.DoRequest[middleware.JWKS](t, server, http.MethodGet, PathJWKS, nil)
+		assert.Equal(t, http.StatusOK, res.Code)
+		assert.Empty(t, body.Keys)
+	})
+
+	t.Run("publishes the keys signing the tokens", func(t *testing.T) {
+		_, privateKey, err := ed25519.GenerateKey(rand.Reader)
+		require.NoError(t, err)
+		keys, err := middleware.NewKeySet(
+			middleware.SigningKey{ID: "ed-1", Method: jwt.SigningMethodEdDSA, Key: privateKey},
+			middleware.NewHMACKey([]byte("secret")),
+		)
+		require.NoError(t, err)
+
+		server := New(db, new(filestorage.MockFileStorage), Options{JWTSecret: []byte("secret"), JWTKeys: keys})
+		t.Cleanup(func() { server.Close() })
+
+		res, body := testutils.DoRequest[middleware.JWKS](t, server, http.MethodGet, PathJWKS, nil)
+		assert.Equal(t, http.StatusOK, res.Code)
+		require.Len(t, body.Keys, 1)
+		assert.Equal(t, "ed-1", body.Keys[0].Kid)
+		assert.Equal(t, "OKP", body.Keys[0].Kty)
+
+		publicKey, err := base64.RawURLEncoding.DecodeString(body.Keys[0].X)
+		require.NoError(t, err)
+		assert.Equal(t, privateKey.Public(), ed25519.PublicKey(publicKey))
+
+		// the tokens signed with the new key and the ones issued with the
+		// secret are both accepted
+		token, err := middleware.CreateToken(server.authOptions(), 1, 0, middleware.RoleAdmin)
+		require.NoError(t, err)
+		_, err = jwt.Parse(token, func(*jwt.Token) (any, error) { return ed25519.PublicKey(publicKey), nil })
+		require.NoError(t, err)
+
+		for _, opt := range []func(*http.Request) error{
+			testutils.WithBearerToken(token),
+			testutils.WithAuthHeader([]byte("secret"), 1),
+		} {
+			res, _ := testutils.DoRequest[string](t, server, http.MethodPost, PathHTTPAuth+"/logout", nil, opt)
+			assert.Equal(t, http.StatusNoContent, res.Code)
+		}
+	})
+}
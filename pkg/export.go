@@ -0,0 +1,480 @@
+package syncinator
+
+import (
+	"archive/zip"
+	"cmp"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/hiimjako/syncinator/internal/repository"
+	"github.com/hiimjako/syncinator/pkg/diff"
+	"github.com/hiimjako/syncinator/pkg/filestorage"
+	"github.com/hiimjako/syncinator/pkg/middleware"
+	"github.com/hiimjako/syncinator/pkg/mimeutils"
+)
+
+// ExportManifestPath is where the manifest is written in the exported
+// archives, out of the way of the files of the vault
+const ExportManifestPath = ".syncinator/manifest.json"
+
+// ExportManifest lists the exported files, to verify the archive and to
+// import it back with the same MIME types
+type ExportManifest struct {
+	WorkspaceID int64 `json:"workspaceId"`
+	// At is the time the files were rebuilt at, missing for the current ones
+	At         *time.Time     `json:"at,omitempty"`
+	ExportedAt time.Time      `json:"exportedAt"`
+	Files      []ExportedFile `json:"files"`
+	// Omitted are the files left out of the export at a past time, since
+	// neither their history nor their snapshots go back to it, or since they
+	// were deleted and their history was purged
+	Omitted []OmittedFile `json:"omitted,omitempty"`
+}
+
+type ExportedFile struct {
+	ID       int64  `json:"id"`
+	Path     string `json:"path"`
+	Version  int64  `json:"version"`
+	Hash     string `json:"hash"`
+	MimeType string `json:"mimeType"`
+	// FromSnapshot is set when the history doesn't go back to the export
+	// time, the file is the latest snapshot created before it
+	FromSnapshot bool `json:"fromSnapshot,omitempty"`
+}
+
+type OmittedFile struct {
+	ID     int64  `json:"id"`
+	Path   string `json:"path"`
+	Reason string `json:"reason"`
+}
+
+// exportHandler zips the files of the workspace, adding the manifest. The text
+// files still cached are read from the cache, under their lock, since their
+// latest edits may not be flushed yet. With "at" (RFC 3339) every file is
+// rebuilt as it was at that time from its snapshots and operations. The files
+// whose history doesn't go back enough fall back to their latest snapshot
+// before that time, the ones without it are listed as omitted in the manifest.
+// The files deleted after that time are rebuilt from the history kept for
+// them until the retention window expires, then listed as omitted.
+func (s *syncinator) exportHandler(w http.ResponseWriter, r *http.Request) {
+	workspaceID, _ := middleware.WorkspaceIDFromCtx(r.Context())
+
+	var at time.Time
+	if r.URL.Query().Get("at") != "" {
+		parsed, err := time.Parse(time.RFC3339, r.URL.Query().Get("at"))
+		if err != nil {
+			http.Error(w, "invalid at time", http.StatusBadRequest)
+			return
+		}
+		at = parsed.UTC()
+	}
+
+	files, err := s.db.FetchFiles(r.Context(), workspaceID)
+	if err != nil {
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	manifest := ExportManifest{
+		WorkspaceID: workspaceID,
+		ExportedAt:  time.Now().UTC(),
+		Files:       []ExportedFile{},
+	}
+	if !at.IsZero() {
+		manifest.Omitted = []OmittedFile{}
+	}
+	exportedAt := manifest.ExportedAt
+	var (
+		paths  map[int64]string
+		purged map[int64]bool
+	)
+	if !at.IsZero() {
+		events, err := s.db.FetchEventsSince(r.Context(), repository.FetchEventsSinceParams{
+			WorkspaceID: workspaceID,
+			Seq:         0,
+		})
+		if err != nil {
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+		paths = pathsAt(events, at)
+		manifest.At = &at
+		exportedAt = at
+
+		deletedFiles, err := s.db.FetchWorkspaceDeletedFiles(r.Context(), workspaceID)
+		if err != nil {
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+		purged = make(map[int64]bool)
+		for _, deleted := range deletedFiles {
+			if !deleted.DeletedAt.After(at) {
+				continue
+			}
+			files = append(files, repository.File{
+				ID:            deleted.FileID,
+				DiskPath:      deleted.DiskPath,
+				WorkspacePath: deleted.WorkspacePath,
+				MimeType:      deleted.MimeType,
+				CreatedAt:     deleted.FileCreatedAt,
+				UpdatedAt:     deleted.FileUpdatedAt,
+				Version:       deleted.Version,
+				BlobVersion:   deleted.Version,
+				WorkspaceID:   deleted.WorkspaceID,
+			})
+			if deleted.DiskPath == "" {
+				purged[deleted.FileID] = true
+			}
+		}
+	}
+
+	zipWriter := zip.NewWriter(w)
+
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set(
+		"Content-Disposition",
+		fmt.Sprintf("attachment; filename=workspace-%d-%s.zip", workspaceID, exportedAt.Format(time.DateOnly)),
+	)
+	rules := pathRulesFromCtx(r.Context())
+	for _, file := range files {
+		if !rules.CanRead(file.WorkspacePath) {
+			continue
+		}
+
+		workspacePath := file.WorkspacePath
+		var (
+			content      io.ReadCloser
+			version      int64
+			fromSnapshot bool
+		)
+		if at.IsZero() {
+			content, version, err = s.currentFileContent(file)
+			if err != nil {
+				log.Printf("failed to read object for zip: %v", err)
+				return
+			}
+		} else {
+			if file.CreatedAt.After(at) {
+				continue
+			}
+			if p, ok := paths[file.ID]; ok {
+				workspacePath = p
+			}
+			if !rules.CanRead(workspacePath) {
+				continue
+			}
+			if purged[file.ID] {
+				manifest.Omitted = append(manifest.Omitted, OmittedFile{
+					ID:     file.ID,
+					Path:   workspacePath,
+					Reason: "deleted, its history was purged",
+				})
+				continue
+			}
+
+			content, version, err = s.fileContentAt(r.Context(), file, at)
+			if err != nil {
+				var snapshotErr error
+				content, version, snapshotErr = s.snapshotContentBefore(r.Context(), file, at)
+				if snapshotErr != nil {
+					reason := fmt.Sprintf("%v, %v", err, snapshotErr)
+					log.Printf("leaving file %d out of the export at %s: %s", file.ID, at.Format(time.RFC3339), reason)
+					manifest.Omitted = append(manifest.Omitted, OmittedFile{
+						ID:     file.ID,
+						Path:   workspacePath,
+						Reason: reason,
+					})
+					continue
+				}
+				fromSnapshot = true
+			}
+		}
+
+		f, err := zipWriter.Create(workspacePath)
+		if err != nil {
+			content.Close()
+			log.Printf("failed to create file in zip: %v", err)
+			return
+		}
+
+		// hashed while written, so the manifest matches what was exported
+		hash, err := filestorage.GenerateHash(io.TeeReader(content, f))
+		content.Close()
+		if err != nil {
+			log.Printf("failed to write content to zip: %v", err)
+			return
+		}
+
+		manifest.Files = append(manifest.Files, ExportedFile{
+			ID:           file.ID,
+			Path:         workspacePath,
+			Version:      version,
+			Hash:         hash,
+			MimeType:     file.MimeType,
+			FromSnapshot: fromSnapshot,
+		})
+	}
+
+	f, err := zipWriter.Create(ExportManifestPath)
+	if err != nil {
+		log.Printf("failed to create manifest in zip: %v", err)
+		return
+	}
+	encoder := json.NewEncoder(f)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(manifest); err != nil {
+		log.Printf("failed to write manifest to zip: %v", err)
+		return
+	}
+
+	if err = zipWriter.Close(); err != nil {
+		log.Printf("failed to close zip writer: %v", err)
+		return
+	}
+
+	details := ""
+	if !at.IsZero() {
+		details = "at " + at.Format(time.RFC3339)
+	}
+	s.auditRequest(r, AuditExport, "", details)
+}
+
+// pathsAt is where the files were at the given time, according to the create
+// and rename events recorded by the api. The files without one keep their
+// current path.
+func pathsAt(events []repository.Event, at time.Time) map[int64]string {
+	paths := make(map[int64]string)
+	for _, event := range events {
+		if event.CreatedAt.After(at) {
+			break
+		}
+
+		switch MessageType(event.Type) {
+		case CreateEventType, RenameEventType:
+			paths[event.FileID] = event.WorkspacePath
+		}
+	}
+	return paths
+}
+
+// currentFileContent returns the latest content of the file with its version
+func (s *syncinator) currentFileContent(file repository.File) (io.ReadCloser, int64, error) {
+	if cached, ok := s.fileCache.Get(file.ID); ok {
+		cached.mut.Lock()
+		content, version := cached.Content, cached.Version
+		cached.mut.Unlock()
+		return io.NopCloser(strings.NewReader(content)), version, nil
+	}
+
+	reader, err := s.storage.ReadObject(file.DiskPath)
+	if err != nil {
+		return nil, 0, err
+	}
+	return reader, file.Version, nil
+}
+
+// fileContentAt returns the content the file had at the given time, which
+// must be after its creation, with its version
+func (s *syncinator) fileContentAt(ctx context.Context, file repository.File, at time.Time) (io.ReadCloser, int64, error) {
+	if !mimeutils.IsText(file.MimeType) {
+		diskPath, version, err := s.binaryObjectAt(ctx, file, at)
+		if err != nil {
+			return nil, 0, err
+		}
+		reader, err := s.storage.ReadObject(diskPath)
+		if err != nil {
+			return nil, 0, err
+		}
+		return reader, version, nil
+	}
+
+	content, version, err := s.textContentAt(ctx, file, at)
+	if err != nil {
+		return nil, 0, err
+	}
+	return io.NopCloser(strings.NewReader(content)), version, nil
+}
+
+// snapshotContentBefore returns the content of the latest snapshot of the
+// file created at or before the given time, with its version
+func (s *syncinator) snapshotContentBefore(ctx context.Context, file repository.File, at time.Time) (io.ReadCloser, int64, error) {
+	snapshots, err := s.db.FetchSnapshots(ctx, repository.FetchSnapshotsParams{
+		FileID:      file.ID,
+		WorkspaceID: file.WorkspaceID,
+	})
+	if err != nil {
+		return nil, 0, fmt.Errorf("fetching snapshots: %w", err)
+	}
+
+	var latest *repository.Snapshot
+	for i := range snapshots {
+		if snapshots[i].CreatedAt.After(at) {
+			continue
+		}
+		if latest == nil || snapshots[i].CreatedAt.After(latest.CreatedAt) ||
+			(snapshots[i].CreatedAt.Equal(latest.CreatedAt) && snapshots[i].Version > latest.Version) {
+			latest = &snapshots[i]
+		}
+	}
+	if latest == nil {
+		return nil, 0, fmt.Errorf("no snapshot before %s", at.Format(time.RFC3339))
+	}
+
+	if !mimeutils.IsText(file.MimeType) {
+		reader, err := s.storage.ReadObject(latest.DiskPath)
+		if err != nil {
+			return nil, 0, fmt.Errorf("reading snapshot %d: %w", latest.Version, err)
+		}
+		return reader, latest.Version, nil
+	}
+
+	content, err := s.ReconstructSnapshot(file.ID, latest.Version, file.WorkspaceID)
+	if err != nil {
+		return nil, 0, fmt.Errorf("reconstructing snapshot %d: %w", latest.Version, err)
+	}
+	return io.NopCloser(strings.NewReader(content)), latest.Version, nil
+}
+
+// binaryObjectAt is the object of the binary file at the given time. Every
+// replaced content is kept as the snapshot of its version, created when it
+// was replaced, so the content at a given time is the first snapshot created
+// after it, as long as the one before it wasn't deleted by the retention.
+func (s *syncinator) binaryObjectAt(ctx context.Context, file repository.File, at time.Time) (string, int64, error) {
+	if !file.UpdatedAt.After(at) {
+		return file.DiskPath, file.Version, nil
+	}
+
+	snapshots, err := s.db.FetchSnapshots(ctx, repository.FetchSnapshotsParams{
+		FileID:      file.ID,
+		WorkspaceID: file.WorkspaceID,
+	})
+	if err != nil {
+		return "", 0, fmt.Errorf("fetching snapshots: %w", err)
+	}
+	slices.SortFunc(snapshots, func(a, b repository.Snapshot) int {
+		return cmp.Compare(a.Version, b.Version)
+	})
+
+	for i, snapshot := range snapshots {
+		if !snapshot.CreatedAt.After(at) {
+			continue
+		}
+		if snapshot.Version != 0 && (i == 0 || snapshots[i-1].Version != snapshot.Version-1) {
+			return "", 0, fmt.Errorf("snapshot of version %d was deleted", snapshot.Version-1)
+		}
+		return snapshot.DiskPath, snapshot.Version, nil
+	}
+
+	return file.DiskPath, file.Version, nil
+}
+
+// textContentAt rebuilds the text file at the version it had at the given
+// time, applying its operations to the latest snapshot before that version
+func (s *syncinator) textContentAt(ctx context.Context, file repository.File, at time.Time) (string, int64, error) {
+	current, version, cached := "", file.Version, false
+	if cachedFile, ok := s.fileCache.Get(file.ID); ok {
+		cachedFile.mut.Lock()
+		current, version, cached = cachedFile.Content, cachedFile.Version, true
+		cachedFile.mut.Unlock()
+	}
+
+	operations, err := s.db.FetchFileOperationsFromVersion(ctx, repository.FetchFileOperationsFromVersionParams{
+		FileID:      file.ID,
+		Version:     0,
+		WorkspaceID: file.WorkspaceID,
+	})
+	if err != nil {
+		return "", 0, fmt.Errorf("fetching operations: %w", err)
+	}
+	snapshots, err := s.db.FetchSnapshots(ctx, repository.FetchSnapshotsParams{
+		FileID:      file.ID,
+		WorkspaceID: file.WorkspaceID,
+	})
+	if err != nil {
+		return "", 0, fmt.Errorf("fetching snapshots: %w", err)
+	}
+
+	// the version before the first operation made after the given time, it
+	// must be known to be reached before it, since the older operations are
+	// purged
+	target := version
+	for _, operation := range operations {
+		if operation.CreatedAt.After(at) {
+			target = operation.Version - 1
+			break
+		}
+	}
+	reached := target == 0 ||
+		(target == version && !file.UpdatedAt.After(at)) ||
+		slices.ContainsFunc(operations, func(operation repository.Operation) bool {
+			return operation.Version == target
+		}) ||
+		slices.ContainsFunc(snapshots, func(snapshot repository.Snapshot) bool {
+			return snapshot.Version == target && !snapshot.CreatedAt.After(at)
+		})
+	if !reached {
+		return "", 0, fmt.Errorf("history doesn't go back to %s", at.Format(time.RFC3339))
+	}
+
+	if target == version {
+		if cached {
+			return current, version, nil
+		}
+
+		reader, err := s.storage.ReadObject(file.DiskPath)
+		if err != nil {
+			return "", 0, fmt.Errorf("reading object: %w", err)
+		}
+		defer reader.Close()
+
+		content, err := io.ReadAll(reader)
+		if err != nil {
+			return "", 0, fmt.Errorf("reading object: %w", err)
+		}
+		return string(content), version, nil
+	}
+
+	var base *repository.Snapshot
+	for i := range snapshots {
+		if snapshots[i].Version <= target && (base == nil || snapshots[i].Version > base.Version) {
+			base = &snapshots[i]
+		}
+	}
+	if base == nil {
+		return "", 0, fmt.Errorf("no snapshot before version %d", target)
+	}
+
+	content, err := s.ReconstructSnapshot(file.ID, base.Version, file.WorkspaceID)
+	if err != nil {
+		return "", 0, fmt.Errorf("reconstructing snapshot %d: %w", base.Version, err)
+	}
+
+	next := base.Version + 1
+	for _, operation := range operations {
+		if operation.Version < next || operation.Version > target {
+			continue
+		}
+		if operation.Version != next {
+			break
+		}
+
+		var chunks []diff.Chunk
+		if err := json.Unmarshal([]byte(operation.Operation), &chunks); err != nil {
+			return "", 0, fmt.Errorf("parsing operation at version %d: %w", operation.Version, err)
+		}
+		content = diff.ApplyMultiple(content, chunks)
+		next++
+	}
+	if next <= target {
+		return "", 0, fmt.Errorf("missing operation at version %d", next)
+	}
+
+	return content, target, nil
+}
@@ -0,0 +1,359 @@
+package syncinator
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hiimjako/syncinator/internal/repository"
+	"github.com/hiimjako/syncinator/internal/testutils"
+	"github.com/hiimjako/syncinator/pkg/filestorage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// readZip returns the content of the exported files and the manifest
+func readZip(t *testing.T, body string) (map[string]string, ExportManifest) {
+	zipReader, err := zip.NewReader(strings.NewReader(body), int64(len(body)))
+	require.NoError(t, err)
+
+	files := make(map[string]string, len(zipReader.File))
+	var manifest ExportManifest
+	for _, zipFile := range zipReader.File {
+		rc, err := zipFile.Open()
+		require.NoError(t, err)
+		content, err := io.ReadAll(rc)
+		rc.Close()
+		require.NoError(t, err)
+
+		if zipFile.Name == ExportManifestPath {
+			require.NoError(t, json.Unmarshal(content, &manifest))
+			continue
+		}
+		files[zipFile.Name] = string(content)
+	}
+	return files, manifest
+}
+
+func Test_exportHandler_at(t *testing.T) {
+	fs := filestorage.NewDisk(t.TempDir())
+	db := testutils.CreateDB(t)
+	options := Options{JWTSecret: []byte("secret")}
+	server := New(db, fs, options)
+	t.Cleanup(func() { server.Close() })
+
+	ctx := context.Background()
+	workspaceID := int64(10)
+	day := time.Date(2026, time.January, 1, 0, 0, 0, 0, time.UTC)
+	hour := func(h int) time.Time { return day.Add(time.Duration(h) * time.Hour) }
+	backdate := func(query string, at time.Time, args ...any) {
+		_, err := db.Exec(query, append([]any{at}, args...)...)
+		require.NoError(t, err)
+	}
+
+	createFile := func(workspacePath string, content []byte) repository.File {
+		form, contentType := testutils.CreateMultipart(t, workspacePath, content, false)
+		res, file := testutils.DoRequest[repository.File](t, server, http.MethodPost, PathHTTPAPI+"/file", form,
+			testutils.WithAuthHeader(options.JWTSecret, workspaceID),
+			testutils.WithContentTypeHeader(contentType))
+		require.Equal(t, http.StatusCreated, res.Code)
+		backdate("UPDATE files SET created_at = ?, updated_at = ? WHERE id = ?", hour(10), hour(10), file.ID)
+		backdate("UPDATE events SET created_at = ? WHERE file_id = ?", hour(10), file.ID)
+		return file
+	}
+
+	// 10:00 both files are created
+	note := createFile("notes/a.md", []byte("hello"))
+	image := createFile("attachments/a.png", pngHeader)
+
+	// 11:00 the note is edited and flushed, 12:00 edited again
+	cached, err := server.fetchAndCacheFile(note.ID)
+	require.NoError(t, err)
+	_, _, err = server.setTextContent(ctx, cached, "hello world")
+	require.NoError(t, err)
+	require.NoError(t, server.CreateFileSnapshot(cached.CachedFile))
+	backdate("UPDATE operations SET created_at = ? WHERE file_id = ? AND version = 1", hour(11), note.ID)
+	backdate("UPDATE snapshots SET created_at = ? WHERE file_id = ? AND version = 1", hour(11), note.ID)
+
+	_, _, err = server.setTextContent(ctx, cached, "hello world!")
+	require.NoError(t, err)
+	backdate("UPDATE operations SET created_at = ? WHERE file_id = ? AND version = 2", hour(12), note.ID)
+
+	// 12:00 the image is replaced, keeping the previous one as a snapshot
+	replacement := append(bytes.Clone(pngHeader), 1, 2, 3)
+	diskPath, err := fs.CreateObject(bytes.NewReader(replacement))
+	require.NoError(t, err)
+	hash, err := filestorage.GenerateHash(bytes.NewReader(replacement))
+	require.NoError(t, err)
+	_, err = server.replaceFileContent(ctx, image, diskPath, image.MimeType, hash, "")
+	require.NoError(t, err)
+	backdate("UPDATE snapshots SET created_at = ? WHERE file_id = ? AND version = 0", hour(12), image.ID)
+	backdate("UPDATE files SET updated_at = ? WHERE id = ?", hour(12), image.ID)
+
+	// 13:00 the note is renamed, 14:00 edited again
+	_, err = server.db.CreateEvent(ctx, repository.CreateEventParams{
+		WorkspaceID:   workspaceID,
+		FileID:        note.ID,
+		Type:          int64(RenameEventType),
+		WorkspacePath: "notes/b.md",
+		ObjectType:    "file",
+	})
+	require.NoError(t, err)
+	backdate("UPDATE events SET created_at = ? WHERE file_id = ? AND type = ?", hour(13), note.ID, RenameEventType)
+	_, err = db.Exec("UPDATE files SET workspace_path = 'notes/b.md' WHERE id = ?", note.ID)
+	require.NoError(t, err)
+
+	_, _, err = server.setTextContent(ctx, cached, "bye")
+	require.NoError(t, err)
+	backdate("UPDATE operations SET created_at = ? WHERE file_id = ? AND version = 3", hour(14), note.ID)
+	backdate("UPDATE files SET updated_at = ? WHERE id = ?", hour(14), note.ID)
+
+	export := func(t *testing.T, at time.Time) (map[string]string, ExportManifest) {
+		res, body := testutils.DoRequest[string](t, server, http.MethodGet,
+			PathHTTPAPI+"/export?at="+at.Format(time.RFC3339), nil,
+			testutils.WithAuthHeader(options.JWTSecret, workspaceID))
+		require.Equal(t, http.StatusOK, res.Code)
+		assert.Contains(t, res.Header().Get("Content-Disposition"), "workspace-10-"+at.Format(time.DateOnly)+".zip")
+		files, manifest := readZip(t, body)
+		require.NotNil(t, manifest.At)
+		assert.True(t, at.Truncate(time.Second).Equal(*manifest.At))
+		return files, manifest
+	}
+
+	t.Run("should skip the files created later", func(t *testing.T) {
+		files, manifest := export(t, hour(9))
+		assert.Empty(t, files)
+		assert.Empty(t, manifest.Omitted)
+	})
+
+	t.Run("should list the files whose history is missing", func(t *testing.T) {
+		// the note was never flushed before its first edit
+		files, manifest := export(t, hour(10).Add(30*time.Minute))
+		assert.Equal(t, map[string]string{
+			"attachments/a.png": string(pngHeader),
+		}, files)
+		require.Len(t, manifest.Omitted, 1)
+		assert.Equal(t, note.ID, manifest.Omitted[0].ID)
+		assert.Equal(t, "notes/a.md", manifest.Omitted[0].Path)
+		assert.Contains(t, manifest.Omitted[0].Reason, "no snapshot before")
+	})
+
+	t.Run("should rebuild the files from snapshots and operations", func(t *testing.T) {
+		files, manifest := export(t, hour(12).Add(30*time.Minute))
+		assert.Equal(t, map[string]string{
+			"notes/a.md":        "hello world!",
+			"attachments/a.png": string(replacement),
+		}, files)
+		assert.Empty(t, manifest.Omitted)
+
+		files, _ = export(t, hour(13).Add(30*time.Minute))
+		assert.Equal(t, map[string]string{
+			"notes/b.md":        "hello world!",
+			"attachments/a.png": string(replacement),
+		}, files)
+
+		files, _ = export(t, time.Now())
+		assert.Equal(t, map[string]string{
+			"notes/b.md":        "bye",
+			"attachments/a.png": string(replacement),
+		}, files)
+	})
+
+	t.Run("should fall back to the latest snapshot when the operations are purged", func(t *testing.T) {
+		_, err := db.Exec("DELETE FROM operations WHERE file_id = ?", note.ID)
+		require.NoError(t, err)
+
+		files, manifest := export(t, hour(12).Add(30*time.Minute))
+		assert.Equal(t, map[string]string{
+			"notes/a.md":        "hello world",
+			"attachments/a.png": string(replacement),
+		}, files)
+		assert.Empty(t, manifest.Omitted)
+		for _, exported := range manifest.Files {
+			assert.Equal(t, exported.ID == note.ID, exported.FromSnapshot)
+			if exported.ID == note.ID {
+				assert.Equal(t, int64(1), exported.Version)
+			}
+		}
+	})
+
+	t.Run("should rebuild the files deleted later", func(t *testing.T) {
+		// 15:00 the note is deleted with its last edit not flushed yet
+		res, _ := testutils.DoRequest[string](t, server, http.MethodDelete,
+			PathHTTPAPI+"/file/"+strconv.FormatInt(note.ID, 10), nil,
+			testutils.WithAuthHeader(options.JWTSecret, workspaceID))
+		require.Equal(t, http.StatusNoContent, res.Code)
+		backdate("UPDATE deleted_files SET deleted_at = ? WHERE file_id = ?", hour(15), note.ID)
+
+		files, manifest := export(t, hour(14).Add(30*time.Minute))
+		assert.Equal(t, map[string]string{
+			"notes/b.md":        "bye",
+			"attachments/a.png": string(replacement),
+		}, files)
+		assert.Empty(t, manifest.Omitted)
+
+		files, _ = export(t, hour(12).Add(30*time.Minute))
+		assert.Equal(t, map[string]string{
+			"notes/a.md":        "hello world",
+			"attachments/a.png": string(replacement),
+		}, files)
+
+		files, manifest = export(t, hour(15).Add(30*time.Minute))
+		assert.Equal(t, map[string]string{
+			"attachments/a.png": string(replacement),
+		}, files)
+		assert.Empty(t, manifest.Omitted)
+	})
+
+	t.Run("should list the deleted files whose history was purged", func(t *testing.T) {
+		_, err := db.Exec("UPDATE deleted_files SET disk_path = '' WHERE file_id = ?", note.ID)
+		require.NoError(t, err)
+
+		files, manifest := export(t, hour(14).Add(30*time.Minute))
+		assert.Equal(t, map[string]string{
+			"attachments/a.png": string(replacement),
+		}, files)
+		require.Len(t, manifest.Omitted, 1)
+		assert.Equal(t, note.ID, manifest.Omitted[0].ID)
+		assert.Equal(t, "notes/b.md", manifest.Omitted[0].Path)
+		assert.Equal(t, "deleted, its history was purged", manifest.Omitted[0].Reason)
+	})
+
+	t.Run("should reject an invalid time", func(t *testing.T) {
+		res, body := testutils.DoRequest[string](t, server, http.MethodGet, PathHTTPAPI+"/export?at=yesterday", nil,
+			testutils.WithAuthHeader(options.JWTSecret, workspaceID))
+		assert.Equal(t, http.StatusBadRequest, res.Code)
+		assert.Equal(t, "invalid at time", body)
+	})
+}
+
+func Test_exportHandler_manifest(t *testing.T) {
+	fs := filestorage.NewDisk(t.TempDir())
+	db := testutils.CreateDB(t)
+	options := Options{JWTSecret: []byte("secret")}
+	server := New(db, fs, options)
+	t.Cleanup(func() { server.Close() })
+
+	var workspaceID, importWorkspaceID int64 = 10, 11
+	importZip := func(t *testing.T, archive []byte) []ImportResult {
+		form, contentType := testutils.CreateMultipart(t, "vault.zip", archive, false)
+		res, results := testutils.DoRequest[[]ImportResult](t, server, http.MethodPost, PathHTTPAPI+"/import", form,
+			testutils.WithAuthHeader(options.JWTSecret, importWorkspaceID),
+			testutils.WithContentTypeHeader(contentType))
+		require.Equal(t, http.StatusOK, res.Code)
+		return results
+	}
+
+	var note repository.File
+	for workspacePath, content := range map[string][]byte{"notes/a.md": []byte("hello"), "attachments/a.png": pngHeader} {
+		form, contentType := testutils.CreateMultipart(t, workspacePath, content, false)
+		res, file := testutils.DoRequest[repository.File](t, server, http.MethodPost, PathHTTPAPI+"/file", form,
+			testutils.WithAuthHeader(options.JWTSecret, workspaceID),
+			testutils.WithContentTypeHeader(contentType))
+		require.Equal(t, http.StatusCreated, res.Code)
+		if workspacePath == "notes/a.md" {
+			note = file
+		}
+	}
+
+	// the edit is only in the cache until the next flush
+	cached, err := server.fetchAndCacheFile(note.ID)
+	require.NoError(t, err)
+	_, _, err = server.setTextContent(context.Background(), cached, "hello world")
+	require.NoError(t, err)
+
+	res, body := testutils.DoRequest[string](t, server, http.MethodGet, PathHTTPAPI+"/export", nil,
+		testutils.WithAuthHeader(options.JWTSecret, workspaceID))
+	require.Equal(t, http.StatusOK, res.Code)
+
+	t.Run("should export the edits not flushed yet", func(t *testing.T) {
+		files, manifest := readZip(t, body)
+		assert.Equal(t, "hello world", files["notes/a.md"])
+
+		assert.Equal(t, workspaceID, manifest.WorkspaceID)
+		assert.Nil(t, manifest.At)
+		require.Len(t, manifest.Files, 2)
+		for _, exported := range manifest.Files {
+			hash, err := filestorage.GenerateHash(strings.NewReader(files[exported.Path]))
+			require.NoError(t, err)
+			assert.Equal(t, hash, exported.Hash, exported.Path)
+
+			if exported.Path == "notes/a.md" {
+				assert.Equal(t, ExportedFile{
+					ID:       note.ID,
+					Path:     "notes/a.md",
+					Version:  1,
+					Hash:     hash,
+					MimeType: note.MimeType,
+				}, exported)
+			}
+		}
+	})
+
+	t.Run("should import an export back", func(t *testing.T) {
+		results := importZip(t, []byte(body))
+		require.Len(t, results, 2, "the manifest isn't imported")
+		for _, result := range results {
+			assert.Equal(t, ImportCreated, result.Status, result.Path)
+		}
+	})
+
+	t.Run("should keep the MIME types and check the hashes of the manifest", func(t *testing.T) {
+		hash, err := filestorage.GenerateHash(strings.NewReader("# kept"))
+		require.NoError(t, err)
+		mislabeledHash, err := filestorage.GenerateHash(strings.NewReader("# mislabeled"))
+		require.NoError(t, err)
+		manifest, err := json.Marshal(ExportManifest{
+			WorkspaceID: workspaceID,
+			Files: []ExportedFile{
+				{Path: "kept.md", Hash: hash, MimeType: "text/markdown"},
+				{Path: "tampered.md", Hash: hash, MimeType: "text/markdown"},
+				{Path: "mislabeled.md", Hash: mislabeledHash, MimeType: "image/png"},
+			},
+		})
+		require.NoError(t, err)
+
+		results := importZip(t, createZip(t, map[string][]byte{
+			"kept.md":          []byte("# kept"),
+			"tampered.md":      []byte("# tampered"),
+			"mislabeled.md":    []byte("# mislabeled"),
+			ExportManifestPath: manifest,
+		}))
+		require.Len(t, results, 3)
+
+		byPath := map[string]ImportResult{}
+		for _, result := range results {
+			byPath[result.Path] = result
+		}
+		assert.Equal(t, ImportCreated, byPath["kept.md"].Status)
+		assert.Equal(t, ImportFailed, byPath["tampered.md"].Status)
+		assert.Equal(t, ErrImportHashMismatch, byPath["tampered.md"].Error)
+		assert.Equal(t, ImportFailed, byPath["mislabeled.md"].Status)
+		assert.Equal(t, ErrImportMimeMismatch, byPath["mislabeled.md"].Error)
+
+		kept, err := server.db.FetchFileFromWorkspacePath(context.Background(), repository.FetchFileFromWorkspacePathParams{
+			WorkspaceID:   importWorkspaceID,
+			WorkspacePath: "kept.md",
+		})
+		require.NoError(t, err)
+		assert.Equal(t, "text/markdown", kept.MimeType)
+	})
+
+	t.Run("should reject an invalid manifest", func(t *testing.T) {
+		form, contentType := testutils.CreateMultipart(t, "vault.zip", createZip(t, map[string][]byte{
+			ExportManifestPath: []byte("not json"),
+		}), false)
+		res, body := testutils.DoRequest[string](t, server, http.MethodPost, PathHTTPAPI+"/import", form,
+			testutils.WithAuthHeader(options.JWTSecret, importWorkspaceID),
+			testutils.WithContentTypeHeader(contentType))
+		assert.Equal(t, http.StatusBadRequest, res.Code)
+		assert.Equal(t, ErrInvalidManifest, body)
+	})
+}
@@ -0,0 +1,259 @@
+package filestorage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/google/uuid"
+	"github.com/hiimjako/syncinator/internal/repository"
+)
+
+var _ Storage = (*Dedup)(nil)
+
+// Dedup is a content-addressed layer over another storage: identical contents
+// are stored once, as a blob keyed by their SHA-256. Every object keeps its
+// own path, mapped to a blob that is deleted when no path references it.
+//
+// Paths not known to the layer are forwarded to the inner storage, so it can
+// be enabled on existing data.
+type Dedup struct {
+	inner Storage
+	conn  *sql.DB
+	db    *repository.Queries
+	// serializes the reference counting
+	mu sync.Mutex
+}
+
+func NewDedup(inner Storage, db *sql.DB) *Dedup {
+	return &Dedup{
+		inner: inner,
+		conn:  db,
+		db:    repository.New(db),
+	}
+}
+
+func (d *Dedup) CreateObject(file io.Reader) (string, error) {
+	content, hash, _, err := spoolPayload(file)
+	if err != nil {
+		return "", err
+	}
+	defer func() {
+		content.Close()
+		os.Remove(content.Name())
+	}()
+
+	relativePath := strings.Join(strings.Split(uuid.New().String(), "-"), "/")
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	err = d.link(hash, content, func(txq *repository.Queries) error {
+		return txq.CreateStorageObject(context.Background(), repository.CreateStorageObjectParams{
+			Path: relativePath,
+			Hash: hash,
+		})
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return relativePath, nil
+}
+
+func (d *Dedup) WriteObject(relativePath string, content io.Reader) error {
+	ctx := context.Background()
+
+	spooled, hash, _, err := spoolPayload(content)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		spooled.Close()
+		os.Remove(spooled.Name())
+	}()
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	object, err := d.db.FetchStorageObject(ctx, relativePath)
+	if errors.Is(err, sql.ErrNoRows) {
+		return d.inner.WriteObject(relativePath, spooled)
+	}
+	if err != nil {
+		return fmt.Errorf("fetching object: %w", err)
+	}
+
+	if hash == object.Hash {
+		return nil
+	}
+
+	// the blob may be shared, so the object is pointed to a new one
+	var released string
+	err = d.link(hash, spooled, func(txq *repository.Queries) error {
+		err := txq.UpdateStorageObjectHash(ctx, repository.UpdateStorageObjectHashParams{
+			Hash: hash,
+			Path: relativePath,
+		})
+		if err != nil {
+			return err
+		}
+
+		released, err = unref(ctx, txq, object.Hash)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	d.deleteBlob(released)
+	return nil
+}
+
+func (d *Dedup) DeleteObject(relativePath string) error {
+	ctx := context.Background()
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	object, err := d.db.FetchStorageObject(ctx, relativePath)
+	if errors.Is(err, sql.ErrNoRows) {
+		return d.inner.DeleteObject(relativePath)
+	}
+	if err != nil {
+		return fmt.Errorf("fetching object: %w", err)
+	}
+
+	tx, err := d.conn.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("opening transaction: %w", err)
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	txq := d.db.WithTx(tx)
+
+	if err := txq.DeleteStorageObject(ctx, relativePath); err != nil {
+		return fmt.Errorf("deleting object: %w", err)
+	}
+
+	released, err := unref(ctx, txq, object.Hash)
+	if err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("committing transaction: %w", err)
+	}
+
+	d.deleteBlob(released)
+	return nil
+}
+
+func (d *Dedup) ReadObject(relativePath string) (io.ReadCloser, error) {
+	ctx := context.Background()
+
+	object, err := d.db.FetchStorageObject(ctx, relativePath)
+	if errors.Is(err, sql.ErrNoRows) {
+		return d.inner.ReadObject(relativePath)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("fetching object: %w", err)
+	}
+
+	blob, err := d.db.FetchStorageBlob(ctx, object.Hash)
+	if err != nil {
+		return nil, fmt.Errorf("fetching blob: %w", err)
+	}
+
+	return d.inner.ReadObject(blob.DiskPath)
+}
+
+// link takes a reference to the blob with the given hash, storing the content
+// if it is the first one, and runs bind in the same transaction to point an
+// object to it. It must be called holding the lock.
+func (d *Dedup) link(hash string, content io.ReadSeeker, bind func(*repository.Queries) error) error {
+	ctx := context.Background()
+
+	newBlob := ""
+	_, err := d.db.FetchStorageBlob(ctx, hash)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		if _, err := content.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+		newBlob, err = d.inner.CreateObject(content)
+		if err != nil {
+			return fmt.Errorf("storing blob: %w", err)
+		}
+	case err != nil:
+		return fmt.Errorf("fetching blob: %w", err)
+	}
+
+	err = func() error {
+		tx, err := d.conn.BeginTx(ctx, nil)
+		if err != nil {
+			return fmt.Errorf("opening transaction: %w", err)
+		}
+		defer func() { _ = tx.Rollback() }()
+
+		txq := d.db.WithTx(tx)
+
+		if newBlob != "" {
+			err = txq.CreateStorageBlob(ctx, repository.CreateStorageBlobParams{
+				Hash:     hash,
+				DiskPath: newBlob,
+			})
+		} else {
+			err = txq.IncrementStorageBlobRefs(ctx, hash)
+		}
+		if err != nil {
+			return fmt.Errorf("referencing blob: %w", err)
+		}
+
+		if err := bind(txq); err != nil {
+			return fmt.Errorf("linking object: %w", err)
+		}
+
+		return tx.Commit()
+	}()
+	if err != nil && newBlob != "" {
+		_ = d.inner.DeleteObject(newBlob)
+	}
+
+	return err
+}
+
+// unref drops a reference to the blob, returning its path in the inner
+// storage when it is not referenced anymore.
+func unref(ctx context.Context, txq *repository.Queries, hash string) (string, error) {
+	blob, err := txq.DecrementStorageBlobRefs(ctx, hash)
+	if err != nil {
+		return "", fmt.Errorf("releasing blob: %w", err)
+	}
+
+	if blob.RefCount > 0 {
+		return "", nil
+	}
+
+	if err := txq.DeleteStorageBlob(ctx, hash); err != nil {
+		return "", fmt.Errorf("deleting blob: %w", err)
+	}
+
+	return blob.DiskPath, nil
+}
+
+func (d *Dedup) deleteBlob(blobPath string) {
+	if blobPath == "" {
+		return
+	}
+
+	if err := d.inner.DeleteObject(blobPath); err != nil {
+		log.Printf("error deleting unreferenced blob %s: %v", blobPath, err)
+	}
+}
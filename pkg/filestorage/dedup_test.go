@@ -0,0 +1,114 @@
+package filestorage
+
+import (
+	"context"
+	"io"
+	"io/fs"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/hiimjako/syncinator/internal/repository"
+	"github.com/hiimjako/syncinator/internal/testutils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+func countFiles(t *testing.T, dir string) int {
+	count := 0
+	err := filepath.WalkDir(dir, func(_ string, entry fs.DirEntry, err error) error {
+		if err == nil && !entry.IsDir() {
+			count++
+		}
+		return err
+	})
+	require.NoError(t, err)
+	return count
+}
+
+func readString(t *testing.T, s Storage, p string) string {
+	reader, err := s.ReadObject(p)
+	require.NoError(t, err)
+	defer reader.Close()
+
+	content, err := io.ReadAll(reader)
+	require.NoError(t, err)
+	return string(content)
+}
+
+func TestDedup(t *testing.T) {
+	db := testutils.CreateDB(t)
+	repo := repository.New(db)
+
+	t.Run("should store identical contents once", func(t *testing.T) {
+		dir := t.TempDir()
+		d := NewDedup(NewDisk(dir), db)
+
+		first, err := d.CreateObject(strings.NewReader("same bytes"))
+		require.NoError(t, err)
+		second, err := d.CreateObject(strings.NewReader("same bytes"))
+		require.NoError(t, err)
+
+		assert.NotEqual(t, first, second)
+		assert.Equal(t, 1, countFiles(t, dir))
+
+		object, err := repo.FetchStorageObject(context.Background(), first)
+		require.NoError(t, err)
+		blob, err := repo.FetchStorageBlob(context.Background(), object.Hash)
+		require.NoError(t, err)
+		assert.Equal(t, int64(2), blob.RefCount)
+
+		require.NoError(t, d.DeleteObject(first))
+		assert.Equal(t, "same bytes", readString(t, d, second))
+		assert.Equal(t, 1, countFiles(t, dir))
+
+		require.NoError(t, d.DeleteObject(second))
+		assert.Equal(t, 0, countFiles(t, dir))
+
+		_, err = d.ReadObject(second)
+		assert.ErrorIs(t, err, fs.ErrNotExist)
+	})
+
+	t.Run("should not change other objects when writing a shared one", func(t *testing.T) {
+		dir := t.TempDir()
+		d := NewDedup(NewDisk(dir), db)
+
+		first, err := d.CreateObject(strings.NewReader("shared"))
+		require.NoError(t, err)
+		second, err := d.CreateObject(strings.NewReader("shared"))
+		require.NoError(t, err)
+
+		require.NoError(t, d.WriteObject(first, strings.NewReader("changed")))
+
+		assert.Equal(t, "changed", readString(t, d, first))
+		assert.Equal(t, "shared", readString(t, d, second))
+		assert.Equal(t, 2, countFiles(t, dir))
+
+		// converging on the same content frees the old blob
+		require.NoError(t, d.WriteObject(second, strings.NewReader("changed")))
+		assert.Equal(t, "changed", readString(t, d, second))
+		assert.Equal(t, 1, countFiles(t, dir))
+	})
+
+	t.Run("should forward paths created before enabling it", func(t *testing.T) {
+		dir := t.TempDir()
+		disk := NewDisk(dir)
+		d := NewDedup(disk, db)
+
+		legacy, err := disk.CreateObject(strings.NewReader("legacy"))
+		require.NoError(t, err)
+
+		assert.Equal(t, "legacy", readString(t, d, legacy))
+
+		require.NoError(t, d.WriteObject(legacy, strings.NewReader("updated")))
+		assert.Equal(t, "updated", readString(t, disk, legacy))
+
+		require.NoError(t, d.DeleteObject(legacy))
+		assert.Equal(t, 0, countFiles(t, dir))
+
+		err = d.WriteObject(legacy, strings.NewReader("missing"))
+		assert.Error(t, err)
+	})
+}
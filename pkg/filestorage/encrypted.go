@@ -0,0 +1,471 @@
+package filestorage
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"database/sql"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"sync"
+
+	"github.com/hiimjako/syncinator/internal/repository"
+)
+
+var (
+	_ Storage         = (*EncryptedStorage)(nil)
+	_ WorkspaceScoped = (*EncryptedStorage)(nil)
+)
+
+var (
+	ErrWorkspaceRequired = errors.New("encrypted storage requires a workspace scope to write")
+	ErrPlaintextObject   = errors.New("object isn't encrypted")
+)
+
+const (
+	// encryptedMagic prefixes every encrypted object, objects without it are
+	// plaintext ones written before enabling the encryption
+	encryptedMagic    = "SYNCENC1"
+	noncePrefixSize   = 7
+	encryptedHeadSize = len(encryptedMagic) + 8 + noncePrefixSize
+	// plaintext bytes sealed together, so objects never need to be held in
+	// memory as a whole
+	segmentSize = 64 << 10
+)
+
+// EncryptedStorage encrypts objects with AES-GCM before handing them to the
+// inner storage. Each workspace has its own data key, stored in the db
+// wrapped by the master key, so rotating the master key only rewraps them.
+//
+// Objects are written through ForWorkspace, reads and deletes work on any
+// path since every object records the workspace it belongs to.
+type EncryptedStorage struct {
+	inner     Storage
+	db        *repository.Queries
+	masterKey cipher.AEAD
+	strict    bool
+
+	mu   sync.Mutex
+	keys map[int64]cipher.AEAD
+}
+
+func NewEncryptedStorage(inner Storage, db *sql.DB, masterKey []byte) (*EncryptedStorage, error) {
+	master, err := newAEAD(masterKey)
+	if err != nil {
+		return nil, fmt.Errorf("invalid master key: %w", err)
+	}
+
+	return &EncryptedStorage{
+		inner:     inner,
+		db:        repository.New(db),
+		masterKey: master,
+		keys:      make(map[int64]cipher.AEAD),
+	}, nil
+}
+
+// RejectPlaintext makes the reads of the objects without the encryption
+// header fail, instead of returning them as they are. It must be enabled once
+// the objects written before the encryption are gone, so that plaintext
+// objects put in the backend are never served.
+func (e *EncryptedStorage) RejectPlaintext() {
+	e.strict = true
+}
+
+func (e *EncryptedStorage) ForWorkspace(workspaceID int64) Storage {
+	return workspaceEncryptedStorage{EncryptedStorage: e, workspaceID: workspaceID}
+}
+
+func (e *EncryptedStorage) CreateObject(io.Reader) (string, error) {
+	return "", ErrWorkspaceRequired
+}
+
+func (e *EncryptedStorage) WriteObject(string, io.Reader) error {
+	return ErrWorkspaceRequired
+}
+
+func (e *EncryptedStorage) DeleteObject(relativePath string) error {
+	return e.inner.DeleteObject(relativePath)
+}
+
+func (e *EncryptedStorage) ReadObject(relativePath string) (io.ReadCloser, error) {
+	r, err := e.inner.ReadObject(relativePath)
+	if err != nil {
+		return nil, err
+	}
+
+	br := bufio.NewReaderSize(r, segmentSize+64)
+	head, err := br.Peek(encryptedHeadSize)
+	if err != nil && !errors.Is(err, io.EOF) {
+		r.Close()
+		return nil, err
+	}
+	if len(head) < encryptedHeadSize || !bytes.HasPrefix(head, []byte(encryptedMagic)) {
+		if e.strict {
+			r.Close()
+			return nil, fmt.Errorf("%w: %s", ErrPlaintextObject, relativePath)
+		}
+		return readCloser{Reader: br, Closer: r}, nil
+	}
+
+	header := bytes.Clone(head)
+	if _, err := br.Discard(encryptedHeadSize); err != nil {
+		r.Close()
+		return nil, err
+	}
+
+	workspaceID := int64(binary.BigEndian.Uint64(header[len(encryptedMagic):]))
+	aead, err := e.workspaceKey(workspaceID, false)
+	if err != nil {
+		r.Close()
+		return nil, err
+	}
+
+	return &decryptingReader{
+		src:    br,
+		closer: r,
+		aead:   aead,
+		header: header,
+		prefix: header[len(encryptedMagic)+8:],
+		seg:    make([]byte, segmentSize+aead.Overhead()),
+	}, nil
+}
+
+// EncryptPlaintext rewrites the object encrypted with the key of the
+// workspace when it was written before enabling the encryption, reporting
+// whether it was rewritten.
+func (e *EncryptedStorage) EncryptPlaintext(workspaceID int64, relativePath string) (bool, error) {
+	r, err := e.inner.ReadObject(relativePath)
+	if err != nil {
+		return false, err
+	}
+	defer r.Close()
+
+	br := bufio.NewReaderSize(r, segmentSize+64)
+	head, err := br.Peek(encryptedHeadSize)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return false, err
+	}
+	if len(head) == encryptedHeadSize && bytes.HasPrefix(head, []byte(encryptedMagic)) {
+		return false, nil
+	}
+
+	// read as a whole, since the object is overwritten
+	content, err := io.ReadAll(br)
+	if err != nil {
+		return false, err
+	}
+
+	if err := e.ForWorkspace(workspaceID).WriteObject(relativePath, bytes.NewReader(content)); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+type workspaceEncryptedStorage struct {
+	*EncryptedStorage
+	workspaceID int64
+}
+
+func (w workspaceEncryptedStorage) CreateObject(file io.Reader) (string, error) {
+	var path string
+	err := w.encrypt(file, func(encrypted io.Reader) error {
+		var err error
+		path, err = w.inner.CreateObject(encrypted)
+		return err
+	})
+	return path, err
+}
+
+func (w workspaceEncryptedStorage) WriteObject(relativePath string, content io.Reader) error {
+	return w.encrypt(content, func(encrypted io.Reader) error {
+		return w.inner.WriteObject(relativePath, encrypted)
+	})
+}
+
+// encrypt streams the encrypted content to store
+func (w workspaceEncryptedStorage) encrypt(content io.Reader, store func(io.Reader) error) error {
+	aead, err := w.workspaceKey(w.workspaceID, true)
+	if err != nil {
+		return err
+	}
+
+	header := make([]byte, encryptedHeadSize)
+	copy(header, encryptedMagic)
+	binary.BigEndian.PutUint64(header[len(encryptedMagic):], uint64(w.workspaceID))
+	if _, err := rand.Read(header[len(encryptedMagic)+8:]); err != nil {
+		return err
+	}
+
+	pr, pw := io.Pipe()
+	go func() {
+		pw.CloseWithError(sealSegments(pw, aead, header, content))
+	}()
+
+	err = store(pr)
+	// unblocks the writer if store returned before reading everything
+	pr.CloseWithError(io.ErrClosedPipe)
+	return err
+}
+
+func sealSegments(w io.Writer, aead cipher.AEAD, header []byte, content io.Reader) error {
+	if _, err := w.Write(header); err != nil {
+		return err
+	}
+
+	prefix := header[len(encryptedMagic)+8:]
+	br := bufio.NewReaderSize(content, segmentSize)
+	seg := make([]byte, segmentSize)
+	for counter := uint32(0); ; counter++ {
+		n, err := io.ReadFull(br, seg)
+		if err != nil && !errors.Is(err, io.EOF) && !errors.Is(err, io.ErrUnexpectedEOF) {
+			return err
+		}
+
+		last := err != nil
+		if !last {
+			if _, err := br.Peek(1); errors.Is(err, io.EOF) {
+				last = true
+			} else if err != nil {
+				return err
+			}
+		}
+
+		sealed := aead.Seal(nil, segmentNonce(prefix, counter, last), seg[:n], header)
+		if _, err := w.Write(sealed); err != nil {
+			return err
+		}
+
+		if last {
+			return nil
+		}
+	}
+}
+
+type decryptingReader struct {
+	src     *bufio.Reader
+	closer  io.Closer
+	aead    cipher.AEAD
+	header  []byte
+	prefix  []byte
+	counter uint32
+	seg     []byte
+	plain   []byte
+	done    bool
+}
+
+func (d *decryptingReader) Read(p []byte) (int, error) {
+	for len(d.plain) == 0 {
+		if d.done {
+			return 0, io.EOF
+		}
+		if err := d.openSegment(); err != nil {
+			return 0, err
+		}
+	}
+
+	n := copy(p, d.plain)
+	d.plain = d.plain[n:]
+	return n, nil
+}
+
+func (d *decryptingReader) openSegment() error {
+	n, err := io.ReadFull(d.src, d.seg)
+	if errors.Is(err, io.EOF) {
+		return fmt.Errorf("truncated encrypted object")
+	}
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) {
+		return err
+	}
+
+	last := err != nil
+	if !last {
+		if _, err := d.src.Peek(1); errors.Is(err, io.EOF) {
+			last = true
+		} else if err != nil {
+			return err
+		}
+	}
+
+	plain, err := d.aead.Open(d.seg[:0], segmentNonce(d.prefix, d.counter, last), d.seg[:n], d.header)
+	if err != nil {
+		return fmt.Errorf("corrupted encrypted object: %w", err)
+	}
+
+	d.plain = plain
+	d.counter++
+	d.done = last
+	return nil
+}
+
+func (d *decryptingReader) Close() error {
+	return d.closer.Close()
+}
+
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
+
+// segmentNonce derives the nonce of a segment, binding its position and
+// whether it is the last one, so segments can't be reordered or truncated
+func segmentNonce(prefix []byte, counter uint32, last bool) []byte {
+	nonce := make([]byte, noncePrefixSize+5)
+	copy(nonce, prefix)
+	binary.BigEndian.PutUint32(nonce[noncePrefixSize:], counter)
+	if last {
+		nonce[len(nonce)-1] = 1
+	}
+	return nonce
+}
+
+// workspaceKey returns the data key of the workspace, generating it on first
+// use when create is set
+func (e *EncryptedStorage) workspaceKey(workspaceID int64, create bool) (cipher.AEAD, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if aead, ok := e.keys[workspaceID]; ok {
+		return aead, nil
+	}
+
+	ctx := context.Background()
+
+	var dataKey []byte
+	wrapped, err := e.db.FetchWorkspaceKey(ctx, workspaceID)
+	switch {
+	case err == nil:
+		dataKey, err = unwrapKey(e.masterKey, workspaceID, wrapped.WrappedKey)
+		if err != nil {
+			return nil, err
+		}
+	case errors.Is(err, sql.ErrNoRows) && create:
+		dataKey = make([]byte, 32)
+		if _, err := rand.Read(dataKey); err != nil {
+			return nil, err
+		}
+
+		wrappedKey, err := wrapKey(e.masterKey, workspaceID, dataKey)
+		if err != nil {
+			return nil, err
+		}
+
+		err = e.db.CreateWorkspaceKey(ctx, repository.CreateWorkspaceKeyParams{
+			WorkspaceID: workspaceID,
+			WrappedKey:  wrappedKey,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("storing key of workspace %d: %w", workspaceID, err)
+		}
+	case errors.Is(err, sql.ErrNoRows):
+		return nil, fmt.Errorf("missing key of workspace %d", workspaceID)
+	default:
+		return nil, fmt.Errorf("fetching key of workspace %d: %w", workspaceID, err)
+	}
+
+	aead, err := newAEAD(dataKey)
+	if err != nil {
+		return nil, err
+	}
+
+	e.keys[workspaceID] = aead
+	return aead, nil
+}
+
+// RotateMasterKey rewraps the data keys of every workspace with the new
+// master key, returning how many keys have been rewrapped. Objects don't need
+// to be rewritten.
+func RotateMasterKey(ctx context.Context, db *sql.DB, oldKey, newKey []byte) (int, error) {
+	oldMaster, err := newAEAD(oldKey)
+	if err != nil {
+		return 0, fmt.Errorf("invalid old master key: %w", err)
+	}
+	newMaster, err := newAEAD(newKey)
+	if err != nil {
+		return 0, fmt.Errorf("invalid new master key: %w", err)
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, fmt.Errorf("opening transaction: %w", err)
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	txq := repository.New(tx)
+
+	keys, err := txq.FetchWorkspaceKeys(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("fetching keys: %w", err)
+	}
+
+	for _, key := range keys {
+		dataKey, err := unwrapKey(oldMaster, key.WorkspaceID, key.WrappedKey)
+		if err != nil {
+			return 0, err
+		}
+
+		wrapped, err := wrapKey(newMaster, key.WorkspaceID, dataKey)
+		if err != nil {
+			return 0, err
+		}
+
+		err = txq.UpdateWorkspaceKey(ctx, repository.UpdateWorkspaceKeyParams{
+			WrappedKey:  wrapped,
+			WorkspaceID: key.WorkspaceID,
+		})
+		if err != nil {
+			return 0, fmt.Errorf("updating key of workspace %d: %w", key.WorkspaceID, err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("committing transaction: %w", err)
+	}
+
+	return len(keys), nil
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	if len(key) != 32 {
+		return nil, fmt.Errorf("key must be 32 bytes, got %d", len(key))
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(block)
+}
+
+func keyAdditionalData(workspaceID int64) []byte {
+	return []byte("workspace:" + strconv.FormatInt(workspaceID, 10))
+}
+
+func wrapKey(master cipher.AEAD, workspaceID int64, dataKey []byte) ([]byte, error) {
+	nonce := make([]byte, master.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+
+	return master.Seal(nonce, nonce, dataKey, keyAdditionalData(workspaceID)), nil
+}
+
+func unwrapKey(master cipher.AEAD, workspaceID int64, wrapped []byte) ([]byte, error) {
+	if len(wrapped) < master.NonceSize() {
+		return nil, fmt.Errorf("invalid key of workspace %d", workspaceID)
+	}
+
+	nonce, sealed := wrapped[:master.NonceSize()], wrapped[master.NonceSize():]
+	dataKey, err := master.Open(nil, nonce, sealed, keyAdditionalData(workspaceID))
+	if err != nil {
+		return nil, fmt.Errorf("unwrapping key of workspace %d: %w", workspaceID, err)
+	}
+
+	return dataKey, nil
+}
@@ -0,0 +1,192 @@
+package filestorage
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/hiimjako/syncinator/internal/testutils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func randomBytes(t *testing.T, n int) []byte {
+	b := make([]byte, n)
+	_, err := rand.Read(b)
+	require.NoError(t, err)
+	return b
+}
+
+func TestEncryptedStorage(t *testing.T) {
+	db := testutils.CreateDB(t)
+	masterKey := randomBytes(t, 32)
+
+	t.Run("should encrypt and decrypt objects of any size", func(t *testing.T) {
+		dir := t.TempDir()
+		e, err := NewEncryptedStorage(NewDisk(dir), db, masterKey)
+		require.NoError(t, err)
+		s := e.ForWorkspace(1)
+
+		for _, size := range []int{0, 10, segmentSize, 3*segmentSize + 7} {
+			content := randomBytes(t, size)
+
+			p, err := s.CreateObject(bytes.NewReader(content))
+			require.NoError(t, err)
+
+			raw, err := os.ReadFile(filepath.Join(dir, p))
+			require.NoError(t, err)
+			assert.True(t, bytes.HasPrefix(raw, []byte(encryptedMagic)))
+			if size > 0 {
+				assert.False(t, bytes.Contains(raw, content))
+			}
+
+			reader, err := e.ReadObject(p)
+			require.NoError(t, err)
+			got, err := io.ReadAll(reader)
+			require.NoError(t, err)
+			require.NoError(t, reader.Close())
+			assert.Equal(t, content, got, "size %d", size)
+		}
+	})
+
+	t.Run("should overwrite objects encrypting the new content", func(t *testing.T) {
+		e, err := NewEncryptedStorage(NewDisk(t.TempDir()), db, masterKey)
+		require.NoError(t, err)
+		s := e.ForWorkspace(2)
+
+		p, err := s.CreateObject(strings.NewReader("foo"))
+		require.NoError(t, err)
+		require.NoError(t, s.WriteObject(p, strings.NewReader("bar")))
+
+		assert.Equal(t, "bar", readString(t, e, p))
+	})
+
+	t.Run("should read plaintext objects written before enabling it", func(t *testing.T) {
+		disk := NewDisk(t.TempDir())
+		e, err := NewEncryptedStorage(disk, db, masterKey)
+		require.NoError(t, err)
+
+		p, err := disk.CreateObject(strings.NewReader("plain"))
+		require.NoError(t, err)
+
+		assert.Equal(t, "plain", readString(t, e, p))
+	})
+
+	t.Run("should reject plaintext objects when strict", func(t *testing.T) {
+		disk := NewDisk(t.TempDir())
+		e, err := NewEncryptedStorage(disk, db, masterKey)
+		require.NoError(t, err)
+		e.RejectPlaintext()
+
+		plain, err := disk.CreateObject(strings.NewReader("plain"))
+		require.NoError(t, err)
+		_, err = e.ReadObject(plain)
+		assert.ErrorIs(t, err, ErrPlaintextObject)
+
+		encrypted, err := e.ForWorkspace(1).CreateObject(strings.NewReader("secret"))
+		require.NoError(t, err)
+		assert.Equal(t, "secret", readString(t, e, encrypted))
+	})
+
+	t.Run("should encrypt the plaintext objects", func(t *testing.T) {
+		disk := NewDisk(t.TempDir())
+		e, err := NewEncryptedStorage(disk, db, masterKey)
+		require.NoError(t, err)
+
+		plain, err := disk.CreateObject(strings.NewReader("plain"))
+		require.NoError(t, err)
+		encrypted, err := e.ForWorkspace(1).CreateObject(strings.NewReader("secret"))
+		require.NoError(t, err)
+
+		rewritten, err := e.EncryptPlaintext(1, plain)
+		require.NoError(t, err)
+		assert.True(t, rewritten)
+		rewritten, err = e.EncryptPlaintext(1, encrypted)
+		require.NoError(t, err)
+		assert.False(t, rewritten)
+
+		e.RejectPlaintext()
+		assert.Equal(t, "plain", readString(t, e, plain))
+		assert.Equal(t, "secret", readString(t, e, encrypted))
+	})
+
+	t.Run("should require a workspace to write", func(t *testing.T) {
+		e, err := NewEncryptedStorage(NewDisk(t.TempDir()), db, masterKey)
+		require.NoError(t, err)
+
+		_, err = e.CreateObject(strings.NewReader("foo"))
+		assert.ErrorIs(t, err, ErrWorkspaceRequired)
+	})
+
+	t.Run("should detect tampered and truncated objects", func(t *testing.T) {
+		dir := t.TempDir()
+		e, err := NewEncryptedStorage(NewDisk(dir), db, masterKey)
+		require.NoError(t, err)
+
+		p, err := e.ForWorkspace(1).CreateObject(bytes.NewReader(randomBytes(t, 2*segmentSize)))
+		require.NoError(t, err)
+
+		diskPath := filepath.Join(dir, p)
+		raw, err := os.ReadFile(diskPath)
+		require.NoError(t, err)
+
+		tampered := bytes.Clone(raw)
+		tampered[len(tampered)-1] ^= 0xff
+		require.NoError(t, os.WriteFile(diskPath, tampered, 0600))
+
+		reader, err := e.ReadObject(p)
+		require.NoError(t, err)
+		_, err = io.ReadAll(reader)
+		assert.Error(t, err)
+		reader.Close()
+
+		truncated := raw[:encryptedHeadSize+segmentSize+16]
+		require.NoError(t, os.WriteFile(diskPath, truncated, 0600))
+
+		reader, err = e.ReadObject(p)
+		require.NoError(t, err)
+		_, err = io.ReadAll(reader)
+		assert.Error(t, err)
+		reader.Close()
+	})
+
+	t.Run("should reject master keys of the wrong size", func(t *testing.T) {
+		_, err := NewEncryptedStorage(NewDisk(t.TempDir()), db, []byte("short"))
+		assert.Error(t, err)
+	})
+}
+
+func TestRotateMasterKey(t *testing.T) {
+	db := testutils.CreateDB(t)
+	dir := t.TempDir()
+
+	oldKey := randomBytes(t, 32)
+	newKey := randomBytes(t, 32)
+
+	e, err := NewEncryptedStorage(NewDisk(dir), db, oldKey)
+	require.NoError(t, err)
+
+	p, err := e.ForWorkspace(10).CreateObject(strings.NewReader("secret note"))
+	require.NoError(t, err)
+
+	_, err = RotateMasterKey(context.Background(), db, randomBytes(t, 32), newKey)
+	assert.Error(t, err, "should not rotate with a wrong old key")
+
+	rotated, err := RotateMasterKey(context.Background(), db, oldKey, newKey)
+	require.NoError(t, err)
+	assert.GreaterOrEqual(t, rotated, 1)
+
+	withNewKey, err := NewEncryptedStorage(NewDisk(dir), db, newKey)
+	require.NoError(t, err)
+	assert.Equal(t, "secret note", readString(t, withNewKey, p))
+
+	withOldKey, err := NewEncryptedStorage(NewDisk(dir), db, oldKey)
+	require.NoError(t, err)
+	_, err = withOldKey.ReadObject(p)
+	assert.Error(t, err)
+}
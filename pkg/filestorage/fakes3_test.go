@@ -0,0 +1,118 @@
+package filestorage
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// FakeS3 is an in-memory S3-compatible server, meant to test the S3 storage
+// without a real service. It verifies the request signatures.
+type FakeS3 struct {
+	AccessKeyID     string
+	SecretAccessKey string
+	Region          string
+
+	mu      sync.Mutex
+	objects map[string][]byte
+}
+
+func NewFakeS3(accessKeyID, secretAccessKey, region string) *FakeS3 {
+	return &FakeS3{
+		AccessKeyID:     accessKeyID,
+		SecretAccessKey: secretAccessKey,
+		Region:          region,
+		objects:         make(map[string][]byte),
+	}
+}
+
+// Objects returns the keys of the stored objects, bucket included
+func (f *FakeS3) Objects() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	keys := make([]string, 0, len(f.objects))
+	for k := range f.objects {
+		keys = append(keys, k)
+	}
+	return keys
+}
+
+func (f *FakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if err := f.verifySignature(r); err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+
+	key := strings.TrimPrefix(r.URL.Path, "/")
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	switch r.Method {
+	case http.MethodPut:
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		sum := sha256.Sum256(body)
+		if hex.EncodeToString(sum[:]) != r.Header.Get("X-Amz-Content-Sha256") {
+			http.Error(w, "XAmzContentSHA256Mismatch", http.StatusBadRequest)
+			return
+		}
+
+		if _, exists := f.objects[key]; exists && r.Header.Get("If-None-Match") == "*" {
+			w.WriteHeader(http.StatusPreconditionFailed)
+			return
+		}
+
+		f.objects[key] = body
+		w.WriteHeader(http.StatusOK)
+	case http.MethodGet, http.MethodHead:
+		content, ok := f.objects[key]
+		if !ok {
+			http.Error(w, "NoSuchKey", http.StatusNotFound)
+			return
+		}
+
+		w.Header().Set("Content-Length", fmt.Sprint(len(content)))
+		w.WriteHeader(http.StatusOK)
+		if r.Method == http.MethodGet {
+			_, _ = w.Write(content)
+		}
+	case http.MethodDelete:
+		delete(f.objects, key)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func (f *FakeS3) verifySignature(r *http.Request) error {
+	amzDate := r.Header.Get("X-Amz-Date")
+	if len(amzDate) < 8 {
+		return fmt.Errorf("missing X-Amz-Date")
+	}
+
+	scope, signature := signatureV4(
+		f.SecretAccessKey, f.Region,
+		r.Method, r.URL.EscapedPath(), r.URL.RawQuery, r.Host,
+		r.Header.Get("X-Amz-Content-Sha256"), amzDate,
+	)
+
+	want := fmt.Sprintf(
+		"AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		f.AccessKeyID, scope, s3SignedHeaders, signature,
+	)
+	if r.Header.Get("Authorization") != want {
+		return fmt.Errorf("SignatureDoesNotMatch")
+	}
+
+	return nil
+}
@@ -18,6 +18,22 @@ type Storage interface {
 	ReadObject(string) (io.ReadCloser, error)
 }
 
+// WorkspaceScoped is implemented by storages that need to know the workspace
+// an object belongs to in order to write it, e.g. to encrypt it with the
+// workspace key.
+type WorkspaceScoped interface {
+	ForWorkspace(workspaceID int64) Storage
+}
+
+// ForWorkspace returns the view of the storage used to write the objects of
+// the workspace.
+func ForWorkspace(s Storage, workspaceID int64) Storage {
+	if scoped, ok := s.(WorkspaceScoped); ok {
+		return scoped.ForWorkspace(workspaceID)
+	}
+	return s
+}
+
 func GenerateHash(file io.Reader) (string, error) {
 	hash := sha256.New()
 	_, err := io.Copy(hash, file)
@@ -0,0 +1,367 @@
+package filestorage
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+var _ Storage = (*S3)(nil)
+
+// emptyPayloadHash is the sha256 of an empty body, used to sign requests
+// without payload
+const emptyPayloadHash = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+
+type S3Options struct {
+	// Endpoint is the base url of the S3-compatible service, e.g. http://minio:9000
+	Endpoint        string
+	Region          string
+	Bucket          string
+	AccessKeyID     string
+	SecretAccessKey string
+	// Prefix is prepended to every object key
+	Prefix string
+	Client *http.Client
+}
+
+// S3 stores objects in a bucket of any S3-compatible service, addressed in
+// path style and signed with AWS Signature Version 4.
+type S3 struct {
+	endpoint        *url.URL
+	region          string
+	bucket          string
+	accessKeyID     string
+	secretAccessKey string
+	prefix          string
+	client          *http.Client
+}
+
+func NewS3(opts S3Options) (*S3, error) {
+	endpoint, err := url.Parse(opts.Endpoint)
+	if err != nil || endpoint.Scheme == "" || endpoint.Host == "" {
+		return nil, fmt.Errorf("invalid s3 endpoint %q", opts.Endpoint)
+	}
+
+	if opts.Bucket == "" {
+		return nil, errors.New("missing s3 bucket")
+	}
+
+	if opts.Region == "" {
+		opts.Region = "us-east-1"
+	}
+
+	if opts.Client == nil {
+		opts.Client = &http.Client{Timeout: 5 * time.Minute}
+	}
+
+	return &S3{
+		endpoint:        endpoint,
+		region:          opts.Region,
+		bucket:          opts.Bucket,
+		accessKeyID:     opts.AccessKeyID,
+		secretAccessKey: opts.SecretAccessKey,
+		prefix:          strings.Trim(opts.Prefix, "/"),
+		client:          opts.Client,
+	}, nil
+}
+
+func (s *S3) resolveKey(relativePath string) (string, error) {
+	for _, segment := range strings.Split(relativePath, "/") {
+		if segment == ".." {
+			return "", fmt.Errorf("path traversal detected: %s escapes prefix", relativePath)
+		}
+	}
+
+	key := strings.TrimPrefix(path.Clean("/"+relativePath), "/")
+	if key == "" {
+		return "", fmt.Errorf("invalid path: %q", relativePath)
+	}
+
+	if s.prefix != "" {
+		key = s.prefix + "/" + key
+	}
+	return key, nil
+}
+
+func (s *S3) CreateObject(file io.Reader) (string, error) {
+	body, payloadHash, size, err := spoolPayload(file)
+	if err != nil {
+		return "", err
+	}
+	defer func() {
+		body.Close()
+		os.Remove(body.Name())
+	}()
+
+	const maxIterations = 100
+	for i := 0; i < maxIterations; i++ {
+		id := uuid.New().String()
+		relativePath := strings.Join(strings.Split(id, "-"), "/")
+		key, err := s.resolveKey(relativePath)
+		if err != nil {
+			return "", err
+		}
+
+		if _, err := body.Seek(0, io.SeekStart); err != nil {
+			return "", err
+		}
+
+		res, err := s.do(http.MethodPut, key, io.NopCloser(body), size, payloadHash, map[string]string{
+			// never overwrite an existing object
+			"If-None-Match": "*",
+		})
+		if err != nil {
+			return "", err
+		}
+		res.Body.Close()
+
+		if res.StatusCode == http.StatusPreconditionFailed {
+			// object already exists, retry with a new UUID
+			continue
+		}
+		if res.StatusCode/100 != 2 {
+			return "", fmt.Errorf("s3 put %s: %s", key, res.Status)
+		}
+
+		return relativePath, nil
+	}
+
+	return "", fmt.Errorf("failed to generate unique path after %d attempts", maxIterations)
+}
+
+func (s *S3) WriteObject(relativePath string, content io.Reader) error {
+	key, err := s.resolveKey(relativePath)
+	if err != nil {
+		return err
+	}
+
+	res, err := s.do(http.MethodHead, key, nil, 0, emptyPayloadHash, nil)
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+	if res.StatusCode == http.StatusNotFound {
+		return &fs.PathError{Op: "write", Path: relativePath, Err: fs.ErrNotExist}
+	}
+	if res.StatusCode/100 != 2 {
+		return fmt.Errorf("s3 head %s: %s", key, res.Status)
+	}
+
+	body, payloadHash, size, err := spoolPayload(content)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		body.Close()
+		os.Remove(body.Name())
+	}()
+
+	res, err = s.do(http.MethodPut, key, io.NopCloser(body), size, payloadHash, nil)
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+	if res.StatusCode/100 != 2 {
+		return fmt.Errorf("s3 put %s: %s", key, res.Status)
+	}
+
+	return nil
+}
+
+func (s *S3) DeleteObject(relativePath string) error {
+	key, err := s.resolveKey(relativePath)
+	if err != nil {
+		return err
+	}
+
+	res, err := s.do(http.MethodDelete, key, nil, 0, emptyPayloadHash, nil)
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+
+	// deleting a missing object is not an error, as for Disk
+	if res.StatusCode/100 != 2 && res.StatusCode != http.StatusNotFound {
+		return fmt.Errorf("s3 delete %s: %s", key, res.Status)
+	}
+
+	return nil
+}
+
+func (s *S3) ReadObject(relativePath string) (io.ReadCloser, error) {
+	key, err := s.resolveKey(relativePath)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := s.do(http.MethodGet, key, nil, 0, emptyPayloadHash, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	if res.StatusCode == http.StatusNotFound {
+		res.Body.Close()
+		return nil, &fs.PathError{Op: "read", Path: relativePath, Err: fs.ErrNotExist}
+	}
+	if res.StatusCode/100 != 2 {
+		res.Body.Close()
+		return nil, fmt.Errorf("s3 get %s: %s", key, res.Status)
+	}
+
+	return res.Body, nil
+}
+
+func (s *S3) do(
+	method, key string,
+	body io.ReadCloser,
+	size int64,
+	payloadHash string,
+	headers map[string]string,
+) (*http.Response, error) {
+	u := *s.endpoint
+	u.Path = path.Join("/", s.endpoint.Path, s.bucket, key)
+	// send the path exactly as it is signed
+	u.RawPath = uriEncodePath(u.Path)
+
+	req, err := http.NewRequest(method, u.String(), body)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.ContentLength = size
+	}
+
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	s.sign(req, payloadHash, time.Now().UTC())
+
+	res, err := s.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("s3 %s %s: %w", strings.ToLower(method), key, err)
+	}
+
+	return res, nil
+}
+
+// sign adds the AWS Signature Version 4 headers to the request
+func (s *S3) sign(req *http.Request, payloadHash string, now time.Time) {
+	amzDate := now.Format("20060102T150405Z")
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	scope, signature := signatureV4(
+		s.secretAccessKey, s.region,
+		req.Method, req.URL.EscapedPath(), req.URL.RawQuery, req.URL.Host,
+		payloadHash, amzDate,
+	)
+
+	req.Header.Set("Authorization", fmt.Sprintf(
+		"AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s.accessKeyID, scope, s3SignedHeaders, signature,
+	))
+}
+
+const s3SignedHeaders = "host;x-amz-content-sha256;x-amz-date"
+
+// signatureV4 returns the credential scope and the signature of a request
+// signing only the headers in s3SignedHeaders
+func signatureV4(
+	secretAccessKey, region string,
+	method, escapedPath, rawQuery, host string,
+	payloadHash, amzDate string,
+) (string, string) {
+	date := amzDate[:8]
+
+	canonicalHeaders := "host:" + host + "\n" +
+		"x-amz-content-sha256:" + payloadHash + "\n" +
+		"x-amz-date:" + amzDate + "\n"
+
+	canonicalRequest := strings.Join([]string{
+		method,
+		escapedPath,
+		rawQuery,
+		canonicalHeaders,
+		s3SignedHeaders,
+		payloadHash,
+	}, "\n")
+
+	scope := date + "/" + region + "/s3/aws4_request"
+	stringToSign := strings.Join([]string{
+		"AWS4-HMAC-SHA256",
+		amzDate,
+		scope,
+		sha256Hex(canonicalRequest),
+	}, "\n")
+
+	key := hmacSHA256([]byte("AWS4"+secretAccessKey), date)
+	key = hmacSHA256(key, region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+
+	return scope, hex.EncodeToString(hmacSHA256(key, stringToSign))
+}
+
+// spoolPayload copies the content to a temporary file, since S3 needs both the
+// length and the hash of the payload before sending it
+func spoolPayload(content io.Reader) (*os.File, string, int64, error) {
+	tmp, err := os.CreateTemp("", "syncinator-s3-*")
+	if err != nil {
+		return nil, "", 0, err
+	}
+
+	h := sha256.New()
+	size, err := io.Copy(io.MultiWriter(tmp, h), content)
+	if err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return nil, "", 0, fmt.Errorf("failed to buffer content: %w", err)
+	}
+
+	if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return nil, "", 0, err
+	}
+
+	return tmp, hex.EncodeToString(h.Sum(nil)), size, nil
+}
+
+// uriEncodePath escapes everything but the unreserved characters and the
+// slashes, as required by the canonical request
+func uriEncodePath(p string) string {
+	var sb strings.Builder
+	for _, b := range []byte(p) {
+		switch {
+		case 'A' <= b && b <= 'Z', 'a' <= b && b <= 'z', '0' <= b && b <= '9',
+			b == '-', b == '_', b == '.', b == '~', b == '/':
+			sb.WriteByte(b)
+		default:
+			fmt.Fprintf(&sb, "%%%02X", b)
+		}
+	}
+	return sb.String()
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+func sha256Hex(data string) string {
+	sum := sha256.Sum256([]byte(data))
+	return hex.EncodeToString(sum[:])
+}
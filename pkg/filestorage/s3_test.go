@@ -0,0 +1,133 @@
+package filestorage
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSignatureV4(t *testing.T) {
+	// examples from the AWS Signature Version 4 documentation for S3
+	const secret = "wJalrXUtnFEMI/K7MDENG/bPxRfiCYEXAMPLEKEY"
+
+	tests := []struct {
+		name     string
+		rawQuery string
+		want     string
+	}{
+		{
+			name:     "get bucket lifecycle",
+			rawQuery: "lifecycle=",
+			want:     "fea454ca298b7da1c68078a5d1bdbfbbe0d65c699e0f91ac7a200a0136783543",
+		},
+		{
+			name:     "list objects",
+			rawQuery: "max-keys=2&prefix=J",
+			want:     "34b48302e7b5fa45bde8084f4b7868a86f0a534bc59db6670ed5711ef69dc6f7",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			scope, signature := signatureV4(
+				secret, "us-east-1",
+				"GET", "/", tt.rawQuery, "examplebucket.s3.amazonaws.com",
+				emptyPayloadHash, "20130524T000000Z",
+			)
+			assert.Equal(t, "20130524/us-east-1/s3/aws4_request", scope)
+			assert.Equal(t, tt.want, signature)
+		})
+	}
+}
+
+func newTestS3(t *testing.T, prefix string) (*S3, *FakeS3) {
+	fake := NewFakeS3("access", "secret", "eu-west-1")
+	ts := httptest.NewServer(fake)
+	t.Cleanup(ts.Close)
+
+	s, err := NewS3(S3Options{
+		Endpoint:        ts.URL,
+		Region:          "eu-west-1",
+		Bucket:          "vault",
+		AccessKeyID:     "access",
+		SecretAccessKey: "secret",
+		Prefix:          prefix,
+	})
+	require.NoError(t, err)
+
+	return s, fake
+}
+
+func TestS3(t *testing.T) {
+	t.Run("should create, overwrite, read and delete an object", func(t *testing.T) {
+		s, fake := newTestS3(t, "data")
+
+		objectPath, err := s.CreateObject(strings.NewReader("foo"))
+		require.NoError(t, err)
+		assert.Equal(t, []string{"vault/data/" + objectPath}, fake.Objects())
+
+		require.NoError(t, s.WriteObject(objectPath, strings.NewReader("bar")))
+
+		reader, err := s.ReadObject(objectPath)
+		require.NoError(t, err)
+		content, err := io.ReadAll(reader)
+		require.NoError(t, err)
+		require.NoError(t, reader.Close())
+		assert.Equal(t, "bar", string(content))
+
+		require.NoError(t, s.DeleteObject(objectPath))
+		assert.Empty(t, fake.Objects())
+
+		// deleting twice is not an error
+		require.NoError(t, s.DeleteObject(objectPath))
+	})
+
+	t.Run("should return not exist errors on missing objects", func(t *testing.T) {
+		s, _ := newTestS3(t, "")
+
+		_, err := s.ReadObject("not/existing")
+		assert.True(t, errors.Is(err, fs.ErrNotExist))
+
+		err = s.WriteObject("not/existing", strings.NewReader("foo"))
+		assert.True(t, errors.Is(err, fs.ErrNotExist))
+	})
+
+	t.Run("should reject path traversal", func(t *testing.T) {
+		s, _ := newTestS3(t, "data")
+
+		_, err := s.ReadObject("../other/object")
+		assert.Error(t, err)
+	})
+
+	t.Run("should fail with wrong credentials", func(t *testing.T) {
+		fake := NewFakeS3("access", "secret", "eu-west-1")
+		ts := httptest.NewServer(fake)
+		t.Cleanup(ts.Close)
+
+		s, err := NewS3(S3Options{
+			Endpoint:        ts.URL,
+			Region:          "eu-west-1",
+			Bucket:          "vault",
+			AccessKeyID:     "access",
+			SecretAccessKey: "wrong",
+		})
+		require.NoError(t, err)
+
+		_, err = s.CreateObject(strings.NewReader("foo"))
+		assert.Error(t, err)
+	})
+
+	t.Run("should validate the options", func(t *testing.T) {
+		_, err := NewS3(S3Options{Endpoint: "minio:9000", Bucket: "vault"})
+		assert.Error(t, err)
+
+		_, err = NewS3(S3Options{Endpoint: "http://minio:9000"})
+		assert.Error(t, err)
+	})
+}
@@ -0,0 +1,417 @@
+package syncinator
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"path"
+	"strings"
+
+	"github.com/hiimjako/syncinator/internal/repository"
+	"github.com/hiimjako/syncinator/internal/requestutils"
+	"github.com/hiimjako/syncinator/pkg/filestorage"
+	"github.com/hiimjako/syncinator/pkg/middleware"
+	"github.com/hiimjako/syncinator/pkg/mimeutils"
+)
+
+// ImportStrategy tells what to do with an imported file whose path already
+// exists in the workspace
+type ImportStrategy string
+
+const (
+	// ImportSkip keeps the existing file
+	ImportSkip ImportStrategy = "skip"
+	// ImportReplace overwrites the content of the existing file, keeping its
+	// history
+	ImportReplace ImportStrategy = "replace"
+	// ImportMerge keeps both, adding the imported file as a conflict copy
+	ImportMerge ImportStrategy = "merge"
+)
+
+// ImportStatus is what happened to an imported file
+type ImportStatus string
+
+const (
+	ImportCreated   ImportStatus = "created"
+	ImportReplaced  ImportStatus = "replaced"
+	ImportCopied    ImportStatus = "copied"
+	ImportSkipped   ImportStatus = "skipped"
+	ImportUnchanged ImportStatus = "unchanged"
+	ImportFailed    ImportStatus = "failed"
+)
+
+type ImportResult struct {
+	Path   string       `json:"path"`
+	Status ImportStatus `json:"status"`
+	FileID int64        `json:"fileId,omitempty"`
+	// CopyPath is where the file was imported with the merge strategy
+	CopyPath string `json:"copyPath,omitempty"`
+	Error    string `json:"error,omitempty"`
+}
+
+const (
+	ErrInvalidArchive        = "invalid zip archive"
+	ErrInvalidImportStrategy = "invalid import strategy, expected merge, replace or skip"
+	ErrInvalidImportPath     = "invalid path"
+	ErrImportTooLarge        = "file too large"
+	ErrImportTypeMismatch    = "text and binary files can't replace each other"
+	ErrInvalidManifest       = "invalid manifest"
+	ErrImportHashMismatch    = "content doesn't match the manifest"
+	ErrImportMimeMismatch    = "MIME type of the manifest doesn't match the content"
+)
+
+// importHandler creates the files of the zip archive sent in the "file" field,
+// resolving the paths that already exist with the "strategy" of the query
+// (skip by default). A file failing doesn't stop the others, the response
+// reports what happened to each one. The files listed in the manifest of an
+// export keep their MIME type and are checked against their hash.
+func (s *syncinator) importHandler(w http.ResponseWriter, r *http.Request) {
+	strategy := ImportStrategy(r.URL.Query().Get("strategy"))
+	switch strategy {
+	case "":
+		strategy = ImportSkip
+	case ImportSkip, ImportReplace, ImportMerge:
+	default:
+		http.Error(w, ErrInvalidImportStrategy, http.StatusBadRequest)
+		return
+	}
+
+	fileReader, cleanup, ok := s.parseMultipartFile(w, r)
+	if !ok {
+		return
+	}
+	defer cleanup()
+
+	archive, err := openZip(fileReader)
+	if err != nil {
+		http.Error(w, ErrInvalidArchive, http.StatusBadRequest)
+		return
+	}
+
+	exported, err := readImportManifest(archive, s.maxFileSizeBytes)
+	if err != nil {
+		http.Error(w, ErrInvalidManifest, http.StatusBadRequest)
+		return
+	}
+
+	workspaceID, _ := middleware.WorkspaceIDFromCtx(r.Context())
+	endToEnd, err := s.db.FetchWorkspaceEndToEndEncrypted(r.Context(), workspaceID)
+	if err != nil {
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	rules := pathRulesFromCtx(r.Context())
+	results := make([]ImportResult, 0, len(archive.File))
+	counts := make(map[ImportStatus]int)
+	for _, entry := range archive.File {
+		if entry.FileInfo().IsDir() || entry.Name == ExportManifestPath {
+			continue
+		}
+
+		result := ImportResult{Path: entry.Name}
+		workspacePath, ok := importPath(entry.Name)
+		switch {
+		case !ok:
+			result.Status, result.Error = ImportFailed, ErrInvalidImportPath
+		case !rules.CanWrite(workspacePath):
+			result.Status, result.Error = ImportFailed, ErrForbiddenPath
+		default:
+			result = s.importFile(r.Context(), workspaceID, endToEnd, strategy, workspacePath, entry, exported[entry.Name])
+		}
+
+		counts[result.Status]++
+		results = append(results, result)
+	}
+
+	s.auditRequest(r, AuditImport, "", fmt.Sprintf("%s: %d created, %d replaced, %d copied, %d skipped, %d unchanged, %d failed",
+		strategy,
+		counts[ImportCreated],
+		counts[ImportReplaced],
+		counts[ImportCopied],
+		counts[ImportSkipped],
+		counts[ImportUnchanged],
+		counts[ImportFailed],
+	))
+
+	writeJSON(w, http.StatusOK, results)
+}
+
+func openZip(file io.ReadSeeker) (*zip.Reader, error) {
+	readerAt, ok := file.(io.ReaderAt)
+	if !ok {
+		return nil, errors.New("archive can't be read at random offsets")
+	}
+
+	size, err := file.Seek(0, io.SeekEnd)
+	if err != nil {
+		return nil, err
+	}
+
+	return zip.NewReader(readerAt, size)
+}
+
+// readImportManifest returns the files listed in the manifest of the archive
+// by their path, none if it isn't an export
+func readImportManifest(archive *zip.Reader, maxSize int64) (map[string]ExportedFile, error) {
+	exported := make(map[string]ExportedFile)
+	for _, entry := range archive.File {
+		if entry.Name != ExportManifestPath {
+			continue
+		}
+
+		content, err := readImportEntry(entry, maxSize)
+		if err != nil {
+			return nil, err
+		}
+
+		var manifest ExportManifest
+		if err := json.Unmarshal(content, &manifest); err != nil {
+			return nil, err
+		}
+		for _, file := range manifest.Files {
+			exported[file.Path] = file
+		}
+		break
+	}
+	return exported, nil
+}
+
+// importPath is the workspace path of an archive entry, entries escaping the
+// root of the archive are rejected
+func importPath(name string) (string, bool) {
+	cleaned := path.Clean(strings.ReplaceAll(name, "\\", "/"))
+	if path.IsAbs(cleaned) || cleaned == "." || cleaned == ".." || strings.HasPrefix(cleaned, "../") {
+		return "", false
+	}
+	return cleaned, true
+}
+
+func (s *syncinator) importFile(
+	ctx context.Context,
+	workspaceID int64,
+	endToEnd bool,
+	strategy ImportStrategy,
+	workspacePath string,
+	entry *zip.File,
+	expected ExportedFile,
+) ImportResult {
+	result := ImportResult{Path: workspacePath}
+	fail := func(msg string) ImportResult {
+		result.Status, result.Error = ImportFailed, msg
+		return result
+	}
+
+	content, err := readImportEntry(entry, s.maxFileSizeBytes)
+	if err != nil {
+		if errors.Is(err, errImportTooLarge) {
+			return fail(ErrImportTooLarge)
+		}
+		return fail(ErrReadingFile)
+	}
+
+	reader := bytes.NewReader(content)
+	mimeType := requestutils.DetectFileMimeType(reader, workspacePath)
+	// the manifest can refine the detected type, but a text file can't be
+	// stored as a binary one or the other way around
+	if expected.MimeType != "" && !endToEnd {
+		if mimeutils.IsText(expected.MimeType) != mimeutils.IsText(mimeType) {
+			return fail(ErrImportMimeMismatch)
+		}
+		mimeType = expected.MimeType
+	}
+	if endToEnd {
+		mimeType = mimeutils.Encrypted
+	}
+	hash, err := filestorage.GenerateHash(reader)
+	if err != nil {
+		return fail(ErrInvalidFile)
+	}
+	if expected.Hash != "" && expected.Hash != hash {
+		return fail(ErrImportHashMismatch)
+	}
+
+	imported := repository.File{
+		WorkspacePath: workspacePath,
+		MimeType:      mimeType,
+		Hash:          hash,
+		WorkspaceID:   workspaceID,
+	}
+
+	existing, err := s.db.FetchFileFromWorkspacePath(ctx, repository.FetchFileFromWorkspacePathParams{
+		WorkspaceID:   workspaceID,
+		WorkspacePath: workspacePath,
+	})
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		created, err := s.createImportedFile(ctx, imported, content, false)
+		if err != nil {
+			log.Printf("error importing %s in workspace %d: %v", workspacePath, workspaceID, err)
+			return fail(ErrInvalidFile)
+		}
+		result.Status, result.FileID = ImportCreated, created.FileID
+		return result
+	case err != nil:
+		return fail(http.StatusText(http.StatusInternalServerError))
+	}
+
+	result.FileID = existing.ID
+	if strategy == ImportSkip {
+		result.Status = ImportSkipped
+		return result
+	}
+	if strategy == ImportMerge {
+		if existing.Hash == hash {
+			result.Status = ImportUnchanged
+			return result
+		}
+
+		copied, err := s.createImportedFile(ctx, imported, content, true)
+		if err != nil {
+			log.Printf("error importing a copy of %s in workspace %d: %v", workspacePath, workspaceID, err)
+			return fail(ErrInvalidFile)
+		}
+		result.Status, result.FileID, result.CopyPath = ImportCopied, copied.FileID, copied.WorkspacePath
+		return result
+	}
+
+	if mimeutils.IsText(existing.MimeType) != mimeutils.IsText(mimeType) {
+		return fail(ErrImportTypeMismatch)
+	}
+	// the cached text files are compared with their latest content instead
+	if !mimeutils.IsText(mimeType) && existing.Hash == hash {
+		result.Status = ImportUnchanged
+		return result
+	}
+
+	changed, err := s.replaceImportedFile(ctx, existing, imported, content)
+	if err != nil {
+		log.Printf("error importing %s in workspace %d: %v", workspacePath, workspaceID, err)
+		return fail(ErrInvalidFile)
+	}
+	result.Status = ImportReplaced
+	if !changed {
+		result.Status = ImportUnchanged
+	}
+	return result
+}
+
+var errImportTooLarge = errors.New("file too large")
+
+func readImportEntry(entry *zip.File, maxSize int64) ([]byte, error) {
+	if entry.UncompressedSize64 > uint64(maxSize) {
+		return nil, errImportTooLarge
+	}
+
+	rc, err := entry.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+
+	// the declared size can't be trusted
+	content, err := io.ReadAll(io.LimitReader(rc, maxSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(content)) > maxSize {
+		return nil, errImportTooLarge
+	}
+	return content, nil
+}
+
+// createImportedFile stores the content as a new file, or as a conflict copy
+// of the file with the same path, announcing it to the clients.
+func (s *syncinator) createImportedFile(ctx context.Context, file repository.File, content []byte, asCopy bool) (*EventMessage, error) {
+	diskPath, err := s.storageFor(file.WorkspaceID).CreateObject(bytes.NewReader(content))
+	if err != nil {
+		return nil, fmt.Errorf("creating object: %w", err)
+	}
+
+	event, err := s.registerImportedFile(ctx, file, diskPath, asCopy)
+	if err != nil {
+		_ = s.storage.DeleteObject(diskPath)
+		return nil, err
+	}
+
+	s.publishToWorkspace(file.WorkspaceID, "", *event)
+	return event, nil
+}
+
+func (s *syncinator) registerImportedFile(ctx context.Context, file repository.File, diskPath string, asCopy bool) (*EventMessage, error) {
+	tx, err := s.conn.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("opening transaction: %w", err)
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	txq := s.db.WithTx(tx)
+	changeSeq, err := txq.BumpWorkspaceChangeSeq(ctx, file.WorkspaceID)
+	if err != nil {
+		return nil, fmt.Errorf("bumping change sequence: %w", err)
+	}
+
+	var event *EventMessage
+	if asCopy {
+		event, err = createConflictCopy(ctx, txq, file, diskPath, changeSeq)
+	} else {
+		event, err = createFileWithEvent(ctx, txq, repository.CreateFileParams{
+			DiskPath:      diskPath,
+			WorkspacePath: file.WorkspacePath,
+			MimeType:      file.MimeType,
+			Hash:          file.Hash,
+			WorkspaceID:   file.WorkspaceID,
+			ChangeSeq:     changeSeq,
+		})
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("committing transaction: %w", err)
+	}
+	return event, nil
+}
+
+// replaceImportedFile overwrites the content of the existing file like the
+// clients would: text files through an operation, binary files with a new
+// blob, keeping the previous one as a snapshot.
+func (s *syncinator) replaceImportedFile(ctx context.Context, existing, imported repository.File, content []byte) (bool, error) {
+	if mimeutils.IsText(existing.MimeType) {
+		file, ok := s.fileCache.Get(existing.ID)
+		if !ok {
+			var err error
+			file, err = s.fetchAndCacheFile(existing.ID)
+			if err != nil {
+				return false, fmt.Errorf("caching file: %w", err)
+			}
+		}
+
+		_, changed, err := s.setTextContent(ctx, file, string(content))
+		return changed, err
+	}
+
+	diskPath, err := s.storageFor(existing.WorkspaceID).CreateObject(bytes.NewReader(content))
+	if err != nil {
+		return false, fmt.Errorf("creating object: %w", err)
+	}
+
+	if _, err := s.replaceFileContent(ctx, existing, diskPath, imported.MimeType, imported.Hash, ""); err != nil {
+		_ = s.storage.DeleteObject(diskPath)
+		return false, err
+	}
+
+	if err := s.pruneBinarySnapshots(ctx, existing); err != nil {
+		log.Printf("error pruning snapshots of file %d: %v", existing.ID, err)
+	}
+
+	return true, nil
+}
@@ -0,0 +1,199 @@
+package syncinator
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/hiimjako/syncinator/internal/repository"
+	"github.com/hiimjako/syncinator/internal/testutils"
+	"github.com/hiimjako/syncinator/pkg/filestorage"
+	"github.com/hiimjako/syncinator/pkg/middleware"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// pngHeader is enough for the content to be detected as an image
+var pngHeader = []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR")
+
+func createZip(t *testing.T, files map[string][]byte) []byte {
+	buf := &bytes.Buffer{}
+	zipWriter := zip.NewWriter(buf)
+	for name, content := range files {
+		f, err := zipWriter.Create(name)
+		require.NoError(t, err)
+		_, err = f.Write(content)
+		require.NoError(t, err)
+	}
+	require.NoError(t, zipWriter.Close())
+	return buf.Bytes()
+}
+
+func Test_importHandler(t *testing.T) {
+	fs := filestorage.NewDisk(t.TempDir())
+	db := testutils.CreateDB(t)
+	options := Options{JWTSecret: []byte("secret")}
+	server := New(db, fs, options)
+	t.Cleanup(func() { server.Close() })
+
+	var workspaceID int64 = 10
+	asEditor := testutils.WithRoleAuthHeader(options.JWTSecret, workspaceID, middleware.RoleEditor)
+
+	// userID 0 imports with the workspace password
+	importZip := func(t *testing.T, query string, archive []byte, userID int64) (int, []ImportResult) {
+		form, contentType := testutils.CreateMultipart(t, "vault.zip", archive, false)
+		res, results := testutils.DoRequest[[]ImportResult](t, server, http.MethodPost, PathHTTPAPI+"/import"+query, form,
+			testutils.WithUserAuthHeader(options.JWTSecret, workspaceID, userID, middleware.RoleEditor),
+			testutils.WithContentTypeHeader(contentType))
+		return res.Code, results
+	}
+	readFile := func(t *testing.T, workspacePath string) (repository.File, string) {
+		file, err := server.db.FetchFileFromWorkspacePath(context.Background(), repository.FetchFileFromWorkspacePathParams{
+			WorkspaceID:   workspaceID,
+			WorkspacePath: workspacePath,
+		})
+		require.NoError(t, err)
+		if cached, ok := server.fileCache.Get(file.ID); ok {
+			return cached.File, cached.Content
+		}
+		object, err := fs.ReadObject(file.DiskPath)
+		require.NoError(t, err)
+		defer object.Close()
+		content, err := io.ReadAll(object)
+		require.NoError(t, err)
+		return file, string(content)
+	}
+
+	t.Run("should create the files of the archive", func(t *testing.T) {
+		code, results := importZip(t, "", createZip(t, map[string][]byte{
+			"notes/todo.md":     []byte("# todo"),
+			"attachments/a.png": pngHeader,
+		}), 0)
+		require.Equal(t, http.StatusOK, code)
+		require.Len(t, results, 2)
+		for _, result := range results {
+			assert.Equal(t, ImportCreated, result.Status, result.Path)
+			assert.NotZero(t, result.FileID)
+		}
+
+		file, content := readFile(t, "notes/todo.md")
+		assert.Equal(t, "text/plain; charset=utf-8", file.MimeType)
+		assert.Equal(t, "# todo", content)
+
+		file, _ = readFile(t, "attachments/a.png")
+		assert.Equal(t, "image/png", file.MimeType)
+	})
+
+	t.Run("should keep the existing files when skipping", func(t *testing.T) {
+		code, results := importZip(t, "?strategy=skip", createZip(t, map[string][]byte{
+			"notes/todo.md": []byte("# skipped"),
+		}), 0)
+		require.Equal(t, http.StatusOK, code)
+		require.Len(t, results, 1)
+		assert.Equal(t, ImportSkipped, results[0].Status)
+
+		_, content := readFile(t, "notes/todo.md")
+		assert.Equal(t, "# todo", content)
+	})
+
+	t.Run("should add a copy when merging", func(t *testing.T) {
+		code, results := importZip(t, "?strategy=merge", createZip(t, map[string][]byte{
+			"notes/todo.md":     []byte("# merged"),
+			"attachments/a.png": pngHeader,
+		}), 0)
+		require.Equal(t, http.StatusOK, code)
+		require.Len(t, results, 2)
+
+		byPath := map[string]ImportResult{}
+		for _, result := range results {
+			byPath[result.Path] = result
+		}
+		assert.Equal(t, ImportUnchanged, byPath["attachments/a.png"].Status)
+
+		copied := byPath["notes/todo.md"]
+		assert.Equal(t, ImportCopied, copied.Status)
+		assert.True(t, strings.HasPrefix(copied.CopyPath, "notes/todo (conflict "), copied.CopyPath)
+
+		_, content := readFile(t, copied.CopyPath)
+		assert.Equal(t, "# merged", content)
+		_, content = readFile(t, "notes/todo.md")
+		assert.Equal(t, "# todo", content)
+	})
+
+	t.Run("should overwrite the existing files when replacing", func(t *testing.T) {
+		before, _ := readFile(t, "notes/todo.md")
+		image, _ := readFile(t, "attachments/a.png")
+
+		code, results := importZip(t, "?strategy=replace", createZip(t, map[string][]byte{
+			"notes/todo.md":     []byte("# replaced"),
+			"attachments/a.png": append(pngHeader, 1, 2, 3),
+		}), 0)
+		require.Equal(t, http.StatusOK, code)
+		require.Len(t, results, 2)
+		for _, result := range results {
+			assert.Equal(t, ImportReplaced, result.Status, result.Path)
+		}
+
+		file, content := readFile(t, "notes/todo.md")
+		assert.Equal(t, before.ID, file.ID)
+		assert.Equal(t, before.Version+1, file.Version)
+		assert.Equal(t, "# replaced", content)
+
+		file, _ = readFile(t, "attachments/a.png")
+		assert.Equal(t, image.ID, file.ID)
+		assert.Equal(t, image.Version+1, file.Version)
+		snapshots, err := server.db.FetchSnapshots(context.Background(), repository.FetchSnapshotsParams{
+			FileID:      image.ID,
+			WorkspaceID: workspaceID,
+		})
+		require.NoError(t, err)
+		assert.Len(t, snapshots, 1, "the replaced image is kept as a snapshot")
+	})
+
+	t.Run("should report the files that can't be imported", func(t *testing.T) {
+		userID := addRestrictedMember(t, db, workspaceID, "importer", PathRules{
+			{Pattern: "notes/**", Access: PathWrite},
+		})
+
+		code, results := importZip(t, "?strategy=replace", createZip(t, map[string][]byte{
+			"../escaped.md":     []byte("outside"),
+			"private/secret.md": []byte("secret"),
+			"notes/todo.md":     pngHeader,
+		}), userID)
+		require.Equal(t, http.StatusOK, code)
+		require.Len(t, results, 3)
+
+		byPath := map[string]ImportResult{}
+		for _, result := range results {
+			assert.Equal(t, ImportFailed, result.Status, result.Path)
+			byPath[result.Path] = result
+		}
+		assert.Equal(t, ErrInvalidImportPath, byPath["../escaped.md"].Error)
+		assert.Equal(t, ErrForbiddenPath, byPath["private/secret.md"].Error)
+		assert.Equal(t, ErrImportTypeMismatch, byPath["notes/todo.md"].Error)
+	})
+
+	t.Run("should reject invalid requests", func(t *testing.T) {
+		form, contentType := testutils.CreateMultipart(t, "vault.zip", []byte("not a zip"), false)
+		res, body := testutils.DoRequest[string](t, server, http.MethodPost, PathHTTPAPI+"/import", form,
+			asEditor, testutils.WithContentTypeHeader(contentType))
+		assert.Equal(t, http.StatusBadRequest, res.Code)
+		assert.Equal(t, ErrInvalidArchive, body)
+
+		form, contentType = testutils.CreateMultipart(t, "vault.zip", createZip(t, nil), false)
+		res, body = testutils.DoRequest[string](t, server, http.MethodPost, PathHTTPAPI+"/import?strategy=overwrite", form,
+			asEditor, testutils.WithContentTypeHeader(contentType))
+		assert.Equal(t, http.StatusBadRequest, res.Code)
+		assert.Equal(t, ErrInvalidImportStrategy, body)
+
+		form, contentType = testutils.CreateMultipart(t, "vault.zip", createZip(t, nil), false)
+		res, _ = testutils.DoRequest[string](t, server, http.MethodPost, PathHTTPAPI+"/import", form,
+			testutils.WithRoleAuthHeader(options.JWTSecret, workspaceID, middleware.RoleViewer),
+			testutils.WithContentTypeHeader(contentType))
+		assert.Equal(t, http.StatusForbidden, res.Code)
+	})
+}
@@ -0,0 +1,85 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseScopes(t *testing.T) {
+	scopes, err := ParseScopes("read, export,read")
+	require.NoError(t, err)
+	assert.Equal(t, Scopes{ScopeRead, ScopeExport}, scopes)
+	assert.Equal(t, "read,export", scopes.String())
+
+	assert.True(t, scopes.Has(ScopeExport))
+	assert.False(t, scopes.Has(ScopeWrite))
+	assert.Equal(t, RoleViewer, scopes.Role())
+
+	assert.True(t, Scopes{ScopeAdmin}.Has(ScopeWrite), "admin grants every scope")
+	assert.Equal(t, RoleEditor, Scopes{ScopeWrite}.Role())
+	assert.Equal(t, RoleAdmin, Scopes{ScopeAdmin}.Role())
+
+	_, err = ParseScopes("read,owner")
+	assert.Error(t, err)
+	_, err = ParseScopes("")
+	assert.Error(t, err)
+}
+
+func TestIsAuthenticated_APIKey(t *testing.T) {
+	key, err := GenerateAPIKey()
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(key, APIKeyPrefix))
+
+	ao := AuthOptions{
+		SecretKey: []byte("secret-key"),
+		LookupAPIKey: func(_ context.Context, k string) (*APIKey, error) {
+			if k != key {
+				return nil, nil
+			}
+			return &APIKey{ID: 3, WorkspaceID: 123, Scopes: Scopes{ScopeRead}}, nil
+		},
+	}
+
+	serve := func(token string, scope Scope) *httptest.ResponseRecorder {
+		req := httptest.NewRequestWithContext(context.Background(), http.MethodGet, "/", http.NoBody)
+		req.Header.Set("Authorization", "Bearer "+token)
+		rec := httptest.NewRecorder()
+
+		next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			wid, _ := WorkspaceIDFromCtx(r.Context())
+			assert.Equal(t, int64(123), wid)
+			role, _ := RoleFromCtx(r.Context())
+			assert.Equal(t, RoleViewer, role)
+			tokenID, _ := TokenIDFromCtx(r.Context())
+			assert.Equal(t, APIKeyTokenID(3), tokenID)
+			w.WriteHeader(http.StatusOK)
+		})
+
+		CreateStack(IsAuthenticated(ao, ExtractBearerToken), RequireScope(scope))(next).ServeHTTP(rec, req)
+		return rec
+	}
+
+	assert.Equal(t, http.StatusOK, serve(key, ScopeRead).Code)
+	assert.Equal(t, http.StatusForbidden, serve(key, ScopeExport).Code)
+	assert.Equal(t, http.StatusUnauthorized, serve(APIKeyPrefix+"unknown", ScopeRead).Code)
+
+	t.Run("should not limit the tokens by scope", func(t *testing.T) {
+		token, err := CreateToken(ao, 123, 0, RoleViewer)
+		require.NoError(t, err)
+
+		req := httptest.NewRequestWithContext(context.Background(), http.MethodGet, "/", http.NoBody)
+		req.Header.Set("Authorization", "Bearer "+token)
+		rec := httptest.NewRecorder()
+		next := http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+			w.WriteHeader(http.StatusOK)
+		})
+		CreateStack(IsAuthenticated(ao, ExtractBearerToken), RequireScope(ScopeExport))(next).ServeHTTP(rec, req)
+		assert.Equal(t, http.StatusOK, rec.Code)
+	})
+}
@@ -16,17 +16,52 @@ type authKey string
 
 const (
 	AuthWorkspaceID authKey = "middleware.auth.workspaceID"
+	AuthUserID      authKey = "middleware.auth.userID"
+	AuthRole        authKey = "middleware.auth.role"
+	AuthTokenID     authKey = "middleware.auth.tokenID"
+	AuthScopes      authKey = "middleware.auth.scopes"
 
 	Issuer    = "obsidian-rt"
 	jwtLeeway = 5 * time.Second
+
+	// TokenTTL is how long an access token is valid, it must be refreshed
+	// or a new login is needed afterwards
+	TokenTTL = 30 * time.Minute
 )
 
 type CustomClaims struct {
+	WorkspaceID int64 `json:"wid"`
+	// UserID is the member the token was issued to, 0 when it was issued
+	// with the shared password of the workspace
+	UserID int64 `json:"uid,omitempty"`
+	Role   Role  `json:"role"`
 	jwt.RegisteredClaims
 }
 
 type AuthOptions struct {
+	// SecretKey signs and verifies the tokens with HS256 when there are no Keys
 	SecretKey []byte
+	Keys      *KeySet
+	// IsRevoked reports whether the token with the given jti has been revoked,
+	// nil skips the check
+	IsRevoked func(ctx context.Context, tokenID string) (bool, error)
+	// LookupAPIKey returns the API key, nil if it doesn't exist or expired.
+	// When nil, only JWTs are accepted.
+	LookupAPIKey func(ctx context.Context, key string) (*APIKey, error)
+	// LookupRole returns the current role of the user in the workspace, empty
+	// if the user lost access to it. The tokens already issued are limited to
+	// it, so that removals and demotions apply before they expire, while
+	// promotions need a new token. nil skips the check.
+	LookupRole func(ctx context.Context, workspaceID, userID int64) (Role, error)
+}
+
+// keySet returns the Keys, or a set made of the SecretKey alone, whose
+// tokens have no "kid"
+func (ao AuthOptions) keySet() *KeySet {
+	if ao.Keys != nil {
+		return ao.Keys
+	}
+	return &KeySet{keys: []SigningKey{{Method: jwt.SigningMethodHS256, Key: ao.SecretKey}}}
 }
 
 func writeUnauthed(w http.ResponseWriter) {
@@ -65,13 +100,63 @@ func IsAuthenticated(ao AuthOptions, tokenExtractor JWTTokenExtractor) func(next
 				return
 			}
 
-			workspaceID, err := VerifyToken(ao, encodedToken)
+			if ao.LookupAPIKey != nil && strings.HasPrefix(encodedToken, APIKeyPrefix) {
+				key, err := ao.LookupAPIKey(r.Context(), encodedToken)
+				if err != nil {
+					http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+					return
+				}
+				if key == nil {
+					writeUnauthed(w)
+					return
+				}
+
+				ctx := context.WithValue(r.Context(), AuthWorkspaceID, key.WorkspaceID)
+				ctx = context.WithValue(ctx, AuthUserID, int64(0))
+				ctx = context.WithValue(ctx, AuthRole, key.Scopes.Role())
+				ctx = context.WithValue(ctx, AuthTokenID, APIKeyTokenID(key.ID))
+				ctx = context.WithValue(ctx, AuthScopes, key.Scopes)
+
+				next.ServeHTTP(w, r.WithContext(ctx))
+				return
+			}
+
+			claims, err := VerifyToken(ao, encodedToken)
 			if err != nil {
 				writeUnauthed(w)
 				return
 			}
 
-			ctx := context.WithValue(r.Context(), AuthWorkspaceID, workspaceID)
+			if ao.IsRevoked != nil {
+				revoked, err := ao.IsRevoked(r.Context(), claims.ID)
+				if err != nil {
+					http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+					return
+				}
+				if revoked {
+					writeUnauthed(w)
+					return
+				}
+			}
+
+			role := claims.Role
+			if ao.LookupRole != nil {
+				current, err := ao.LookupRole(r.Context(), claims.WorkspaceID, claims.UserID)
+				if err != nil {
+					http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+					return
+				}
+				if current == "" {
+					writeUnauthed(w)
+					return
+				}
+				role = role.Lower(current)
+			}
+
+			ctx := context.WithValue(r.Context(), AuthWorkspaceID, claims.WorkspaceID)
+			ctx = context.WithValue(ctx, AuthUserID, claims.UserID)
+			ctx = context.WithValue(ctx, AuthRole, role)
+			ctx = context.WithValue(ctx, AuthTokenID, claims.ID)
 			req := r.WithContext(ctx)
 
 			next.ServeHTTP(w, req)
@@ -79,55 +164,99 @@ func IsAuthenticated(ao AuthOptions, tokenExtractor JWTTokenExtractor) func(next
 	}
 }
 
-func CreateToken(ao AuthOptions, workspaceID int64) (string, error) {
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256,
-		CustomClaims{
-			RegisteredClaims: jwt.RegisteredClaims{
-				ExpiresAt: jwt.NewNumericDate(time.Now().Add(30 * time.Minute)),
-				IssuedAt:  jwt.NewNumericDate(time.Now()),
-				NotBefore: jwt.NewNumericDate(time.Now()),
-				Issuer:    Issuer,
-				Subject:   strconv.Itoa(int(workspaceID)),
-				ID:        uuid.New().String(),
-			},
-		})
-	tokenString, err := token.SignedString(ao.SecretKey)
+// CreateToken issues a token granting access to the workspace with the given
+// role. userID is the member logging in, 0 when using the shared password of
+// the workspace.
+func CreateToken(ao AuthOptions, workspaceID, userID int64, role Role) (string, error) {
+	token, _, err := IssueToken(ao, workspaceID, userID, role)
+	return token, err
+}
+
+// IssueToken is CreateToken also returning the claims of the token, like its
+// jti needed to revoke it.
+func IssueToken(ao AuthOptions, workspaceID, userID int64, role Role) (string, *CustomClaims, error) {
+	subject := "workspace:" + strconv.FormatInt(workspaceID, 10)
+	if userID != 0 {
+		subject = "user:" + strconv.FormatInt(userID, 10)
+	}
+
+	claims := &CustomClaims{
+		WorkspaceID: workspaceID,
+		UserID:      userID,
+		Role:        role,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(TokenTTL)),
+			IssuedAt:  jwt.NewNumericDate(time.Now()),
+			NotBefore: jwt.NewNumericDate(time.Now()),
+			Issuer:    Issuer,
+			Subject:   subject,
+			ID:        uuid.New().String(),
+		},
+	}
+
+	key := ao.keySet().signingKey()
+	token := jwt.NewWithClaims(key.Method, claims)
+	if key.ID != "" {
+		token.Header["kid"] = key.ID
+	}
+
+	tokenString, err := token.SignedString(key.Key)
 	if err != nil {
-		return "", err
+		return "", nil, err
 	}
 
-	return tokenString, nil
+	return tokenString, claims, nil
 }
 
-func VerifyToken(ao AuthOptions, tokenString string) (int64, error) {
+func VerifyToken(ao AuthOptions, tokenString string) (*CustomClaims, error) {
+	keys := ao.keySet()
 	token, err := jwt.ParseWithClaims(
 		tokenString,
 		&CustomClaims{},
-		func(_ *jwt.Token) (interface{}, error) {
-			return ao.SecretKey, nil
-		},
-		jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Name}),
+		keys.keyFunc,
+		jwt.WithValidMethods(keys.methods()),
 		jwt.WithLeeway(jwtLeeway),
 		jwt.WithIssuer(Issuer),
 	)
 	if err != nil {
-		return 0, err
+		return nil, err
 	}
 
 	if !token.Valid {
-		return 0, fmt.Errorf("invalid token")
+		return nil, fmt.Errorf("invalid token")
 	}
 
 	claims := token.Claims.(*CustomClaims)
-	sub, err := strconv.Atoi(claims.Subject)
-	if err != nil {
-		return 0, fmt.Errorf("invalid sub")
+	if claims.WorkspaceID <= 0 {
+		return nil, fmt.Errorf("invalid workspace")
+	}
+
+	if _, err := ParseRole(string(claims.Role)); err != nil {
+		return nil, err
 	}
 
-	return int64(sub), nil
+	return claims, nil
 }
 
 func WorkspaceIDFromCtx(ctx context.Context) (int64, bool) {
 	val, ok := ctx.Value(AuthWorkspaceID).(int64)
 	return val, ok
 }
+
+func RoleFromCtx(ctx context.Context) (Role, bool) {
+	val, ok := ctx.Value(AuthRole).(Role)
+	return val, ok
+}
+
+// UserIDFromCtx returns the authenticated member, 0 if the workspace password
+// was used to log in.
+func UserIDFromCtx(ctx context.Context) (int64, bool) {
+	val, ok := ctx.Value(AuthUserID).(int64)
+	return val, ok
+}
+
+// TokenIDFromCtx returns the jti of the token used to authenticate
+func TokenIDFromCtx(ctx context.Context) (string, bool) {
+	val, ok := ctx.Value(AuthTokenID).(string)
+	return val, ok
+}
@@ -14,7 +14,7 @@ func TestIsAuthenticated(t *testing.T) {
 	ao := AuthOptions{SecretKey: []byte("secret-key")}
 
 	createToken := func(workspaceID int64) string {
-		token, err := CreateToken(ao, workspaceID)
+		token, err := CreateToken(ao, workspaceID, 0, RoleAdmin)
 		require.NoError(t, err)
 		require.NotEmpty(t, token)
 		return token
@@ -91,21 +91,120 @@ func TestCreateToken_NeverReturnsEmptyTokenWithNilError(t *testing.T) {
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			ao := AuthOptions{SecretKey: tt.secret}
-			token, err := CreateToken(ao, 42)
+			token, err := CreateToken(ao, 42, 7, RoleEditor)
 
 			if token == "" {
 				assert.Error(t, err, "error must not be nil when token is empty")
 			}
 			if err == nil {
 				assert.NotEmpty(t, token, "token must not be empty when error is nil")
-				workspaceID, verifyErr := VerifyToken(ao, token)
-				assert.NoError(t, verifyErr)
-				assert.Equal(t, int64(42), workspaceID)
+				claims, verifyErr := VerifyToken(ao, token)
+				require.NoError(t, verifyErr)
+				assert.Equal(t, int64(42), claims.WorkspaceID)
+				assert.Equal(t, int64(7), claims.UserID)
+				assert.Equal(t, RoleEditor, claims.Role)
 			}
 		})
 	}
 }
 
+func TestIsAuthenticated_UserID(t *testing.T) {
+	ao := AuthOptions{SecretKey: []byte("secret-key")}
+
+	for _, userID := range []int64{0, 5} {
+		token, err := CreateToken(ao, 123, userID, RoleViewer)
+		require.NoError(t, err)
+
+		req := httptest.NewRequestWithContext(context.Background(), http.MethodGet, "/", http.NoBody)
+		req.Header.Set("Authorization", "Bearer "+token)
+		rec := httptest.NewRecorder()
+
+		next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			uid, ok := UserIDFromCtx(r.Context())
+			assert.True(t, ok)
+			assert.Equal(t, userID, uid)
+			w.WriteHeader(http.StatusOK)
+		})
+
+		IsAuthenticated(ao, ExtractBearerToken)(next).ServeHTTP(rec, req)
+		assert.Equal(t, http.StatusOK, rec.Code)
+	}
+}
+
+func TestIsAuthenticated_Revoked(t *testing.T) {
+	revoked := map[string]bool{}
+	ao := AuthOptions{
+		SecretKey: []byte("secret-key"),
+		IsRevoked: func(_ context.Context, tokenID string) (bool, error) {
+			return revoked[tokenID], nil
+		},
+	}
+
+	token, claims, err := IssueToken(ao, 123, 0, RoleAdmin)
+	require.NoError(t, err)
+
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tokenID, ok := TokenIDFromCtx(r.Context())
+		assert.True(t, ok)
+		assert.Equal(t, claims.ID, tokenID)
+		w.WriteHeader(http.StatusOK)
+	})
+
+	serve := func() int {
+		req := httptest.NewRequestWithContext(context.Background(), http.MethodGet, "/", http.NoBody)
+		req.Header.Set("Authorization", "Bearer "+token)
+		rec := httptest.NewRecorder()
+		IsAuthenticated(ao, ExtractBearerToken)(next).ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	assert.Equal(t, http.StatusOK, serve())
+
+	revoked[claims.ID] = true
+	assert.Equal(t, http.StatusUnauthorized, serve())
+}
+
+func TestIsAuthenticated_LookupRole(t *testing.T) {
+	roles := map[int64]Role{5: RoleViewer}
+	ao := AuthOptions{
+		SecretKey: []byte("secret-key"),
+		LookupRole: func(_ context.Context, workspaceID, userID int64) (Role, error) {
+			assert.Equal(t, int64(123), workspaceID)
+			return roles[userID], nil
+		},
+	}
+
+	token, err := CreateToken(ao, 123, 5, RoleEditor)
+	require.NoError(t, err)
+
+	serve := func() (int, Role) {
+		var role Role
+		next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			role, _ = RoleFromCtx(r.Context())
+			w.WriteHeader(http.StatusOK)
+		})
+
+		req := httptest.NewRequestWithContext(context.Background(), http.MethodGet, "/", http.NoBody)
+		req.Header.Set("Authorization", "Bearer "+token)
+		rec := httptest.NewRecorder()
+		IsAuthenticated(ao, ExtractBearerToken)(next).ServeHTTP(rec, req)
+		return rec.Code, role
+	}
+
+	code, role := serve()
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, RoleViewer, role)
+
+	roles[5] = RoleAdmin
+	code, role = serve()
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, RoleEditor, role)
+
+	delete(roles, 5)
+	code, _ = serve()
+	assert.Equal(t, http.StatusUnauthorized, code)
+}
+
 func TestWorkspaceIDFromCtx(t *testing.T) {
 	expectedWorkspaceID := int64(10)
 	ctx := context.WithValue(context.Background(), AuthWorkspaceID, int64(10))
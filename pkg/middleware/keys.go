@@ -0,0 +1,319 @@
+package middleware
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// SigningKey signs and verifies the tokens
+type SigningKey struct {
+	// ID is sent as the "kid" header of the tokens signed by the key
+	ID     string
+	Method jwt.SigningMethod
+	// Key is the []byte secret for HS256, the *rsa.PrivateKey for RS256 or the
+	// ed25519.PrivateKey for EdDSA
+	Key any
+	// VerifyUntil retires the key: it doesn't sign anymore, but still
+	// verifies the tokens it signed until then. Zero for the active keys.
+	VerifyUntil time.Time
+}
+
+func (k SigningKey) isActive() bool {
+	return k.VerifyUntil.IsZero()
+}
+
+func (k SigningKey) verifies(now time.Time) bool {
+	return k.isActive() || now.Before(k.VerifyUntil)
+}
+
+// verificationKey returns the key checking the signatures, the public one of
+// the asymmetric keys
+func (k SigningKey) verificationKey() any {
+	switch key := k.Key.(type) {
+	case *rsa.PrivateKey:
+		return &key.PublicKey
+	case ed25519.PrivateKey:
+		return key.Public()
+	default:
+		return key
+	}
+}
+
+// NewHMACKey returns an HS256 key, identified by a digest of the secret
+func NewHMACKey(secret []byte) SigningKey {
+	sum := sha256.Sum256(secret)
+	return SigningKey{
+		ID:     "hs-" + base64.RawURLEncoding.EncodeToString(sum[:9]),
+		Method: jwt.SigningMethodHS256,
+		Key:    secret,
+	}
+}
+
+// ParsePrivateKey parses a PEM encoded RSA or Ed25519 private key, identified
+// by its JWK thumbprint (RFC 7638)
+func ParsePrivateKey(pemBytes []byte) (SigningKey, error) {
+	block, _ := pem.Decode(pemBytes)
+	if block == nil {
+		return SigningKey{}, errors.New("no PEM block found")
+	}
+
+	var key any
+	var err error
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		key, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	default:
+		key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	}
+	if err != nil {
+		return SigningKey{}, fmt.Errorf("parsing private key: %w", err)
+	}
+
+	var method jwt.SigningMethod
+	switch key.(type) {
+	case *rsa.PrivateKey:
+		method = jwt.SigningMethodRS256
+	case ed25519.PrivateKey:
+		method = jwt.SigningMethodEdDSA
+	default:
+		return SigningKey{}, fmt.Errorf("unsupported private key %T, use RSA or Ed25519", key)
+	}
+
+	signingKey := SigningKey{Method: method, Key: key}
+	jwk, _ := signingKey.jwk()
+	thumbprint, err := jwk.thumbprint()
+	if err != nil {
+		return SigningKey{}, err
+	}
+	signingKey.ID = thumbprint
+
+	return signingKey, nil
+}
+
+// KeySet holds the keys of the tokens: the first active key signs the new
+// tokens, every active key and the retired ones still in their grace period
+// verify them.
+type KeySet struct {
+	keys []SigningKey
+}
+
+func NewKeySet(keys ...SigningKey) (*KeySet, error) {
+	hasActive := false
+	for _, key := range keys {
+		if key.ID == "" {
+			return nil, errors.New("signing keys must have an ID")
+		}
+
+		switch key.Key.(type) {
+		case []byte:
+			if key.Method != jwt.SigningMethodHS256 {
+				return nil, fmt.Errorf("key %s: secrets can only be used with HS256", key.ID)
+			}
+		case *rsa.PrivateKey:
+			if key.Method != jwt.SigningMethodRS256 {
+				return nil, fmt.Errorf("key %s: RSA keys can only be used with RS256", key.ID)
+			}
+		case ed25519.PrivateKey:
+			if key.Method != jwt.SigningMethodEdDSA {
+				return nil, fmt.Errorf("key %s: Ed25519 keys can only be used with EdDSA", key.ID)
+			}
+		default:
+			return nil, fmt.Errorf("key %s: unsupported key %T", key.ID, key.Key)
+		}
+
+		hasActive = hasActive || key.isActive()
+	}
+
+	if !hasActive {
+		return nil, errors.New("at least one active signing key is needed")
+	}
+
+	return &KeySet{keys: keys}, nil
+}
+
+func (ks *KeySet) signingKey() SigningKey {
+	for _, key := range ks.keys {
+		if key.isActive() {
+			return key
+		}
+	}
+	// unreachable, NewKeySet requires an active key
+	return SigningKey{}
+}
+
+// methods returns the algorithms of the keys, the only ones accepted
+func (ks *KeySet) methods() []string {
+	var methods []string
+	seen := map[string]bool{}
+	for _, key := range ks.keys {
+		alg := key.Method.Alg()
+		if !seen[alg] {
+			seen[alg] = true
+			methods = append(methods, alg)
+		}
+	}
+	return methods
+}
+
+// keyFunc picks the key by the "kid" of the token. Tokens issued before kids
+// were introduced are checked against every key of their algorithm.
+func (ks *KeySet) keyFunc(token *jwt.Token) (any, error) {
+	kid, _ := token.Header["kid"].(string)
+	now := time.Now()
+
+	var candidates jwt.VerificationKeySet
+	for _, key := range ks.keys {
+		if !key.verifies(now) || key.Method.Alg() != token.Method.Alg() {
+			continue
+		}
+		if kid != "" && key.ID != kid {
+			continue
+		}
+		candidates.Keys = append(candidates.Keys, key.verificationKey())
+	}
+
+	if len(candidates.Keys) == 0 {
+		return nil, fmt.Errorf("unknown signing key %q", kid)
+	}
+
+	return candidates, nil
+}
+
+// JWK is the public part of a signing key, as published in the JWKS
+type JWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	// RSA
+	N string `json:"n,omitempty"`
+	E string `json:"e,omitempty"`
+	// Ed25519 and EC
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+	Y   string `json:"y,omitempty"`
+}
+
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+// JWKS returns the public keys verifying the tokens, the HS256 secrets can't
+// be shared and aren't published
+func (ks *KeySet) JWKS() JWKS {
+	jwks := JWKS{Keys: []JWK{}}
+	now := time.Now()
+	for _, key := range ks.keys {
+		if !key.verifies(now) {
+			continue
+		}
+		if jwk, ok := key.jwk(); ok {
+			jwks.Keys = append(jwks.Keys, jwk)
+		}
+	}
+	return jwks
+}
+
+func (k SigningKey) jwk() (JWK, bool) {
+	jwk := JWK{Kid: k.ID, Use: "sig", Alg: k.Method.Alg()}
+
+	switch key := k.Key.(type) {
+	case *rsa.PrivateKey:
+		jwk.Kty = "RSA"
+		jwk.N = base64.RawURLEncoding.EncodeToString(key.N.Bytes())
+		jwk.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes())
+	case ed25519.PrivateKey:
+		jwk.Kty = "OKP"
+		jwk.Crv = "Ed25519"
+		jwk.X = base64.RawURLEncoding.EncodeToString(key.Public().(ed25519.PublicKey))
+	default:
+		return JWK{}, false
+	}
+
+	return jwk, true
+}
+
+// PublicKey returns the key verifying the tokens signed with the JWK, e.g. the
+// ones of an identity provider
+func (j JWK) PublicKey() (any, error) {
+	decode := func(value string) (*big.Int, error) {
+		raw, err := base64.RawURLEncoding.DecodeString(value)
+		if err != nil || len(raw) == 0 {
+			return nil, fmt.Errorf("invalid key %s", j.Kid)
+		}
+		return new(big.Int).SetBytes(raw), nil
+	}
+
+	switch {
+	case j.Kty == "RSA":
+		n, err := decode(j.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decode(j.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case j.Kty == "EC" && j.Crv == "P-256":
+		x, err := decode(j.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decode(j.Y)
+		if err != nil {
+			return nil, err
+		}
+		return &ecdsa.PublicKey{Curve: elliptic.P256(), X: x, Y: y}, nil
+	case j.Kty == "OKP" && j.Crv == "Ed25519":
+		x, err := base64.RawURLEncoding.DecodeString(j.X)
+		if err != nil || len(x) != ed25519.PublicKeySize {
+			return nil, fmt.Errorf("invalid key %s", j.Kid)
+		}
+		return ed25519.PublicKey(x), nil
+	default:
+		return nil, fmt.Errorf("unsupported key type %q", j.Kty)
+	}
+}
+
+// thumbprint hashes the required members of the key in lexicographic order
+func (j JWK) thumbprint() (string, error) {
+	var members any
+	switch j.Kty {
+	case "RSA":
+		members = struct {
+			E   string `json:"e"`
+			Kty string `json:"kty"`
+			N   string `json:"n"`
+		}{j.E, j.Kty, j.N}
+	case "OKP":
+		members = struct {
+			Crv string `json:"crv"`
+			Kty string `json:"kty"`
+			X   string `json:"x"`
+		}{j.Crv, j.Kty, j.X}
+	default:
+		return "", fmt.Errorf("unsupported key type %q", j.Kty)
+	}
+
+	canonical, err := json.Marshal(members)
+	if err != nil {
+		return "", err
+	}
+
+	sum := sha256.Sum256(canonical)
+	return base64.RawURLEncoding.EncodeToString(sum[:]), nil
+}
@@ -0,0 +1,196 @@
+package middleware
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newRSAKey(t *testing.T) SigningKey {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	pemBytes := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+	signingKey, err := ParsePrivateKey(pemBytes)
+	require.NoError(t, err)
+	return signingKey
+}
+
+func newEd25519Key(t *testing.T) SigningKey {
+	t.Helper()
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	require.NoError(t, err)
+	signingKey, err := ParsePrivateKey(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}))
+	require.NoError(t, err)
+	return signingKey
+}
+
+func tokenKid(t *testing.T, token string) string {
+	t.Helper()
+	parsed, _, err := jwt.NewParser().ParseUnverified(token, &CustomClaims{})
+	require.NoError(t, err)
+	kid, _ := parsed.Header["kid"].(string)
+	return kid
+}
+
+func TestKeySet_SignAndVerify(t *testing.T) {
+	tests := []struct {
+		name string
+		key  SigningKey
+		alg  string
+	}{
+		{"HS256", NewHMACKey([]byte("secret")), "HS256"},
+		{"RS256", newRSAKey(t), "RS256"},
+		{"EdDSA", newEd25519Key(t), "EdDSA"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			keys, err := NewKeySet(tt.key)
+			require.NoError(t, err)
+			ao := AuthOptions{Keys: keys}
+
+			token, err := CreateToken(ao, 10, 0, RoleAdmin)
+			require.NoError(t, err)
+
+			parsed, _, err := jwt.NewParser().ParseUnverified(token, &CustomClaims{})
+			require.NoError(t, err)
+			assert.Equal(t, tt.alg, parsed.Method.Alg())
+			assert.Equal(t, tt.key.ID, parsed.Header["kid"])
+
+			claims, err := VerifyToken(ao, token)
+			require.NoError(t, err)
+			assert.Equal(t, int64(10), claims.WorkspaceID)
+		})
+	}
+}
+
+func TestKeySet_Rotation(t *testing.T) {
+	oldKey := newRSAKey(t)
+	newKey := newEd25519Key(t)
+
+	oldKeys, err := NewKeySet(oldKey)
+	require.NoError(t, err)
+	oldToken, err := CreateToken(AuthOptions{Keys: oldKeys}, 10, 0, RoleAdmin)
+	require.NoError(t, err)
+
+	t.Run("retired key verifies during the grace period", func(t *testing.T) {
+		retired := oldKey
+		retired.VerifyUntil = time.Now().Add(time.Hour)
+		keys, err := NewKeySet(newKey, retired)
+		require.NoError(t, err)
+		ao := AuthOptions{Keys: keys}
+
+		_, err = VerifyToken(ao, oldToken)
+		require.NoError(t, err)
+
+		token, err := CreateToken(ao, 10, 0, RoleAdmin)
+		require.NoError(t, err)
+		assert.Equal(t, newKey.ID, tokenKid(t, token))
+	})
+
+	t.Run("retired key doesn't verify after the grace period", func(t *testing.T) {
+		retired := oldKey
+		retired.VerifyUntil = time.Now().Add(-time.Second)
+		keys, err := NewKeySet(newKey, retired)
+		require.NoError(t, err)
+
+		_, err = VerifyToken(AuthOptions{Keys: keys}, oldToken)
+		assert.Error(t, err)
+	})
+
+	t.Run("every active key verifies", func(t *testing.T) {
+		keys, err := NewKeySet(newKey, oldKey)
+		require.NoError(t, err)
+
+		_, err = VerifyToken(AuthOptions{Keys: keys}, oldToken)
+		require.NoError(t, err)
+	})
+
+	t.Run("unknown key", func(t *testing.T) {
+		keys, err := NewKeySet(newKey)
+		require.NoError(t, err)
+
+		_, err = VerifyToken(AuthOptions{Keys: keys}, oldToken)
+		assert.Error(t, err)
+	})
+}
+
+func TestKeySet_TokensWithoutKid(t *testing.T) {
+	secret := []byte("secret")
+	legacyToken, err := CreateToken(AuthOptions{SecretKey: secret}, 10, 0, RoleAdmin)
+	require.NoError(t, err)
+	assert.Empty(t, tokenKid(t, legacyToken))
+
+	keys, err := NewKeySet(newRSAKey(t), NewHMACKey(secret))
+	require.NoError(t, err)
+
+	_, err = VerifyToken(AuthOptions{Keys: keys}, legacyToken)
+	require.NoError(t, err)
+
+	otherKeys, err := NewKeySet(newRSAKey(t), NewHMACKey([]byte("other")))
+	require.NoError(t, err)
+
+	_, err = VerifyToken(AuthOptions{Keys: otherKeys}, legacyToken)
+	assert.Error(t, err)
+}
+
+func TestNewKeySet_Invalid(t *testing.T) {
+	retired := NewHMACKey([]byte("secret"))
+	retired.VerifyUntil = time.Now().Add(time.Hour)
+	_, err := NewKeySet(retired)
+	assert.Error(t, err)
+
+	_, err = NewKeySet()
+	assert.Error(t, err)
+
+	wrongMethod := newRSAKey(t)
+	wrongMethod.Method = jwt.SigningMethodHS256
+	_, err = NewKeySet(wrongMethod)
+	assert.Error(t, err)
+
+	_, err = ParsePrivateKey([]byte("not a pem"))
+	assert.Error(t, err)
+}
+
+func TestKeySet_JWKS(t *testing.T) {
+	rsaKey := newRSAKey(t)
+	edKey := newEd25519Key(t)
+	expired := newRSAKey(t)
+	expired.VerifyUntil = time.Now().Add(-time.Second)
+
+	keys, err := NewKeySet(rsaKey, edKey, NewHMACKey([]byte("secret")), expired)
+	require.NoError(t, err)
+
+	jwks := keys.JWKS()
+	require.Len(t, jwks.Keys, 2)
+	assert.Equal(t, "RSA", jwks.Keys[0].Kty)
+	assert.Equal(t, rsaKey.ID, jwks.Keys[0].Kid)
+	assert.Equal(t, "RS256", jwks.Keys[0].Alg)
+	assert.Equal(t, "OKP", jwks.Keys[1].Kty)
+	assert.Equal(t, "Ed25519", jwks.Keys[1].Crv)
+	assert.Equal(t, edKey.ID, jwks.Keys[1].Kid)
+
+	// a token can be checked only with the published key
+	token, err := CreateToken(AuthOptions{Keys: keys}, 10, 0, RoleAdmin)
+	require.NoError(t, err)
+
+	publicKey, err := jwks.Keys[0].PublicKey()
+	require.NoError(t, err)
+	assert.True(t, rsaKey.Key.(*rsa.PrivateKey).PublicKey.Equal(publicKey))
+
+	_, err = jwt.Parse(token, func(*jwt.Token) (any, error) { return publicKey, nil })
+	require.NoError(t, err)
+}
@@ -0,0 +1,62 @@
+package middleware
+
+import (
+	"fmt"
+	"net/http"
+)
+
+// Role is what a member is allowed to do in a workspace, each role includes
+// the permissions of the previous one.
+type Role string
+
+const (
+	// RoleViewer can read files and receive changes
+	RoleViewer Role = "viewer"
+	// RoleEditor can also create, edit, rename and delete files
+	RoleEditor Role = "editor"
+	// RoleAdmin can also manage the workspace
+	RoleAdmin Role = "admin"
+)
+
+var roleRanks = map[Role]int{
+	RoleViewer: 1,
+	RoleEditor: 2,
+	RoleAdmin:  3,
+}
+
+func ParseRole(role string) (Role, error) {
+	if _, ok := roleRanks[Role(role)]; !ok {
+		return "", fmt.Errorf("invalid role %q", role)
+	}
+	return Role(role), nil
+}
+
+// Includes reports whether the role grants the permissions of required
+func (r Role) Includes(required Role) bool {
+	rank, ok := roleRanks[r]
+	return ok && rank >= roleRanks[required]
+}
+
+// Lower returns the role granting the fewest permissions of the two
+func (r Role) Lower(other Role) Role {
+	if r.Includes(other) {
+		return other
+	}
+	return r
+}
+
+// RequireRole rejects the requests of the members without the given role, it
+// must follow IsAuthenticated.
+func RequireRole(required Role) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			role, _ := RoleFromCtx(r.Context())
+			if !role.Includes(required) {
+				http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
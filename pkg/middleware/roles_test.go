@@ -0,0 +1,50 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRequireRole(t *testing.T) {
+	tests := []struct {
+		role     Role
+		required Role
+		want     int
+	}{
+		{RoleViewer, RoleViewer, http.StatusOK},
+		{RoleViewer, RoleEditor, http.StatusForbidden},
+		{RoleEditor, RoleEditor, http.StatusOK},
+		{RoleAdmin, RoleEditor, http.StatusOK},
+		{RoleEditor, RoleAdmin, http.StatusForbidden},
+		{Role(""), RoleViewer, http.StatusForbidden},
+	}
+
+	next := http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+
+	for _, tt := range tests {
+		t.Run(string(tt.role)+" requiring "+string(tt.required), func(t *testing.T) {
+			ctx := context.WithValue(context.Background(), AuthRole, tt.role)
+			req := httptest.NewRequestWithContext(ctx, http.MethodGet, "/", http.NoBody)
+			rec := httptest.NewRecorder()
+
+			RequireRole(tt.required)(next).ServeHTTP(rec, req)
+
+			assert.Equal(t, tt.want, rec.Code)
+		})
+	}
+}
+
+func TestParseRole(t *testing.T) {
+	role, err := ParseRole("editor")
+	assert.NoError(t, err)
+	assert.Equal(t, RoleEditor, role)
+
+	_, err = ParseRole("owner")
+	assert.Error(t, err)
+}
@@ -2,6 +2,10 @@ package mimeutils
 
 import "strings"
 
+// Encrypted is the MIME type given to the files of end-to-end encrypted
+// workspaces, whose content is opaque to the server.
+const Encrypted = "application/octet-stream"
+
 func IsText(mimeType string) bool {
 	return strings.HasPrefix(mimeType, "text/")
 }
@@ -0,0 +1,405 @@
+package syncinator
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/hiimjako/syncinator/internal/repository"
+	"github.com/hiimjako/syncinator/pkg/middleware"
+)
+
+// OIDCOptions configures the login with an OpenID Connect provider, through
+// the authorization code flow.
+type OIDCOptions struct {
+	// Issuer is the URL of the provider, its configuration is discovered at
+	// Issuer/.well-known/openid-configuration
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	// RedirectURL is the PathOIDCCallback of the server, as registered in the
+	// provider
+	RedirectURL string
+	// UsernameClaim maps the identity to the name of a user, "email" by default
+	UsernameClaim string
+	HTTPClient    *http.Client
+}
+
+const (
+	PathOIDCLogin    = PathHTTPAuth + "/oidc/login"
+	PathOIDCCallback = PathHTTPAuth + "/oidc/callback"
+)
+
+const (
+	ErrInvalidOIDCState = "invalid or expired login state"
+	ErrMissingWorkspace = "missing workspace"
+)
+
+// oidcLoginTTL is how long the user has to log in on the provider
+const oidcLoginTTL = 10 * time.Minute
+
+// oidcLogin is a login started on the provider, identified by its state
+type oidcLogin struct {
+	workspace string
+	nonce     string
+	verifier  string
+	expiresAt time.Time
+}
+
+type oidcDiscovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+type oidcProvider struct {
+	opts OIDCOptions
+
+	mu        sync.Mutex
+	discovery *oidcDiscovery
+	keys      map[string]any
+	logins    map[string]oidcLogin
+}
+
+func newOIDCProvider(opts OIDCOptions) *oidcProvider {
+	if opts.UsernameClaim == "" {
+		opts.UsernameClaim = "email"
+	}
+	if opts.HTTPClient == nil {
+		opts.HTTPClient = &http.Client{Timeout: 10 * time.Second}
+	}
+	opts.Issuer = strings.TrimSuffix(opts.Issuer, "/")
+
+	return &oidcProvider{
+		opts:   opts,
+		keys:   make(map[string]any),
+		logins: make(map[string]oidcLogin),
+	}
+}
+
+// oidcLoginHandler redirects to the provider, the workspace to log in is
+// given by the "workspace" query parameter.
+func (s *syncinator) oidcLoginHandler(w http.ResponseWriter, r *http.Request) {
+	workspace := r.URL.Query().Get("workspace")
+	if workspace == "" {
+		http.Error(w, ErrMissingWorkspace, http.StatusBadRequest)
+		return
+	}
+
+	discovery, err := s.oidc.discover(r.Context())
+	if err != nil {
+		log.Printf("error discovering oidc provider: %v", err)
+		http.Error(w, http.StatusText(http.StatusBadGateway), http.StatusBadGateway)
+		return
+	}
+
+	state, login, err := s.oidc.startLogin(workspace)
+	if err != nil {
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	challenge := sha256.Sum256([]byte(login.verifier))
+	query := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {s.oidc.opts.ClientID},
+		"redirect_uri":          {s.oidc.opts.RedirectURL},
+		"scope":                 {"openid profile email"},
+		"state":                 {state},
+		"nonce":                 {login.nonce},
+		"code_challenge":        {base64.RawURLEncoding.EncodeToString(challenge[:])},
+		"code_challenge_method": {"S256"},
+	}
+
+	authURL, err := url.Parse(discovery.AuthorizationEndpoint)
+	if err != nil {
+		log.Printf("error parsing oidc authorization endpoint: %v", err)
+		http.Error(w, http.StatusText(http.StatusBadGateway), http.StatusBadGateway)
+		return
+	}
+	authQuery := authURL.Query()
+	for key, values := range query {
+		authQuery[key] = values
+	}
+	authURL.RawQuery = authQuery.Encode()
+
+	http.Redirect(w, r, authURL.String(), http.StatusFound)
+}
+
+// oidcCallbackHandler exchanges the code for the identity of the user, who
+// must be a member of the workspace, and logs in like the password login.
+func (s *syncinator) oidcCallbackHandler(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	login, ok := s.oidc.finishLogin(query.Get("state"))
+	if !ok {
+		http.Error(w, ErrInvalidOIDCState, http.StatusBadRequest)
+		return
+	}
+
+	if query.Get("error") != "" || query.Get("code") == "" {
+		http.Error(w, ErrInvalidCredentials, http.StatusUnauthorized)
+		return
+	}
+
+	username, err := s.oidc.exchange(r.Context(), query.Get("code"), login)
+	if err != nil {
+		log.Printf("error verifying oidc identity: %v", err)
+		http.Error(w, ErrInvalidCredentials, http.StatusUnauthorized)
+		return
+	}
+
+	user, err := s.db.FetchUser(r.Context(), username)
+	if err != nil {
+		s.auditFailedLogin(r, WorkspaceCredentials{Name: login.workspace, User: username})
+		http.Error(w, ErrInvalidCredentials, http.StatusUnauthorized)
+		return
+	}
+
+	workspace, err := s.db.FetchMemberWorkspace(r.Context(), repository.FetchMemberWorkspaceParams{
+		Name:   login.workspace,
+		UserID: user.ID,
+	})
+	if err != nil {
+		s.auditFailedLogin(r, WorkspaceCredentials{Name: login.workspace, User: username})
+		http.Error(w, ErrInvalidCredentials, http.StatusUnauthorized)
+		return
+	}
+
+	s.audit(r.Context(), auditEvent{
+		workspaceID: workspace.ID,
+		actor:       loginActor(user.ID),
+		action:      AuditLogin,
+		details:     "oidc",
+		ip:          clientIP(r),
+	})
+
+	s.writeLoginResponse(w, r, workspace, user.ID)
+}
+
+func (p *oidcProvider) startLogin(workspace string) (string, oidcLogin, error) {
+	state, err := randomToken()
+	if err != nil {
+		return "", oidcLogin{}, err
+	}
+	nonce, err := randomToken()
+	if err != nil {
+		return "", oidcLogin{}, err
+	}
+	verifier, err := randomToken()
+	if err != nil {
+		return "", oidcLogin{}, err
+	}
+
+	login := oidcLogin{
+		workspace: workspace,
+		nonce:     nonce,
+		verifier:  verifier,
+		expiresAt: time.Now().Add(oidcLoginTTL),
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	// the logins never completed would pile up otherwise
+	for key, pending := range p.logins {
+		if time.Now().After(pending.expiresAt) {
+			delete(p.logins, key)
+		}
+	}
+	p.logins[state] = login
+
+	return state, login, nil
+}
+
+// finishLogin returns the login of the state, which can be used only once
+func (p *oidcProvider) finishLogin(state string) (oidcLogin, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	login, ok := p.logins[state]
+	if !ok {
+		return oidcLogin{}, false
+	}
+	delete(p.logins, state)
+
+	return login, time.Now().Before(login.expiresAt)
+}
+
+// discover fetches the configuration of the provider, once it succeeds it
+// is kept for the lifetime of the server.
+func (p *oidcProvider) discover(ctx context.Context) (*oidcDiscovery, error) {
+	p.mu.Lock()
+	discovery := p.discovery
+	p.mu.Unlock()
+	if discovery != nil {
+		return discovery, nil
+	}
+
+	discovery = &oidcDiscovery{}
+	if err := p.getJSON(ctx, p.opts.Issuer+"/.well-known/openid-configuration", discovery); err != nil {
+		return nil, fmt.Errorf("fetching configuration: %w", err)
+	}
+	if discovery.Issuer != p.opts.Issuer {
+		return nil, fmt.Errorf("issuer %q doesn't match the configured one", discovery.Issuer)
+	}
+
+	p.mu.Lock()
+	p.discovery = discovery
+	p.mu.Unlock()
+
+	return discovery, nil
+}
+
+// exchange trades the code for the ID token, returning the username of the
+// verified identity
+func (p *oidcProvider) exchange(ctx context.Context, code string, login oidcLogin) (string, error) {
+	discovery, err := p.discover(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {p.opts.RedirectURL},
+		"code_verifier": {login.verifier},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, discovery.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", fmt.Errorf("creating token request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth(url.QueryEscape(p.opts.ClientID), url.QueryEscape(p.opts.ClientSecret))
+
+	var tokens struct {
+		IDToken string `json:"id_token"`
+	}
+	if err := p.doJSON(req, &tokens); err != nil {
+		return "", fmt.Errorf("exchanging code: %w", err)
+	}
+
+	claims := jwt.MapClaims{}
+	_, err = jwt.ParseWithClaims(
+		tokens.IDToken,
+		claims,
+		func(token *jwt.Token) (any, error) {
+			kid, _ := token.Header["kid"].(string)
+			return p.verificationKey(ctx, discovery, kid)
+		},
+		jwt.WithValidMethods([]string{"RS256", "ES256", "EdDSA"}),
+		jwt.WithIssuer(p.opts.Issuer),
+		jwt.WithAudience(p.opts.ClientID),
+		jwt.WithExpirationRequired(),
+		jwt.WithLeeway(time.Minute),
+	)
+	if err != nil {
+		return "", fmt.Errorf("verifying id token: %w", err)
+	}
+
+	if nonce, _ := claims["nonce"].(string); nonce != login.nonce {
+		return "", errors.New("id token nonce doesn't match")
+	}
+
+	// an unverified email could be set by anyone to the one of a member
+	if p.opts.UsernameClaim == "email" {
+		if verified, _ := claims["email_verified"].(bool); !verified {
+			return "", errors.New("email not verified")
+		}
+	}
+
+	username, _ := claims[p.opts.UsernameClaim].(string)
+	if username == "" {
+		return "", fmt.Errorf("missing %q claim", p.opts.UsernameClaim)
+	}
+
+	return username, nil
+}
+
+// verificationKey returns the key of the provider with the given kid, the
+// keys are fetched again when it's unknown since the provider rotated them.
+func (p *oidcProvider) verificationKey(ctx context.Context, discovery *oidcDiscovery, kid string) (any, error) {
+	p.mu.Lock()
+	key, ok := p.keys[kid]
+	p.mu.Unlock()
+	if ok {
+		return key, nil
+	}
+
+	var jwks middleware.JWKS
+	if err := p.getJSON(ctx, discovery.JWKSURI, &jwks); err != nil {
+		return nil, fmt.Errorf("fetching keys: %w", err)
+	}
+
+	keys := make(map[string]any, len(jwks.Keys))
+	for _, jwk := range jwks.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+		publicKey, err := jwk.PublicKey()
+		if err != nil {
+			log.Printf("skipping oidc key %s: %v", jwk.Kid, err)
+			continue
+		}
+		keys[jwk.Kid] = publicKey
+	}
+
+	p.mu.Lock()
+	p.keys = keys
+	p.mu.Unlock()
+
+	key, ok = keys[kid]
+	if !ok {
+		return nil, fmt.Errorf("unknown key %q", kid)
+	}
+
+	return key, nil
+}
+
+func (p *oidcProvider) getJSON(ctx context.Context, url string, v any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, http.NoBody)
+	if err != nil {
+		return fmt.Errorf("creating request: %w", err)
+	}
+	return p.doJSON(req, v)
+}
+
+func (p *oidcProvider) doJSON(req *http.Request, v any) error {
+	req.Header.Set("Accept", "application/json")
+	res, err := p.opts.HTTPClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(res.Body, maxBodySize))
+	if err != nil {
+		return fmt.Errorf("reading response: %w", err)
+	}
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status %d: %s", res.StatusCode, body)
+	}
+
+	return json.Unmarshal(body, v)
+}
+
+func randomToken() (string, error) {
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return "", fmt.Errorf("generating random token: %w", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(raw), nil
+}
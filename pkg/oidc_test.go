@@ -0,0 +1,333 @@
+package syncinator
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/hiimjako/syncinator/internal/migration"
+	"github.com/hiimjako/syncinator/internal/repository"
+	"github.com/hiimjako/syncinator/pkg/filestorage"
+	"github.com/hiimjako/syncinator/pkg/middleware"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	oidcClientID     = "syncinator"
+	oidcClientSecret = "client-secret"
+	oidcRedirectURL  = "https://syncinator.test" + PathOIDCCallback
+)
+
+// fakeOIDCProvider is an identity provider logging in any user it is told to,
+// it checks the requests of the authorization code flow like a real one.
+type fakeOIDCProvider struct {
+	server *httptest.Server
+	key    middleware.SigningKey
+	keys   *middleware.KeySet
+
+	mu sync.Mutex
+	// claims of the identity logged in on the next authorization
+	claims jwt.MapClaims
+	codes  map[string]fakeOIDCCode
+}
+
+type fakeOIDCCode struct {
+	nonce     string
+	challenge string
+	claims    jwt.MapClaims
+}
+
+func newFakeOIDCProvider(t *testing.T) *fakeOIDCProvider {
+	t.Helper()
+
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	key, err := middleware.ParsePrivateKey(pem.EncodeToMemory(&pem.Block{
+		Type:  "RSA PRIVATE KEY",
+		Bytes: x509.MarshalPKCS1PrivateKey(rsaKey),
+	}))
+	require.NoError(t, err)
+	keys, err := middleware.NewKeySet(key)
+	require.NoError(t, err)
+
+	p := &fakeOIDCProvider{key: key, keys: keys, codes: make(map[string]fakeOIDCCode)}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, oidcDiscovery{
+			Issuer:                p.server.URL,
+			AuthorizationEndpoint: p.server.URL + "/authorize",
+			TokenEndpoint:         p.server.URL + "/token",
+			JWKSURI:               p.server.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("GET /jwks", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, p.keys.JWKS())
+	})
+	mux.HandleFunc("GET /authorize", p.authorize)
+	mux.HandleFunc("POST /token", p.token)
+
+	p.server = httptest.NewServer(mux)
+	t.Cleanup(p.server.Close)
+
+	return p
+}
+
+func (p *fakeOIDCProvider) authorize(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	if query.Get("client_id") != oidcClientID ||
+		query.Get("redirect_uri") != oidcRedirectURL ||
+		query.Get("response_type") != "code" ||
+		query.Get("code_challenge_method") != "S256" {
+		http.Error(w, "invalid request", http.StatusBadRequest)
+		return
+	}
+
+	code := rand.Text()
+	p.mu.Lock()
+	p.codes[code] = fakeOIDCCode{
+		nonce:     query.Get("nonce"),
+		challenge: query.Get("code_challenge"),
+		claims:    p.claims,
+	}
+	p.mu.Unlock()
+
+	redirect := query.Get("redirect_uri") + "?" + url.Values{
+		"code":  {code},
+		"state": {query.Get("state")},
+	}.Encode()
+	http.Redirect(w, r, redirect, http.StatusFound)
+}
+
+func (p *fakeOIDCProvider) token(w http.ResponseWriter, r *http.Request) {
+	clientID, clientSecret, ok := r.BasicAuth()
+	if !ok || clientID != oidcClientID || clientSecret != oidcClientSecret {
+		http.Error(w, "invalid client", http.StatusUnauthorized)
+		return
+	}
+
+	p.mu.Lock()
+	code, ok := p.codes[r.PostFormValue("code")]
+	delete(p.codes, r.PostFormValue("code"))
+	p.mu.Unlock()
+
+	challenge := sha256.Sum256([]byte(r.PostFormValue("code_verifier")))
+	if !ok ||
+		r.PostFormValue("grant_type") != "authorization_code" ||
+		r.PostFormValue("redirect_uri") != oidcRedirectURL ||
+		base64.RawURLEncoding.EncodeToString(challenge[:]) != code.challenge {
+		http.Error(w, "invalid grant", http.StatusBadRequest)
+		return
+	}
+
+	claims := jwt.MapClaims{
+		"iss":   p.server.URL,
+		"aud":   oidcClientID,
+		"sub":   "subject",
+		"nonce": code.nonce,
+		"iat":   time.Now().Unix(),
+		"exp":   time.Now().Add(time.Minute).Unix(),
+	}
+	for name, value := range code.claims {
+		claims[name] = value
+	}
+
+	idToken := jwt.NewWithClaims(p.key.Method, claims)
+	idToken.Header["kid"] = p.key.ID
+	signed, err := idToken.SignedString(p.key.Key)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]string{
+		"access_token": "access-token",
+		"token_type":   "Bearer",
+		"id_token":     signed,
+	})
+}
+
+// login goes through the flow, returning the response of the callback
+func (p *fakeOIDCProvider) login(t *testing.T, server http.Handler, workspace string, claims jwt.MapClaims) *httptest.ResponseRecorder {
+	t.Helper()
+
+	p.mu.Lock()
+	p.claims = claims
+	p.mu.Unlock()
+
+	callback := p.authorizeURL(t, server, workspace)
+	return doOIDCCallback(t, server, callback)
+}
+
+// authorizeURL starts the login, returning the callback the provider
+// redirects to
+func (p *fakeOIDCProvider) authorizeURL(t *testing.T, server http.Handler, workspace string) *url.URL {
+	t.Helper()
+
+	req := httptest.NewRequestWithContext(context.Background(), http.MethodGet, PathOIDCLogin+"?workspace="+url.QueryEscape(workspace), http.NoBody)
+	res := httptest.NewRecorder()
+	server.ServeHTTP(res, req)
+	require.Equal(t, http.StatusFound, res.Code)
+
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}}
+	authReq, err := http.NewRequestWithContext(context.Background(), http.MethodGet, res.Header().Get("Location"), http.NoBody)
+	require.NoError(t, err)
+	authRes, err := client.Do(authReq)
+	require.NoError(t, err)
+	defer authRes.Body.Close()
+	require.Equal(t, http.StatusFound, authRes.StatusCode)
+
+	callback, err := url.Parse(authRes.Header.Get("Location"))
+	require.NoError(t, err)
+	return callback
+}
+
+func doOIDCCallback(t *testing.T, server http.Handler, callback *url.URL) *httptest.ResponseRecorder {
+	t.Helper()
+
+	req := httptest.NewRequestWithContext(context.Background(), http.MethodGet, callback.RequestURI(), http.NoBody)
+	res := httptest.NewRecorder()
+	server.ServeHTTP(res, req)
+	return res
+}
+
+func Test_oidcLogin(t *testing.T) {
+	db, err := sql.Open("sqlite3", ":memory:")
+	require.NoError(t, err)
+	require.NoError(t, migration.Migrate(db))
+
+	provider := newFakeOIDCProvider(t)
+	server := New(db, new(filestorage.MockFileStorage), Options{
+		JWTSecret: []byte("secret"),
+		OIDC: &OIDCOptions{
+			Issuer:       provider.server.URL,
+			ClientID:     oidcClientID,
+			ClientSecret: oidcClientSecret,
+			RedirectURL:  oidcRedirectURL,
+		},
+	})
+	t.Cleanup(func() {
+		server.Close()
+		db.Close()
+	})
+
+	ctx := context.Background()
+	hash, err := bcrypt.GenerateFromPassword([]byte("password"), bcrypt.MinCost)
+	require.NoError(t, err)
+	require.NoError(t, server.db.AddWorkspace(ctx, repository.AddWorkspaceParams{Name: "workspace1", Password: string(hash)}))
+	require.NoError(t, server.db.AddWorkspace(ctx, repository.AddWorkspaceParams{Name: "workspace2", Password: string(hash)}))
+	require.NoError(t, server.db.AddUser(ctx, repository.AddUserParams{Name: "alice@example.com", Password: string(hash)}))
+	workspace, err := server.db.FetchWorkspace(ctx, "workspace1")
+	require.NoError(t, err)
+	alice, err := server.db.FetchUser(ctx, "alice@example.com")
+	require.NoError(t, err)
+	require.NoError(t, server.db.AddWorkspaceMember(ctx, repository.AddWorkspaceMemberParams{
+		WorkspaceID: workspace.ID,
+		UserID:      alice.ID,
+		Role:        string(middleware.RoleEditor),
+	}))
+
+	aliceClaims := jwt.MapClaims{"email": "alice@example.com", "email_verified": true}
+
+	t.Run("member logs in", func(t *testing.T) {
+		res := provider.login(t, server, "workspace1", aliceClaims)
+		require.Equal(t, http.StatusOK, res.Code)
+
+		var body LoginResponse
+		require.NoError(t, json.NewDecoder(res.Body).Decode(&body))
+		assert.NotEmpty(t, body.RefreshToken)
+
+		claims, err := middleware.VerifyToken(server.authOptions(), body.Token)
+		require.NoError(t, err)
+		assert.Equal(t, workspace.ID, claims.WorkspaceID)
+		assert.Equal(t, alice.ID, claims.UserID)
+		assert.Equal(t, middleware.RoleEditor, claims.Role)
+	})
+
+	t.Run("not a member of the workspace", func(t *testing.T) {
+		res := provider.login(t, server, "workspace2", aliceClaims)
+		assert.Equal(t, http.StatusUnauthorized, res.Code)
+	})
+
+	t.Run("unknown user", func(t *testing.T) {
+		res := provider.login(t, server, "workspace1", jwt.MapClaims{"email": "bob@example.com", "email_verified": true})
+		assert.Equal(t, http.StatusUnauthorized, res.Code)
+	})
+
+	t.Run("unverified email", func(t *testing.T) {
+		res := provider.login(t, server, "workspace1", jwt.MapClaims{"email": "alice@example.com", "email_verified": false})
+		assert.Equal(t, http.StatusUnauthorized, res.Code)
+	})
+
+	t.Run("id token for another client", func(t *testing.T) {
+		res := provider.login(t, server, "workspace1", jwt.MapClaims{"email": "alice@example.com", "email_verified": true, "aud": "other"})
+		assert.Equal(t, http.StatusUnauthorized, res.Code)
+	})
+
+	t.Run("id token of another login", func(t *testing.T) {
+		res := provider.login(t, server, "workspace1", jwt.MapClaims{"email": "alice@example.com", "email_verified": true, "nonce": "other"})
+		assert.Equal(t, http.StatusUnauthorized, res.Code)
+	})
+
+	t.Run("state can be used once", func(t *testing.T) {
+		provider.mu.Lock()
+		provider.claims = aliceClaims
+		provider.mu.Unlock()
+		callback := provider.authorizeURL(t, server, "workspace1")
+
+		res := doOIDCCallback(t, server, callback)
+		assert.Equal(t, http.StatusOK, res.Code)
+
+		res = doOIDCCallback(t, server, callback)
+		assert.Equal(t, http.StatusBadRequest, res.Code)
+	})
+
+	t.Run("unknown state", func(t *testing.T) {
+		callback, err := url.Parse(PathOIDCCallback + "?code=code&state=unknown")
+		require.NoError(t, err)
+
+		res := doOIDCCallback(t, server, callback)
+		assert.Equal(t, http.StatusBadRequest, res.Code)
+	})
+
+	t.Run("missing workspace", func(t *testing.T) {
+		req := httptest.NewRequestWithContext(ctx, http.MethodGet, PathOIDCLogin, http.NoBody)
+		res := httptest.NewRecorder()
+		server.ServeHTTP(res, req)
+		assert.Equal(t, http.StatusBadRequest, res.Code)
+	})
+}
+
+func Test_oidcLogin_Disabled(t *testing.T) {
+	db, err := sql.Open("sqlite3", ":memory:")
+	require.NoError(t, err)
+	require.NoError(t, migration.Migrate(db))
+
+	server := New(db, new(filestorage.MockFileStorage), Options{JWTSecret: []byte("secret")})
+	t.Cleanup(func() {
+		server.Close()
+		db.Close()
+	})
+
+	req := httptest.NewRequestWithContext(context.Background(), http.MethodGet, PathOIDCLogin+"?workspace=workspace1", http.NoBody)
+	res := httptest.NewRecorder()
+	server.ServeHTTP(res, req)
+	assert.Equal(t, http.StatusNotFound, res.Code)
+}
@@ -0,0 +1,405 @@
+package syncinator
+
+import (
+	"cmp"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/hiimjako/syncinator/internal/repository"
+	"github.com/hiimjako/syncinator/pkg/diff"
+	"github.com/hiimjako/syncinator/pkg/filestorage"
+)
+
+// RetentionTier keeps one snapshot every Every for the snapshots younger than
+// Within. A zero Every keeps all of them.
+type RetentionTier struct {
+	Within time.Duration
+	Every  time.Duration
+}
+
+// RetentionPolicy is a list of tiers sorted by Within. Snapshots older than
+// the last tier are deleted, except the latest one of each file. The files
+// deleted before the last tier lose all their history.
+type RetentionPolicy []RetentionTier
+
+// ParseRetentionPolicy parses a policy like "24h:all;168h:1h;720h:24h",
+// meaning keep all for a day, hourly for a week and daily for a month.
+// An empty string disables the retention.
+func ParseRetentionPolicy(raw string) (RetentionPolicy, error) {
+	raw = strings.TrimSpace(raw)
+	if raw == "" {
+		return nil, nil
+	}
+
+	var policy RetentionPolicy
+	for _, rawTier := range strings.Split(raw, ";") {
+		rawWithin, rawEvery, ok := strings.Cut(strings.TrimSpace(rawTier), ":")
+		if !ok {
+			return nil, fmt.Errorf("invalid retention tier %q", rawTier)
+		}
+
+		within, err := time.ParseDuration(rawWithin)
+		if err != nil || within <= 0 {
+			return nil, fmt.Errorf("invalid retention window %q", rawWithin)
+		}
+
+		var every time.Duration
+		if rawEvery != "all" {
+			every, err = time.ParseDuration(rawEvery)
+			if err != nil || every <= 0 {
+				return nil, fmt.Errorf("invalid retention interval %q", rawEvery)
+			}
+		}
+
+		if len(policy) > 0 && within <= policy[len(policy)-1].Within {
+			return nil, fmt.Errorf("retention tiers must have increasing windows")
+		}
+
+		policy = append(policy, RetentionTier{Within: within, Every: every})
+	}
+
+	return policy, nil
+}
+
+// versionsToKeep returns the versions retained by the policy. Snapshots must
+// be sorted from the newest one, as returned by FetchSnapshots.
+func (p RetentionPolicy) versionsToKeep(snapshots []repository.Snapshot, now time.Time) map[int64]bool {
+	type bucket struct {
+		tier  int
+		start time.Time
+	}
+
+	keep := make(map[int64]bool, len(snapshots))
+	seen := make(map[bucket]bool)
+	for i, snapshot := range snapshots {
+		if i == 0 {
+			keep[snapshot.Version] = true
+			continue
+		}
+
+		age := now.Sub(snapshot.CreatedAt)
+		tier := slices.IndexFunc(p, func(t RetentionTier) bool { return age <= t.Within })
+		if tier < 0 {
+			continue
+		}
+
+		if p[tier].Every == 0 {
+			keep[snapshot.Version] = true
+			continue
+		}
+
+		b := bucket{tier: tier, start: snapshot.CreatedAt.UTC().Truncate(p[tier].Every)}
+		if !seen[b] {
+			seen[b] = true
+			keep[snapshot.Version] = true
+		}
+	}
+
+	return keep
+}
+
+// compactSnapshots periodically applies the retention policy to the
+// snapshots of every file. The function runs until context cancellation.
+func (s *syncinator) compactSnapshots() {
+	ticker := time.NewTicker(s.snapshotCompactionInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			s.compactAllSnapshots(time.Now())
+		case <-s.ctx.Done():
+			return
+		}
+	}
+}
+
+func (s *syncinator) compactAllSnapshots(now time.Time) {
+	files, err := s.db.FetchFilesWithSnapshots(s.ctx)
+	if err != nil {
+		log.Println("error while fetching files with snapshots", err)
+		return
+	}
+
+	for _, f := range files {
+		if err := s.compactFileSnapshots(f.FileID, f.WorkspaceID, now); err != nil {
+			log.Printf("error while compacting snapshots of file %d: %v", f.FileID, err)
+		}
+	}
+
+	s.purgeDeletedFiles(now)
+}
+
+// purgeDeletedFiles drops the history kept for the point-in-time exports of
+// the files deleted before the last tier of the policy: their snapshots,
+// their operations and their last content.
+func (s *syncinator) purgeDeletedFiles(now time.Time) {
+	if len(s.snapshotRetention) == 0 {
+		return
+	}
+
+	cutoff := now.Add(-s.snapshotRetention[len(s.snapshotRetention)-1].Within).UTC()
+	deleted, err := s.db.FetchDeletedFilesWithHistoryBefore(s.ctx, cutoff)
+	if err != nil {
+		log.Println("error while fetching deleted files with history", err)
+		return
+	}
+
+	for _, file := range deleted {
+		if err := s.purgeDeletedFile(file); err != nil {
+			log.Printf("error while purging history of deleted file %d: %v", file.FileID, err)
+		}
+	}
+}
+
+func (s *syncinator) purgeDeletedFile(file repository.DeletedFile) error {
+	snapshots, err := s.db.FetchSnapshots(s.ctx, repository.FetchSnapshotsParams{
+		FileID:      file.FileID,
+		WorkspaceID: file.WorkspaceID,
+	})
+	if err != nil {
+		return fmt.Errorf("fetching snapshots: %w", err)
+	}
+
+	tx, err := s.conn.BeginTx(s.ctx, nil)
+	if err != nil {
+		return fmt.Errorf("opening transaction: %w", err)
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	txq := s.db.WithTx(tx)
+	if err := txq.DeleteSnapshotsForFile(s.ctx, file.FileID); err != nil {
+		return fmt.Errorf("deleting snapshots: %w", err)
+	}
+	if err := txq.DeleteFileOperations(s.ctx, file.FileID); err != nil {
+		return fmt.Errorf("deleting operations: %w", err)
+	}
+	if err := txq.ClearDeletedFileHistory(s.ctx, file.FileID); err != nil {
+		return fmt.Errorf("clearing deleted file: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("committing transaction: %w", err)
+	}
+
+	// objects are deleted once nothing references them anymore
+	for _, p := range workspaceObjects(nil, []repository.DeletedFile{file}, snapshots) {
+		if err := s.storage.DeleteObject(p); err != nil {
+			log.Printf("error deleting blob of deleted file %d: %v", file.FileID, err)
+		}
+	}
+
+	return nil
+}
+
+type snapshotRewrite struct {
+	version  int64
+	diskPath string
+	kind     string
+	hash     string
+}
+
+// compactFileSnapshots deletes the snapshots of a file not retained by the
+// policy. Diff snapshots losing their predecessor are rewritten against the
+// previous retained snapshot, or as full snapshots when none is left, so
+// ReconstructSnapshot keeps working.
+func (s *syncinator) compactFileSnapshots(fileID, workspaceID int64, now time.Time) error {
+	// avoid racing with the flush routine creating a new snapshot
+	if file, ok := s.fileCache.Get(fileID); ok {
+		file.mut.Lock()
+		defer file.mut.Unlock()
+	}
+
+	snapshots, err := s.db.FetchSnapshots(s.ctx, repository.FetchSnapshotsParams{
+		FileID:      fileID,
+		WorkspaceID: workspaceID,
+	})
+	if err != nil {
+		return fmt.Errorf("fetching snapshots: %w", err)
+	}
+
+	keep := s.snapshotRetention.versionsToKeep(snapshots, now)
+	if len(keep) == len(snapshots) {
+		return nil
+	}
+
+	hasDiffs := slices.ContainsFunc(snapshots, func(snap repository.Snapshot) bool {
+		return snap.Type == "diff"
+	})
+
+	slices.SortFunc(snapshots, func(a, b repository.Snapshot) int {
+		return cmp.Compare(a.Version, b.Version)
+	})
+
+	var (
+		removed    []repository.Snapshot
+		rewrites   []snapshotRewrite
+		staleBlobs []string
+		newBlobs   []string
+	)
+	cleanupNewBlobs := func() {
+		for _, p := range newBlobs {
+			_ = s.storage.DeleteObject(p)
+		}
+	}
+
+	// content is only tracked for files with diff snapshots, binary files
+	// only have full ones
+	var content, keptContent string
+	hasBase, hasKept, chainBroken := false, false, false
+	for _, snap := range snapshots {
+		if hasDiffs {
+			switch snap.Type {
+			case "file":
+				content, err = s.readSnapshotObject(snap.DiskPath)
+				if err != nil {
+					cleanupNewBlobs()
+					return err
+				}
+				hasBase = true
+			case "diff":
+				if !hasBase {
+					cleanupNewBlobs()
+					return fmt.Errorf("diff snapshot %d without a base", snap.Version)
+				}
+
+				raw, err := s.readSnapshotObject(snap.DiskPath)
+				if err != nil {
+					cleanupNewBlobs()
+					return err
+				}
+
+				var chunks []diff.Chunk
+				if err := json.Unmarshal([]byte(raw), &chunks); err != nil {
+					cleanupNewBlobs()
+					return fmt.Errorf("parsing diff snapshot %d: %w", snap.Version, err)
+				}
+				content = diff.ApplyMultiple(content, chunks)
+			}
+		}
+
+		if !keep[snap.Version] {
+			removed = append(removed, snap)
+			chainBroken = true
+			continue
+		}
+
+		if snap.Type == "diff" && chainBroken {
+			rewrite := snapshotRewrite{version: snap.Version, kind: "file"}
+			object := content
+			if hasKept {
+				d, err := json.Marshal(diff.Compute([]rune(keptContent), []rune(content)))
+				if err != nil {
+					cleanupNewBlobs()
+					return err
+				}
+				rewrite.kind = "diff"
+				object = string(d)
+			}
+
+			rewrite.diskPath, rewrite.hash, err = s.createSnapshotObject(workspaceID, object)
+			if err != nil {
+				cleanupNewBlobs()
+				return fmt.Errorf("rewriting snapshot %d: %w", snap.Version, err)
+			}
+			newBlobs = append(newBlobs, rewrite.diskPath)
+			staleBlobs = append(staleBlobs, snap.DiskPath)
+			rewrites = append(rewrites, rewrite)
+		}
+
+		keptContent = content
+		hasKept = true
+		chainBroken = false
+	}
+
+	if err := s.applySnapshotCompaction(fileID, removed, rewrites); err != nil {
+		cleanupNewBlobs()
+		return err
+	}
+
+	for _, snap := range removed {
+		staleBlobs = append(staleBlobs, snap.DiskPath)
+	}
+	for _, p := range staleBlobs {
+		if err := s.storage.DeleteObject(p); err != nil {
+			log.Printf("error deleting snapshot blob of file %d: %v", fileID, err)
+		}
+	}
+
+	return nil
+}
+
+func (s *syncinator) applySnapshotCompaction(fileID int64, removed []repository.Snapshot, rewrites []snapshotRewrite) error {
+	tx, err := s.conn.BeginTx(s.ctx, nil)
+	if err != nil {
+		return fmt.Errorf("opening transaction: %w", err)
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	txq := s.db.WithTx(tx)
+
+	for _, snap := range removed {
+		if err := txq.DeleteSnapshot(s.ctx, repository.DeleteSnapshotParams{
+			FileID:  fileID,
+			Version: snap.Version,
+		}); err != nil {
+			return fmt.Errorf("deleting snapshot %d: %w", snap.Version, err)
+		}
+	}
+
+	for _, rewrite := range rewrites {
+		if err := txq.UpdateSnapshotContent(s.ctx, repository.UpdateSnapshotContentParams{
+			DiskPath: rewrite.diskPath,
+			Type:     rewrite.kind,
+			Hash:     rewrite.hash,
+			FileID:   fileID,
+			Version:  rewrite.version,
+		}); err != nil {
+			return fmt.Errorf("updating snapshot %d: %w", rewrite.version, err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("committing transaction: %w", err)
+	}
+
+	return nil
+}
+
+func (s *syncinator) readSnapshotObject(diskPath string) (string, error) {
+	reader, err := s.storage.ReadObject(diskPath)
+	if err != nil {
+		return "", fmt.Errorf("reading snapshot object: %w", err)
+	}
+	defer reader.Close()
+
+	content, err := io.ReadAll(reader)
+	if err != nil {
+		return "", fmt.Errorf("reading snapshot object: %w", err)
+	}
+
+	return string(content), nil
+}
+
+func (s *syncinator) createSnapshotObject(workspaceID int64, content string) (string, string, error) {
+	reader := strings.NewReader(content)
+	diskPath, err := s.storageFor(workspaceID).CreateObject(reader)
+	if err != nil {
+		return "", "", err
+	}
+
+	if _, err = reader.Seek(0, 0); err != nil {
+		return "", "", errors.Join(err, s.storage.DeleteObject(diskPath))
+	}
+	hash, err := filestorage.GenerateHash(reader)
+	if err != nil {
+		return "", "", errors.Join(err, s.storage.DeleteObject(diskPath))
+	}
+
+	return diskPath, hash, nil
+}
@@ -0,0 +1,228 @@
+package syncinator
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hiimjako/syncinator/internal/repository"
+	"github.com/hiimjako/syncinator/internal/testutils"
+	"github.com/hiimjako/syncinator/pkg/diff"
+	"github.com/hiimjako/syncinator/pkg/filestorage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseRetentionPolicy(t *testing.T) {
+	tests := []struct {
+		name    string
+		raw     string
+		want    RetentionPolicy
+		wantErr bool
+	}{
+		{name: "empty disables the retention", raw: "", want: nil},
+		{
+			name: "tiered policy",
+			raw:  "24h:all; 168h:1h; 720h:24h",
+			want: RetentionPolicy{
+				{Within: 24 * time.Hour},
+				{Within: 168 * time.Hour, Every: time.Hour},
+				{Within: 720 * time.Hour, Every: 24 * time.Hour},
+			},
+		},
+		{name: "missing interval", raw: "24h", wantErr: true},
+		{name: "invalid window", raw: "day:all", wantErr: true},
+		{name: "invalid interval", raw: "24h:0s", wantErr: true},
+		{name: "decreasing windows", raw: "168h:1h;24h:all", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseRetentionPolicy(tt.raw)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func Test_compactSnapshots(t *testing.T) {
+	fs := filestorage.NewDisk(t.TempDir())
+	db := testutils.CreateDB(t)
+	repo := repository.New(db)
+
+	var workspaceID int64 = 1
+	now := time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC)
+
+	policy, err := ParseRetentionPolicy("24h:all;168h:1h;720h:24h")
+	require.NoError(t, err)
+
+	opts := Options{JWTSecret: []byte("secret"), FlushInterval: time.Hour, SnapshotRetention: policy}
+	server := New(db, fs, opts)
+	t.Cleanup(func() { server.Close() })
+
+	createFile := func(t *testing.T, path string) repository.File {
+		file, err := repo.CreateFile(context.Background(), repository.CreateFileParams{
+			DiskPath:      "ignored",
+			WorkspacePath: path,
+			MimeType:      "text/plain",
+			Hash:          "h",
+			WorkspaceID:   workspaceID,
+		})
+		require.NoError(t, err)
+		return file
+	}
+
+	createSnapshot := func(t *testing.T, fileID, version int64, kind, content string, createdAt time.Time) string {
+		diskPath, err := fs.CreateObject(strings.NewReader(content))
+		require.NoError(t, err)
+
+		require.NoError(t, repo.CreateSnapshot(context.Background(), repository.CreateSnapshotParams{
+			FileID:      fileID,
+			Version:     version,
+			DiskPath:    diskPath,
+			Type:        kind,
+			Hash:        "h",
+			WorkspaceID: workspaceID,
+		}))
+
+		_, err = db.ExecContext(context.Background(),
+			"UPDATE snapshots SET created_at = ? WHERE file_id = ? AND version = ?",
+			createdAt, fileID, version)
+		require.NoError(t, err)
+
+		return diskPath
+	}
+
+	addText := func(t *testing.T, position int64, text string) string {
+		d, err := json.Marshal([]diff.Chunk{{Position: position, Type: diff.Add, Text: text, Len: int64(len(text))}})
+		require.NoError(t, err)
+		return string(d)
+	}
+
+	t.Run("should delete snapshots re-basing the remaining diffs", func(t *testing.T) {
+		file := createFile(t, "rebase.md")
+
+		blobs := []string{
+			createSnapshot(t, file.ID, 1, "file", "a", now.Add(-40*24*time.Hour)),
+			createSnapshot(t, file.ID, 2, "diff", addText(t, 1, "b"), now.Add(-20*24*time.Hour)),
+			createSnapshot(t, file.ID, 3, "diff", addText(t, 2, "c"), now.Add(-74*time.Hour-50*time.Minute)),
+			createSnapshot(t, file.ID, 4, "diff", addText(t, 3, "d"), now.Add(-74*time.Hour-20*time.Minute)),
+		}
+		latest := createSnapshot(t, file.ID, 5, "diff", addText(t, 4, "e"), now.Add(-time.Hour))
+
+		server.compactAllSnapshots(now)
+
+		snapshots, err := repo.FetchSnapshots(context.Background(), repository.FetchSnapshotsParams{
+			FileID:      file.ID,
+			WorkspaceID: workspaceID,
+		})
+		require.NoError(t, err)
+		require.Len(t, snapshots, 3)
+		assert.Equal(t, int64(5), snapshots[0].Version)
+		assert.Equal(t, latest, snapshots[0].DiskPath)
+		assert.Equal(t, int64(4), snapshots[1].Version)
+		assert.Equal(t, "diff", snapshots[1].Type)
+		assert.Equal(t, int64(2), snapshots[2].Version)
+		assert.Equal(t, "file", snapshots[2].Type)
+
+		for version, want := range map[int64]string{2: "ab", 4: "abcd", 5: "abcde"} {
+			content, err := server.ReconstructSnapshot(file.ID, version, workspaceID)
+			require.NoError(t, err)
+			assert.Equal(t, want, content)
+		}
+
+		for _, blob := range blobs {
+			_, err := fs.ReadObject(blob)
+			assert.Error(t, err)
+		}
+	})
+
+	t.Run("should always keep the latest snapshot", func(t *testing.T) {
+		file := createFile(t, "old.md")
+		createSnapshot(t, file.ID, 1, "file", "old", now.Add(-90*24*time.Hour))
+
+		server.compactAllSnapshots(now)
+
+		content, err := server.ReconstructSnapshot(file.ID, 1, workspaceID)
+		require.NoError(t, err)
+		assert.Equal(t, "old", content)
+	})
+
+	t.Run("should purge the history of the files deleted before the last tier", func(t *testing.T) {
+		deleteFile := func(t *testing.T, path string, deletedAt time.Time) (repository.File, []string) {
+			file := createFile(t, path)
+			snapshot := createSnapshot(t, file.ID, 1, "file", "a", deletedAt.Add(-time.Hour))
+			object, err := fs.CreateObject(strings.NewReader("ab"))
+			require.NoError(t, err)
+			require.NoError(t, repo.CreateOperation(context.Background(), repository.CreateOperationParams{
+				FileID:      file.ID,
+				Version:     2,
+				Operation:   addText(t, 1, "b"),
+				BaseVersion: 1,
+			}))
+
+			require.NoError(t, repo.CreateDeletedFile(context.Background(), repository.CreateDeletedFileParams{
+				FileID:        file.ID,
+				WorkspaceID:   workspaceID,
+				WorkspacePath: path,
+				DiskPath:      object,
+				MimeType:      file.MimeType,
+				Version:       2,
+				FileCreatedAt: file.CreatedAt,
+				FileUpdatedAt: file.UpdatedAt,
+			}))
+			require.NoError(t, repo.DeleteFile(context.Background(), file.ID))
+			_, err = db.ExecContext(context.Background(),
+				"UPDATE deleted_files SET deleted_at = ? WHERE file_id = ?", deletedAt, file.ID)
+			require.NoError(t, err)
+
+			return file, []string{snapshot, object}
+		}
+
+		expired, expiredObjects := deleteFile(t, "expired.md", now.Add(-31*24*time.Hour))
+		recent, recentObjects := deleteFile(t, "recent.md", now.Add(-29*24*time.Hour))
+
+		server.compactAllSnapshots(now)
+
+		for file, purged := range map[repository.File]bool{expired: true, recent: false} {
+			deleted, err := repo.FetchDeletedFile(context.Background(), file.ID)
+			require.NoError(t, err)
+			snapshots, err := repo.FetchSnapshots(context.Background(), repository.FetchSnapshotsParams{
+				FileID:      file.ID,
+				WorkspaceID: workspaceID,
+			})
+			require.NoError(t, err)
+			operations, err := repo.FetchFileOperationsFromVersion(context.Background(), repository.FetchFileOperationsFromVersionParams{
+				FileID:      file.ID,
+				WorkspaceID: workspaceID,
+			})
+			require.NoError(t, err)
+
+			if purged {
+				assert.Empty(t, deleted.DiskPath)
+				assert.Empty(t, snapshots)
+				assert.Empty(t, operations)
+			} else {
+				assert.NotEmpty(t, deleted.DiskPath)
+				assert.Len(t, snapshots, 1)
+				assert.Len(t, operations, 1)
+			}
+		}
+
+		for _, object := range expiredObjects {
+			_, err := fs.ReadObject(object)
+			assert.Error(t, err)
+		}
+		for _, object := range recentObjects {
+			reader, err := fs.ReadObject(object)
+			require.NoError(t, err)
+			reader.Close()
+		}
+	})
+}
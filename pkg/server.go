@@ -11,6 +11,7 @@ import (
 	lru "github.com/hashicorp/golang-lru/v2"
 	"github.com/hiimjako/syncinator/internal/repository"
 	"github.com/hiimjako/syncinator/pkg/filestorage"
+	"github.com/hiimjako/syncinator/pkg/middleware"
 	"golang.org/x/sync/singleflight"
 	"golang.org/x/time/rate"
 )
@@ -21,20 +22,32 @@ const (
 	PathWebSocket = APIV1Prefix + "/sync"
 	PathHTTPAPI   = APIV1Prefix + "/api"
 	PathHTTPAuth  = APIV1Prefix + "/auth"
+
+	PathJWKS = "/.well-known/jwks.json"
 )
 
 type Options struct {
-	JWTSecret              []byte
-	MaxFileSizeMB          int64
-	OperationTTL           time.Duration
-	CacheSize              int
-	MinChangesThreshold    int64
-	FlushInterval          time.Duration
-	SnapshotCheckpoint     int64 // Create full snapshot every N versions
-	MaxSnapshotDiffChain   int64 // Max consecutive diffs before forcing full snapshot
-	SubscriberRateInterval time.Duration
-	SubscriberRateBurst    int
-	PurgeCacheInterval     time.Duration
+	JWTSecret []byte
+	// JWTKeys sign and verify the tokens, when nil JWTSecret is used instead
+	JWTKeys                    *middleware.KeySet
+	MaxFileSizeMB              int64
+	OperationTTL               time.Duration
+	CacheSize                  int
+	MinChangesThreshold        int64
+	FlushInterval              time.Duration
+	SnapshotCheckpoint         int64 // Create full snapshot every N versions
+	MaxSnapshotDiffChain       int64 // Max consecutive diffs before forcing full snapshot
+	BinarySnapshotRetention    int64 // Snapshots kept per binary file, unless the workspace overrides it
+	SubscriberRateInterval     time.Duration
+	SubscriberRateBurst        int
+	PurgeCacheInterval         time.Duration
+	AccessCheckInterval        time.Duration   // How often the role and rules of the connected members are reloaded
+	SnapshotRetention          RetentionPolicy // Empty disables the snapshot compaction
+	SnapshotCompactionInterval time.Duration
+	RefreshTokenTTL            time.Duration
+	OIDC                       *OIDCOptions  // Nil disables the login with OpenID Connect
+	LoginFreeAttempts          int           // Failed logins of a client before slowing it down
+	LoginLockout               time.Duration // Longest wait imposed on a client failing to log in
 }
 
 func (o *Options) Default() {
@@ -66,6 +79,10 @@ func (o *Options) Default() {
 		o.MaxSnapshotDiffChain = 10 // Max 10 consecutive diffs
 	}
 
+	if o.BinarySnapshotRetention <= 0 {
+		o.BinarySnapshotRetention = 10
+	}
+
 	if o.SubscriberRateInterval <= 0 {
 		o.SubscriberRateInterval = 50 * time.Millisecond
 	}
@@ -77,6 +94,26 @@ func (o *Options) Default() {
 	if o.PurgeCacheInterval <= 0 {
 		o.PurgeCacheInterval = 10 * time.Minute
 	}
+
+	if o.AccessCheckInterval <= 0 {
+		o.AccessCheckInterval = 30 * time.Second
+	}
+
+	if o.SnapshotCompactionInterval <= 0 {
+		o.SnapshotCompactionInterval = 1 * time.Hour
+	}
+
+	if o.RefreshTokenTTL <= 0 {
+		o.RefreshTokenTTL = 30 * 24 * time.Hour
+	}
+
+	if o.LoginFreeAttempts <= 0 {
+		o.LoginFreeAttempts = 5
+	}
+
+	if o.LoginLockout <= 0 {
+		o.LoginLockout = 15 * time.Minute
+	}
 }
 
 type CachedFile struct {
@@ -100,16 +137,24 @@ type syncinator struct {
 	cancel context.CancelFunc
 	wg     sync.WaitGroup
 
-	jwtSecret              []byte
-	maxFileSizeBytes       int64
-	operationTTL           time.Duration
-	minChangesThreshold    int64
-	flushInterval          time.Duration
-	snapshotCheckpoint     int64
-	maxSnapshotDiffChain   int64
-	subscriberRateInterval time.Duration
-	subscriberRateBurst    int
-	purgeCacheInterval     time.Duration
+	jwtSecret                  []byte
+	jwtKeys                    *middleware.KeySet
+	maxFileSizeBytes           int64
+	operationTTL               time.Duration
+	minChangesThreshold        int64
+	flushInterval              time.Duration
+	snapshotCheckpoint         int64
+	maxSnapshotDiffChain       int64
+	binarySnapshotRetention    int64
+	subscriberRateInterval     time.Duration
+	subscriberRateBurst        int
+	purgeCacheInterval         time.Duration
+	accessCheckInterval        time.Duration
+	snapshotRetention          RetentionPolicy
+	snapshotCompactionInterval time.Duration
+	refreshTokenTTL            time.Duration
+	oidc                       *oidcProvider
+	loginThrottle              *loginThrottle
 
 	publishLimiter *rate.Limiter
 	serverMux      *http.ServeMux
@@ -132,16 +177,23 @@ func New(db *sql.DB, fs filestorage.Storage, opts Options) *syncinator {
 		ctx:    ctx,
 		cancel: cancel,
 
-		jwtSecret:              opts.JWTSecret,
-		maxFileSizeBytes:       opts.MaxFileSizeMB << 20,
-		operationTTL:           opts.OperationTTL,
-		minChangesThreshold:    opts.MinChangesThreshold,
-		flushInterval:          opts.FlushInterval,
-		snapshotCheckpoint:     opts.SnapshotCheckpoint,
-		maxSnapshotDiffChain:   opts.MaxSnapshotDiffChain,
-		subscriberRateInterval: opts.SubscriberRateInterval,
-		subscriberRateBurst:    opts.SubscriberRateBurst,
-		purgeCacheInterval:     opts.PurgeCacheInterval,
+		jwtSecret:                  opts.JWTSecret,
+		jwtKeys:                    opts.JWTKeys,
+		maxFileSizeBytes:           opts.MaxFileSizeMB << 20,
+		operationTTL:               opts.OperationTTL,
+		minChangesThreshold:        opts.MinChangesThreshold,
+		flushInterval:              opts.FlushInterval,
+		snapshotCheckpoint:         opts.SnapshotCheckpoint,
+		maxSnapshotDiffChain:       opts.MaxSnapshotDiffChain,
+		binarySnapshotRetention:    opts.BinarySnapshotRetention,
+		subscriberRateInterval:     opts.SubscriberRateInterval,
+		subscriberRateBurst:        opts.SubscriberRateBurst,
+		purgeCacheInterval:         opts.PurgeCacheInterval,
+		accessCheckInterval:        opts.AccessCheckInterval,
+		snapshotRetention:          opts.SnapshotRetention,
+		snapshotCompactionInterval: opts.SnapshotCompactionInterval,
+		refreshTokenTTL:            opts.RefreshTokenTTL,
+		loginThrottle:              newLoginThrottle(opts.LoginFreeAttempts, opts.LoginLockout),
 
 		serverMux:      http.NewServeMux(),
 		publishLimiter: rate.NewLimiter(rate.Every(opts.SubscriberRateInterval), opts.SubscriberRateBurst),
@@ -152,15 +204,20 @@ func New(db *sql.DB, fs filestorage.Storage, opts Options) *syncinator {
 		db:             repo,
 	}
 
+	if opts.OIDC != nil {
+		s.oidc = newOIDCProvider(*opts.OIDC)
+	}
+
 	s.initCache(opts.CacheSize)
 
 	s.serverMux.HandleFunc("/healthz", s.healthzHandler)
 	s.serverMux.HandleFunc("/readyz", s.readyzHandler)
+	s.serverMux.HandleFunc("GET "+PathJWKS, s.jwksHandler)
 	s.serverMux.Handle(PathHTTPAPI+"/", http.StripPrefix(PathHTTPAPI, s.apiHandler()))
 	s.serverMux.Handle(PathHTTPAuth+"/", http.StripPrefix(PathHTTPAuth, s.authHandler()))
 	s.serverMux.Handle(PathWebSocket, s.wsHandler())
 
-	s.wg.Add(2)
+	s.wg.Add(3)
 	go func() {
 		defer s.wg.Done()
 		s.processFileChanges()
@@ -169,6 +226,18 @@ func New(db *sql.DB, fs filestorage.Storage, opts Options) *syncinator {
 		defer s.wg.Done()
 		s.purgeCache()
 	}()
+	go func() {
+		defer s.wg.Done()
+		s.checkSubscribersAccess()
+	}()
+
+	if len(s.snapshotRetention) > 0 {
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			s.compactSnapshots()
+		}()
+	}
 
 	return s
 }
@@ -191,6 +260,11 @@ func (s *syncinator) initCache(cacheSize int) {
 	s.fileCache = fileCache
 }
 
+// storageFor returns the storage used to write the objects of the workspace
+func (s *syncinator) storageFor(workspaceID int64) filestorage.Storage {
+	return filestorage.ForWorkspace(s.storage, workspaceID)
+}
+
 func (s *syncinator) healthzHandler(w http.ResponseWriter, _ *http.Request) {
 	w.WriteHeader(http.StatusOK)
 }
@@ -7,6 +7,7 @@ import (
 	"log"
 	"net/http"
 	"strings"
+	"sync"
 	"sync/atomic"
 	"time"
 
@@ -25,17 +26,31 @@ type subscriber struct {
 	r    *http.Request
 	ctx  context.Context
 
-	isConnected     atomic.Bool
-	clientID        string
-	workspaceID     int64
-	msgLimiter      *rate.Limiter
-	chunkMsgQueue   chan ChunkMessage
-	eventMsgQueue   chan EventMessage
-	cursorMsgQueue  chan CursorMessage
-	closeSlow       func()
-	onChunkMessage  func(*subscriber, ChunkMessage)
-	onEventMessage  func(*subscriber, EventMessage)
-	onCursorMessage func(*subscriber, CursorMessage)
+	isConnected             atomic.Bool
+	clientID                string
+	workspaceID             int64
+	userID                  int64
+	tokenID                 string
+	apiKey                  bool
+	actor                   string
+	tokenRole               middleware.Role
+	endToEndEncrypted       bool
+	accessMu                sync.RWMutex
+	role                    middleware.Role
+	pathRules               PathRules
+	editedMu                sync.Mutex
+	editedFiles             map[int64]bool
+	msgLimiter              *rate.Limiter
+	chunkMsgQueue           chan ChunkMessage
+	encryptedChunkMsgQueue  chan EncryptedChunkMessage
+	eventMsgQueue           chan EventMessage
+	cursorMsgQueue          chan CursorMessage
+	ackMsgQueue             chan AckMessage
+	closeSlow               func()
+	onChunkMessage          func(*subscriber, ChunkMessage)
+	onEventMessage          func(*subscriber, EventMessage)
+	onCursorMessage         func(*subscriber, CursorMessage)
+	onEncryptedChunkMessage func(*subscriber, EncryptedChunkMessage)
 }
 
 func NewSubscriber(
@@ -47,6 +62,7 @@ func NewSubscriber(
 	onChunkMessage func(*subscriber, ChunkMessage),
 	onEventMessage func(*subscriber, EventMessage),
 	onCursorMessage func(*subscriber, CursorMessage),
+	onEncryptedChunkMessage func(*subscriber, EncryptedChunkMessage),
 ) (*subscriber, error) {
 	c, err := websocket.Accept(w, r, &websocket.AcceptOptions{
 		OriginPatterns: []string{"localhost", "127.0.0.1", "obsidian.md"},
@@ -57,27 +73,40 @@ func NewSubscriber(
 
 	const subscriberMessageBuffer = 8
 	workspaceID, _ := middleware.WorkspaceIDFromCtx(r.Context())
+	userID, _ := middleware.UserIDFromCtx(r.Context())
+	role, _ := middleware.RoleFromCtx(r.Context())
+	tokenID, _ := middleware.TokenIDFromCtx(r.Context())
+	_, apiKey := middleware.ScopesFromCtx(r.Context())
 
 	s := &subscriber{
-		conn:           c,
-		w:              w,
-		r:              r,
-		ctx:            ctx,
-		isConnected:    atomic.Bool{},
-		msgLimiter:     rate.NewLimiter(rate.Every(rateInterval), rateBurst),
-		chunkMsgQueue:  make(chan ChunkMessage, subscriberMessageBuffer),
-		eventMsgQueue:  make(chan EventMessage, subscriberMessageBuffer),
-		cursorMsgQueue: make(chan CursorMessage, subscriberMessageBuffer),
-		workspaceID:    workspaceID,
-		clientID:       uuid.New().String(),
+		conn:                   c,
+		w:                      w,
+		r:                      r,
+		ctx:                    ctx,
+		isConnected:            atomic.Bool{},
+		msgLimiter:             rate.NewLimiter(rate.Every(rateInterval), rateBurst),
+		chunkMsgQueue:          make(chan ChunkMessage, subscriberMessageBuffer),
+		encryptedChunkMsgQueue: make(chan EncryptedChunkMessage, subscriberMessageBuffer),
+		eventMsgQueue:          make(chan EventMessage, subscriberMessageBuffer),
+		cursorMsgQueue:         make(chan CursorMessage, subscriberMessageBuffer),
+		ackMsgQueue:            make(chan AckMessage, subscriberMessageBuffer),
+		workspaceID:            workspaceID,
+		userID:                 userID,
+		tokenID:                tokenID,
+		apiKey:                 apiKey,
+		actor:                  auditActor(r.Context()),
+		tokenRole:              role,
+		role:                   role,
+		clientID:               uuid.New().String(),
 		closeSlow: func() {
 			if c != nil {
 				c.Close(websocket.StatusPolicyViolation, "connection too slow to keep up with messages")
 			}
 		},
-		onChunkMessage:  onChunkMessage,
-		onEventMessage:  onEventMessage,
-		onCursorMessage: onCursorMessage,
+		onChunkMessage:          onChunkMessage,
+		onEventMessage:          onEventMessage,
+		onCursorMessage:         onCursorMessage,
+		onEncryptedChunkMessage: onEncryptedChunkMessage,
 	}
 
 	s.isConnected.Store(true)
@@ -85,6 +114,46 @@ func NewSubscriber(
 	return s, nil
 }
 
+// access returns the role and the path rules of the client, which change
+// while it is connected when the member is edited
+func (s *subscriber) access() (middleware.Role, PathRules) {
+	s.accessMu.RLock()
+	defer s.accessMu.RUnlock()
+	return s.role, s.pathRules
+}
+
+func (s *subscriber) setAccess(role middleware.Role, rules PathRules) {
+	s.accessMu.Lock()
+	defer s.accessMu.Unlock()
+	s.role, s.pathRules = role, rules
+}
+
+func (s *subscriber) currentRole() middleware.Role {
+	role, _ := s.access()
+	return role
+}
+
+func (s *subscriber) rules() PathRules {
+	_, rules := s.access()
+	return rules
+}
+
+// markEdited tells if the client edits the file for the first time in the
+// session
+func (s *subscriber) markEdited(fileID int64) bool {
+	s.editedMu.Lock()
+	defer s.editedMu.Unlock()
+
+	if s.editedFiles[fileID] {
+		return false
+	}
+	if s.editedFiles == nil {
+		s.editedFiles = make(map[int64]bool)
+	}
+	s.editedFiles[fileID] = true
+	return true
+}
+
 func (s *subscriber) IsConnected() bool {
 	return s.isConnected.Load()
 }
@@ -124,6 +193,13 @@ func (s *subscriber) Listen() {
 				continue
 			}
 
+			if s.isReadOnly(msgType) {
+				//nolint:gosec
+				log.Printf("rejected change from read-only client %s (%d)\n", s.clientID, s.workspaceID)
+				s.rejectChange(msg)
+				continue
+			}
+
 			switch msgType {
 			case ChunkEventType:
 				var chunk ChunkMessage
@@ -152,6 +228,15 @@ func (s *subscriber) Listen() {
 				}
 
 				s.onCursorMessage(s, cursor)
+			case EncryptedChunkEventType:
+				var chunk EncryptedChunkMessage
+				err := mapToStruct(msg, &chunk)
+				if err != nil {
+					log.Println(err)
+					continue
+				}
+
+				s.onEncryptedChunkMessage(s, chunk)
 			}
 		}
 	}()
@@ -170,6 +255,16 @@ func (s *subscriber) Listen() {
 						return
 					}
 				}
+			case encryptedChunkMsg := <-s.encryptedChunkMsgQueue:
+				err := s.WriteMessage(encryptedChunkMsg, writeTimeout)
+				if err != nil {
+					//nolint:gosec
+					log.Printf("error sending encrypted chunk message from %s (%d): %v\n", s.clientID, s.workspaceID, err)
+					s.checkWsError(err)
+					if !s.IsConnected() {
+						return
+					}
+				}
 			case eventMsg := <-s.eventMsgQueue:
 				err := s.WriteMessage(eventMsg, writeTimeout)
 				if err != nil {
@@ -190,6 +285,16 @@ func (s *subscriber) Listen() {
 						return
 					}
 				}
+			case ackMsg := <-s.ackMsgQueue:
+				err := s.WriteMessage(ackMsg, writeTimeout)
+				if err != nil {
+					//nolint:gosec
+					log.Printf("error sending ack message from %s (%d): %v\n", s.clientID, s.workspaceID, err)
+					s.checkWsError(err)
+					if !s.IsConnected() {
+						return
+					}
+				}
 			case <-s.ctx.Done():
 				s.Close()
 				return
@@ -203,6 +308,43 @@ func (s *subscriber) Listen() {
 	<-s.ctx.Done()
 }
 
+// isReadOnly reports whether the message changes the workspace but the client
+// isn't allowed to, it still receives the changes of the others.
+func (s *subscriber) isReadOnly(msgType MessageType) bool {
+	switch msgType {
+	case ChunkEventType, EncryptedChunkEventType, CreateEventType, DeleteEventType, RenameEventType:
+		return !s.currentRole().Includes(middleware.RoleEditor)
+	default:
+		return false
+	}
+}
+
+// rejectChange nacks the message, if the client asked for an ack
+func (s *subscriber) rejectChange(msg map[string]any) {
+	var header struct {
+		WsMessageHeader
+		RequestID string `json:"requestId"`
+	}
+	if err := mapToStruct(msg, &header); err != nil || header.RequestID == "" {
+		return
+	}
+
+	ack := AckMessage{
+		WsMessageHeader: WsMessageHeader{
+			FileID: header.FileID,
+			Type:   AckEventType,
+		},
+		RequestID: header.RequestID,
+		Error:     AckErrForbidden,
+	}
+
+	select {
+	case s.ackMsgQueue <- ack:
+	default:
+		go s.closeSlow()
+	}
+}
+
 func (s *subscriber) MessageType(data map[string]any) (int, error) {
 	msgType, ok := data["type"].(float64)
 	if !ok {
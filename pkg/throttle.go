@@ -0,0 +1,155 @@
+package syncinator
+
+import (
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// loginThrottle slows down password guessing. The failed logins are counted
+// per client IP and per workspace name: past the free attempts every failure
+// doubles the wait before the next attempt, until the key is locked out.
+// Workspaces tolerate more failures, since all their members share them.
+type loginThrottle struct {
+	freeAttempts int
+	lockout      time.Duration
+
+	mu       sync.Mutex
+	attempts map[string]*loginAttempts
+}
+
+type loginAttempts struct {
+	failures     int
+	lastFailure  time.Time
+	blockedUntil time.Time
+}
+
+// workspaceAttemptsFactor multiplies the free attempts of a workspace name
+const workspaceAttemptsFactor = 4
+
+// backoffBase is the wait after the first failure past the free attempts
+const backoffBase = time.Second
+
+func newLoginThrottle(freeAttempts int, lockout time.Duration) *loginThrottle {
+	return &loginThrottle{
+		freeAttempts: freeAttempts,
+		lockout:      lockout,
+		attempts:     make(map[string]*loginAttempts),
+	}
+}
+
+func ipThrottleKey(ip string) string {
+	return "ip:" + ip
+}
+
+func workspaceThrottleKey(workspace string) string {
+	return "workspace:" + workspace
+}
+
+// retryAfter returns how long the client must wait before logging in again,
+// zero if it can already.
+func (lt *loginThrottle) retryAfter(now time.Time, ip, workspace string) time.Duration {
+	lt.mu.Lock()
+	defer lt.mu.Unlock()
+
+	return lt.wait(now, ip, workspace)
+}
+
+// reserve counts the login attempt as a failure before the password is
+// checked, so that concurrent attempts can't get past the limits, returning
+// zero. If the client must wait instead, it returns how long, and the attempt
+// isn't counted.
+func (lt *loginThrottle) reserve(now time.Time, ip, workspace string) time.Duration {
+	lt.mu.Lock()
+	defer lt.mu.Unlock()
+
+	if wait := lt.wait(now, ip, workspace); wait > 0 {
+		return wait
+	}
+
+	lt.fail(now, ipThrottleKey(ip), lt.freeAttempts)
+	lt.fail(now, workspaceThrottleKey(workspace), lt.freeAttempts*workspaceAttemptsFactor)
+	return 0
+}
+
+// recordSuccess releases the attempt reserved by a successful login and
+// forgets the failures of the client. The ones of the workspace are kept,
+// otherwise a member logging in would reset them for an attacker.
+func (lt *loginThrottle) recordSuccess(ip, workspace string) {
+	lt.mu.Lock()
+	defer lt.mu.Unlock()
+
+	delete(lt.attempts, ipThrottleKey(ip))
+
+	attempts, ok := lt.attempts[workspaceThrottleKey(workspace)]
+	if !ok || attempts.failures == 0 {
+		return
+	}
+	attempts.failures--
+	// only the reserved attempt could have blocked the workspace
+	if attempts.failures <= lt.freeAttempts*workspaceAttemptsFactor {
+		attempts.blockedUntil = time.Time{}
+	}
+}
+
+func (lt *loginThrottle) wait(now time.Time, ip, workspace string) time.Duration {
+	var wait time.Duration
+	for _, key := range []string{ipThrottleKey(ip), workspaceThrottleKey(workspace)} {
+		if attempts, ok := lt.attempts[key]; ok && now.Before(attempts.blockedUntil) {
+			wait = max(wait, attempts.blockedUntil.Sub(now))
+		}
+	}
+	return wait
+}
+
+func (lt *loginThrottle) fail(now time.Time, key string, freeAttempts int) {
+	attempts, ok := lt.attempts[key]
+	if !ok || lt.isExpired(now, attempts) {
+		attempts = &loginAttempts{}
+		lt.attempts[key] = attempts
+	}
+
+	attempts.failures++
+	attempts.lastFailure = now
+
+	extra := attempts.failures - freeAttempts
+	switch {
+	case extra <= 0:
+		return
+	case attempts.failures >= 2*freeAttempts:
+		attempts.blockedUntil = now.Add(lt.lockout)
+	default:
+		// capped shift, it would overflow long before reaching the lockout
+		delay := backoffBase << min(extra-1, 30)
+		attempts.blockedUntil = now.Add(min(delay, lt.lockout))
+	}
+}
+
+// isExpired tells if the failures are old enough to be forgotten
+func (lt *loginThrottle) isExpired(now time.Time, attempts *loginAttempts) bool {
+	return now.After(attempts.blockedUntil) && now.Sub(attempts.lastFailure) > lt.lockout
+}
+
+// prune removes the expired failures, so that the attempts of many clients
+// don't pile up
+func (lt *loginThrottle) prune(now time.Time) {
+	lt.mu.Lock()
+	defer lt.mu.Unlock()
+
+	for key, attempts := range lt.attempts {
+		if lt.isExpired(now, attempts) {
+			delete(lt.attempts, key)
+		}
+	}
+}
+
+// clientIP is the address of the peer, the headers set by proxies are ignored
+// since anyone can send them.
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
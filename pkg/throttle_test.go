@@ -0,0 +1,216 @@
+package syncinator
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hiimjako/syncinator/internal/migration"
+	"github.com/hiimjako/syncinator/internal/repository"
+	"github.com/hiimjako/syncinator/internal/testutils"
+	"github.com/hiimjako/syncinator/pkg/filestorage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestLoginThrottle(t *testing.T) {
+	now := time.Now()
+
+	t.Run("backs off exponentially and then locks out", func(t *testing.T) {
+		lt := newLoginThrottle(3, time.Hour)
+
+		for range 3 {
+			assert.Zero(t, lt.reserve(now, "1.1.1.1", "workspace"))
+			assert.Zero(t, lt.retryAfter(now, "1.1.1.1", "workspace"))
+		}
+
+		assert.Zero(t, lt.reserve(now, "1.1.1.1", "workspace"))
+		assert.Equal(t, time.Second, lt.retryAfter(now, "1.1.1.1", "workspace"))
+
+		// the attempts made while waiting aren't counted
+		assert.Equal(t, time.Second, lt.reserve(now, "1.1.1.1", "workspace"))
+
+		now := now.Add(time.Second)
+		assert.Zero(t, lt.reserve(now, "1.1.1.1", "workspace"))
+		assert.Equal(t, 2*time.Second, lt.retryAfter(now, "1.1.1.1", "workspace"))
+
+		now = now.Add(2 * time.Second)
+		assert.Zero(t, lt.reserve(now, "1.1.1.1", "workspace"))
+		assert.Equal(t, time.Hour, lt.retryAfter(now, "1.1.1.1", "workspace"))
+		assert.Equal(t, time.Minute, lt.retryAfter(now.Add(59*time.Minute), "1.1.1.1", "workspace"))
+
+		// other clients can still log in the workspace
+		assert.Zero(t, lt.retryAfter(now, "2.2.2.2", "workspace"))
+	})
+
+	t.Run("success forgets the failures of the client", func(t *testing.T) {
+		lt := newLoginThrottle(3, time.Hour)
+
+		for range 4 {
+			lt.reserve(now, "1.1.1.1", "workspace")
+		}
+		require.NotZero(t, lt.retryAfter(now, "1.1.1.1", "workspace"))
+
+		lt.recordSuccess("1.1.1.1", "workspace")
+		assert.Zero(t, lt.retryAfter(now, "1.1.1.1", "workspace"))
+	})
+
+	t.Run("success releases the attempt of the workspace", func(t *testing.T) {
+		lt := newLoginThrottle(1, time.Hour)
+
+		for i := range workspaceAttemptsFactor {
+			lt.reserve(now, "10.0.0."+strconv.Itoa(i), "workspace")
+		}
+		assert.Zero(t, lt.reserve(now, "1.1.1.1", "workspace"))
+		require.NotZero(t, lt.retryAfter(now, "2.2.2.2", "workspace"))
+
+		lt.recordSuccess("1.1.1.1", "workspace")
+		assert.Zero(t, lt.retryAfter(now, "2.2.2.2", "workspace"))
+	})
+
+	t.Run("workspace attacked from many clients", func(t *testing.T) {
+		lt := newLoginThrottle(3, time.Hour)
+
+		for i := range 3 * workspaceAttemptsFactor {
+			lt.reserve(now, "10.0.0."+strconv.Itoa(i), "workspace")
+		}
+		assert.Zero(t, lt.retryAfter(now, "1.1.1.1", "workspace"))
+
+		lt.reserve(now, "1.1.1.1", "workspace")
+		assert.Equal(t, time.Second, lt.retryAfter(now, "1.1.1.1", "workspace"))
+		assert.Zero(t, lt.retryAfter(now, "1.1.1.1", "other-workspace"))
+	})
+
+	t.Run("old failures are forgotten", func(t *testing.T) {
+		lt := newLoginThrottle(3, time.Hour)
+
+		for range 4 {
+			lt.reserve(now, "1.1.1.1", "workspace")
+		}
+
+		later := now.Add(2 * time.Hour)
+		lt.prune(later)
+		assert.Empty(t, lt.attempts)
+
+		lt.reserve(later, "1.1.1.1", "workspace")
+		assert.Zero(t, lt.retryAfter(later, "1.1.1.1", "workspace"))
+	})
+}
+
+func Test_fetchWorkspaceHandler_Throttle(t *testing.T) {
+	db, err := sql.Open("sqlite3", ":memory:")
+	require.NoError(t, err)
+	require.NoError(t, migration.Migrate(db))
+
+	server := New(db, new(filestorage.MockFileStorage), Options{
+		JWTSecret:         []byte("secret"),
+		LoginFreeAttempts: 2,
+		LoginLockout:      time.Hour,
+	})
+	t.Cleanup(func() {
+		server.Close()
+		db.Close()
+	})
+
+	hash, err := bcrypt.GenerateFromPassword([]byte("strong_password"), bcrypt.MinCost)
+	require.NoError(t, err)
+	require.NoError(t, server.db.AddWorkspace(context.Background(), repository.AddWorkspaceParams{
+		Name:     "workspace1",
+		Password: string(hash),
+	}))
+
+	const apiPath = PathHTTPAuth + "/login"
+	wrong := WorkspaceCredentials{Name: "workspace1", Password: "wrong"}
+	right := WorkspaceCredentials{Name: "workspace1", Password: "strong_password"}
+
+	for range 2 {
+		res, _ := testutils.DoRequest[string](t, server, http.MethodPost, apiPath, wrong)
+		assert.Equal(t, http.StatusUnauthorized, res.Code)
+	}
+
+	res, _ := testutils.DoRequest[string](t, server, http.MethodPost, apiPath, wrong)
+	assert.Equal(t, http.StatusUnauthorized, res.Code)
+
+	// even the right password is rejected while backing off
+	res, _ = testutils.DoRequest[string](t, server, http.MethodPost, apiPath, right)
+	assert.Equal(t, http.StatusTooManyRequests, res.Code)
+	assert.Equal(t, "1", res.Header().Get("Retry-After"))
+
+	res, _ = testutils.DoRequest[string](t, server, http.MethodPost, apiPath, wrong)
+	assert.Equal(t, http.StatusTooManyRequests, res.Code)
+
+	// the attacker is locked out once the backoff is over
+	server.loginThrottle.mu.Lock()
+	for _, attempts := range server.loginThrottle.attempts {
+		attempts.blockedUntil = time.Now()
+	}
+	server.loginThrottle.mu.Unlock()
+
+	res, _ = testutils.DoRequest[string](t, server, http.MethodPost, apiPath, wrong)
+	assert.Equal(t, http.StatusUnauthorized, res.Code)
+
+	res, _ = testutils.DoRequest[string](t, server, http.MethodPost, apiPath, right)
+	assert.Equal(t, http.StatusTooManyRequests, res.Code)
+	assert.Equal(t, "3600", res.Header().Get("Retry-After"))
+}
+
+func Test_fetchWorkspaceHandler_ConcurrentThrottle(t *testing.T) {
+	db, err := sql.Open("sqlite3", ":memory:")
+	require.NoError(t, err)
+	require.NoError(t, migration.Migrate(db))
+
+	server := New(db, new(filestorage.MockFileStorage), Options{
+		JWTSecret:         []byte("secret"),
+		LoginFreeAttempts: 2,
+		LoginLockout:      time.Hour,
+	})
+	t.Cleanup(func() {
+		server.Close()
+		db.Close()
+	})
+
+	hash, err := bcrypt.GenerateFromPassword([]byte("strong_password"), bcrypt.MinCost)
+	require.NoError(t, err)
+	require.NoError(t, server.db.AddWorkspace(context.Background(), repository.AddWorkspaceParams{
+		Name:     "workspace1",
+		Password: string(hash),
+	}))
+
+	body, err := json.Marshal(WorkspaceCredentials{Name: "workspace1", Password: "wrong"})
+	require.NoError(t, err)
+
+	const attempts = 20
+	codes := make(chan int, attempts)
+	var wg sync.WaitGroup
+	for range attempts {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			req := httptest.NewRequest(http.MethodPost, PathHTTPAuth+"/login", bytes.NewReader(body))
+			res := httptest.NewRecorder()
+			server.ServeHTTP(res, req)
+			codes <- res.Code
+		}()
+	}
+	wg.Wait()
+	close(codes)
+
+	checked := 0
+	for code := range codes {
+		if code == http.StatusUnauthorized {
+			checked++
+		} else {
+			assert.Equal(t, http.StatusTooManyRequests, code)
+		}
+	}
+	// the free attempts and the first one backing off
+	assert.Equal(t, 3, checked)
+}
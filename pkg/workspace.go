@@ -0,0 +1,211 @@
+package syncinator
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/coder/websocket"
+	"github.com/hiimjako/syncinator/internal/repository"
+	"github.com/hiimjako/syncinator/pkg/filestorage"
+	"github.com/hiimjako/syncinator/pkg/middleware"
+)
+
+// WorkspaceDeletion counts what was removed with a workspace, or what would
+// be on a dry run
+type WorkspaceDeletion struct {
+	DryRun     bool  `json:"dryRun"`
+	Files      int64 `json:"files"`
+	Snapshots  int64 `json:"snapshots"`
+	Operations int64 `json:"operations"`
+	Events     int64 `json:"events"`
+	Members    int64 `json:"members"`
+	APIKeys    int64 `json:"apiKeys"`
+	// Objects are the ones removed from the storage
+	Objects int `json:"objects"`
+}
+
+// DeleteWorkspace removes the workspace along with its files, history,
+// members and keys. The audit log is kept.
+//
+// The rows are deleted in a single transaction and the objects only after it
+// commits: an object failing to be deleted is left orphaned in the storage,
+// instead of a file pointing to a missing object. On a dry run the
+// transaction is rolled back, so the counts are exact without deleting
+// anything.
+func DeleteWorkspace(ctx context.Context, db *sql.DB, storage filestorage.Storage, workspaceID int64, dryRun bool) (WorkspaceDeletion, error) {
+	deletion := WorkspaceDeletion{DryRun: dryRun}
+	repo := repository.New(db)
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return deletion, err
+	}
+	defer func() { _ = tx.Rollback() }()
+	txq := repo.WithTx(tx)
+
+	// read in the transaction, so that the objects of the files created
+	// meanwhile aren't left orphaned
+	files, err := txq.FetchWorkspaceFiles(ctx, workspaceID)
+	if err != nil {
+		return deletion, fmt.Errorf("fetching files: %w", err)
+	}
+	deletedFiles, err := txq.FetchWorkspaceDeletedFiles(ctx, workspaceID)
+	if err != nil {
+		return deletion, fmt.Errorf("fetching deleted files: %w", err)
+	}
+	snapshots, err := txq.FetchWorkspaceSnapshots(ctx, workspaceID)
+	if err != nil {
+		return deletion, fmt.Errorf("fetching snapshots: %w", err)
+	}
+
+	if deletion.Operations, err = txq.DeleteWorkspaceOperations(ctx, workspaceID); err != nil {
+		return deletion, fmt.Errorf("deleting operations: %w", err)
+	}
+	if deletion.Snapshots, err = txq.DeleteWorkspaceSnapshots(ctx, workspaceID); err != nil {
+		return deletion, fmt.Errorf("deleting snapshots: %w", err)
+	}
+	if deletion.Files, err = txq.DeleteWorkspaceFiles(ctx, workspaceID); err != nil {
+		return deletion, fmt.Errorf("deleting files: %w", err)
+	}
+	if err = txq.DeleteWorkspaceDeletedFiles(ctx, workspaceID); err != nil {
+		return deletion, fmt.Errorf("deleting deleted files: %w", err)
+	}
+	if deletion.Events, err = txq.DeleteWorkspaceEvents(ctx, workspaceID); err != nil {
+		return deletion, fmt.Errorf("deleting events: %w", err)
+	}
+	if err = txq.DeleteWorkspacePathRules(ctx, workspaceID); err != nil {
+		return deletion, fmt.Errorf("deleting path rules: %w", err)
+	}
+	if deletion.Members, err = txq.DeleteWorkspaceMembers(ctx, workspaceID); err != nil {
+		return deletion, fmt.Errorf("deleting members: %w", err)
+	}
+	if err = txq.DeleteWorkspaceRefreshTokens(ctx, workspaceID); err != nil {
+		return deletion, fmt.Errorf("deleting refresh tokens: %w", err)
+	}
+	if deletion.APIKeys, err = txq.DeleteWorkspaceAPIKeys(ctx, workspaceID); err != nil {
+		return deletion, fmt.Errorf("deleting api keys: %w", err)
+	}
+	if err = txq.DeleteWorkspaceKey(ctx, workspaceID); err != nil {
+		return deletion, fmt.Errorf("deleting workspace key: %w", err)
+	}
+	if err = txq.DeleteWorkspace(ctx, workspaceID); err != nil {
+		return deletion, fmt.Errorf("deleting workspace: %w", err)
+	}
+
+	objects := workspaceObjects(files, deletedFiles, snapshots)
+	if dryRun {
+		deletion.Objects = len(objects)
+		return deletion, nil
+	}
+
+	if err := tx.Commit(); err != nil {
+		return deletion, err
+	}
+
+	for _, p := range objects {
+		if err := storage.DeleteObject(p); err != nil {
+			log.Printf("error deleting object %s of workspace %d: %v", p, workspaceID, err)
+			continue
+		}
+		deletion.Objects++
+	}
+
+	return deletion, nil
+}
+
+// workspaceObjects returns the paths of the objects referenced by the files,
+// the deleted files still keeping their history and the snapshots, each one
+// once since the snapshots of the binary files can share the object of a
+// version.
+func workspaceObjects(files []repository.File, deletedFiles []repository.DeletedFile, snapshots []repository.Snapshot) []string {
+	seen := make(map[string]struct{}, len(files)+len(snapshots))
+	paths := make([]string, 0, len(files)+len(snapshots))
+	add := func(p string) {
+		if _, ok := seen[p]; ok {
+			return
+		}
+		seen[p] = struct{}{}
+		paths = append(paths, p)
+	}
+	for _, file := range files {
+		add(file.DiskPath)
+	}
+	for _, file := range deletedFiles {
+		if file.DiskPath != "" {
+			add(file.DiskPath)
+		}
+	}
+	for _, snapshot := range snapshots {
+		add(snapshot.DiskPath)
+	}
+	return paths
+}
+
+// deleteWorkspaceHandler deletes the workspace of the request with all its
+// data. With "dryRun" nothing is deleted, it returns what would be.
+func (s *syncinator) deleteWorkspaceHandler(w http.ResponseWriter, r *http.Request) {
+	dryRun := false
+	if r.URL.Query().Get("dryRun") != "" {
+		var err error
+		dryRun, err = strconv.ParseBool(r.URL.Query().Get("dryRun"))
+		if err != nil {
+			http.Error(w, "invalid dryRun", http.StatusBadRequest)
+			return
+		}
+	}
+
+	workspaceID, _ := middleware.WorkspaceIDFromCtx(r.Context())
+
+	// the clients are disconnected first, so that they don't change the
+	// files while they are deleted
+	if !dryRun {
+		s.disconnectWorkspace(workspaceID)
+		s.evictWorkspaceFiles(workspaceID)
+	}
+
+	deletion, err := DeleteWorkspace(r.Context(), s.conn, s.storage, workspaceID, dryRun)
+	if err != nil {
+		log.Printf("error deleting workspace %d: %v", workspaceID, err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	if !dryRun {
+		s.auditRequest(r, AuditWorkspaceDelete, "", "")
+	}
+
+	writeJSON(w, http.StatusOK, deletion)
+}
+
+// disconnectWorkspace closes all the connections of the workspace
+func (s *syncinator) disconnectWorkspace(workspaceID int64) {
+	s.subscribersMu.RLock()
+	ws, ok := s.subscribers[workspaceID]
+	s.subscribersMu.RUnlock()
+
+	if !ok {
+		return
+	}
+
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+
+	for sub := range ws.subs {
+		delete(ws.subs, sub)
+		go sub.conn.Close(websocket.StatusPolicyViolation, "workspace deleted") //nolint:errcheck
+	}
+}
+
+// evictWorkspaceFiles removes the files of the workspace from the cache,
+// the eviction writes their pending changes to the storage
+func (s *syncinator) evictWorkspaceFiles(workspaceID int64) {
+	for _, fileID := range s.fileCache.Keys() {
+		if file, ok := s.fileCache.Peek(fileID); ok && file.WorkspaceID == workspaceID {
+			s.fileCache.Remove(fileID)
+		}
+	}
+}
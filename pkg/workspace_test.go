@@ -0,0 +1,254 @@
+package syncinator
+
+import (
+	"context"
+	"database/sql"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/coder/websocket"
+	"github.com/hiimjako/syncinator/internal/repository"
+	"github.com/hiimjako/syncinator/internal/testutils"
+	"github.com/hiimjako/syncinator/pkg/filestorage"
+	"github.com/hiimjako/syncinator/pkg/middleware"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDeleteWorkspace(t *testing.T) {
+	ctx := context.Background()
+	fs := filestorage.NewDisk(t.TempDir())
+	db := testutils.CreateDB(t)
+	repo := repository.New(db)
+
+	var workspaceID, otherWorkspaceID int64 = 10, 11
+
+	createFile := func(t *testing.T, workspaceID int64, path string) (repository.File, string) {
+		diskPath, err := fs.CreateObject(strings.NewReader("content of " + path))
+		require.NoError(t, err)
+		file, err := repo.CreateFile(ctx, repository.CreateFileParams{
+			DiskPath:      diskPath,
+			WorkspacePath: path,
+			MimeType:      "text/plain",
+			Hash:          "h",
+			WorkspaceID:   workspaceID,
+		})
+		require.NoError(t, err)
+
+		snapshotPath, err := fs.CreateObject(strings.NewReader("snapshot of " + path))
+		require.NoError(t, err)
+		require.NoError(t, repo.CreateSnapshot(ctx, repository.CreateSnapshotParams{
+			FileID:      file.ID,
+			Version:     1,
+			DiskPath:    snapshotPath,
+			Type:        "file",
+			Hash:        "h",
+			WorkspaceID: workspaceID,
+		}))
+		require.NoError(t, repo.CreateOperation(ctx, repository.CreateOperationParams{
+			FileID:    file.ID,
+			Version:   1,
+			Operation: "[]",
+		}))
+		_, err = repo.CreateEvent(ctx, repository.CreateEventParams{
+			WorkspaceID:   workspaceID,
+			FileID:        file.ID,
+			Type:          int64(CreateEventType),
+			WorkspacePath: path,
+			ObjectType:    "file",
+		})
+		require.NoError(t, err)
+		return file, snapshotPath
+	}
+
+	deletedFile, deletedSnapshot := createFile(t, workspaceID, "a.md")
+	keptFile, keptSnapshot := createFile(t, otherWorkspaceID, "a.md")
+
+	require.NoError(t, repo.AddUser(ctx, repository.AddUserParams{Name: "alice", Password: "x"}))
+	user, err := repo.FetchUser(ctx, "alice")
+	require.NoError(t, err)
+	require.NoError(t, repo.AddWorkspaceMember(ctx, repository.AddWorkspaceMemberParams{
+		WorkspaceID: workspaceID,
+		UserID:      user.ID,
+		Role:        string(middleware.RoleEditor),
+	}))
+	_, err = repo.AddMemberPathRule(ctx, repository.AddMemberPathRuleParams{
+		WorkspaceID: workspaceID,
+		UserID:      user.ID,
+		Pattern:     "private/**",
+		Access:      "read",
+	})
+	require.NoError(t, err)
+	_, err = repo.CreateAPIKey(ctx, repository.CreateAPIKeyParams{
+		WorkspaceID: workspaceID,
+		Name:        "ci",
+		KeyHash:     "hash",
+		Scopes:      "files:read",
+	})
+	require.NoError(t, err)
+
+	deletion, err := DeleteWorkspace(ctx, db, fs, workspaceID, true)
+	require.NoError(t, err)
+	assert.Equal(t, WorkspaceDeletion{
+		DryRun:     true,
+		Files:      1,
+		Snapshots:  1,
+		Operations: 1,
+		Events:     1,
+		Members:    1,
+		APIKeys:    1,
+		Objects:    2,
+	}, deletion)
+
+	// the dry run doesn't delete anything
+	files, err := repo.FetchWorkspaceFiles(ctx, workspaceID)
+	require.NoError(t, err)
+	assert.Equal(t, []repository.File{deletedFile}, files)
+	object, err := fs.ReadObject(deletedSnapshot)
+	require.NoError(t, err)
+	object.Close()
+
+	deletion, err = DeleteWorkspace(ctx, db, fs, workspaceID, false)
+	require.NoError(t, err)
+	assert.Equal(t, WorkspaceDeletion{
+		Files:      1,
+		Snapshots:  1,
+		Operations: 1,
+		Events:     1,
+		Members:    1,
+		APIKeys:    1,
+		Objects:    2,
+	}, deletion)
+
+	_, err = repo.FetchWorkspace(ctx, "workspace_10")
+	assert.Error(t, err)
+	_, err = repo.FetchMemberWorkspace(ctx, repository.FetchMemberWorkspaceParams{Name: "workspace_10", UserID: user.ID})
+	assert.Error(t, err)
+
+	for _, p := range []string{deletedFile.DiskPath, deletedSnapshot} {
+		_, err := fs.ReadObject(p)
+		assert.Error(t, err, p)
+	}
+
+	// the other workspace is untouched
+	files, err = repo.FetchWorkspaceFiles(ctx, otherWorkspaceID)
+	require.NoError(t, err)
+	assert.Equal(t, []repository.File{keptFile}, files)
+	snapshots, err := repo.FetchWorkspaceSnapshots(ctx, otherWorkspaceID)
+	require.NoError(t, err)
+	assert.Len(t, snapshots, 1)
+	for _, p := range []string{keptFile.DiskPath, keptSnapshot} {
+		object, err := fs.ReadObject(p)
+		require.NoError(t, err, p)
+		object.Close()
+	}
+}
+
+func Test_deleteWorkspaceHandler(t *testing.T) {
+	ctx := context.Background()
+	fs := filestorage.NewDisk(t.TempDir())
+	db := testutils.CreateDB(t)
+	options := Options{JWTSecret: []byte("secret")}
+	server := New(db, fs, options)
+	ts := httptest.NewServer(server)
+	t.Cleanup(func() {
+		ts.Close()
+		server.Close()
+	})
+
+	var workspaceID int64 = 10
+	asAdmin := testutils.WithAuthHeader(options.JWTSecret, workspaceID)
+
+	diskPath, err := fs.CreateObject(strings.NewReader("hello"))
+	require.NoError(t, err)
+	file, err := server.db.CreateFile(ctx, repository.CreateFileParams{
+		DiskPath:      diskPath,
+		WorkspacePath: "hello.md",
+		MimeType:      "text/markdown",
+		Hash:          "h",
+		WorkspaceID:   workspaceID,
+	})
+	require.NoError(t, err)
+	server.fileCache.Add(file.ID, &LockedCachedFile{CachedFile: CachedFile{
+		File:           file,
+		Content:        "hello world",
+		pendingChanges: 1,
+	}})
+
+	//nolint:bodyclose
+	conn, _, err := websocket.Dial(ctx, createWsURLWithAuth(t, ts.URL, workspaceID, options.JWTSecret), nil)
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.CloseNow() })
+
+	subscribers := func() int {
+		server.subscribersMu.RLock()
+		ws, ok := server.subscribers[workspaceID]
+		server.subscribersMu.RUnlock()
+		if !ok {
+			return 0
+		}
+		ws.mu.Lock()
+		defer ws.mu.Unlock()
+		return len(ws.subs)
+	}
+	require.Eventually(t, func() bool { return subscribers() == 1 }, time.Second, 10*time.Millisecond)
+
+	t.Run("requires the admin role", func(t *testing.T) {
+		res, _ := testutils.DoRequest[string](t, server, http.MethodDelete, PathHTTPAPI+"/workspace", nil,
+			testutils.WithRoleAuthHeader(options.JWTSecret, workspaceID, middleware.RoleEditor))
+		assert.Equal(t, http.StatusForbidden, res.Code)
+	})
+
+	t.Run("should reject an invalid dry run", func(t *testing.T) {
+		res, body := testutils.DoRequest[string](t, server, http.MethodDelete, PathHTTPAPI+"/workspace?dryRun=maybe", nil, asAdmin)
+		assert.Equal(t, http.StatusBadRequest, res.Code)
+		assert.Equal(t, "invalid dryRun", body)
+	})
+
+	t.Run("should only count on a dry run", func(t *testing.T) {
+		res, deletion := testutils.DoRequest[WorkspaceDeletion](t, server, http.MethodDelete, PathHTTPAPI+"/workspace?dryRun=true", nil, asAdmin)
+		require.Equal(t, http.StatusOK, res.Code)
+		assert.Equal(t, WorkspaceDeletion{DryRun: true, Files: 1, Objects: 1}, deletion)
+
+		assert.Equal(t, 1, subscribers())
+		assert.True(t, server.fileCache.Contains(file.ID))
+		_, err := server.db.FetchFile(ctx, file.ID)
+		assert.NoError(t, err)
+	})
+
+	t.Run("should delete the workspace and disconnect its clients", func(t *testing.T) {
+		res, deletion := testutils.DoRequest[WorkspaceDeletion](t, server, http.MethodDelete, PathHTTPAPI+"/workspace", nil, asAdmin)
+		require.Equal(t, http.StatusOK, res.Code)
+		assert.Equal(t, WorkspaceDeletion{Files: 1, Objects: 1}, deletion)
+
+		readCtx, cancel := context.WithTimeout(ctx, 2*time.Second)
+		defer cancel()
+		_, _, err := conn.Read(readCtx)
+		assert.Equal(t, websocket.StatusPolicyViolation, websocket.CloseStatus(err))
+		assert.Equal(t, 0, subscribers())
+
+		assert.False(t, server.fileCache.Contains(file.ID))
+		_, err = server.db.FetchFile(ctx, file.ID)
+		assert.ErrorIs(t, err, sql.ErrNoRows)
+		_, err = fs.ReadObject(diskPath)
+		assert.Error(t, err)
+
+		events, err := server.db.FetchAuditEvents(ctx, repository.FetchAuditEventsParams{
+			WorkspaceID: workspaceID,
+			Until:       time.Now().Add(time.Hour),
+			Action:      string(AuditWorkspaceDelete),
+			Limit:       10,
+		})
+		require.NoError(t, err)
+		require.Len(t, events, 1)
+		assert.Equal(t, AuditActorWorkspace, events[0].Actor)
+	})
+
+	t.Run("should reject the tokens of the deleted workspace", func(t *testing.T) {
+		res, _ := testutils.DoRequest[string](t, server, http.MethodGet, PathHTTPAPI+"/file", nil, asAdmin)
+		assert.Equal(t, http.StatusUnauthorized, res.Code)
+	})
+}
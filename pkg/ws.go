@@ -28,6 +28,20 @@ const (
 	DeleteEventType
 	RenameEventType
 	CursorEventType
+	AckEventType
+	EncryptedChunkEventType
+)
+
+type AckErrorCode = string
+
+// Machine-readable reasons attached to a negative AckMessage.
+const (
+	AckErrInvalidChunks   AckErrorCode = "invalid_chunks"
+	AckErrFileUnavailable AckErrorCode = "file_unavailable"
+	AckErrStaleVersion    AckErrorCode = "stale_version"
+	AckErrPersistFailed   AckErrorCode = "persist_failed"
+	AckErrEncryptionMode  AckErrorCode = "encryption_mode_mismatch"
+	AckErrForbidden       AckErrorCode = "forbidden"
 )
 
 type WsMessageHeader struct {
@@ -37,14 +51,42 @@ type WsMessageHeader struct {
 
 type EventMessage struct {
 	WsMessageHeader
+	Seq           int64  `json:"seq,omitempty"`
 	WorkspacePath string `json:"workspacePath"`
 	ObjectType    string `json:"objectType"`
 }
 
 type ChunkMessage struct {
 	WsMessageHeader
-	Chunks  []diff.Chunk `json:"chunks"`
-	Version int64        `json:"version"`
+	RequestID string       `json:"requestId,omitempty"`
+	Chunks    []diff.Chunk `json:"chunks"`
+	Version   int64        `json:"version"`
+}
+
+// EncryptedChunkMessage carries an operation encrypted by the client of an
+// end-to-end encrypted workspace. The server can't read nor transform it, so
+// it is stored and relayed as is to the other clients: Version is the version
+// of the file the operation was made on, operations made on an older one are
+// rejected and clients holding a different one must catch up first.
+type EncryptedChunkMessage struct {
+	WsMessageHeader
+	RequestID string `json:"requestId,omitempty"`
+	Payload   string `json:"payload"`
+	Version   int64  `json:"version"`
+	// BaseVersion is set by the server when relaying the operation: it is
+	// stored after Version, but it was made on BaseVersion, older if it won
+	// over concurrent operations
+	BaseVersion int64 `json:"baseVersion"`
+}
+
+// AckMessage is sent back to the author of a ChunkMessage carrying a
+// RequestID. An empty Error means the chunks were applied at Version,
+// otherwise Version is the current server version of the file (if known).
+type AckMessage struct {
+	WsMessageHeader
+	RequestID string       `json:"requestId"`
+	Version   int64        `json:"version"`
+	Error     AckErrorCode `json:"error,omitempty"`
 }
 
 type CursorMessage struct {
@@ -62,7 +104,8 @@ func (s *syncinator) wsHandler() http.Handler {
 	router.HandleFunc("GET /", s.createSubscriber)
 
 	stack := middleware.CreateStack(
-		middleware.IsAuthenticated(middleware.AuthOptions{SecretKey: s.jwtSecret}, middleware.ExtractWsToken),
+		middleware.IsAuthenticated(s.authOptions(), middleware.ExtractWsToken),
+		middleware.RequireScope(middleware.ScopeRead),
 	)
 
 	routerWithStack := stack(router)
@@ -88,12 +131,25 @@ func (s *syncinator) subscribe(w http.ResponseWriter, r *http.Request) error {
 	sub, err := NewSubscriber(
 		s.ctx, w, r,
 		s.subscriberRateInterval, s.subscriberRateBurst,
-		s.onChunkMessage, s.onEventMessage, s.onCursorMessage,
+		s.onChunkMessage, s.onEventMessage, s.onCursorMessage, s.onEncryptedChunkMessage,
 	)
 	if err != nil {
 		return err
 	}
 
+	sub.endToEndEncrypted, err = s.db.FetchWorkspaceEndToEndEncrypted(r.Context(), sub.workspaceID)
+	if err != nil {
+		sub.Close()
+		return fmt.Errorf("fetching workspace %d: %w", sub.workspaceID, err)
+	}
+
+	rules, err := s.memberPathRules(r.Context(), sub.workspaceID, sub.userID)
+	if err != nil {
+		sub.Close()
+		return err
+	}
+	sub.setAccess(sub.tokenRole, rules)
+
 	s.addSubscriber(sub)
 	defer s.deleteSubscriber(sub)
 
@@ -105,21 +161,118 @@ func (s *syncinator) subscribe(w http.ResponseWriter, r *http.Request) error {
 }
 
 func (s *syncinator) onEventMessage(sender *subscriber, event EventMessage) {
+	if !sender.rules().CanWrite(event.WorkspacePath) {
+		log.Printf("forbidden path, skipping event. fileId: %v, type: %v\n", event.FileID, event.Type)
+		return
+	}
+
+	recorded, err := s.checkEvent(sender, event)
+	if err != nil {
+		log.Printf("invalid event, skipping it. fileId: %v, type: %v, err: %v\n", event.FileID, event.Type, err)
+		return
+	}
+
+	s.auditAnnouncedEvent(sender, event, recorded)
+
+	// the change was recorded by the api, the event only announces it
+	event.Seq = recorded.Seq
 	s.broadcastMessage(sender, event)
 }
 
+// checkEvent verifies that the event announces a change which already
+// happened to a file of the workspace of the sender, returning the event
+// recorded by the api for it.
+func (s *syncinator) checkEvent(sender *subscriber, event EventMessage) (repository.Event, error) {
+	file, err := s.db.FetchFile(s.ctx, event.FileID)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return repository.Event{}, fmt.Errorf("fetching file: %w", err)
+	}
+	found := err == nil
+	if found && file.WorkspaceID != sender.workspaceID {
+		return repository.Event{}, errors.New("file of another workspace")
+	}
+
+	switch event.Type {
+	case CreateEventType, RenameEventType:
+		if !found {
+			return repository.Event{}, errors.New("file not found")
+		}
+		if file.WorkspacePath != event.WorkspacePath {
+			return repository.Event{}, fmt.Errorf("file is at %q", file.WorkspacePath)
+		}
+		if !sender.rules().CanWrite(file.WorkspacePath) {
+			return repository.Event{}, fmt.Errorf("forbidden path %q", file.WorkspacePath)
+		}
+	case DeleteEventType:
+		if found {
+			return repository.Event{}, errors.New("file not deleted")
+		}
+		deleted, err := s.db.FetchDeletedFile(s.ctx, event.FileID)
+		if err != nil {
+			return repository.Event{}, fmt.Errorf("fetching deleted file: %w", err)
+		}
+		if deleted.WorkspaceID != sender.workspaceID {
+			return repository.Event{}, errors.New("file of another workspace")
+		}
+		if deleted.WorkspacePath != event.WorkspacePath {
+			return repository.Event{}, fmt.Errorf("file was at %q", deleted.WorkspacePath)
+		}
+		if !sender.rules().CanWrite(deleted.WorkspacePath) {
+			return repository.Event{}, fmt.Errorf("forbidden path %q", deleted.WorkspacePath)
+		}
+	default:
+		return repository.Event{}, fmt.Errorf("unknown event type %d", event.Type)
+	}
+
+	recorded, err := s.db.FetchLatestFileEvent(s.ctx, repository.FetchLatestFileEventParams{
+		WorkspaceID: sender.workspaceID,
+		FileID:      event.FileID,
+	})
+	if err != nil {
+		return repository.Event{}, fmt.Errorf("fetching recorded event: %w", err)
+	}
+	if MessageType(recorded.Type) != event.Type {
+		return repository.Event{}, fmt.Errorf("latest recorded event has type %d", recorded.Type)
+	}
+
+	// a renamed file was moved from a path the sender has to be allowed on
+	if event.Type == RenameEventType {
+		previous, err := s.db.FetchFileEventBefore(s.ctx, repository.FetchFileEventBeforeParams{
+			WorkspaceID: sender.workspaceID,
+			FileID:      event.FileID,
+			Seq:         recorded.Seq,
+		})
+		if err != nil {
+			return repository.Event{}, fmt.Errorf("fetching previous event: %w", err)
+		}
+		if !sender.rules().CanWrite(previous.WorkspacePath) {
+			return repository.Event{}, fmt.Errorf("forbidden path %q", previous.WorkspacePath)
+		}
+	}
+
+	return recorded, nil
+}
+
 func (s *syncinator) onCursorMessage(sender *subscriber, cursor CursorMessage) {
 	s.broadcastMessage(sender, cursor)
 }
 
 func (s *syncinator) onChunkMessage(sender *subscriber, data ChunkMessage) {
+	// the server can't apply plain text operations on encrypted contents
+	if sender != nil && sender.endToEndEncrypted {
+		s.replyAck(sender, data.FileID, data.RequestID, 0, AckErrEncryptionMode)
+		return
+	}
+
 	if len(data.Chunks) == 0 {
 		log.Printf("0 chunks, skipping message. fileId: %v, version: %v\n", data.FileID, data.Version)
+		s.replyAck(sender, data.FileID, data.RequestID, 0, AckErrInvalidChunks)
 		return
 	}
 
 	if err := diff.ValidateChunks(data.Chunks); err != nil {
 		log.Printf("invalid chunks, skipping message. fileId: %v, version: %v, err: %v\n", data.FileID, data.Version, err)
+		s.replyAck(sender, data.FileID, data.RequestID, 0, AckErrInvalidChunks)
 		return
 	}
 
@@ -129,6 +282,7 @@ func (s *syncinator) onChunkMessage(sender *subscriber, data ChunkMessage) {
 		file, err = s.fetchAndCacheFile(data.FileID)
 		if err != nil {
 			log.Printf("error while caching file %v: %v\n", data.FileID, err)
+			s.replyAck(sender, data.FileID, data.RequestID, 0, AckErrFileUnavailable)
 			return
 		}
 	}
@@ -136,33 +290,178 @@ func (s *syncinator) onChunkMessage(sender *subscriber, data ChunkMessage) {
 	file.mut.Lock()
 	defer file.mut.Unlock()
 
+	// the cache holds the files of every workspace
+	if sender != nil && file.WorkspaceID != sender.workspaceID {
+		s.replyAck(sender, data.FileID, data.RequestID, 0, AckErrFileUnavailable)
+		return
+	}
+
+	if sender != nil && !sender.rules().CanWrite(file.WorkspacePath) {
+		s.replyAck(sender, data.FileID, data.RequestID, 0, AckErrForbidden)
+		return
+	}
+
 	chunkToApply, err := transformStaleChunks(s.ctx, s.db, file, data.Version, data.Chunks)
 	if err != nil {
 		log.Printf("error transforming chunks. fileId: %v, version: %v, err: %v\n", data.FileID, data.Version, err)
+		s.replyAck(sender, data.FileID, data.RequestID, file.Version, AckErrStaleVersion)
 		return
 	}
 
 	newContent := diff.ApplyMultiple(file.Content, chunkToApply)
 	newVersion := file.Version + 1
 
-	err = persistChunkOperation(s.ctx, s.conn, s.db, file.ID, newVersion, chunkToApply)
+	changeSeq, err := persistChunkOperation(s.ctx, s.conn, s.db, file.File, newVersion, chunkToApply)
 	if err != nil {
 		log.Printf("error persisting operation. fileId: %v, version: %v, err: %v\n", data.FileID, data.Version, err)
+		s.replyAck(sender, data.FileID, data.RequestID, file.Version, AckErrPersistFailed)
 		return
 	}
 
 	file.Content = newContent
 	file.Version = newVersion
+	file.ChangeSeq = changeSeq
 	file.pendingChanges += 1
 	file.UpdatedAt = time.Now()
 
+	if sender != nil && sender.markEdited(file.ID) {
+		s.auditSubscriber(sender, AuditFileEdit, file.WorkspacePath)
+	}
+
+	s.replyAck(sender, data.FileID, data.RequestID, newVersion, "")
 	s.broadcastMessage(sender, ChunkMessage{
 		WsMessageHeader: data.WsMessageHeader,
+		RequestID:       data.RequestID,
 		Chunks:          chunkToApply,
 		Version:         newVersion,
 	})
 }
 
+// onEncryptedChunkMessage relays the operation to the other clients of the
+// workspace, acknowledging it with the current version of the file.
+func (s *syncinator) onEncryptedChunkMessage(sender *subscriber, data EncryptedChunkMessage) {
+	if !sender.endToEndEncrypted {
+		s.replyAck(sender, data.FileID, data.RequestID, 0, AckErrEncryptionMode)
+		return
+	}
+
+	if data.Payload == "" {
+		log.Printf("empty payload, skipping message. fileId: %v, version: %v\n", data.FileID, data.Version)
+		s.replyAck(sender, data.FileID, data.RequestID, 0, AckErrInvalidChunks)
+		return
+	}
+
+	file, err := s.db.FetchFile(s.ctx, data.FileID)
+	if err != nil || file.WorkspaceID != sender.workspaceID {
+		s.replyAck(sender, data.FileID, data.RequestID, 0, AckErrFileUnavailable)
+		return
+	}
+
+	if !sender.rules().CanWrite(file.WorkspacePath) {
+		s.replyAck(sender, data.FileID, data.RequestID, 0, AckErrForbidden)
+		return
+	}
+
+	// the operations can't be transformed: the ones made on an older version
+	// still in the history win, keeping the concurrent ones in a copy
+	if data.Version > file.Version || data.Version < file.BlobVersion {
+		s.replyAck(sender, data.FileID, data.RequestID, file.Version, AckErrStaleVersion)
+		return
+	}
+
+	payload, err := json.Marshal(data.Payload)
+	if err != nil {
+		s.replyAck(sender, data.FileID, data.RequestID, file.Version, AckErrInvalidChunks)
+		return
+	}
+
+	if data.Version < file.Version {
+		s.persistConflictingEncryptedOperation(sender, file, data, string(payload))
+		return
+	}
+
+	// a concurrent operation on the same version fails on the primary key
+	newVersion := file.Version + 1
+	if _, err := persistOperation(s.ctx, s.conn, s.db, file, newVersion, string(payload)); err != nil {
+		log.Printf("error persisting encrypted operation. fileId: %v, version: %v, err: %v\n", data.FileID, newVersion, err)
+		s.replyAck(sender, data.FileID, data.RequestID, file.Version, AckErrPersistFailed)
+		return
+	}
+
+	if sender.markEdited(file.ID) {
+		s.auditSubscriber(sender, AuditFileEdit, file.WorkspacePath)
+	}
+
+	s.replyAck(sender, data.FileID, data.RequestID, newVersion, "")
+	data.BaseVersion = data.Version
+	s.broadcastMessage(sender, data)
+}
+
+// persistConflictingEncryptedOperation stores the operation made on an older
+// version after the latest one, so that the last writer wins as when the file
+// is replaced. The file as it was is kept as a conflict copy, with the blob
+// and the operations to rebuild it.
+func (s *syncinator) persistConflictingEncryptedOperation(
+	sender *subscriber,
+	file repository.File,
+	data EncryptedChunkMessage,
+	payload string,
+) {
+	conflictDiskPath, err := s.copyObject(file.WorkspaceID, file.DiskPath)
+	if err != nil {
+		log.Printf("error copying file %d for a conflict: %v", file.ID, err)
+		s.replyAck(sender, data.FileID, data.RequestID, file.Version, AckErrPersistFailed)
+		return
+	}
+
+	newVersion := file.Version + 1
+	conflict, err := s.persistConflictingOperation(s.ctx, file, data.Version, payload, conflictDiskPath)
+	if err != nil {
+		_ = s.storage.DeleteObject(conflictDiskPath)
+		if errors.Is(err, errStaleFileVersion) {
+			s.replyAck(sender, data.FileID, data.RequestID, file.Version, AckErrStaleVersion)
+			return
+		}
+		log.Printf("error persisting encrypted operation. fileId: %v, version: %v, err: %v\n", data.FileID, newVersion, err)
+		s.replyAck(sender, data.FileID, data.RequestID, file.Version, AckErrPersistFailed)
+		return
+	}
+
+	s.publishToWorkspace(file.WorkspaceID, "", *conflict)
+	if sender.markEdited(file.ID) {
+		s.auditSubscriber(sender, AuditFileEdit, file.WorkspacePath)
+	}
+
+	s.replyAck(sender, data.FileID, data.RequestID, newVersion, "")
+	data.BaseVersion = data.Version
+	data.Version = file.Version
+	s.broadcastMessage(sender, data)
+}
+
+// replyAck tells the sender whether its chunk message has been applied.
+// Acks are opt-in: messages without a RequestID are never acknowledged.
+func (s *syncinator) replyAck(sender *subscriber, fileID int64, requestID string, version int64, code AckErrorCode) {
+	if sender == nil || requestID == "" {
+		return
+	}
+
+	ack := AckMessage{
+		WsMessageHeader: WsMessageHeader{
+			FileID: fileID,
+			Type:   AckEventType,
+		},
+		RequestID: requestID,
+		Version:   version,
+		Error:     code,
+	}
+
+	select {
+	case sender.ackMsgQueue <- ack:
+	default:
+		go sender.closeSlow()
+	}
+}
+
 func transformStaleChunks(
 	ctx context.Context,
 	db *repository.Queries,
@@ -202,47 +501,144 @@ func transformStaleChunks(
 	return transformed, nil
 }
 
+// persistChunkOperation stores the operation and bumps the file version,
+// returning the workspace change sequence stamped on the file.
 func persistChunkOperation(
 	ctx context.Context,
 	conn *sql.DB,
 	db *repository.Queries,
-	fileID int64,
+	file repository.File,
 	newVersion int64,
 	chunks []diff.Chunk,
-) error {
+) (int64, error) {
+	operation, err := json.Marshal(chunks)
+	if err != nil {
+		return 0, fmt.Errorf("marshaling operation: %w", err)
+	}
+
+	return persistOperation(ctx, conn, db, file, newVersion, string(operation))
+}
+
+// persistOperation stores the JSON encoded operation and bumps the file
+// version, returning the workspace change sequence stamped on the file.
+func persistOperation(
+	ctx context.Context,
+	conn *sql.DB,
+	db *repository.Queries,
+	file repository.File,
+	newVersion int64,
+	operation string,
+) (int64, error) {
 	tx, err := conn.BeginTx(ctx, nil)
 	if err != nil {
-		return fmt.Errorf("opening transaction: %w", err)
+		return 0, fmt.Errorf("opening transaction: %w", err)
 	}
 	defer func() { _ = tx.Rollback() }()
 
 	txq := db.WithTx(tx)
 
-	operation, err := json.Marshal(chunks)
+	changeSeq, err := storeOperation(ctx, txq, file, newVersion, newVersion-1, operation)
 	if err != nil {
-		return fmt.Errorf("marshaling operation: %w", err)
+		return 0, err
 	}
 
-	if err := txq.CreateOperation(ctx, repository.CreateOperationParams{
-		FileID:    fileID,
-		Version:   newVersion,
-		Operation: string(operation),
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("committing transaction: %w", err)
+	}
+
+	return changeSeq, nil
+}
+
+// persistConflictingOperation stores the JSON encoded operation made on
+// baseVersion after the latest version, registering conflictDiskPath as a copy
+// of the file with the operations since its blob. It returns the creation
+// event of the copy to publish.
+func (s *syncinator) persistConflictingOperation(
+	ctx context.Context,
+	file repository.File,
+	baseVersion int64,
+	operation, conflictDiskPath string,
+) (*EventMessage, error) {
+	tx, err := s.conn.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("opening transaction: %w", err)
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	txq := s.db.WithTx(tx)
+
+	changeSeq, err := storeOperation(ctx, txq, file, file.Version+1, baseVersion, operation)
+	if err != nil {
+		return nil, err
+	}
+
+	conflict, err := createConflictCopy(ctx, txq, file, conflictDiskPath, changeSeq)
+	if err != nil {
+		return nil, fmt.Errorf("creating conflict copy: %w", err)
+	}
+
+	if err := txq.CopyFileOperations(ctx, repository.CopyFileOperationsParams{
+		TargetFileID: conflict.FileID,
+		FileID:       file.ID,
+		FromVersion:  file.BlobVersion,
+		ToVersion:    file.Version,
 	}); err != nil {
-		return fmt.Errorf("storing operation: %w", err)
+		return nil, fmt.Errorf("copying operations: %w", err)
 	}
 
-	if err := txq.UpdateFileVersion(ctx, repository.UpdateFileVersionParams{
-		ID:      fileID,
-		Version: newVersion,
+	if err := txq.UpdateFileVersions(ctx, repository.UpdateFileVersionsParams{
+		ID:          conflict.FileID,
+		Version:     file.Version,
+		BlobVersion: file.BlobVersion,
 	}); err != nil {
-		return fmt.Errorf("updating version: %w", err)
+		return nil, fmt.Errorf("updating conflict copy version: %w", err)
 	}
 
 	if err := tx.Commit(); err != nil {
-		return fmt.Errorf("committing transaction: %w", err)
+		return nil, fmt.Errorf("committing transaction: %w", err)
 	}
 
-	return nil
+	return conflict, nil
+}
+
+// storeOperation adds the operation made on baseVersion as the newVersion of
+// the file, failing with errStaleFileVersion if another one was stored
+// meanwhile. It returns the workspace change sequence stamped on the file.
+func storeOperation(
+	ctx context.Context,
+	txq *repository.Queries,
+	file repository.File,
+	newVersion, baseVersion int64,
+	operation string,
+) (int64, error) {
+	if err := txq.CreateOperation(ctx, repository.CreateOperationParams{
+		FileID:      file.ID,
+		Version:     newVersion,
+		Operation:   operation,
+		BaseVersion: baseVersion,
+	}); err != nil {
+		return 0, fmt.Errorf("storing operation: %w", err)
+	}
+
+	changeSeq, err := txq.BumpWorkspaceChangeSeq(ctx, file.WorkspaceID)
+	if err != nil {
+		return 0, fmt.Errorf("bumping change sequence: %w", err)
+	}
+
+	updated, err := txq.UpdateFileVersion(ctx, repository.UpdateFileVersionParams{
+		ID:              file.ID,
+		Version:         newVersion,
+		ChangeSeq:       changeSeq,
+		ExpectedVersion: newVersion - 1,
+	})
+	if err != nil {
+		return 0, fmt.Errorf("updating version: %w", err)
+	}
+	if updated == 0 {
+		return 0, errStaleFileVersion
+	}
+
+	return changeSeq, nil
 }
 
 func (s *syncinator) broadcastMessage(sender *subscriber, msg any) {
@@ -251,13 +647,19 @@ func (s *syncinator) broadcastMessage(sender *subscriber, msg any) {
 		return
 	}
 
+	s.publishToWorkspace(sender.workspaceID, sender.clientID, msg)
+}
+
+// publishToWorkspace delivers the message to the subscribers of the workspace.
+// senderID is the client the message comes from, empty when it is the server.
+func (s *syncinator) publishToWorkspace(workspaceID int64, senderID string, msg any) {
 	err := s.publishLimiter.Wait(s.ctx)
 	if err != nil {
 		log.Println(err)
 	}
 
 	s.subscribersMu.RLock()
-	ws, ok := s.subscribers[sender.workspaceID]
+	ws, ok := s.subscribers[workspaceID]
 	s.subscribersMu.RUnlock()
 
 	if !ok {
@@ -267,13 +669,26 @@ func (s *syncinator) broadcastMessage(sender *subscriber, msg any) {
 	ws.mu.Lock()
 	defer ws.mu.Unlock()
 
+	// the path is resolved once, and only if a subscriber is restricted
+	msgPath, pathKnown, pathResolved := "", false, false
+
 	for sub := range ws.subs {
 		if !sub.IsConnected() {
 			delete(ws.subs, sub)
 			continue
 		}
 
-		isSameClient := sub.clientID == sender.clientID
+		if rules := sub.rules(); len(rules) > 0 {
+			if !pathResolved {
+				msgPath, pathKnown = s.messagePath(msg)
+				pathResolved = true
+			}
+			if !pathKnown || !rules.CanRead(msgPath) {
+				continue
+			}
+		}
+
+		isSameClient := sub.clientID == senderID
 
 		switch m := msg.(type) {
 		case ChunkMessage:
@@ -282,6 +697,16 @@ func (s *syncinator) broadcastMessage(sender *subscriber, msg any) {
 			default:
 				go sub.closeSlow()
 			}
+		case EncryptedChunkMessage:
+			if isSameClient {
+				continue
+			}
+
+			select {
+			case sub.encryptedChunkMsgQueue <- m:
+			default:
+				go sub.closeSlow()
+			}
 		case EventMessage:
 			if isSameClient {
 				continue
@@ -297,7 +722,7 @@ func (s *syncinator) broadcastMessage(sender *subscriber, msg any) {
 				continue
 			}
 
-			m.ID = sender.clientID
+			m.ID = senderID
 
 			select {
 			case sub.cursorMsgQueue <- m:
@@ -370,12 +795,23 @@ func (s *syncinator) purgeCache() {
 	for {
 		select {
 		case <-ticker.C:
-			// removing items from operation table
+			// removing items from operation table, but the encrypted ones
+			// not in a blob yet: the server can't apply them
 			err := s.db.DeleteOperationOlderThan(s.ctx, time.Now().Add(-s.operationTTL))
 			if err != nil {
 				log.Println("error while removing old operations", err)
 			}
 
+			// expired tokens are rejected anyway, there is no need to keep them
+			now := time.Now().UTC()
+			if err := s.db.DeleteExpiredRefreshTokens(s.ctx, now); err != nil {
+				log.Println("error while removing expired refresh tokens", err)
+			}
+			if err := s.db.DeleteExpiredRevokedTokens(s.ctx, now); err != nil {
+				log.Println("error while removing expired revoked tokens", err)
+			}
+			s.loginThrottle.prune(now)
+
 		case <-s.ctx.Done():
 			return
 		}
@@ -404,7 +840,7 @@ func (s *syncinator) flushFileToStorage(file CachedFile) error {
 		return nil
 	}
 
-	if err := s.storage.WriteObject(file.DiskPath, strings.NewReader(file.Content)); err != nil {
+	if err := s.storageFor(file.WorkspaceID).WriteObject(file.DiskPath, strings.NewReader(file.Content)); err != nil {
 		return err
 	}
 
@@ -414,8 +850,9 @@ func (s *syncinator) flushFileToStorage(file CachedFile) error {
 	}
 
 	return s.db.UpdateFileHash(s.ctx, repository.UpdateFileHashParams{
-		ID:   file.ID,
-		Hash: hash,
+		ID:          file.ID,
+		Hash:        hash,
+		BlobVersion: file.Version,
 	})
 }
 
@@ -466,7 +903,7 @@ func (s *syncinator) shouldCreateFullSnapshot(file CachedFile) (bool, repository
 
 func (s *syncinator) createFullSnapshot(file CachedFile) error {
 	reader := strings.NewReader(file.Content)
-	diskPath, err := s.storage.CreateObject(reader)
+	diskPath, err := s.storageFor(file.WorkspaceID).CreateObject(reader)
 	if err != nil {
 		return err
 	}
@@ -502,7 +939,7 @@ func (s *syncinator) createDiffSnapshot(file CachedFile, latestSnapshot reposito
 	}
 
 	reader := strings.NewReader(string(diffJSON))
-	diskPath, err := s.storage.CreateObject(reader)
+	diskPath, err := s.storageFor(file.WorkspaceID).CreateObject(reader)
 	if err != nil {
 		return err
 	}
@@ -641,6 +1078,93 @@ func (s *syncinator) addSubscriber(sub *subscriber) {
 	ws.mu.Unlock()
 }
 
+// disconnectToken closes the connections authenticated with the token
+func (s *syncinator) disconnectToken(workspaceID int64, tokenID string) {
+	s.subscribersMu.RLock()
+	ws, ok := s.subscribers[workspaceID]
+	s.subscribersMu.RUnlock()
+
+	if !ok {
+		return
+	}
+
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+
+	for sub := range ws.subs {
+		if sub.tokenID != tokenID {
+			continue
+		}
+
+		delete(ws.subs, sub)
+		go sub.conn.Close(websocket.StatusPolicyViolation, "token revoked") //nolint:errcheck
+	}
+}
+
+// checkSubscribersAccess periodically reloads the role and the path rules of
+// the connected members, since they can be changed by the CLI while their
+// connections are open, and disconnects the ones which lost access to the
+// workspace.
+func (s *syncinator) checkSubscribersAccess() {
+	ticker := time.NewTicker(s.accessCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			s.subscribersMu.RLock()
+			workspaces := make([]*workspaceSubscribers, 0, len(s.subscribers))
+			for _, ws := range s.subscribers {
+				workspaces = append(workspaces, ws)
+			}
+			s.subscribersMu.RUnlock()
+
+			for _, ws := range workspaces {
+				ws.mu.Lock()
+				subs := make([]*subscriber, 0, len(ws.subs))
+				for sub := range ws.subs {
+					subs = append(subs, sub)
+				}
+				ws.mu.Unlock()
+
+				for _, sub := range subs {
+					// API keys are checked on revocation, their role is given
+					// by their scopes
+					if !sub.apiKey {
+						s.refreshSubscriberAccess(sub)
+					}
+				}
+			}
+		case <-s.ctx.Done():
+			return
+		}
+	}
+}
+
+// refreshSubscriberAccess reloads the role, limited to the one of its token
+// like on the HTTP requests, and the path rules of the subscriber,
+// disconnecting it if it isn't a member of the workspace anymore
+func (s *syncinator) refreshSubscriberAccess(sub *subscriber) {
+	role, err := s.lookupRole(s.ctx, sub.workspaceID, sub.userID)
+	if err != nil {
+		log.Printf("error checking access of client %s (%d): %v\n", sub.clientID, sub.workspaceID, err)
+		return
+	}
+	if role == "" {
+		s.deleteSubscriber(sub)
+		go sub.conn.Close(websocket.StatusPolicyViolation, "access revoked") //nolint:errcheck
+		return
+	}
+
+	rules, err := s.memberPathRules(s.ctx, sub.workspaceID, sub.userID)
+	if err != nil {
+		log.Printf("error checking access of client %s (%d): %v\n", sub.clientID, sub.workspaceID, err)
+		return
+	}
+
+	sub.setAccess(sub.tokenRole.Lower(role), rules)
+}
+
 // deleteSubscriber deletes the given subscriber.
 func (s *syncinator) deleteSubscriber(sub *subscriber) {
 	s.subscribersMu.RLock()
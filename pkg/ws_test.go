@@ -2,10 +2,12 @@ package syncinator
 
 import (
 	"context"
+	"database/sql"
 	"encoding/json"
 	"fmt"
 	"io"
 	"log"
+	"net/http"
 	"net/http/httptest"
 	"os"
 	"path"
@@ -21,6 +23,7 @@ import (
 	"github.com/hiimjako/syncinator/pkg/diff"
 	"github.com/hiimjako/syncinator/pkg/filestorage"
 	"github.com/hiimjako/syncinator/pkg/middleware"
+	"github.com/hiimjako/syncinator/pkg/mimeutils"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 
@@ -28,7 +31,15 @@ import (
 )
 
 func createWsURLWithAuth(t testing.TB, url string, workspaceID int64, secret []byte) string {
-	token, err := middleware.CreateToken(middleware.AuthOptions{SecretKey: secret}, workspaceID)
+	return createWsURLWithRole(t, url, workspaceID, secret, middleware.RoleAdmin)
+}
+
+func createWsURLWithRole(t testing.TB, url string, workspaceID int64, secret []byte, role middleware.Role) string {
+	return createWsURLWithUser(t, url, workspaceID, 0, secret, role)
+}
+
+func createWsURLWithUser(t testing.TB, url string, workspaceID, userID int64, secret []byte, role middleware.Role) string {
+	token, err := middleware.CreateToken(middleware.AuthOptions{SecretKey: secret}, workspaceID, userID, role)
 	require.NoError(t, err)
 
 	newURL := strings.Replace(url, "http", "ws", 1) + PathWebSocket
@@ -457,7 +468,8 @@ func Test_handleChunk(t *testing.T) {
 						Len:      int64(len(startingString)),
 					},
 				}),
-				CreatedAt: operations[1].CreatedAt,
+				CreatedAt:   operations[1].CreatedAt,
+				BaseVersion: 1,
 			},
 		}, operations)
 	})
@@ -490,12 +502,30 @@ func Test_handleEvent(t *testing.T) {
 	receiverWorkspace2, _, err := websocket.Dial(ctx, urlWorkspace2, nil)
 	require.NoError(t, err)
 
+	file, err := handler.db.CreateFile(context.Background(), repository.CreateFileParams{
+		DiskPath:      "disk/a.md",
+		WorkspacePath: "a.md",
+		MimeType:      "text/plain",
+		WorkspaceID:   workspaceID1,
+	})
+	require.NoError(t, err)
+	// the creation is recorded by the api
+	_, err = handler.db.CreateEvent(context.Background(), repository.CreateEventParams{
+		WorkspaceID:   workspaceID1,
+		FileID:        file.ID,
+		Type:          int64(CreateEventType),
+		WorkspacePath: file.WorkspacePath,
+		ObjectType:    "file",
+	})
+	require.NoError(t, err)
+
 	msg := EventMessage{
 		WsMessageHeader: WsMessageHeader{
 			Type:   CreateEventType,
-			FileID: 1,
+			FileID: file.ID,
 		},
-		ObjectType: "file",
+		WorkspacePath: file.WorkspacePath,
+		ObjectType:    "file",
 	}
 
 	wg := sync.WaitGroup{}
@@ -526,7 +556,11 @@ func Test_handleEvent(t *testing.T) {
 		var recMsg EventMessage
 		err := wsjson.Read(ctx, receiverWorkspace1, &recMsg)
 		assert.NoError(t, err)
-		assert.Equal(t, msg, recMsg)
+
+		// the sequence number is assigned by the server
+		want := msg
+		want.Seq = 1
+		assert.Equal(t, want, recMsg)
 
 		wg.Done()
 	}()
@@ -535,6 +569,30 @@ func Test_handleEvent(t *testing.T) {
 
 	wg.Wait()
 
+	// the announced event isn't recorded again
+	events, err := handler.db.FetchEventsSince(context.Background(), repository.FetchEventsSinceParams{
+		WorkspaceID: workspaceID1,
+		Seq:         0,
+	})
+	assert.NoError(t, err)
+	require.Len(t, events, 1)
+	assert.Equal(t, repository.Event{
+		Seq:           1,
+		WorkspaceID:   workspaceID1,
+		FileID:        msg.FileID,
+		Type:          int64(msg.Type),
+		WorkspacePath: msg.WorkspacePath,
+		ObjectType:    msg.ObjectType,
+		CreatedAt:     events[0].CreatedAt,
+	}, events[0])
+
+	events, err = handler.db.FetchEventsSince(context.Background(), repository.FetchEventsSinceParams{
+		WorkspaceID: workspaceID2,
+		Seq:         0,
+	})
+	assert.NoError(t, err)
+	assert.Len(t, events, 0)
+
 	t.Cleanup(func() {
 		cancel()
 		senderWorkspace1.Close(websocket.StatusNormalClosure, "")
@@ -545,6 +603,110 @@ func Test_handleEvent(t *testing.T) {
 	})
 }
 
+func Test_handleEvent_invalid(t *testing.T) {
+	db := testutils.CreateDB(t)
+	opts := Options{JWTSecret: []byte("secret")}
+	handler := New(db, new(filestorage.MockFileStorage), opts)
+	ts := httptest.NewServer(handler)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	t.Cleanup(func() {
+		cancel()
+		ts.Close()
+		handler.Close()
+	})
+
+	var workspaceID, otherWorkspaceID int64 = 1, 2
+	createFile := func(workspaceID int64, workspacePath string) repository.File {
+		file, err := handler.db.CreateFile(context.Background(), repository.CreateFileParams{
+			DiskPath:      "disk/" + workspacePath,
+			WorkspacePath: workspacePath,
+			MimeType:      "text/plain",
+			WorkspaceID:   workspaceID,
+		})
+		require.NoError(t, err)
+		return file
+	}
+	note := createFile(workspaceID, "note.md")
+	foreign := createFile(otherWorkspaceID, "foreign.md")
+	for _, file := range []repository.File{note, foreign} {
+		_, err := handler.db.CreateEvent(context.Background(), repository.CreateEventParams{
+			WorkspaceID:   file.WorkspaceID,
+			FileID:        file.ID,
+			Type:          int64(CreateEventType),
+			WorkspacePath: file.WorkspacePath,
+			ObjectType:    "file",
+		})
+		require.NoError(t, err)
+	}
+
+	dial := func() *websocket.Conn {
+		//nolint:bodyclose
+		conn, _, err := websocket.Dial(ctx, createWsURLWithAuth(t, ts.URL, workspaceID, opts.JWTSecret), nil)
+		require.NoError(t, err)
+		t.Cleanup(func() { conn.Close(websocket.StatusNormalClosure, "") })
+		return conn
+	}
+	sender, receiver := dial(), dial()
+
+	require.Eventually(t, func() bool {
+		handler.subscribersMu.RLock()
+		ws, ok := handler.subscribers[workspaceID]
+		handler.subscribersMu.RUnlock()
+		if !ok {
+			return false
+		}
+		ws.mu.Lock()
+		defer ws.mu.Unlock()
+		return len(ws.subs) == 2
+	}, time.Second, 10*time.Millisecond)
+
+	for name, event := range map[string]EventMessage{
+		"missing file": {
+			WsMessageHeader: WsMessageHeader{Type: CreateEventType, FileID: 999},
+			WorkspacePath:   "missing.md",
+		},
+		"file of another workspace": {
+			WsMessageHeader: WsMessageHeader{Type: CreateEventType, FileID: foreign.ID},
+			WorkspacePath:   foreign.WorkspacePath,
+		},
+		"rename not done": {
+			WsMessageHeader: WsMessageHeader{Type: RenameEventType, FileID: note.ID},
+			WorkspacePath:   "renamed.md",
+		},
+		"rename not recorded": {
+			WsMessageHeader: WsMessageHeader{Type: RenameEventType, FileID: note.ID},
+			WorkspacePath:   note.WorkspacePath,
+		},
+		"delete not done": {
+			WsMessageHeader: WsMessageHeader{Type: DeleteEventType, FileID: note.ID},
+			WorkspacePath:   note.WorkspacePath,
+		},
+	} {
+		event.ObjectType = "file"
+		require.NoError(t, wsjson.Write(ctx, sender, event), name)
+	}
+
+	// a valid event is sent last, it must be the only one received
+	valid := EventMessage{
+		WsMessageHeader: WsMessageHeader{Type: CreateEventType, FileID: note.ID},
+		WorkspacePath:   note.WorkspacePath,
+		ObjectType:      "file",
+	}
+	require.NoError(t, wsjson.Write(ctx, sender, valid))
+
+	var received EventMessage
+	require.NoError(t, wsjson.Read(ctx, receiver, &received))
+	assert.Equal(t, note.ID, received.FileID)
+	assert.Equal(t, CreateEventType, received.Type)
+
+	events, err := handler.db.FetchEventsSince(context.Background(), repository.FetchEventsSinceParams{
+		WorkspaceID: workspaceID,
+	})
+	require.NoError(t, err)
+	require.Len(t, events, 1)
+	assert.Equal(t, events[0].Seq, received.Seq)
+}
+
 func Test_handleCursor(t *testing.T) {
 	db := testutils.CreateDB(t)
 
@@ -632,6 +794,735 @@ func Test_handleCursor(t *testing.T) {
 	})
 }
 
+func Test_chunkAck(t *testing.T) {
+	fs := filestorage.NewDisk(t.TempDir())
+	diskPath, err := fs.CreateObject(strings.NewReader(""))
+	require.NoError(t, err)
+
+	db := testutils.CreateDB(t)
+	repo := repository.New(db)
+
+	var workspaceID int64 = 1
+	file, err := repo.CreateFile(context.Background(), repository.CreateFileParams{
+		DiskPath:      diskPath,
+		WorkspacePath: "workspace_path",
+		MimeType:      "text/plain",
+		Hash:          "",
+		WorkspaceID:   workspaceID,
+	})
+	require.NoError(t, err)
+
+	opts := Options{JWTSecret: []byte("secret"), FlushInterval: time.Hour}
+	handler := New(db, fs, opts)
+	ts := httptest.NewServer(handler)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	t.Cleanup(func() {
+		cancel()
+		ts.Close()
+		handler.Close()
+	})
+
+	url := createWsURLWithAuth(t, ts.URL, workspaceID, opts.JWTSecret)
+
+	//nolint:bodyclose
+	sender, _, err := websocket.Dial(ctx, url, nil)
+	require.NoError(t, err)
+	t.Cleanup(func() { sender.Close(websocket.StatusNormalClosure, "") })
+
+	readAck := func(t *testing.T) AckMessage {
+		for {
+			var msg map[string]any
+			require.NoError(t, wsjson.Read(ctx, sender, &msg))
+			if msg["type"] != float64(AckEventType) {
+				continue
+			}
+
+			var ack AckMessage
+			require.NoError(t, mapToStruct(msg, &ack))
+			return ack
+		}
+	}
+
+	t.Run("should ack an applied chunk with the new version", func(t *testing.T) {
+		msg := ChunkMessage{
+			WsMessageHeader: WsMessageHeader{Type: ChunkEventType, FileID: file.ID},
+			RequestID:       "req-1",
+			Version:         0,
+			Chunks:          []diff.Chunk{{Position: 0, Type: diff.Add, Text: "a", Len: 1}},
+		}
+		require.NoError(t, wsjson.Write(ctx, sender, msg))
+
+		assert.Equal(t, AckMessage{
+			WsMessageHeader: WsMessageHeader{Type: AckEventType, FileID: file.ID},
+			RequestID:       "req-1",
+			Version:         1,
+		}, readAck(t))
+	})
+
+	t.Run("should nack invalid chunks", func(t *testing.T) {
+		msg := ChunkMessage{
+			WsMessageHeader: WsMessageHeader{Type: ChunkEventType, FileID: file.ID},
+			RequestID:       "req-2",
+			Version:         1,
+			Chunks:          []diff.Chunk{{Position: -1, Type: diff.Add, Text: "a", Len: 1}},
+		}
+		require.NoError(t, wsjson.Write(ctx, sender, msg))
+
+		assert.Equal(t, AckMessage{
+			WsMessageHeader: WsMessageHeader{Type: AckEventType, FileID: file.ID},
+			RequestID:       "req-2",
+			Error:           AckErrInvalidChunks,
+		}, readAck(t))
+	})
+
+	t.Run("should nack chunks for a not existing file", func(t *testing.T) {
+		msg := ChunkMessage{
+			WsMessageHeader: WsMessageHeader{Type: ChunkEventType, FileID: 999},
+			RequestID:       "req-3",
+			Chunks:          []diff.Chunk{{Position: 0, Type: diff.Add, Text: "a", Len: 1}},
+		}
+		require.NoError(t, wsjson.Write(ctx, sender, msg))
+
+		assert.Equal(t, AckMessage{
+			WsMessageHeader: WsMessageHeader{Type: AckEventType, FileID: 999},
+			RequestID:       "req-3",
+			Error:           AckErrFileUnavailable,
+		}, readAck(t))
+	})
+
+	t.Run("should nack chunks for a file of another workspace", func(t *testing.T) {
+		other, err := repo.CreateFile(context.Background(), repository.CreateFileParams{
+			DiskPath:      diskPath,
+			WorkspacePath: "other_path",
+			MimeType:      "text/plain",
+			WorkspaceID:   2,
+		})
+		require.NoError(t, err)
+
+		msg := ChunkMessage{
+			WsMessageHeader: WsMessageHeader{Type: ChunkEventType, FileID: other.ID},
+			RequestID:       "req-4",
+			Chunks:          []diff.Chunk{{Position: 0, Type: diff.Add, Text: "a", Len: 1}},
+		}
+
+		// whether the file is cached or not
+		for range 2 {
+			require.NoError(t, wsjson.Write(ctx, sender, msg))
+
+			assert.Equal(t, AckMessage{
+				WsMessageHeader: WsMessageHeader{Type: AckEventType, FileID: other.ID},
+				RequestID:       "req-4",
+				Error:           AckErrFileUnavailable,
+			}, readAck(t))
+		}
+
+		stored, err := repo.FetchFile(context.Background(), other.ID)
+		require.NoError(t, err)
+		assert.Equal(t, int64(0), stored.Version)
+	})
+}
+
+func Test_readOnlySubscriber(t *testing.T) {
+	fs := filestorage.NewDisk(t.TempDir())
+	diskPath, err := fs.CreateObject(strings.NewReader("hello"))
+	require.NoError(t, err)
+
+	db := testutils.CreateDB(t)
+	repo := repository.New(db)
+
+	var workspaceID int64 = 11
+	file, err := repo.CreateFile(context.Background(), repository.CreateFileParams{
+		DiskPath:      diskPath,
+		WorkspacePath: "read_only.md",
+		MimeType:      "text/plain",
+		WorkspaceID:   workspaceID,
+	})
+	require.NoError(t, err)
+
+	opts := Options{JWTSecret: []byte("secret"), FlushInterval: time.Hour}
+	handler := New(db, fs, opts)
+	ts := httptest.NewServer(handler)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	t.Cleanup(func() {
+		cancel()
+		ts.Close()
+		handler.Close()
+	})
+
+	dial := func(role middleware.Role) *websocket.Conn {
+		//nolint:bodyclose
+		conn, _, err := websocket.Dial(ctx, createWsURLWithRole(t, ts.URL, workspaceID, opts.JWTSecret, role), nil)
+		require.NoError(t, err)
+		t.Cleanup(func() { conn.Close(websocket.StatusNormalClosure, "") })
+		return conn
+	}
+
+	viewer := dial(middleware.RoleViewer)
+	editor := dial(middleware.RoleEditor)
+
+	require.Eventually(t, func() bool {
+		handler.subscribersMu.RLock()
+		ws, ok := handler.subscribers[workspaceID]
+		handler.subscribersMu.RUnlock()
+		if !ok {
+			return false
+		}
+		ws.mu.Lock()
+		defer ws.mu.Unlock()
+		return len(ws.subs) == 2
+	}, time.Second, 10*time.Millisecond)
+
+	t.Run("should reject the changes of a viewer", func(t *testing.T) {
+		require.NoError(t, wsjson.Write(ctx, viewer, EventMessage{
+			WsMessageHeader: WsMessageHeader{Type: DeleteEventType, FileID: file.ID},
+			WorkspacePath:   file.WorkspacePath,
+			ObjectType:      "file",
+		}))
+		require.NoError(t, wsjson.Write(ctx, viewer, ChunkMessage{
+			WsMessageHeader: WsMessageHeader{Type: ChunkEventType, FileID: file.ID},
+			RequestID:       "viewer-1",
+			Chunks:          []diff.Chunk{{Position: 0, Type: diff.Add, Text: "a", Len: 1}},
+		}))
+
+		var ack AckMessage
+		require.NoError(t, wsjson.Read(ctx, viewer, &ack))
+		assert.Equal(t, AckMessage{
+			WsMessageHeader: WsMessageHeader{Type: AckEventType, FileID: file.ID},
+			RequestID:       "viewer-1",
+			Error:           AckErrForbidden,
+		}, ack)
+
+		events, err := repo.FetchEventsSince(context.Background(), repository.FetchEventsSinceParams{
+			WorkspaceID: workspaceID,
+		})
+		require.NoError(t, err)
+		assert.Empty(t, events)
+
+		fetched, err := repo.FetchFile(context.Background(), file.ID)
+		require.NoError(t, err)
+		assert.Equal(t, int64(0), fetched.Version)
+	})
+
+	t.Run("should broadcast the changes of an editor to a viewer", func(t *testing.T) {
+		msg := ChunkMessage{
+			WsMessageHeader: WsMessageHeader{Type: ChunkEventType, FileID: file.ID},
+			Chunks:          []diff.Chunk{{Position: 0, Type: diff.Add, Text: "a", Len: 1}},
+		}
+		require.NoError(t, wsjson.Write(ctx, editor, msg))
+
+		var received ChunkMessage
+		require.NoError(t, wsjson.Read(ctx, viewer, &received))
+		assert.Equal(t, file.ID, received.FileID)
+		assert.Equal(t, int64(1), received.Version)
+		assert.Equal(t, msg.Chunks, received.Chunks)
+	})
+}
+
+func Test_pathRulesSubscriber(t *testing.T) {
+	db := testutils.CreateDB(t)
+	repo := repository.New(db)
+
+	var workspaceID int64 = 11
+	userID := addRestrictedMember(t, db, workspaceID, "alpha-subscriber", PathRules{
+		{Pattern: "Alpha/**", Access: PathWrite},
+	})
+
+	fs := filestorage.NewDisk(t.TempDir())
+	createFile := func(workspacePath string) repository.File {
+		diskPath, err := fs.CreateObject(strings.NewReader("hello"))
+		require.NoError(t, err)
+		file, err := repo.CreateFile(context.Background(), repository.CreateFileParams{
+			DiskPath:      diskPath,
+			WorkspacePath: workspacePath,
+			MimeType:      "text/plain",
+			WorkspaceID:   workspaceID,
+		})
+		require.NoError(t, err)
+		return file
+	}
+	alpha := createFile("Alpha/note.md")
+	beta := createFile("Beta/note.md")
+
+	opts := Options{JWTSecret: []byte("secret"), FlushInterval: time.Hour}
+	handler := New(db, fs, opts)
+	ts := httptest.NewServer(handler)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	t.Cleanup(func() {
+		cancel()
+		ts.Close()
+		handler.Close()
+	})
+
+	dial := func(url string) *websocket.Conn {
+		//nolint:bodyclose
+		conn, _, err := websocket.Dial(ctx, url, nil)
+		require.NoError(t, err)
+		t.Cleanup(func() { conn.Close(websocket.StatusNormalClosure, "") })
+		return conn
+	}
+
+	admin := dial(createWsURLWithAuth(t, ts.URL, workspaceID, opts.JWTSecret))
+	member := dial(createWsURLWithUser(t, ts.URL, workspaceID, userID, opts.JWTSecret, middleware.RoleEditor))
+
+	require.Eventually(t, func() bool {
+		handler.subscribersMu.RLock()
+		ws, ok := handler.subscribers[workspaceID]
+		handler.subscribersMu.RUnlock()
+		if !ok {
+			return false
+		}
+		ws.mu.Lock()
+		defer ws.mu.Unlock()
+		return len(ws.subs) == 2
+	}, time.Second, 10*time.Millisecond)
+
+	t.Run("should only receive the chunks of readable files", func(t *testing.T) {
+		for _, file := range []repository.File{beta, alpha} {
+			require.NoError(t, wsjson.Write(ctx, admin, ChunkMessage{
+				WsMessageHeader: WsMessageHeader{Type: ChunkEventType, FileID: file.ID},
+				Chunks:          []diff.Chunk{{Position: 0, Type: diff.Add, Text: "a", Len: 1}},
+			}))
+		}
+
+		var received ChunkMessage
+		require.NoError(t, wsjson.Read(ctx, member, &received))
+		assert.Equal(t, alpha.ID, received.FileID)
+	})
+
+	t.Run("should only receive the cursors of readable files", func(t *testing.T) {
+		for _, p := range []string{beta.WorkspacePath, alpha.WorkspacePath} {
+			require.NoError(t, wsjson.Write(ctx, admin, CursorMessage{
+				WsMessageHeader: WsMessageHeader{Type: CursorEventType},
+				Path:            p,
+				Label:           "admin",
+			}))
+		}
+
+		var received CursorMessage
+		require.NoError(t, wsjson.Read(ctx, member, &received))
+		assert.Equal(t, alpha.WorkspacePath, received.Path)
+	})
+
+	t.Run("should reject the changes outside the writable paths", func(t *testing.T) {
+		require.NoError(t, wsjson.Write(ctx, member, ChunkMessage{
+			WsMessageHeader: WsMessageHeader{Type: ChunkEventType, FileID: beta.ID},
+			RequestID:       "member-1",
+			Chunks:          []diff.Chunk{{Position: 0, Type: diff.Add, Text: "b", Len: 1}},
+		}))
+
+		var ack AckMessage
+		require.NoError(t, wsjson.Read(ctx, member, &ack))
+		assert.Equal(t, AckErrForbidden, ack.Error)
+
+		fetched, err := repo.FetchFile(context.Background(), beta.ID)
+		require.NoError(t, err)
+		assert.Equal(t, int64(1), fetched.Version)
+	})
+	t.Run("should reject the renames from outside the writable paths", func(t *testing.T) {
+		recordMoves := func(file repository.File, from string) {
+			for _, event := range []repository.CreateEventParams{
+				{Type: int64(CreateEventType), WorkspacePath: from},
+				{Type: int64(RenameEventType), WorkspacePath: file.WorkspacePath},
+			} {
+				event.WorkspaceID = workspaceID
+				event.FileID = file.ID
+				event.ObjectType = "file"
+				_, err := repo.CreateEvent(context.Background(), event)
+				require.NoError(t, err)
+			}
+		}
+		stolen := createFile("Alpha/stolen.md")
+		recordMoves(stolen, "Beta/stolen.md")
+		moved := createFile("Alpha/moved.md")
+		recordMoves(moved, "Alpha/old.md")
+
+		for _, file := range []repository.File{stolen, moved} {
+			require.NoError(t, wsjson.Write(ctx, member, EventMessage{
+				WsMessageHeader: WsMessageHeader{Type: RenameEventType, FileID: file.ID},
+				WorkspacePath:   file.WorkspacePath,
+				ObjectType:      "file",
+			}))
+		}
+
+		// the chunks of the admin are echoed back to it
+		for {
+			var msg map[string]any
+			require.NoError(t, wsjson.Read(ctx, admin, &msg))
+			if msg["type"] == float64(RenameEventType) {
+				assert.Equal(t, float64(moved.ID), msg["fileId"])
+				return
+			}
+		}
+	})
+}
+
+func Test_memberAccessChanges(t *testing.T) {
+	db := testutils.CreateDB(t)
+	repo := repository.New(db)
+
+	var workspaceID int64 = 11
+	userID := addRestrictedMember(t, db, workspaceID, "changing-member", nil)
+
+	opts := Options{JWTSecret: []byte("secret"), AccessCheckInterval: 10 * time.Millisecond}
+	handler := New(db, new(filestorage.MockFileStorage), opts)
+	ts := httptest.NewServer(handler)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	t.Cleanup(func() {
+		cancel()
+		ts.Close()
+		handler.Close()
+	})
+
+	//nolint:bodyclose
+	member, _, err := websocket.Dial(ctx, createWsURLWithUser(t, ts.URL, workspaceID, userID, opts.JWTSecret, middleware.RoleEditor), nil)
+	require.NoError(t, err)
+	t.Cleanup(func() { member.Close(websocket.StatusNormalClosure, "") })
+
+	memberSubscriber := func() *subscriber {
+		handler.subscribersMu.RLock()
+		ws, ok := handler.subscribers[workspaceID]
+		handler.subscribersMu.RUnlock()
+		if !ok {
+			return nil
+		}
+		ws.mu.Lock()
+		defer ws.mu.Unlock()
+		for sub := range ws.subs {
+			return sub
+		}
+		return nil
+	}
+	require.Eventually(t, func() bool { return memberSubscriber() != nil }, time.Second, 10*time.Millisecond)
+
+	listFiles := func() int {
+		res, _ := testutils.DoRequest[string](
+			t,
+			handler,
+			http.MethodGet,
+			PathHTTPAPI+"/file",
+			nil,
+			testutils.WithUserAuthHeader(opts.JWTSecret, workspaceID, userID, middleware.RoleEditor),
+		)
+		return res.Code
+	}
+
+	t.Run("should apply the rule changes to the connections", func(t *testing.T) {
+		rule := PathRule{Pattern: "Alpha/**", Access: PathRead}
+		_, err := repo.AddMemberPathRule(context.Background(), repository.AddMemberPathRuleParams{
+			WorkspaceID: workspaceID,
+			UserID:      userID,
+			Pattern:     rule.Pattern,
+			Access:      rule.Access,
+		})
+		require.NoError(t, err)
+
+		require.Eventually(t, func() bool {
+			rules := memberSubscriber().rules()
+			return len(rules) == 1 && rules[0] == rule
+		}, time.Second, 10*time.Millisecond)
+		assert.False(t, memberSubscriber().rules().CanWrite("Alpha/note.md"))
+	})
+
+	t.Run("should apply the demotions to the tokens and connections", func(t *testing.T) {
+		_, err := repo.UpdateWorkspaceMemberRole(context.Background(), repository.UpdateWorkspaceMemberRoleParams{
+			Role:        string(middleware.RoleViewer),
+			WorkspaceID: workspaceID,
+			UserID:      userID,
+		})
+		require.NoError(t, err)
+
+		res, _ := testutils.DoRequest[string](
+			t,
+			handler,
+			http.MethodDelete,
+			fmt.Sprintf("%s/file/%d", PathHTTPAPI, 1),
+			nil,
+			testutils.WithUserAuthHeader(opts.JWTSecret, workspaceID, userID, middleware.RoleEditor),
+		)
+		assert.Equal(t, http.StatusForbidden, res.Code)
+
+		require.Eventually(t, func() bool {
+			return memberSubscriber().currentRole() == middleware.RoleViewer
+		}, time.Second, 10*time.Millisecond)
+
+		require.NoError(t, wsjson.Write(ctx, member, ChunkMessage{
+			WsMessageHeader: WsMessageHeader{Type: ChunkEventType, FileID: 1},
+			RequestID:       "viewer-1",
+			Chunks:          []diff.Chunk{{Position: 0, Type: diff.Add, Text: "a", Len: 1}},
+		}))
+
+		var ack AckMessage
+		require.NoError(t, wsjson.Read(ctx, member, &ack))
+		assert.Equal(t, AckErrForbidden, ack.Error)
+	})
+
+	t.Run("should reject the requests and disconnect the removed members", func(t *testing.T) {
+		assert.Equal(t, http.StatusOK, listFiles())
+
+		_, err := repo.DeleteWorkspaceMember(context.Background(), repository.DeleteWorkspaceMemberParams{
+			WorkspaceID: workspaceID,
+			UserID:      userID,
+		})
+		require.NoError(t, err)
+
+		assert.Equal(t, http.StatusUnauthorized, listFiles())
+
+		_, _, err = member.Read(ctx)
+		assert.Equal(t, websocket.StatusPolicyViolation, websocket.CloseStatus(err))
+		assert.Nil(t, memberSubscriber())
+	})
+}
+
+// createEndToEndWorkspace adds an end-to-end encrypted workspace to the db
+func createEndToEndWorkspace(t *testing.T, db *sql.DB, workspaceID int64) {
+	_, err := db.ExecContext(context.Background(),
+		"INSERT OR IGNORE INTO workspaces (id, name, password, end_to_end_encrypted) VALUES (?, ?, ?, TRUE)",
+		workspaceID, fmt.Sprintf("workspace_%d", workspaceID), "test")
+	require.NoError(t, err)
+}
+
+func Test_encryptedChunk(t *testing.T) {
+	db := testutils.CreateDB(t)
+	repo := repository.New(db)
+
+	var workspaceID int64 = 50
+	createEndToEndWorkspace(t, db, workspaceID)
+
+	fs := filestorage.NewDisk(t.TempDir())
+	diskPath, err := fs.CreateObject(strings.NewReader("Y2lwaGVydGV4dA=="))
+	require.NoError(t, err)
+
+	file, err := repo.CreateFile(context.Background(), repository.CreateFileParams{
+		DiskPath:      diskPath,
+		WorkspacePath: "note.md",
+		MimeType:      mimeutils.Encrypted,
+		WorkspaceID:   workspaceID,
+	})
+	require.NoError(t, err)
+
+	opts := Options{JWTSecret: []byte("secret")}
+	handler := New(db, fs, opts)
+	ts := httptest.NewServer(handler)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	t.Cleanup(func() {
+		cancel()
+		ts.Close()
+		handler.Close()
+	})
+
+	dial := func(workspaceID int64) *websocket.Conn {
+		//nolint:bodyclose
+		conn, _, err := websocket.Dial(ctx, createWsURLWithAuth(t, ts.URL, workspaceID, opts.JWTSecret), nil)
+		require.NoError(t, err)
+		t.Cleanup(func() { conn.Close(websocket.StatusNormalClosure, "") })
+		return conn
+	}
+
+	sender := dial(workspaceID)
+	receiver := dial(workspaceID)
+	plainClient := dial(1)
+
+	require.Eventually(t, func() bool {
+		handler.subscribersMu.RLock()
+		ws, ok := handler.subscribers[workspaceID]
+		handler.subscribersMu.RUnlock()
+		if !ok {
+			return false
+		}
+		ws.mu.Lock()
+		defer ws.mu.Unlock()
+		return len(ws.subs) == 2
+	}, time.Second, 10*time.Millisecond)
+
+	readType := func(t *testing.T, conn *websocket.Conn, msgType MessageType, result any) {
+		for {
+			var msg map[string]any
+			require.NoError(t, wsjson.Read(ctx, conn, &msg))
+			if msg["type"] == float64(msgType) {
+				require.NoError(t, mapToStruct(msg, result))
+				return
+			}
+		}
+	}
+
+	t.Run("should store and relay the encrypted operation without applying it", func(t *testing.T) {
+		msg := EncryptedChunkMessage{
+			WsMessageHeader: WsMessageHeader{Type: EncryptedChunkEventType, FileID: file.ID},
+			RequestID:       "req-1",
+			Payload:         "b3BhcXVlIG9wZXJhdGlvbg==",
+			Version:         0,
+		}
+		require.NoError(t, wsjson.Write(ctx, sender, msg))
+
+		var ack AckMessage
+		readType(t, sender, AckEventType, &ack)
+		assert.Equal(t, AckMessage{
+			WsMessageHeader: WsMessageHeader{Type: AckEventType, FileID: file.ID},
+			RequestID:       "req-1",
+			Version:         1,
+		}, ack)
+
+		var relayed EncryptedChunkMessage
+		readType(t, receiver, EncryptedChunkEventType, &relayed)
+		assert.Equal(t, msg, relayed)
+
+		stored, err := repo.FetchFile(context.Background(), file.ID)
+		require.NoError(t, err)
+		assert.Equal(t, int64(1), stored.Version)
+
+		res, operations := testutils.DoRequest[[]Operation](
+			t,
+			handler,
+			http.MethodGet,
+			fmt.Sprintf("%s/operation?from=0&fileId=%d", PathHTTPAPI, file.ID),
+			nil,
+			testutils.WithAuthHeader(opts.JWTSecret, workspaceID),
+		)
+		assert.Equal(t, http.StatusOK, res.Code)
+		require.Len(t, operations, 1)
+		assert.Equal(t, int64(1), operations[0].Version)
+		assert.Equal(t, msg.Payload, operations[0].Payload)
+		assert.Empty(t, operations[0].Operation)
+	})
+
+	listOperations := func(t *testing.T, fileID, from int64) []Operation {
+		res, operations := testutils.DoRequest[[]Operation](
+			t,
+			handler,
+			http.MethodGet,
+			fmt.Sprintf("%s/operation?from=%d&fileId=%d", PathHTTPAPI, from, fileID),
+			nil,
+			testutils.WithAuthHeader(opts.JWTSecret, workspaceID),
+		)
+		require.Equal(t, http.StatusOK, res.Code)
+		return operations
+	}
+
+	t.Run("should keep a conflict copy when an operation made on an old version wins", func(t *testing.T) {
+		latest := EncryptedChunkMessage{
+			WsMessageHeader: WsMessageHeader{Type: EncryptedChunkEventType, FileID: file.ID},
+			RequestID:       "req-latest",
+			Payload:         "bGF0ZXN0",
+			Version:         1,
+		}
+		require.NoError(t, wsjson.Write(ctx, receiver, latest))
+
+		var ack AckMessage
+		readType(t, receiver, AckEventType, &ack)
+		require.Empty(t, ack.Error)
+		require.Equal(t, int64(2), ack.Version)
+
+		// made by a client that didn't see the latest operation
+		stale := EncryptedChunkMessage{
+			WsMessageHeader: WsMessageHeader{Type: EncryptedChunkEventType, FileID: file.ID},
+			RequestID:       "req-stale",
+			Payload:         "c3RhbGU=",
+			Version:         1,
+		}
+		require.NoError(t, wsjson.Write(ctx, sender, stale))
+
+		readType(t, sender, AckEventType, &ack)
+		assert.Empty(t, ack.Error)
+		assert.Equal(t, int64(3), ack.Version)
+
+		var event EventMessage
+		readType(t, receiver, CreateEventType, &event)
+		assert.Regexp(t, `^note \(conflict [0-9-]+ [0-9]+\)\.md$`, event.WorkspacePath)
+
+		var relayed EncryptedChunkMessage
+		readType(t, receiver, EncryptedChunkEventType, &relayed)
+		assert.Equal(t, stale.Payload, relayed.Payload)
+		assert.Equal(t, int64(2), relayed.Version)
+		assert.Equal(t, int64(1), relayed.BaseVersion)
+
+		stored, err := repo.FetchFile(context.Background(), file.ID)
+		require.NoError(t, err)
+		assert.Equal(t, int64(3), stored.Version)
+		assert.Equal(t, int64(0), stored.BlobVersion)
+
+		operations := listOperations(t, file.ID, 1)
+		require.Len(t, operations, 2)
+		assert.Equal(t, latest.Payload, operations[0].Payload)
+		assert.Equal(t, int64(1), operations[0].BaseVersion)
+		assert.Equal(t, stale.Payload, operations[1].Payload)
+		assert.Equal(t, int64(3), operations[1].Version)
+		assert.Equal(t, int64(1), operations[1].BaseVersion)
+
+		// the copy is the file before the stale operation
+		conflict, err := repo.FetchFile(context.Background(), event.FileID)
+		require.NoError(t, err)
+		assert.Equal(t, int64(2), conflict.Version)
+		assert.Equal(t, int64(0), conflict.BlobVersion)
+		assert.NotEqual(t, file.DiskPath, conflict.DiskPath)
+
+		reader, err := fs.ReadObject(conflict.DiskPath)
+		require.NoError(t, err)
+		content, err := io.ReadAll(reader)
+		require.NoError(t, reader.Close())
+		require.NoError(t, err)
+		assert.Equal(t, "Y2lwaGVydGV4dA==", string(content))
+
+		operations = listOperations(t, conflict.ID, 0)
+		require.Len(t, operations, 2)
+		assert.Equal(t, "b3BhcXVlIG9wZXJhdGlvbg==", operations[0].Payload)
+		assert.Equal(t, latest.Payload, operations[1].Payload)
+	})
+
+	t.Run("should purge the encrypted operations only once they are in a blob", func(t *testing.T) {
+		require.NoError(t, repo.DeleteOperationOlderThan(context.Background(), time.Now().Add(time.Hour)))
+		assert.Len(t, listOperations(t, file.ID, 0), 3)
+
+		require.NoError(t, repo.UpdateFileHash(context.Background(), repository.UpdateFileHashParams{
+			ID:          file.ID,
+			Hash:        "checkpoint",
+			BlobVersion: 3,
+		}))
+		require.NoError(t, repo.DeleteOperationOlderThan(context.Background(), time.Now().Add(time.Hour)))
+		assert.Empty(t, listOperations(t, file.ID, 0))
+	})
+
+	t.Run("should reject encrypted operations made on a version older than the blob", func(t *testing.T) {
+		msg := EncryptedChunkMessage{
+			WsMessageHeader: WsMessageHeader{Type: EncryptedChunkEventType, FileID: file.ID},
+			RequestID:       "req-old",
+			Payload:         "b2xk",
+			Version:         2,
+		}
+		require.NoError(t, wsjson.Write(ctx, sender, msg))
+
+		var ack AckMessage
+		readType(t, sender, AckEventType, &ack)
+		assert.Equal(t, AckErrStaleVersion, ack.Error)
+		assert.Equal(t, int64(3), ack.Version)
+	})
+
+	t.Run("should reject plain text chunks", func(t *testing.T) {
+		msg := ChunkMessage{
+			WsMessageHeader: WsMessageHeader{Type: ChunkEventType, FileID: file.ID},
+			RequestID:       "req-2",
+			Chunks:          []diff.Chunk{{Position: 0, Type: diff.Add, Text: "a", Len: 1}},
+		}
+		require.NoError(t, wsjson.Write(ctx, sender, msg))
+
+		var ack AckMessage
+		readType(t, sender, AckEventType, &ack)
+		assert.Equal(t, AckErrEncryptionMode, ack.Error)
+	})
+
+	t.Run("should reject encrypted chunks on plain workspaces", func(t *testing.T) {
+		msg := EncryptedChunkMessage{
+			WsMessageHeader: WsMessageHeader{Type: EncryptedChunkEventType, FileID: file.ID},
+			RequestID:       "req-3",
+			Payload:         "b3BhcXVl",
+		}
+		require.NoError(t, wsjson.Write(ctx, plainClient, msg))
+
+		var ack AckMessage
+		readType(t, plainClient, AckEventType, &ack)
+		assert.Equal(t, AckErrEncryptionMode, ack.Error)
+	})
+}
+
 func Test_processFileChanges(t *testing.T) {
 	t.Run("should not write file to storage and save snapshot if too early", func(t *testing.T) {
 		db := testutils.CreateDB(t)
@@ -0,0 +1,24 @@
+-- name: CreateAPIKey :one
+INSERT INTO api_keys (workspace_id, name, key_hash, scopes, expires_at)
+VALUES (?, ?, ?, ?, ?)
+RETURNING id, workspace_id, name, scopes, expires_at, created_at;
+
+-- name: FetchValidAPIKey :one
+SELECT id, workspace_id, scopes
+FROM api_keys
+WHERE key_hash = ? AND (expires_at IS NULL OR expires_at > ?)
+LIMIT 1;
+
+-- name: FetchAPIKeys :many
+SELECT id, workspace_id, name, scopes, expires_at, created_at
+FROM api_keys
+WHERE workspace_id = ?
+ORDER BY id ASC;
+
+-- name: DeleteAPIKey :execrows
+DELETE FROM api_keys
+WHERE id = ? AND workspace_id = ?;
+
+-- name: DeleteWorkspaceAPIKeys :execrows
+DELETE FROM api_keys
+WHERE workspace_id = ?;
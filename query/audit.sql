@@ -0,0 +1,14 @@
+-- name: CreateAuditEvent :exec
+INSERT INTO audit_events (workspace_id, actor, action, target, details, ip, created_at)
+VALUES (?, ?, ?, ?, ?, ?, ?);
+
+-- name: FetchAuditEvents :many
+SELECT *
+FROM audit_events
+WHERE workspace_id = @workspace_id
+  AND created_at >= @since
+  AND created_at < @until
+  AND (@actor = '' OR actor = @actor)
+  AND (@action = '' OR action = @action)
+ORDER BY id DESC
+LIMIT @limit;
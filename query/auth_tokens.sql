@@ -0,0 +1,44 @@
+-- name: CreateRefreshToken :exec
+INSERT INTO refresh_tokens (token_hash, workspace_id, user_id, access_token_id, expires_at)
+VALUES (?, ?, ?, ?, ?);
+
+-- name: ConsumeRefreshToken :one
+DELETE FROM refresh_tokens
+WHERE token_hash = ? AND expires_at > ?
+RETURNING *;
+
+-- name: DeleteAccessTokenRefreshTokens :exec
+DELETE FROM refresh_tokens
+WHERE access_token_id = ?;
+
+-- name: DeleteExpiredRefreshTokens :exec
+DELETE FROM refresh_tokens
+WHERE expires_at < ?;
+
+-- name: RevokeToken :exec
+INSERT INTO revoked_tokens (jti, expires_at)
+VALUES (?, ?)
+ON CONFLICT (jti) DO NOTHING;
+
+-- name: IsTokenRevoked :one
+SELECT EXISTS (
+    SELECT 1
+    FROM revoked_tokens
+    WHERE jti = ?
+);
+
+-- name: DeleteExpiredRevokedTokens :exec
+DELETE FROM revoked_tokens
+WHERE expires_at < ?;
+
+-- name: DeleteWorkspaceRefreshTokens :exec
+DELETE FROM refresh_tokens
+WHERE workspace_id = ?;
+
+-- name: DeleteSharedRefreshTokens :exec
+DELETE FROM refresh_tokens
+WHERE workspace_id = ? AND user_id = 0;
+
+-- name: DeleteUserRefreshTokens :exec
+DELETE FROM refresh_tokens
+WHERE user_id = ?;
@@ -0,0 +1,28 @@
+-- name: CreateEvent :one
+INSERT INTO events (workspace_id, file_id, type, workspace_path, object_type)
+VALUES (?, ?, ?, ?, ?)
+RETURNING *;
+
+-- name: FetchEventsSince :many
+SELECT *
+FROM events
+WHERE workspace_id = ? AND seq > ?
+ORDER BY seq ASC;
+
+-- name: FetchLatestFileEvent :one
+SELECT *
+FROM events
+WHERE workspace_id = ? AND file_id = ?
+ORDER BY seq DESC
+LIMIT 1;
+
+-- name: FetchFileEventBefore :one
+SELECT *
+FROM events
+WHERE workspace_id = ? AND file_id = ? AND seq < ?
+ORDER BY seq DESC
+LIMIT 1;
+
+-- name: DeleteWorkspaceEvents :execrows
+DELETE FROM events
+WHERE workspace_id = ?;
@@ -1,6 +1,6 @@
 -- name: CreateFile :one
-INSERT INTO files (disk_path, workspace_path, mime_type, hash, workspace_id)
-VALUES (?, ?, ?, ?, ?)
+INSERT INTO files (disk_path, workspace_path, mime_type, hash, workspace_id, change_seq)
+VALUES (?, ?, ?, ?, ?, ?)
 RETURNING *;
 
 -- name: FetchFile :one
@@ -14,6 +14,27 @@ SELECT *
 FROM files
 WHERE workspace_id = ?;
 
+-- name: FetchFilesChangedSince :many
+SELECT *
+FROM files
+WHERE workspace_id = ? AND change_seq > ?
+ORDER BY change_seq ASC;
+
+-- name: FetchWorkspaceDeletedFiles :many
+SELECT *
+FROM deleted_files
+WHERE workspace_id = ?;
+
+-- name: FetchDeletedFilesWithHistoryBefore :many
+SELECT *
+FROM deleted_files
+WHERE disk_path != '' AND deleted_at < ?;
+
+-- name: ClearDeletedFileHistory :exec
+UPDATE deleted_files
+SET disk_path = ''
+WHERE file_id = ?;
+
 -- name: FetchWorkspaceFiles :many
 SELECT *
 FROM files
@@ -42,20 +63,66 @@ LIMIT 1;
 UPDATE files
 SET 
     workspace_path = ?,
+    change_seq = ?,
     updated_at = CURRENT_TIMESTAMP
 WHERE id = ?;
 
--- name: UpdateFileVersion :exec
+-- name: UpdateFileVersion :execrows
 UPDATE files
 SET 
     version = ?,
+    change_seq = ?,
     updated_at = CURRENT_TIMESTAMP
+WHERE id = ? AND version = sqlc.arg(expected_version);
+
+-- name: UpdateFileVersions :exec
+UPDATE files
+SET
+    version = ?,
+    blob_version = ?
 WHERE id = ?;
 
+-- name: UpdateFileContent :execrows
+UPDATE files
+SET
+    disk_path = ?,
+    mime_type = ?,
+    hash = ?,
+    version = ?,
+    blob_version = ?,
+    change_seq = ?,
+    updated_at = CURRENT_TIMESTAMP
+WHERE id = ? AND version = sqlc.arg(expected_version);
+
 -- name: UpdateFileHash :exec
 UPDATE files
 SET
     hash = ?,
+    blob_version = ?,
     updated_at = CURRENT_TIMESTAMP
 WHERE id = ?;
 
+
+-- name: CreateDeletedFile :exec
+INSERT INTO deleted_files (file_id, workspace_id, workspace_path, change_seq, disk_path, mime_type, version, file_created_at, file_updated_at)
+VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?);
+
+-- name: FetchDeletedFile :one
+SELECT *
+FROM deleted_files
+WHERE file_id = ?
+LIMIT 1;
+
+-- name: FetchDeletedFilesSince :many
+SELECT *
+FROM deleted_files
+WHERE workspace_id = ? AND change_seq > ?
+ORDER BY change_seq ASC;
+
+-- name: DeleteWorkspaceFiles :execrows
+DELETE FROM files
+WHERE workspace_id = ?;
+
+-- name: DeleteWorkspaceDeletedFiles :exec
+DELETE FROM deleted_files
+WHERE workspace_id = ?;
@@ -1,6 +1,12 @@
 -- name: CreateOperation :exec
-INSERT INTO operations (file_id, version, operation)
-VALUES (?, ?, ?);
+INSERT INTO operations (file_id, version, operation, base_version)
+VALUES (?, ?, ?, ?);
+
+-- name: CopyFileOperations :exec
+INSERT INTO operations (file_id, version, operation, base_version, created_at)
+SELECT sqlc.arg(target_file_id), version, operation, base_version, created_at
+FROM operations
+WHERE file_id = sqlc.arg(file_id) AND version > sqlc.arg(from_version) AND version <= sqlc.arg(to_version);
 
 -- name: FetchOperation :one
 SELECT *
@@ -11,11 +17,34 @@ LIMIT 1;
 -- name: FetchFileOperationsFromVersion :many
 SELECT o.*
 FROM operations o
-JOIN files f ON o.file_id = f.id
-WHERE o.file_id = ? AND o.version > ? AND f.workspace_id = ?
+WHERE o.file_id = @file_id AND o.version > @version AND (
+    EXISTS (SELECT 1 FROM files f WHERE f.id = o.file_id AND f.workspace_id = @workspace_id)
+    OR EXISTS (SELECT 1 FROM deleted_files d WHERE d.file_id = o.file_id AND d.workspace_id = @workspace_id)
+)
 ORDER BY o.version ASC;
 
 -- name: DeleteOperationOlderThan :exec
 DELETE FROM operations
-WHERE created_at < ?;
+WHERE created_at < ? AND NOT EXISTS (
+    SELECT 1
+    FROM files f
+    JOIN workspaces w ON w.id = f.workspace_id
+    WHERE f.id = operations.file_id AND w.end_to_end_encrypted AND operations.version > f.blob_version
+);
+
+
+-- name: DeleteFileOperations :exec
+DELETE FROM operations
+WHERE file_id = ?;
 
+-- name: DeleteWorkspaceOperations :execrows
+DELETE FROM operations
+WHERE file_id IN (
+    SELECT id
+    FROM files
+    WHERE workspace_id = @workspace_id
+    UNION
+    SELECT file_id
+    FROM deleted_files
+    WHERE workspace_id = @workspace_id
+);
@@ -0,0 +1,22 @@
+-- name: AddMemberPathRule :one
+INSERT INTO member_path_rules (workspace_id, user_id, pattern, access)
+VALUES (?, ?, ?, ?)
+RETURNING *;
+
+-- name: FetchMemberPathRules :many
+SELECT *
+FROM member_path_rules
+WHERE workspace_id = ? AND user_id = ?
+ORDER BY id ASC;
+
+-- name: DeleteMemberPathRule :execrows
+DELETE FROM member_path_rules
+WHERE id = ? AND workspace_id = ?;
+
+-- name: DeleteMemberPathRules :exec
+DELETE FROM member_path_rules
+WHERE workspace_id = ? AND user_id = ?;
+
+-- name: DeleteWorkspacePathRules :exec
+DELETE FROM member_path_rules
+WHERE workspace_id = ?;
@@ -26,3 +26,29 @@ WHERE file_id = ?
 -- name: DeleteSnapshotsForFile :exec
 DELETE FROM snapshots
 WHERE file_id = ?;
+
+-- name: DeleteSnapshot :exec
+DELETE FROM snapshots
+WHERE file_id = ? AND version = ?;
+
+-- name: FetchFilesWithSnapshots :many
+SELECT DISTINCT file_id, workspace_id
+FROM snapshots;
+
+-- name: UpdateSnapshotContent :exec
+UPDATE snapshots
+SET
+    disk_path = ?,
+    type = ?,
+    hash = ?
+WHERE file_id = ? AND version = ?;
+
+-- name: DeleteWorkspaceSnapshots :execrows
+DELETE FROM snapshots
+WHERE workspace_id = ?;
+
+-- name: FetchWorkspaceSnapshots :many
+SELECT *
+FROM snapshots
+WHERE workspace_id = ?
+ORDER BY file_id ASC, version ASC;
@@ -0,0 +1,41 @@
+-- name: FetchStorageObject :one
+SELECT *
+FROM storage_objects
+WHERE path = ?;
+
+-- name: CreateStorageObject :exec
+INSERT INTO storage_objects (path, hash)
+VALUES (?, ?);
+
+-- name: UpdateStorageObjectHash :exec
+UPDATE storage_objects
+SET hash = ?
+WHERE path = ?;
+
+-- name: DeleteStorageObject :exec
+DELETE FROM storage_objects
+WHERE path = ?;
+
+-- name: FetchStorageBlob :one
+SELECT *
+FROM storage_blobs
+WHERE hash = ?;
+
+-- name: CreateStorageBlob :exec
+INSERT INTO storage_blobs (hash, disk_path, ref_count)
+VALUES (?, ?, 1);
+
+-- name: IncrementStorageBlobRefs :exec
+UPDATE storage_blobs
+SET ref_count = ref_count + 1
+WHERE hash = ?;
+
+-- name: DecrementStorageBlobRefs :one
+UPDATE storage_blobs
+SET ref_count = ref_count - 1
+WHERE hash = ?
+RETURNING *;
+
+-- name: DeleteStorageBlob :exec
+DELETE FROM storage_blobs
+WHERE hash = ?;
@@ -0,0 +1,53 @@
+-- name: AddUser :exec
+INSERT INTO users (name, password)
+VALUES (?, ?);
+
+-- name: FetchUser :one
+SELECT id, name, password
+FROM users
+WHERE name = ?
+LIMIT 1;
+
+-- name: UpdateUserPassword :exec
+UPDATE users
+SET
+    password = ?,
+    updated_at = CURRENT_TIMESTAMP
+WHERE id = ?;
+
+-- name: AddWorkspaceMember :exec
+INSERT INTO workspace_members (workspace_id, user_id, role)
+VALUES (?, ?, ?);
+
+-- name: DeleteWorkspaceMember :execrows
+DELETE FROM workspace_members
+WHERE workspace_id = ? AND user_id = ?;
+
+-- name: FetchMemberWorkspace :one
+SELECT w.id, w.end_to_end_encrypted, m.role
+FROM workspaces w
+JOIN workspace_members m ON m.workspace_id = w.id
+WHERE w.name = ? AND m.user_id = ?
+LIMIT 1;
+
+-- name: FetchWorkspaceMemberRole :one
+SELECT role
+FROM workspace_members
+WHERE workspace_id = ? AND user_id = ?
+LIMIT 1;
+
+-- name: FetchWorkspaceMembers :many
+SELECT u.id, u.name, m.role, m.created_at
+FROM workspace_members m
+JOIN users u ON u.id = m.user_id
+WHERE m.workspace_id = ?
+ORDER BY u.name ASC;
+
+-- name: UpdateWorkspaceMemberRole :execrows
+UPDATE workspace_members
+SET role = ?
+WHERE workspace_id = ? AND user_id = ?;
+
+-- name: DeleteWorkspaceMembers :execrows
+DELETE FROM workspace_members
+WHERE workspace_id = ?;
@@ -1,9 +1,60 @@
 -- name: AddWorkspace :exec
-INSERT INTO workspaces (name, password)
-VALUES (?, ?);
+INSERT INTO workspaces (name, password, binary_snapshot_retention, end_to_end_encrypted)
+VALUES (?, ?, ?, ?);
 
 -- name: FetchWorkspace :one
-SELECT id, name, password 
+SELECT id, name, password, end_to_end_encrypted
 FROM workspaces
 WHERE name = ?
-LIMIT 1
+LIMIT 1;
+
+-- name: BumpWorkspaceChangeSeq :one
+UPDATE workspaces
+SET change_seq = change_seq + 1
+WHERE id = ?
+RETURNING change_seq;
+
+-- name: FetchWorkspaceChangeSeq :one
+SELECT change_seq
+FROM workspaces
+WHERE id = ?;
+
+-- name: FetchWorkspaceEndToEndEncrypted :one
+SELECT end_to_end_encrypted
+FROM workspaces
+WHERE id = ?;
+
+-- name: FetchWorkspaceSnapshotRetention :one
+SELECT binary_snapshot_retention
+FROM workspaces
+WHERE id = ?;
+
+-- name: UpdateWorkspaceSnapshotRetention :exec
+UPDATE workspaces
+SET
+    binary_snapshot_retention = ?,
+    updated_at = CURRENT_TIMESTAMP
+WHERE id = ?;
+
+-- name: FetchWorkspaces :many
+SELECT id, name, end_to_end_encrypted, created_at
+FROM workspaces
+ORDER BY name ASC;
+
+-- name: RenameWorkspace :execrows
+UPDATE workspaces
+SET
+    name = ?,
+    updated_at = CURRENT_TIMESTAMP
+WHERE id = ?;
+
+-- name: UpdateWorkspacePassword :exec
+UPDATE workspaces
+SET
+    password = ?,
+    updated_at = CURRENT_TIMESTAMP
+WHERE id = ?;
+
+-- name: DeleteWorkspace :exec
+DELETE FROM workspaces
+WHERE id = ?;
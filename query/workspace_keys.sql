@@ -0,0 +1,24 @@
+-- name: FetchWorkspaceKey :one
+SELECT *
+FROM workspace_keys
+WHERE workspace_id = ?;
+
+-- name: FetchWorkspaceKeys :many
+SELECT *
+FROM workspace_keys
+ORDER BY workspace_id;
+
+-- name: CreateWorkspaceKey :exec
+INSERT INTO workspace_keys (workspace_id, wrapped_key)
+VALUES (?, ?);
+
+-- name: UpdateWorkspaceKey :exec
+UPDATE workspace_keys
+SET
+    wrapped_key = ?,
+    updated_at = CURRENT_TIMESTAMP
+WHERE workspace_id = ?;
+
+-- name: DeleteWorkspaceKey :exec
+DELETE FROM workspace_keys
+WHERE workspace_id = ?;